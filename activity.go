@@ -0,0 +1,187 @@
+// activity.go
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Activity entry types
+const (
+	ActivityMessage  = "message"  // sent a chat message
+	ActivityFile     = "file"     // shared a file
+	ActivityJoin     = "join"     // joined a room
+	ActivityLeave    = "leave"    // left a room
+	ActivityReaction = "reaction" // added or took back a reaction
+)
+
+// How long a computed feed page is served from cache
+const activityCacheTTL = 30 * time.Second
+
+// ActivityEntry is one action in a user's activity feed
+type ActivityEntry struct {
+	ID        uint64          `json:"id"`
+	Type      string          `json:"type"`
+	Timestamp time.Time       `json:"timestamp"`
+	Room      string          `json:"room"`
+	Detail    *ActivityDetail `json:"detail,omitempty"`
+}
+
+// ActivityDetail describes what an activity entry was about
+type ActivityDetail struct {
+	MessageID string `json:"messageId,omitempty"`
+	Content   string `json:"content,omitempty"`
+	FileName  string `json:"fileName,omitempty"`
+	Emoji     string `json:"emoji,omitempty"`
+}
+
+// A feed page with its cache expiry
+type cachedActivity struct {
+	entries    []ActivityEntry
+	nextCursor string
+	expires    time.Time
+}
+
+// Activity log and feed cache
+var (
+	activityLimit  int                                // entries kept per user
+	activityLog    = make(map[string][]ActivityEntry) // username -> entries, oldest first
+	lastActivityID uint64
+	activityMu     sync.Mutex
+
+	activityCache   = make(map[string]cachedActivity) // see activityCacheKey
+	activityCacheMu sync.Mutex
+)
+
+// Initialize the activity log from environment variables
+func initActivity() {
+	activityLimit = getEnvInt("ACTIVITY_LOG_LIMIT", 500)
+	if activityLimit < 1 {
+		log.Printf("Warning: ACTIVITY_LOG_LIMIT must be positive, using 500")
+		activityLimit = 500
+	}
+}
+
+// Record an action in a user's activity log, dropping the user's oldest
+// entry when the log is full
+func recordActivity(username, activityType, room string, detail *ActivityDetail) {
+	if username == "" || username == "System" || activityLimit == 0 {
+		return
+	}
+	activityMu.Lock()
+	defer activityMu.Unlock()
+
+	lastActivityID++
+	entries := append(activityLog[username], ActivityEntry{
+		ID:        lastActivityID,
+		Type:      activityType,
+		Timestamp: time.Now(),
+		Room:      room,
+		Detail:    detail,
+	})
+	if len(entries) > activityLimit {
+		entries = entries[len(entries)-activityLimit:]
+	}
+	activityLog[username] = entries
+}
+
+// Record a chat message or file a user sent
+func recordMessageActivity(msg Message) {
+	if msg.FileURL != "" {
+		recordActivity(msg.Username, ActivityFile, msg.Room, &ActivityDetail{MessageID: msg.ID, FileName: msg.FileName})
+		return
+	}
+	recordActivity(msg.Username, ActivityMessage, msg.Room, &ActivityDetail{MessageID: msg.ID, Content: msg.Content})
+}
+
+// Key of a feed page in the cache. The owner and admins see the same
+// full feed; anyone else's view depends on the rooms they can access.
+func activityCacheKey(username, viewer string, full bool, cursor uint64, limit int) string {
+	if full {
+		viewer = ""
+	}
+	return username + "\x00" + viewer + "\x00" + strconv.FormatUint(cursor, 10) + "\x00" + strconv.Itoa(limit)
+}
+
+// Return a user's recent activity, newest first. The user (?username=)
+// and admins see all of it; anyone else only sees entries in rooms they
+// can access. ?cursor=<id> continues below that entry; nextCursor is the
+// value for the next page, or empty on the last one.
+func handleUserActivity(c *gin.Context) {
+	username := c.Param("username")
+	viewer := c.Query("username")
+	full := viewer == username || isAdmin(c)
+
+	limit, ok := historyLimit(c)
+	if !ok {
+		return
+	}
+	var cursor uint64
+	if value := c.Query("cursor"); value != "" {
+		var err error
+		if cursor, err = strconv.ParseUint(value, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "cursor must be an activity entry ID"})
+			return
+		}
+	}
+
+	key := activityCacheKey(username, viewer, full, cursor, limit)
+	activityCacheMu.Lock()
+	cached, hit := activityCache[key]
+	activityCacheMu.Unlock()
+	if hit && time.Now().Before(cached.expires) {
+		c.JSON(http.StatusOK, gin.H{"username": username, "activity": cached.entries, "nextCursor": cached.nextCursor})
+		return
+	}
+
+	activityMu.Lock()
+	entries := activityLog[username]
+	activityMu.Unlock()
+
+	// Entries are only appended or dropped from the front, so the slice
+	// taken above stays valid to read
+	ctx := c.Request.Context()
+	page := []ActivityEntry{}
+	access := make(map[string]bool)
+	nextCursor := ""
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if cursor != 0 && entry.ID >= cursor {
+			continue
+		}
+		if !full {
+			allowed, checked := access[entry.Room]
+			if !checked {
+				allowed = canAccessRoom(ctx, entry.Room, viewer)
+				access[entry.Room] = allowed
+			}
+			if !allowed {
+				continue
+			}
+		}
+		if len(page) == limit {
+			nextCursor = strconv.FormatUint(page[len(page)-1].ID, 10)
+			break
+		}
+		page = append(page, entry)
+	}
+
+	activityCacheMu.Lock()
+	now := time.Now()
+	if len(activityCache) > 1000 {
+		for k, v := range activityCache {
+			if now.After(v.expires) {
+				delete(activityCache, k)
+			}
+		}
+	}
+	activityCache[key] = cachedActivity{entries: page, nextCursor: nextCursor, expires: now.Add(activityCacheTTL)}
+	activityCacheMu.Unlock()
+
+	c.JSON(http.StatusOK, gin.H{"username": username, "activity": page, "nextCursor": nextCursor})
+}
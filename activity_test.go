@@ -0,0 +1,116 @@
+// activity_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Start a test with an empty activity log and feed cache, keeping up to
+// limit entries per user
+func useEmptyActivity(t *testing.T, limit int) {
+	t.Helper()
+	activityMu.Lock()
+	savedLog, savedLimit := activityLog, activityLimit
+	activityLog, activityLimit = make(map[string][]ActivityEntry), limit
+	activityMu.Unlock()
+	activityCacheMu.Lock()
+	savedCache := activityCache
+	activityCache = make(map[string]cachedActivity)
+	activityCacheMu.Unlock()
+	t.Cleanup(func() {
+		activityMu.Lock()
+		activityLog, activityLimit = savedLog, savedLimit
+		activityMu.Unlock()
+		activityCacheMu.Lock()
+		activityCache = savedCache
+		activityCacheMu.Unlock()
+	})
+}
+
+func TestRecordActivity(t *testing.T) {
+	useEmptyActivity(t, 3)
+	recordMessageActivity(Message{ID: "m1", Room: "general", Username: "bob", Content: "hi"})
+	recordMessageActivity(Message{ID: "m2", Room: "general", Username: "bob", FileURL: "/download/general/a.png", FileName: "a.png"})
+	recordActivity("bob", ActivityJoin, "random", nil)
+	recordActivity("bob", ActivityLeave, "random", nil)
+	recordActivity("System", ActivityJoin, "general", nil)
+	recordActivity("", ActivityJoin, "general", nil)
+
+	activityMu.Lock()
+	defer activityMu.Unlock()
+	var types []string
+	for _, entry := range activityLog["bob"] {
+		types = append(types, entry.Type)
+	}
+	if got := strings.Join(types, ","); got != "file,join,leave" {
+		t.Errorf("bob's activity = %s, want the newest 3: file,join,leave", got)
+	}
+	if len(activityLog) != 1 {
+		t.Errorf("activity recorded for %d users, want only bob", len(activityLog))
+	}
+}
+
+func TestHandleUserActivity(t *testing.T) {
+	useMemoryStore(t)
+	useEmptyActivity(t, 100)
+	privateRooms = true
+	t.Cleanup(func() { privateRooms = false })
+	adminToken = "admin-secret"
+	t.Cleanup(func() { adminToken = "" })
+	ctx := context.Background()
+	messageStore.JoinRoom(ctx, "general", "alice")
+
+	recordActivity("bob", ActivityJoin, "general", nil)
+	recordActivity("bob", ActivityJoin, "secret", nil)
+	recordMessageActivity(Message{ID: "m1", Room: "secret", Username: "bob", Content: "psst"})
+	recordMessageActivity(Message{ID: "m2", Room: "general", Username: "bob", Content: "hi"})
+
+	tests := []struct {
+		name   string
+		target string
+		admin  bool
+		code   int
+		want   string // entry rooms, newest first
+		next   bool
+	}{
+		{"owner sees everything", "/users/bob/activity?username=bob", false, http.StatusOK, "general,secret,secret,general", false},
+		{"admin sees everything", "/users/bob/activity", true, http.StatusOK, "general,secret,secret,general", false},
+		{"others see rooms they can access", "/users/bob/activity?username=alice", false, http.StatusOK, "general,general", false},
+		{"paged", "/users/bob/activity?username=bob&limit=2", false, http.StatusOK, "general,secret", true},
+		{"bad cursor", "/users/bob/activity?username=bob&cursor=x", false, http.StatusBadRequest, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.admin {
+				req.Header.Set("Authorization", "Bearer admin-secret")
+			}
+			rec := serveTestRequest("/users/:username/activity", req, handleUserActivity)
+			if rec.Code != tt.code {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.code, rec.Body)
+			}
+			if tt.code != http.StatusOK {
+				return
+			}
+			var resp struct {
+				Activity   []ActivityEntry `json:"activity"`
+				NextCursor string          `json:"nextCursor"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			var rooms []string
+			for _, entry := range resp.Activity {
+				rooms = append(rooms, entry.Room)
+			}
+			if strings.Join(rooms, ",") != tt.want || (resp.NextCursor != "") != tt.next {
+				t.Errorf("activity in %v (next %q), want %s (next %v)", rooms, resp.NextCursor, tt.want, tt.next)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+// audio.go
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"os/exec"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Audio types accepted as voice messages
+var voiceMessageTypes = map[string]bool{
+	"audio/webm": true,
+	"audio/ogg":  true,
+}
+
+// Sniffing can't tell audio-only WebM and Ogg files from video, so files
+// sniffed as these are voice messages when the client declares an audio type
+var audioContainerTypes = map[string]bool{
+	"video/webm":      true,
+	"application/ogg": true,
+}
+
+// Voice messages are decoded to mono PCM at this rate for analysis
+const waveformSampleRate = 8000
+
+// Number of amplitude samples in a waveform
+const waveformPoints = 100
+
+// AttachmentMetadata describes an attached file's contents, so clients can
+// render a preview without downloading it
+type AttachmentMetadata struct {
+	Type            string    `json:"type"`
+	Waveform        []float64 `json:"waveform,omitempty"`
+	DurationSeconds float64   `json:"durationSeconds,omitempty"`
+}
+
+// AudioTooLongError is returned for voice messages over the length limit
+type AudioTooLongError struct {
+	Duration float64
+}
+
+func (e *AudioTooLongError) Error() string {
+	return fmt.Sprintf("audio is %.1f seconds long, the limit is %d", e.Duration, maxAudioDuration)
+}
+
+// Voice message settings
+var (
+	ffmpegPath       string
+	maxAudioDuration int // seconds
+)
+
+// Initialize voice message analysis from environment variables
+func initAudio() {
+	ffmpegPath = os.Getenv("FFMPEG_PATH")
+	if ffmpegPath == "" {
+		ffmpegPath = "ffmpeg"
+	}
+	if _, err := exec.LookPath(ffmpegPath); err != nil {
+		log.Printf("Warning: %s not found, voice messages will have no waveform", ffmpegPath)
+		ffmpegPath = ""
+	}
+	maxAudioDuration = getEnvInt("MAX_AUDIO_DURATION_SECONDS", 300)
+}
+
+// Analyze an uploaded file, returning metadata for voice messages and nil
+// for anything else. Voice messages longer than MAX_AUDIO_DURATION_SECONDS
+// fail with *AudioTooLongError. The file is rewound afterwards.
+func analyzeUpload(ctx context.Context, file multipart.File, header *multipart.FileHeader) (*AttachmentMetadata, error) {
+	if !isVoiceMessage(file, header) || ffmpegPath == "" {
+		return nil, nil
+	}
+
+	samples, err := decodeAudio(ctx, file)
+	if _, seekErr := file.Seek(0, io.SeekStart); seekErr != nil {
+		return nil, seekErr
+	}
+	if err != nil {
+		// Still accept the file, just without a preview
+		log.Printf("Error decoding voice message %s: %v", header.Filename, err)
+		return &AttachmentMetadata{Type: "audio"}, nil
+	}
+
+	duration := float64(len(samples)) / waveformSampleRate
+	if maxAudioDuration > 0 && duration > float64(maxAudioDuration) {
+		return nil, &AudioTooLongError{Duration: duration}
+	}
+	return &AttachmentMetadata{
+		Type:            "audio",
+		Waveform:        waveform(samples, waveformPoints),
+		DurationSeconds: math.Round(duration*10) / 10,
+	}, nil
+}
+
+// Report whether an upload is a voice message, going by its contents and,
+// for container formats that may hold video, the type the client declared
+func isVoiceMessage(file multipart.File, header *multipart.FileHeader) bool {
+	mediaType, _, _ := mime.ParseMediaType(detectContentType(file, header.Filename))
+	if voiceMessageTypes[mediaType] {
+		return true
+	}
+	declared, _, _ := mime.ParseMediaType(header.Header.Get("Content-Type"))
+	return audioContainerTypes[mediaType] && voiceMessageTypes[declared]
+}
+
+// Decode audio to mono 16-bit PCM samples with ffmpeg. Decoding stops a
+// little past the duration limit, so overlong uploads are not decoded in full.
+func decodeAudio(ctx context.Context, r io.Reader) ([]int16, error) {
+	args := []string{"-v", "error", "-i", "pipe:0"}
+	if maxAudioDuration > 0 {
+		args = append(args, "-t", fmt.Sprint(maxAudioDuration+1))
+	}
+	args = append(args, "-f", "s16le", "-ac", "1", "-ar", fmt.Sprint(waveformSampleRate), "pipe:1")
+
+	cmd := exec.CommandContext(ctx, ffmpegPath, args...)
+	cmd.Stdin = r
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	pcm, err := io.ReadAll(stdout)
+	if err != nil {
+		cmd.Wait()
+		return nil, err
+	}
+	if err := cmd.Wait(); err != nil {
+		return nil, err
+	}
+
+	samples := make([]int16, len(pcm)/2)
+	for i := range samples {
+		samples[i] = int16(binary.LittleEndian.Uint16(pcm[2*i:]))
+	}
+	return samples, nil
+}
+
+// Reduce samples to n peak amplitudes between 0 and 1, scaled so the
+// loudest point is 1
+func waveform(samples []int16, n int) []float64 {
+	if len(samples) == 0 {
+		return nil
+	}
+	n = min(n, len(samples))
+
+	peaks := make([]float64, n)
+	var loudest float64
+	for i := range peaks {
+		start, end := i*len(samples)/n, (i+1)*len(samples)/n
+		for _, sample := range samples[start:end] {
+			peaks[i] = math.Max(peaks[i], math.Abs(float64(sample)))
+		}
+		loudest = math.Max(loudest, peaks[i])
+	}
+	for i := range peaks {
+		if loudest > 0 {
+			peaks[i] = math.Round(peaks[i]/loudest*100) / 100
+		}
+	}
+	return peaks
+}
+
+// Respond to an upload that failed analysis
+func respondAnalyzeError(c *gin.Context, err error) {
+	var tooLong *AudioTooLongError
+	if errors.As(err, &tooLong) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Voice message is too long", "maxDurationSeconds": maxAudioDuration})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read file"})
+	log.Printf("Error analyzing upload: %v", err)
+}
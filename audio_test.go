@@ -0,0 +1,177 @@
+// audio_test.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Magic number WebM files start with
+const webmMagic = "\x1a\x45\xdf\xa3"
+
+// Use a stand-in for ffmpeg that outputs the uploaded file after its WebM
+// magic number as the decoded PCM, or fails when fail is set
+func useFakeFFmpeg(t *testing.T, maxDuration int, fail bool) {
+	t.Helper()
+	script := "#!/bin/sh\ntail -c +5\n"
+	if fail {
+		script = "#!/bin/sh\ncat >/dev/null\necho 'invalid data' >&2\nexit 1\n"
+	}
+	path := filepath.Join(t.TempDir(), "ffmpeg")
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	savedPath, savedMax := ffmpegPath, maxAudioDuration
+	ffmpegPath, maxAudioDuration = path, maxDuration
+	t.Cleanup(func() { ffmpegPath, maxAudioDuration = savedPath, savedMax })
+}
+
+// Encode samples as a WebM "file" the fake ffmpeg decodes back to them
+func fakeVoiceFile(samples []int16) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(webmMagic)
+	binary.Write(&buf, binary.LittleEndian, samples)
+	return buf.Bytes()
+}
+
+// Parse a single-file upload with the declared content type, as a handler
+// would receive it
+func uploadedFile(t *testing.T, fileName, contentType string, data []byte) (multipart.File, *multipart.FileHeader) {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", `form-data; name="file"; filename="`+fileName+`"`)
+	header.Set("Content-Type", contentType)
+	part, _ := form.CreatePart(header)
+	part.Write(data)
+	form.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, "/upload", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	file, fileHeader, err := req.FormFile("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+	return file, fileHeader
+}
+
+func TestWaveform(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []int16
+		n       int
+		want    []float64
+	}{
+		{"empty", nil, 4, nil},
+		{"peaks scaled to the loudest", []int16{100, -200, 50, 400}, 4, []float64{0.25, 0.5, 0.13, 1}},
+		{"negative peaks count", []int16{0, -1000, 500, 0}, 2, []float64{1, 0.5}},
+		{"buckets take their peak", []int16{1, 8, 2, 4, 3, 2}, 3, []float64{1, 0.5, 0.38}},
+		{"fewer samples than points", []int16{10, 20}, 100, []float64{0.5, 1}},
+		{"silence", []int16{0, 0, 0}, 3, []float64{0, 0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := waveform(tt.samples, tt.n); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("waveform(%v, %d) = %v, want %v", tt.samples, tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnalyzeVoiceMessage(t *testing.T) {
+	useFakeFFmpeg(t, 300, false)
+
+	// 2.5 seconds getting louder
+	samples := make([]int16, 2*waveformSampleRate+waveformSampleRate/2)
+	for i := range samples {
+		samples[i] = int16(i)
+	}
+	file, header := uploadedFile(t, "voice.webm", "audio/webm", fakeVoiceFile(samples))
+
+	metadata, err := analyzeUpload(context.Background(), file, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata == nil || metadata.Type != "audio" || metadata.DurationSeconds != 2.5 {
+		t.Fatalf("metadata = %+v, want 2.5 seconds of audio", metadata)
+	}
+	if len(metadata.Waveform) != waveformPoints || metadata.Waveform[waveformPoints-1] != 1 || metadata.Waveform[0] >= metadata.Waveform[waveformPoints/2] {
+		t.Errorf("waveform = %v, want %d rising points ending at 1", metadata.Waveform, waveformPoints)
+	}
+
+	// The file is rewound for storing
+	if data, _ := readAll(file); !bytes.Equal(data, fakeVoiceFile(samples)) {
+		t.Error("file was not rewound after analysis")
+	}
+}
+
+func TestAnalyzeUploadKinds(t *testing.T) {
+	voice := fakeVoiceFile(make([]int16, waveformSampleRate))
+	tests := []struct {
+		name        string
+		fileName    string
+		contentType string
+		data        []byte
+		audio       bool
+	}{
+		{"WebM declared as audio", "voice.webm", "audio/webm", voice, true},
+		{"WebM declared as audio with codecs", "voice.webm", "audio/webm; codecs=opus", voice, true},
+		{"WebM declared as video", "clip.webm", "video/webm", voice, false},
+		{"WebM declared as anything", "clip.webm", "application/octet-stream", voice, false},
+		{"not audio", "notes.txt", "audio/webm", []byte("just some notes"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFakeFFmpeg(t, 300, false)
+			file, header := uploadedFile(t, tt.fileName, tt.contentType, tt.data)
+			metadata, err := analyzeUpload(context.Background(), file, header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (metadata != nil) != tt.audio {
+				t.Errorf("metadata = %+v, want audio = %v", metadata, tt.audio)
+			}
+		})
+	}
+}
+
+func TestAnalyzeVoiceMessageTooLong(t *testing.T) {
+	useFakeFFmpeg(t, 1, false)
+	file, header := uploadedFile(t, "voice.webm", "audio/webm", fakeVoiceFile(make([]int16, 2*waveformSampleRate)))
+
+	_, err := analyzeUpload(context.Background(), file, header)
+	var tooLong *AudioTooLongError
+	if !errors.As(err, &tooLong) || tooLong.Duration != 2 {
+		t.Errorf("err = %v, want a 2-second AudioTooLongError", err)
+	}
+}
+
+func TestAnalyzeVoiceMessageUndecodable(t *testing.T) {
+	captureLog(t)
+	useFakeFFmpeg(t, 300, true)
+	file, header := uploadedFile(t, "voice.webm", "audio/webm", fakeVoiceFile(make([]int16, 100)))
+
+	// Still accepted, just without a preview
+	metadata, err := analyzeUpload(context.Background(), file, header)
+	if err != nil || metadata == nil || metadata.Type != "audio" || metadata.Waveform != nil {
+		t.Errorf("analyzeUpload = %+v, %v; want audio without a waveform", metadata, err)
+	}
+}
+
+// Read a file from its current position
+func readAll(file multipart.File) ([]byte, error) {
+	var buf bytes.Buffer
+	_, err := buf.ReadFrom(file)
+	return buf.Bytes(), err
+}
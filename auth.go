@@ -0,0 +1,65 @@
+// auth.go
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Shared secrets for admin and moderator endpoints (each is disabled when empty)
+var (
+	adminToken     string
+	moderatorToken string
+)
+
+// Initialize admin and moderator authentication from environment variables
+func initAuth() {
+	adminToken = os.Getenv("ADMIN_TOKEN")
+	moderatorToken = os.Getenv("MODERATOR_TOKEN")
+}
+
+// Report whether the request carries token as a bearer token
+func hasBearerToken(c *gin.Context, token string) bool {
+	if token == "" {
+		return false
+	}
+	given, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// Report whether the request carries the admin token as a bearer token
+func isAdmin(c *gin.Context) bool {
+	return hasBearerToken(c, adminToken)
+}
+
+// Report whether the request carries the moderator or admin token
+func isModerator(c *gin.Context) bool {
+	return hasBearerToken(c, moderatorToken) || isAdmin(c)
+}
+
+// AdminRequired rejects requests that don't carry the admin token
+func AdminRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !isAdmin(c) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// ModeratorRequired rejects requests that carry neither the moderator nor
+// the admin token
+func ModeratorRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !isModerator(c) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Moderator access required"})
+			return
+		}
+		c.Next()
+	}
+}
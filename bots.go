@@ -0,0 +1,49 @@
+// bots.go
+package main
+
+import "log"
+
+// Bot is a participant run by the server that reacts to chat messages
+type Bot interface {
+	// Name is the username the bot posts as
+	Name() string
+	// Handle is called with every stored chat message. It runs on the
+	// broadcast loop, so slow work must happen in the background.
+	Handle(msg Message)
+}
+
+// Enabled bots; replaced on a config reload, so read them with currentBots
+var bots []Bot
+
+// Initialize the bots enabled by environment variables and reserve their
+// names, so users can't pose as them
+func initBots() {
+	enabled, err := loadBots()
+	if err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	bots = enabled
+	for _, bot := range bots {
+		reserveUsername(bot.Name())
+	}
+}
+
+// Build the bots enabled by environment variables. On error the bots that
+// are configured correctly are still returned.
+func loadBots() ([]Bot, error) {
+	var enabled []Bot
+	bot, err := newOpenAIBot()
+	if bot != nil {
+		enabled = append(enabled, bot)
+	}
+	return enabled, err
+}
+
+// Pass a chat message to every bot except the one that sent it
+func dispatchToBots(msg Message) {
+	for _, bot := range currentBots() {
+		if bot.Name() != msg.Username {
+			bot.Handle(msg)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+// broadcastfilter.go
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// BroadcastFilter decides whether a room member receives a broadcast;
+// returning false skips them. Filters run while the client list is locked,
+// so they must not send messages themselves.
+type BroadcastFilter func(username string, msg *Message) bool
+
+// A filter gets this long to decide for every recipient of a message before
+// the message goes to the whole room instead; a variable so tests can
+// lengthen it on a loaded machine
+var broadcastFilterDeadline = time.Millisecond
+
+// Room holds a room's runtime configuration
+type Room struct {
+	name string
+
+	mu     sync.RWMutex
+	filter BroadcastFilter
+
+	// Minimum gap between each user's messages and when each user last
+	// sent one; see slowmode.go
+	slowMode time.Duration
+	lastSent map[string]time.Time
+}
+
+// Configuration of rooms that have any, by name
+var (
+	roomConfigs   = make(map[string]*Room)
+	roomConfigsMu sync.Mutex
+)
+
+// Return a room's configuration, creating it on first use
+func getRoom(name string) *Room {
+	roomConfigsMu.Lock()
+	defer roomConfigsMu.Unlock()
+	room, ok := roomConfigs[name]
+	if !ok {
+		room = &Room{name: name}
+		roomConfigs[name] = room
+	}
+	return room
+}
+
+// Return a room's configuration, or nil when it has none
+func findRoom(name string) *Room {
+	roomConfigsMu.Lock()
+	defer roomConfigsMu.Unlock()
+	return roomConfigs[name]
+}
+
+// Return a room's broadcast filter, or nil when the room has none
+func roomBroadcastFilter(name string) BroadcastFilter {
+	room := findRoom(name)
+	if room == nil {
+		return nil
+	}
+	room.mu.RLock()
+	defer room.mu.RUnlock()
+	return room.filter
+}
+
+// SetBroadcastFilter restricts the room's broadcasts to the members f
+// accepts; nil delivers to every member again. Critical messages always go
+// to everyone.
+func (r *Room) SetBroadcastFilter(f BroadcastFilter) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.filter = f
+}
+
+// Run a filter for every recipient of a message and return the usernames it
+// accepts. Returns nil, meaning everyone, when the filter misses the
+// deadline or panics. The caller must hold clientsMu.
+func filterRecipientsLocked(filter BroadcastFilter, msg Message) map[string]bool {
+	var usernames []string
+	seen := make(map[string]bool)
+	for client := range clients {
+		if client.room == msg.Room && !seen[client.username] {
+			seen[client.username] = true
+			usernames = append(usernames, client.username)
+		}
+	}
+
+	// Buffered so a filter that finishes after the deadline does not block
+	result := make(chan map[string]bool, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("Broadcast filter for room %s panicked: %v", msg.Room, r)
+				result <- nil
+			}
+		}()
+		allowed := make(map[string]bool, len(usernames))
+		for _, username := range usernames {
+			// Each call gets its own copy, so a filter cannot alter the broadcast
+			m := msg
+			if filter(username, &m) {
+				allowed[username] = true
+			}
+		}
+		result <- allowed
+	}()
+
+	timer := time.NewTimer(broadcastFilterDeadline)
+	defer timer.Stop()
+	select {
+	case allowed := <-result:
+		return allowed
+	case <-timer.C:
+		log.Printf("Broadcast filter for room %s missed its deadline, sending to everyone", msg.Room)
+		return nil
+	}
+}
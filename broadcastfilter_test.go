@@ -0,0 +1,90 @@
+// broadcastfilter_test.go
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Give a room a broadcast filter, with deadline to decide, for a test
+func useBroadcastFilter(t *testing.T, room string, deadline time.Duration, filter BroadcastFilter) {
+	t.Helper()
+	saved := broadcastFilterDeadline
+	broadcastFilterDeadline = deadline
+	getRoom(room).SetBroadcastFilter(filter)
+	t.Cleanup(func() {
+		broadcastFilterDeadline = saved
+		roomConfigsMu.Lock()
+		delete(roomConfigs, room)
+		roomConfigsMu.Unlock()
+	})
+}
+
+// Members of general, plus one user in another room
+func useFilterClients(t *testing.T) (alice, bob, carol, dave *Client) {
+	t.Helper()
+	alice = &Client{username: "alice", room: "general", send: make(chan Message, 10)}
+	bob = &Client{username: "bob", room: "general", send: make(chan Message, 10)}
+	carol = &Client{username: "carol", room: "general", send: make(chan Message, 10)}
+	dave = &Client{username: "dave", room: "random", send: make(chan Message, 10)}
+	useClients(t, alice, bob, carol, dave)
+	return alice, bob, carol, dave
+}
+
+func TestBroadcastFilterTargetsMembers(t *testing.T) {
+	alice, bob, carol, dave := useFilterClients(t)
+	useBroadcastFilter(t, "general", time.Second, func(username string, msg *Message) bool {
+		msg.Content = "altered" // must not reach anyone
+		return username == "alice" || username == "carol"
+	})
+
+	fanOut(Message{ID: "m1", Room: "general", Username: "System", Content: "for some"})
+	for _, client := range []*Client{alice, carol} {
+		if len(client.send) != 1 {
+			t.Fatalf("%s got %d messages, want the targeted one", client.username, len(client.send))
+		}
+		if msg := <-client.send; msg.Content != "for some" {
+			t.Errorf("%s got content %q, want it unaltered by the filter", client.username, msg.Content)
+		}
+	}
+	for _, client := range []*Client{bob, dave} {
+		if len(client.send) != 0 {
+			t.Errorf("%s got a message meant for others", client.username)
+		}
+	}
+
+	// Critical messages go to everyone in the room regardless
+	fanOut(Message{ID: "m2", Room: "general", Username: "System", Content: "urgent", Priority: PriorityCritical})
+	for _, client := range []*Client{alice, bob, carol} {
+		if len(client.send) != 1 {
+			t.Errorf("%s got %d critical messages, want 1", client.username, len(client.send))
+		}
+	}
+}
+
+func TestBroadcastFilterFailsOpen(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter BroadcastFilter
+	}{
+		{"panics", func(username string, msg *Message) bool { panic("bad filter") }},
+		{"too slow", func(username string, msg *Message) bool {
+			time.Sleep(200 * time.Millisecond)
+			return false
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			captureLog(t)
+			alice, bob, carol, _ := useFilterClients(t)
+			useBroadcastFilter(t, "general", 20*time.Millisecond, tt.filter)
+
+			fanOut(Message{ID: "m1", Room: "general", Username: "System", Content: "hi"})
+			for _, client := range []*Client{alice, bob, carol} {
+				if len(client.send) != 1 {
+					t.Errorf("%s got %d messages, want the broadcast sent to everyone", client.username, len(client.send))
+				}
+			}
+		})
+	}
+}
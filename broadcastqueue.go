@@ -0,0 +1,177 @@
+// broadcastqueue.go
+package main
+
+import (
+	"container/list"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Broadcast queue metrics
+var (
+	broadcastQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "chat_broadcast_queue_depth",
+		Help: "Normal-priority messages published but not yet delivered",
+	})
+	broadcastQueueShedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_broadcast_queue_shed_total",
+		Help: "Queued messages dropped because a queue limit was exceeded, by limit (room or global)",
+	}, []string{"limit"})
+)
+
+// How often shedding is logged for each room, and for the overall limit
+const shedWarningInterval = 10 * time.Second
+
+// broadcastQueue is the normal-priority broadcast lane. Publishing never
+// blocks; when producers outpace delivery, the oldest queued messages are
+// shed so a burst can't grow the backlog without bound.
+type broadcastQueue struct {
+	mu         sync.Mutex
+	messages   *list.List                 // queued messages, oldest first
+	rooms      map[string][]*list.Element // room -> its queued messages, oldest first
+	limit      int                        // max queued messages overall; 0 means unlimited
+	roomLimit  int                        // max queued messages per room; 0 means unlimited
+	lastWarned map[string]time.Time       // room -> last warning about its limit
+	// Last warning about the overall limit
+	lastGlobalWarning time.Time
+
+	// Holds a token while the queue may be non-empty
+	ready chan struct{}
+}
+
+// Create a broadcast queue with the given overall and per-room limits
+func newBroadcastQueue(limit, roomLimit int) *broadcastQueue {
+	return &broadcastQueue{
+		messages:   list.New(),
+		rooms:      make(map[string][]*list.Element),
+		limit:      limit,
+		roomLimit:  roomLimit,
+		lastWarned: make(map[string]time.Time),
+		ready:      make(chan struct{}, 1),
+	}
+}
+
+// Initialize the broadcast queue limits from environment variables
+func initBroadcastQueue() {
+	limit := getEnvInt("BROADCAST_QUEUE_LIMIT", 10000)
+	roomLimit := getEnvInt("BROADCAST_ROOM_QUEUE_LIMIT", 1000)
+	if limit < 0 {
+		log.Printf("Warning: BROADCAST_QUEUE_LIMIT must not be negative, using 10000")
+		limit = 10000
+	}
+	if roomLimit < 0 {
+		log.Printf("Warning: BROADCAST_ROOM_QUEUE_LIMIT must not be negative, using 1000")
+		roomLimit = 1000
+	}
+
+	broadcast.mu.Lock()
+	defer broadcast.mu.Unlock()
+	broadcast.limit = limit
+	broadcast.roomLimit = roomLimit
+}
+
+// Queue a message, shedding the oldest messages of its room or of the
+// whole queue when that goes over a limit
+func (q *broadcastQueue) Push(msg Message) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.rooms[msg.Room] = append(q.rooms[msg.Room], q.messages.PushBack(msg))
+	if q.roomLimit > 0 {
+		for len(q.rooms[msg.Room]) > q.roomLimit {
+			q.shedLocked(q.rooms[msg.Room][0], "room")
+		}
+	}
+	if q.limit > 0 {
+		for q.messages.Len() > q.limit {
+			q.shedLocked(q.messages.Front(), "global")
+		}
+	}
+	broadcastQueueDepth.Set(float64(q.messages.Len()))
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Take the oldest queued message; ok is false when the queue is empty
+func (q *broadcastQueue) Pop() (msg Message, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	front := q.messages.Front()
+	if front == nil {
+		return Message{}, false
+	}
+	msg = q.removeLocked(front)
+	broadcastQueueDepth.Set(float64(q.messages.Len()))
+
+	// Pass the token on for the messages still queued
+	if q.messages.Len() > 0 {
+		select {
+		case q.ready <- struct{}{}:
+		default:
+		}
+	}
+	return msg, true
+}
+
+// Drop every queued message for a room, e.g. one being deleted, and
+// return how many there were
+func (q *broadcastQueue) DropRoom(room string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	queued := q.rooms[room]
+	for _, elem := range queued {
+		q.messages.Remove(elem)
+	}
+	delete(q.rooms, room)
+	delete(q.lastWarned, room)
+	broadcastQueueDepth.Set(float64(q.messages.Len()))
+	return len(queued)
+}
+
+// Remove a queued message; it is always the oldest of its room
+func (q *broadcastQueue) removeLocked(elem *list.Element) Message {
+	msg := q.messages.Remove(elem).(Message)
+	if queued := q.rooms[msg.Room][1:]; len(queued) > 0 {
+		q.rooms[msg.Room] = queued
+	} else {
+		delete(q.rooms, msg.Room)
+	}
+	return msg
+}
+
+// Drop a queued message for going over the room or global limit, counting
+// it and warning at most once per shedWarningInterval
+func (q *broadcastQueue) shedLocked(elem *list.Element, limit string) {
+	msg := q.removeLocked(elem)
+	broadcastQueueShedTotal.WithLabelValues(limit).Inc()
+
+	now := time.Now()
+	if limit == "global" {
+		if now.Sub(q.lastGlobalWarning) >= shedWarningInterval {
+			q.lastGlobalWarning = now
+			log.Printf("Warning: broadcast queue is over its limit of %d, dropping oldest messages", q.limit)
+		}
+		return
+	}
+	if now.Sub(q.lastWarned[msg.Room]) < shedWarningInterval {
+		return
+	}
+	q.lastWarned[msg.Room] = now
+	if len(q.lastWarned) > 1000 {
+		for room, warned := range q.lastWarned {
+			if now.Sub(warned) >= shedWarningInterval {
+				delete(q.lastWarned, room)
+			}
+		}
+	}
+	log.Printf("Warning: broadcast queue for room %q is over its limit of %d, dropping oldest messages", msg.Room, q.roomLimit)
+}
@@ -0,0 +1,97 @@
+// broadcastqueue_test.go
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestBroadcastQueueShedding(t *testing.T) {
+	tests := []struct {
+		name       string
+		limit      int
+		roomLimit  int
+		pushes     string // room letter per message, e.g. "aab"
+		want       []string
+		roomShed   int
+		globalShed int
+	}{
+		{"unlimited", 0, 0, "aabab", []string{"a0", "a1", "b2", "a3", "b4"}, 0, 0},
+		{"under the limits", 5, 3, "aab", []string{"a0", "a1", "b2"}, 0, 0},
+		{"room limit drops the room's oldest", 0, 2, "abaa", []string{"b1", "a2", "a3"}, 1, 0},
+		{"room limit leaves other rooms alone", 0, 1, "abab", []string{"a2", "b3"}, 2, 0},
+		{"global limit drops the oldest", 3, 0, "abab", []string{"b1", "a2", "b3"}, 0, 1},
+		{"both limits", 3, 2, "aaabb", []string{"a2", "b3", "b4"}, 1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roomShed := testutil.ToFloat64(broadcastQueueShedTotal.WithLabelValues("room"))
+			globalShed := testutil.ToFloat64(broadcastQueueShedTotal.WithLabelValues("global"))
+
+			q := newBroadcastQueue(tt.limit, tt.roomLimit)
+			for i, room := range strings.Split(tt.pushes, "") {
+				q.Push(Message{ID: room + string(rune('0'+i)), Room: room})
+			}
+			var got []string
+			for {
+				msg, ok := q.Pop()
+				if !ok {
+					break
+				}
+				got = append(got, msg.ID)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("delivered %v, want %v", got, tt.want)
+			}
+			if n := testutil.ToFloat64(broadcastQueueShedTotal.WithLabelValues("room")) - roomShed; n != float64(tt.roomShed) {
+				t.Errorf("%v shed for the room limit, want %d", n, tt.roomShed)
+			}
+			if n := testutil.ToFloat64(broadcastQueueShedTotal.WithLabelValues("global")) - globalShed; n != float64(tt.globalShed) {
+				t.Errorf("%v shed for the global limit, want %d", n, tt.globalShed)
+			}
+			if len(q.rooms) != 0 {
+				t.Errorf("rooms left after draining: %v", q.rooms)
+			}
+		})
+	}
+}
+
+func TestBroadcastQueueDropRoom(t *testing.T) {
+	q := newBroadcastQueue(0, 0)
+	for _, msg := range []Message{{ID: "1", Room: "a"}, {ID: "2", Room: "b"}, {ID: "3", Room: "a"}} {
+		q.Push(msg)
+	}
+	if n := q.DropRoom("a"); n != 2 {
+		t.Errorf("DropRoom = %d, want 2", n)
+	}
+	msg, ok := q.Pop()
+	if !ok || msg.ID != "2" {
+		t.Errorf("Pop = %v, %v, want message 2", msg.ID, ok)
+	}
+	if _, ok := q.Pop(); ok {
+		t.Error("queue not empty after popping the last message")
+	}
+}
+
+func TestBroadcastQueueReady(t *testing.T) {
+	q := newBroadcastQueue(0, 0)
+	q.Push(Message{ID: "1", Room: "a"})
+	q.Push(Message{ID: "2", Room: "a"})
+	<-q.ready
+	q.Pop()
+	select {
+	case <-q.ready:
+	default:
+		t.Fatal("no ready token while a message is still queued")
+	}
+	q.Pop()
+	select {
+	case <-q.ready:
+		t.Fatal("ready token left on an empty queue")
+	default:
+	}
+}
@@ -0,0 +1,147 @@
+// capabilities.go
+package main
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// ServerCapabilities tells a newly connected client what the server offers,
+// sent in a capabilities message right after the welcome message
+type ServerCapabilities struct {
+	// Custom emoji usable as :name: in messages and reactions
+	Emoji []CustomEmoji `json:"emoji"`
+}
+
+// Describe the server's current capabilities
+func currentCapabilities() *ServerCapabilities {
+	return &ServerCapabilities{Emoji: listCustomEmoji()}
+}
+
+// Message type sent to a client before the welcome message, listing which
+// optional features are enabled
+const MessageTypeServerCapabilities = "server_capabilities"
+
+// Code of the nack or HTTP error returned for a request that needs a
+// disabled feature
+const errFeatureDisabled = "ERR_FEATURE_DISABLED"
+
+// Names of the optional features in server_capabilities
+const (
+	FeatureDisappearingMessages = "disappearing_messages"
+	FeatureHistoryReplay        = "history_replay"
+	FeatureLinkPreviews         = "link_previews"
+	FeatureShortcodes           = "shortcodes"
+	FeatureWordFilter           = "word_filter"
+	FeatureBots                 = "bots"
+	FeaturePrivateRooms         = "private_rooms"
+	FeatureFileVersions         = "file_versions"
+	FeatureDirectDownloads      = "direct_downloads"
+	FeatureCompression          = "compression"
+	FeatureChallenge            = "challenge"
+	FeatureCloseHints           = "close_hints"
+	FeatureHeartbeatLatency     = "heartbeat_latency"
+)
+
+// Config is the part of the server's configuration that clients can see:
+// which optional features are on
+type Config struct {
+	DisappearingMessages bool
+	HistoryReplay        bool
+	LinkPreviews         bool
+	Shortcodes           bool
+	WordFilter           bool
+	Bots                 bool
+	PrivateRooms         bool
+	FileVersions         bool
+	DirectDownloads      bool
+	Compression          bool
+	Challenge            bool
+	CloseHints           bool
+	HeartbeatLatency     bool
+}
+
+// Read the feature settings in effect
+func currentConfig() *Config {
+	return &Config{
+		DisappearingMessages: disappearingEnabled,
+		HistoryReplay:        replayMax > 0,
+		LinkPreviews:         linkPreviewsEnabled,
+		Shortcodes:           shortcodes != nil,
+		WordFilter:           currentWordFilter() != nil,
+		Bots:                 len(currentBots()) > 0,
+		PrivateRooms:         privateRooms,
+		FileVersions:         storageVersioning,
+		DirectDownloads:      directDownloads,
+		Compression:          compressionEnabled,
+		Challenge:            challengeEnabled,
+		CloseHints:           closeHints,
+		HeartbeatLatency:     reportLatency,
+	}
+}
+
+// BuildCapabilities lists every optional feature and whether cfg enables it
+func BuildCapabilities(cfg *Config) map[string]bool {
+	return map[string]bool{
+		FeatureDisappearingMessages: cfg.DisappearingMessages,
+		FeatureHistoryReplay:        cfg.HistoryReplay,
+		FeatureLinkPreviews:         cfg.LinkPreviews,
+		FeatureShortcodes:           cfg.Shortcodes,
+		FeatureWordFilter:           cfg.WordFilter,
+		FeatureBots:                 cfg.Bots,
+		FeaturePrivateRooms:         cfg.PrivateRooms,
+		FeatureFileVersions:         cfg.FileVersions,
+		FeatureDirectDownloads:      cfg.DirectDownloads,
+		FeatureCompression:          cfg.Compression,
+		FeatureChallenge:            cfg.Challenge,
+		FeatureCloseHints:           cfg.CloseHints,
+		FeatureHeartbeatLatency:     cfg.HeartbeatLatency,
+	}
+}
+
+// The server_capabilities frame. Message already uses "capabilities" for
+// ServerCapabilities, so this frame has its own shape.
+type serverCapabilitiesMessage struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	ID            string          `json:"id"`
+	Type          string          `json:"type"`
+	Channel       string          `json:"channel,omitempty"`
+	Username      string          `json:"username"`
+	Capabilities  map[string]bool `json:"capabilities"`
+	Timestamp     time.Time       `json:"timestamp"`
+}
+
+// Send the server_capabilities frame on a new connection. It is written
+// before the client is registered, while nothing else writes to ws.
+func sendServerCapabilities(ws *websocket.Conn, channels bool) error {
+	frame := serverCapabilitiesMessage{
+		SchemaVersion: CurrentSchemaVersion,
+		ID:            uuid.New().String(),
+		Type:          MessageTypeServerCapabilities,
+		Username:      "System",
+		Capabilities:  BuildCapabilities(currentConfig()),
+		Timestamp:     time.Now(),
+	}
+	if channels {
+		frame.Channel = ChannelControl
+	}
+	if writeTimeout > 0 {
+		ws.SetWriteDeadline(time.Now().Add(writeTimeout))
+	}
+	return ws.WriteJSON(frame)
+}
+
+// Nack for a message that needs a disabled feature
+func featureDisabledNack(feature string) Message {
+	return Message{
+		ID:        uuid.New().String(),
+		Type:      MessageTypeNack,
+		Username:  "System",
+		Content:   "This feature is disabled on this server.",
+		Reason:    feature,
+		Code:      errFeatureDisabled,
+		Timestamp: time.Now(),
+	}
+}
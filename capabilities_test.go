@@ -0,0 +1,152 @@
+// capabilities_test.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+
+	"go-chat/client"
+)
+
+func TestBuildCapabilities(t *testing.T) {
+	fields := reflect.TypeOf(Config{}).NumField()
+	if all := BuildCapabilities(&Config{}); len(all) != fields {
+		t.Fatalf("BuildCapabilities lists %d features, want one per flag (%d)", len(all), fields)
+	}
+
+	// Each flag turns on exactly one feature, and no two flags the same one
+	seen := make(map[string]string)
+	for i := range fields {
+		var cfg Config
+		reflect.ValueOf(&cfg).Elem().Field(i).SetBool(true)
+		name := reflect.TypeOf(cfg).Field(i).Name
+
+		var enabled []string
+		for feature, on := range BuildCapabilities(&cfg) {
+			if on {
+				enabled = append(enabled, feature)
+			}
+		}
+		if len(enabled) != 1 {
+			t.Errorf("%s enables %v, want exactly one feature", name, enabled)
+			continue
+		}
+		if other, ok := seen[enabled[0]]; ok {
+			t.Errorf("%s and %s both enable %s", name, other, enabled[0])
+		}
+		seen[enabled[0]] = name
+	}
+}
+
+// Read the server_capabilities frame sendServerCapabilities writes on a
+// new connection
+func readServerCapabilities(t *testing.T, channels bool) []byte {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		if err := sendServerCapabilities(ws, channels); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	_, data, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestServerCapabilitiesFrame(t *testing.T) {
+	saved := disappearingEnabled
+	disappearingEnabled = true
+	t.Cleanup(func() { disappearingEnabled = saved })
+
+	for _, channels := range []bool{false, true} {
+		data := readServerCapabilities(t, channels)
+
+		// {"type":"server_capabilities","capabilities":{"disappearing_messages":true,...}}
+		var frame map[string]json.RawMessage
+		if err := json.Unmarshal(data, &frame); err != nil {
+			t.Fatal(err)
+		}
+		var msgType, channel string
+		json.Unmarshal(frame["type"], &msgType)
+		json.Unmarshal(frame["channel"], &channel)
+		if msgType != "server_capabilities" {
+			t.Errorf("type = %q, want server_capabilities", msgType)
+		}
+		if want := map[bool]string{true: ChannelControl}[channels]; channel != want {
+			t.Errorf("channels=%v: channel = %q, want %q", channels, channel, want)
+		}
+		var capabilities map[string]bool
+		if err := json.Unmarshal(frame["capabilities"], &capabilities); err != nil {
+			t.Fatalf("capabilities is not a flat map of features: %s", frame["capabilities"])
+		}
+		if want := BuildCapabilities(currentConfig()); !reflect.DeepEqual(capabilities, want) {
+			t.Errorf("capabilities = %v, want %v", capabilities, want)
+		}
+		if !capabilities[FeatureDisappearingMessages] {
+			t.Errorf("%s is off, want it to reflect the config", FeatureDisappearingMessages)
+		}
+
+		var msg client.Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatal(err)
+		}
+		if got, ok := msg.Features(); !ok || !reflect.DeepEqual(got, capabilities) {
+			t.Errorf("client Features = %v, %v, want %v", got, ok, capabilities)
+		}
+	}
+}
+
+func TestFeatureDisabledSeenByClient(t *testing.T) {
+	data, _ := json.Marshal(featureDisabledNack(FeatureDisappearingMessages))
+	var msg client.Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if feature, ok := msg.FeatureDisabled(); !ok || feature != FeatureDisappearingMessages {
+		t.Errorf("client FeatureDisabled = %q, %v, want %q", feature, ok, FeatureDisappearingMessages)
+	}
+	if _, ok := msg.Features(); ok {
+		t.Error("client Features accepted a nack")
+	}
+}
+
+func TestHandlePostMessageFeatureDisabled(t *testing.T) {
+	useMemoryStore(t)
+	saved := disappearingEnabled
+	disappearingEnabled = false
+	t.Cleanup(func() { disappearingEnabled = saved })
+
+	form := url.Values{"username": {"bob"}, "content": {"hi"}, "expires_in_seconds": {"60"}}
+	req := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := serveTestRequest("/messages", req, handlePostMessage)
+
+	var resp struct {
+		Code    string `json:"code"`
+		Feature string `json:"feature"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if rec.Code != http.StatusForbidden || resp.Code != errFeatureDisabled || resp.Feature != FeatureDisappearingMessages {
+		t.Errorf("got %d %s, want 403 %s for %s", rec.Code, rec.Body, errFeatureDisabled, FeatureDisappearingMessages)
+	}
+}
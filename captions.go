@@ -0,0 +1,47 @@
+// captions.go
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Length limits for attachment text, in characters
+const (
+	maxCaptionLength = 1000
+	maxAltTextLength = 500
+)
+
+// Clean up attachment text: control characters are removed, runs of
+// whitespace (including newlines) collapse to single spaces and the ends
+// are trimmed
+func sanitizeAttachmentText(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) && !unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, s)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// Read the optional caption and alt_text form fields of an upload. Responds
+// with 400 and returns false when either is too long.
+func attachmentText(c *gin.Context) (caption, altText string, ok bool) {
+	caption = sanitizeAttachmentText(c.PostForm("caption"))
+	altText = sanitizeAttachmentText(c.PostForm("alt_text"))
+	if n := utf8.RuneCountInString(caption); n > maxCaptionLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("caption must be at most %d characters", maxCaptionLength)})
+		return "", "", false
+	}
+	if n := utf8.RuneCountInString(altText); n > maxAltTextLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("alt_text must be at most %d characters", maxAltTextLength)})
+		return "", "", false
+	}
+	return caption, altText, true
+}
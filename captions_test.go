@@ -0,0 +1,79 @@
+// captions_test.go
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeAttachmentText(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain", "a sunset", "a sunset"},
+		{"trimmed", "  a sunset\n", "a sunset"},
+		{"newlines collapsed", "line one\n\nline two", "line one line two"},
+		{"tabs collapsed", "a\t\tb", "a b"},
+		{"control characters removed", "a\x00b\x1bc\u007fd", "abcd"},
+		{"only whitespace", " \n\t ", ""},
+		{"non-ASCII kept", "café 🌅", "café 🌅"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeAttachmentText(tt.in); got != tt.want {
+				t.Errorf("sanitizeAttachmentText(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUploadCaptionAndAltText(t *testing.T) {
+	useMemoryStore(t)
+	useUploadLimiter(t, 100, 100)
+	useFakeS3(t, bucketName)
+
+	tests := []struct {
+		name    string
+		caption string
+		altText string
+		code    int
+		error   string
+	}{
+		{"none", "", "", http.StatusOK, ""},
+		{"both", "  our\nteam ", "five people\tsmiling", http.StatusOK, ""},
+		{"caption at the limit in characters", strings.Repeat("é", maxCaptionLength), "", http.StatusOK, ""},
+		{"caption too long", strings.Repeat("a", maxCaptionLength+1), "", http.StatusBadRequest, "caption"},
+		{"alt text at the limit", "", strings.Repeat("a", maxAltTextLength), http.StatusOK, ""},
+		{"alt text too long", "", strings.Repeat("a", maxAltTextLength+1), http.StatusBadRequest, "alt_text"},
+		{"whitespace doesn't count", strings.Repeat("a ", maxCaptionLength/2) + strings.Repeat(" ", 50), "", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queue := useBroadcastQueue(t)
+			req := newUploadForm("/upload", map[string]string{"username": "alice", "room": "general", "caption": tt.caption, "alt_text": tt.altText}, "team.png", "\x89PNG\r\n\x1a\n")
+			rec := serveTestRequest("/upload", req, handleFileUpload)
+			if rec.Code != tt.code {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.code, rec.Body)
+			}
+			if tt.code != http.StatusOK {
+				if !strings.Contains(rec.Body.String(), tt.error) {
+					t.Errorf("body = %s, want it to name %s", rec.Body, tt.error)
+				}
+				if _, ok := queue.Pop(); ok {
+					t.Error("rejected upload was broadcast")
+				}
+				return
+			}
+			msg, ok := queue.Pop()
+			if !ok {
+				t.Fatal("upload was not broadcast")
+			}
+			if msg.Caption != sanitizeAttachmentText(tt.caption) || msg.AltText != sanitizeAttachmentText(tt.altText) {
+				t.Errorf("caption %q, alt text %q; want them sanitized", msg.Caption, msg.AltText)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+// challenge_test.go
+package main
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestConnectionChallenge(t *testing.T) {
+	tests := []struct {
+		name   string
+		answer func(challenge Message) *Message // nil sends nothing
+		want   string                           // close reason; empty when accepted
+	}{
+		{"correct answer", func(c Message) *Message {
+			return &Message{Type: MessageTypeChallengeResponse, Hash: challengeAnswer(c.Nonce, "Alice")}
+		}, ""},
+		{"wrong answer", func(c Message) *Message {
+			return &Message{Type: MessageTypeChallengeResponse, Hash: challengeAnswer(c.Nonce, "mallory")}
+		}, "wrong challenge response"},
+		{"not a response", func(c Message) *Message {
+			return &Message{Content: "hello"}
+		}, "expected challenge response"},
+		{"no answer in time", nil, "no challenge response"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := useWSServer(t)
+			savedEnabled, savedTimeout := challengeEnabled, challengeTimeout
+			challengeEnabled, challengeTimeout = true, 200*time.Millisecond
+			t.Cleanup(func() { challengeEnabled, challengeTimeout = savedEnabled, savedTimeout })
+
+			ws, _, err := server.dial(url.Values{"username": {"Alice"}, "room": {"general"}}.Encode())
+			if err != nil {
+				t.Fatalf("connecting: %v", err)
+			}
+			var challenge Message
+			if err := ws.ReadJSON(&challenge); err != nil {
+				t.Fatalf("reading challenge: %v", err)
+			}
+			if challenge.Type != MessageTypeChallenge || len(challenge.Nonce) != 64 || challenge.Algorithm != "sha256" {
+				t.Fatalf("challenge = %+v, want a sha256 challenge with a 32-byte nonce", challenge)
+			}
+			if tt.answer != nil {
+				if err := ws.WriteJSON(tt.answer(challenge)); err != nil {
+					t.Fatalf("answering: %v", err)
+				}
+			}
+
+			if tt.want == "" {
+				readUntil(t, ws, func(msg Message) bool { return strings.HasPrefix(msg.Content, "Welcome, ") })
+				return
+			}
+			start := time.Now()
+			ws.SetReadDeadline(time.Now().Add(3 * time.Second))
+			_, _, err = ws.ReadMessage()
+			var closeErr *websocket.CloseError
+			if !errors.As(err, &closeErr) {
+				t.Fatalf("read error = %v, want the connection closed", err)
+			}
+			if closeErr.Code != closeChallengeFailed || !strings.Contains(closeErr.Text, tt.want) {
+				t.Errorf("closed with %d %q, want %d %q", closeErr.Code, closeErr.Text, closeChallengeFailed, tt.want)
+			}
+			if tt.answer == nil && time.Since(start) > time.Second {
+				t.Errorf("silent client closed after %v, want after the 200ms timeout", time.Since(start))
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+// channels.go
+package main
+
+// Logical channels a connection opened with ?channels=true sees every
+// message tagged with, so clients can route them without knowing each
+// event type:
+//
+//   - content: chat messages and the events that change them (reactions,
+//     deletions, streamed messages, thread replies, link previews,
+//     download counts)
+//   - control: everything about the connection or the room rather than
+//     its messages (heartbeats, capabilities, acks and nacks,
+//     backpressure, slow mode, typing, presence, and System notices such
+//     as the welcome message and join/leave announcements)
+//
+// The challenge handshake happens before the connection is registered and
+// is never tagged, and neither are compact events (see compact.go), whose
+// channel follows from their type. client.Demux routes by the tag.
+const (
+	ChannelContent = "content"
+	ChannelControl = "control"
+)
+
+// Event types on the content channel; chat messages from users are too
+var contentEventTypes = map[string]bool{
+	MessageTypeReaction:      true,
+	MessageTypeFileDeleted:   true,
+	MessageTypeMessageDelete: true,
+	MessageTypeStreamStart:   true,
+	MessageTypeStreamChunk:   true,
+	MessageTypeStreamEnd:     true,
+	MessageTypeThreadReply:   true,
+	MessageTypeLinkPreview:   true,
+	MessageTypeDownloadCount: true,
+}
+
+// Return the channel a message belongs to
+func messageChannel(msg Message) string {
+	if msg.Type == "" {
+		if msg.Username == "System" {
+			return ChannelControl
+		}
+		return ChannelContent
+	}
+	if contentEventTypes[msg.Type] {
+		return ChannelContent
+	}
+	return ChannelControl
+}
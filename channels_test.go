@@ -0,0 +1,87 @@
+// channels_test.go
+package main
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"go-chat/client"
+)
+
+// Messages of every kind the server sends, with their channel
+var channelTests = []struct {
+	name string
+	msg  Message
+	want string
+}{
+	{"chat message", Message{Username: "alice", Content: "hi"}, ChannelContent},
+	{"System notice", Message{Username: "System", Content: "alice joined"}, ChannelControl},
+	{"reaction", Message{Type: MessageTypeReaction}, ChannelContent},
+	{"file deleted", Message{Type: MessageTypeFileDeleted}, ChannelContent},
+	{"message deleted", Message{Type: MessageTypeMessageDelete}, ChannelContent},
+	{"stream start", Message{Type: MessageTypeStreamStart}, ChannelContent},
+	{"stream chunk", Message{Type: MessageTypeStreamChunk}, ChannelContent},
+	{"stream end", Message{Type: MessageTypeStreamEnd}, ChannelContent},
+	{"thread reply", Message{Type: MessageTypeThreadReply}, ChannelContent},
+	{"link preview", Message{Type: MessageTypeLinkPreview}, ChannelContent},
+	{"download count", Message{Type: MessageTypeDownloadCount}, ChannelContent},
+	{"typing", Message{Type: MessageTypeTyping}, ChannelControl},
+	{"presence", Message{Type: MessageTypePresence}, ChannelControl},
+	{"backpressure", Message{Type: MessageTypeBackpressure}, ChannelControl},
+	{"heartbeat", Message{Type: MessageTypeHeartbeat}, ChannelControl},
+	{"capabilities", Message{Type: MessageTypeCapabilities}, ChannelControl},
+	{"server capabilities", Message{Type: MessageTypeServerCapabilities}, ChannelControl},
+	{"slow mode", Message{Type: MessageTypeSlowMode}, ChannelControl},
+	{"nack", Message{Type: MessageTypeNack}, ChannelControl},
+	{"ready", Message{Type: MessageTypeReady}, ChannelControl},
+	{"replay end", Message{Type: MessageTypeReplayEnd}, ChannelControl},
+	{"report", Message{Type: MessageTypeReport}, ChannelControl},
+	{"unknown type", Message{Type: "from_the_future"}, ChannelControl},
+}
+
+func TestMessageChannel(t *testing.T) {
+	for _, tt := range channelTests {
+		if got := messageChannel(tt.msg); got != tt.want {
+			t.Errorf("%s: channel = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestClientChannelMatchesServer(t *testing.T) {
+	// Untagged messages, e.g. compact events, are classified the same way
+	// by the client
+	for _, tt := range channelTests {
+		msg := client.Message{Type: tt.msg.Type, Username: tt.msg.Username}
+		if got := client.ChannelOf(msg); got != tt.want {
+			t.Errorf("%s: client channel = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestChannelTagging(t *testing.T) {
+	server := useWSServer(t)
+	tagged, _, err := server.dial(url.Values{"username": {"alice"}, "room": {"general"}, "channels": {"true"}}.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	untagged := server.connect("bob", "general")
+	waitConnected(t, "alice", "bob")
+
+	publish(Message{ID: "m1", Room: "general", Username: "carol", Content: "hi", Timestamp: time.Now()})
+	publish(Message{ID: "t1", Type: MessageTypeTyping, Room: "general", Username: "carol", Timestamp: time.Now()})
+	publish(Message{ID: "s1", Room: "general", Username: "System", Content: "carol set a topic", Timestamp: time.Now()})
+
+	// Events may overtake chat messages, so take them in any order
+	want := map[string]string{"m1": ChannelContent, "t1": ChannelControl, "s1": ChannelControl}
+	for len(want) > 0 {
+		msg := readUntil(t, tagged, func(msg Message) bool { return want[msg.ID] != "" })
+		if msg.Channel != want[msg.ID] {
+			t.Errorf("%s tagged %q, want %q", msg.ID, msg.Channel, want[msg.ID])
+		}
+		delete(want, msg.ID)
+	}
+	if msg := readUntil(t, untagged, func(msg Message) bool { return msg.ID == "m1" }); msg.Channel != "" {
+		t.Errorf("tagged %q without ?channels=true", msg.Channel)
+	}
+}
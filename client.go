@@ -0,0 +1,275 @@
+// client.go
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// Client represents a connected WebSocket client and its outbound message buffer
+type Client struct {
+	id          string // connection ID, unique per connection
+	conn        *websocket.Conn
+	username    string
+	room        string
+	connectedAt time.Time
+	moderator   bool // connected with the moderator or admin token
+	compact     bool // connected with ?encoding=compact; see compact.go
+	channels    bool // connected with ?channels=true; see channels.go
+	send        chan Message
+
+	// Messages of history to replay once the client is ready, and whether
+	// the replay has started; see replay.go
+	replayLimit   int
+	replayStarted atomic.Bool
+
+	// Why the server is closing the connection, sent in the close frame;
+	// set under clientsMu before the send buffer is closed. See closehints.go.
+	closing *closeHint
+
+	// Set (as UnixNano) when the server is shutting down; bounds how long the
+	// write pump may spend flushing the remaining buffered messages
+	flushDeadline atomic.Int64
+
+	// Set once the send buffer passes the high-water mark and cleared when
+	// it drains below the low-water mark
+	backpressured atomic.Bool
+	// Set while a slow_down signal waits in the send buffer; resuming
+	// before it is written would reach the client out of order
+	slowDownQueued atomic.Bool
+
+	// Sequence number of the last heartbeat the client acknowledged, when
+	// (as UnixNano) and the round-trip time last measured; see heartbeat.go
+	heartbeatAck atomic.Uint64
+	lastAckAt    atomic.Int64
+	roundTrip    atomic.Int64
+}
+
+// Client registry
+var (
+	clients      = make(map[*Client]bool) // connected clients
+	clientsMu    sync.Mutex               // guards clients and shuttingDown
+	clientsWG    sync.WaitGroup           // running write pumps
+	shuttingDown bool
+
+	sendBufferSize       int
+	shutdownFlushTimeout time.Duration
+
+	// Longest a single write may block before the client is considered
+	// stuck and disconnected; 0 disables the limit
+	writeTimeout time.Duration
+
+	// Send buffer depths that start and end backpressure for a client;
+	// a high-water mark below 1 disables backpressure signals
+	highWaterMark int
+	lowWaterMark  int
+)
+
+// How a connection asked to be served
+type clientOptions struct {
+	moderator bool // connected with the moderator or admin token
+	compact   bool // compact event encoding
+	channels  bool // tag messages with their logical channel
+	history   int  // recent messages to replay once the client is ready
+}
+
+// Create a client for the connection and start its write pump.
+// Returns nil if the server is shutting down.
+func addClient(conn *websocket.Conn, username, room string, opts clientOptions) *Client {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	if shuttingDown {
+		return nil
+	}
+
+	client := &Client{
+		id:          uuid.New().String(),
+		conn:        conn,
+		username:    username,
+		room:        room,
+		connectedAt: time.Now(),
+		moderator:   opts.moderator,
+		compact:     opts.compact,
+		channels:    opts.channels,
+		replayLimit: opts.history,
+		send:        make(chan Message, sendBufferSize),
+	}
+	// Heartbeats sent before the client connected don't count as missed
+	client.heartbeatAck.Store(heartbeatSeq.Load())
+	client.lastAckAt.Store(time.Now().UnixNano())
+	clients[client] = true
+	clientsWG.Add(1)
+	go client.writePump()
+	return client
+}
+
+// Unregister a client and close its send buffer; safe to call more than once
+func removeClient(client *Client) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	removeClientLocked(client)
+}
+
+// Same as removeClient; the caller must hold clientsMu
+func removeClientLocked(client *Client) {
+	if _, ok := clients[client]; ok {
+		delete(clients, client)
+		close(client.send)
+	}
+}
+
+// Queue a message for a client without blocking. A client whose buffer is
+// full is too slow to keep up and gets disconnected. The caller must hold clientsMu.
+//
+// Once the buffer passes the high-water mark the client is sent a
+// backpressure signal, and typing and presence events are dropped for it
+// until the buffer drains below the low-water mark.
+func queueMessageLocked(client *Client, msg Message) {
+	if client.backpressured.Load() && isCoalescable(msg.Type) {
+		return
+	}
+
+	select {
+	case client.send <- msg:
+	default:
+		log.Printf("Send buffer full for %s, disconnecting", client.username)
+		hint := transientClose(websocket.CloseTryAgainLater, CloseCodeSlowConsumer, "send buffer full", transientRetryAfter)
+		client.closing = &hint
+		removeClientLocked(client)
+		return
+	}
+
+	if highWaterMark > 0 && len(client.send) >= highWaterMark && client.backpressured.CompareAndSwap(false, true) {
+		client.slowDownQueued.Store(true)
+		select {
+		case client.send <- backpressureMessage("slow_down"):
+		default:
+			client.slowDownQueued.Store(false)
+		}
+	}
+}
+
+// Build a backpressure signal for a single client
+func backpressureMessage(state string) Message {
+	return Message{
+		Type:      MessageTypeBackpressure,
+		Username:  "System",
+		Content:   state,
+		Priority:  PriorityHigh,
+		Timestamp: time.Now(),
+	}
+}
+
+// Queue a message for a single client if it is still connected
+func queueMessage(client *Client, msg Message) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	if clients[client] {
+		queueMessageLocked(client, msg)
+	}
+}
+
+// Queue a message for every connection belonging to username
+func sendToUser(username string, msg Message) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	for client := range clients {
+		if client.username == username {
+			queueMessageLocked(client, msg)
+		}
+	}
+}
+
+// Write queued messages to the connection until the send buffer is closed
+func (c *Client) writePump() {
+	defer clientsWG.Done()
+	defer c.conn.Close()
+
+	for msg := range c.send {
+		msg.SchemaVersion = CurrentSchemaVersion
+		if err := c.writeJSON(msg); err != nil {
+			removeClient(c)
+			return
+		}
+
+		if msg.Type == MessageTypeBackpressure {
+			c.slowDownQueued.Store(false)
+		}
+
+		// Written straight to the connection: queueing the signal would put
+		// it behind the very backlog it reports as cleared
+		if len(c.send) <= lowWaterMark && !c.slowDownQueued.Load() && c.backpressured.CompareAndSwap(true, false) {
+			resume := backpressureMessage("resume")
+			resume.SchemaVersion = CurrentSchemaVersion
+			if err := c.writeJSON(resume); err != nil {
+				removeClient(c)
+				return
+			}
+		}
+	}
+
+	// Send buffer closed: say goodbye before closing the connection
+	if c.flushDeadline.Load() != 0 {
+		writeClose(c.conn, shutdownClose())
+	} else if c.closing != nil {
+		writeClose(c.conn, *c.closing)
+	} else {
+		c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	}
+}
+
+// Write a message to the connection, giving up after the write timeout or
+// the shutdown flush deadline, whichever comes first. A client that stops
+// reading fills the TCP buffers and would otherwise block the write forever.
+func (c *Client) writeJSON(msg Message) error {
+	var deadline time.Time
+	if writeTimeout > 0 {
+		deadline = time.Now().Add(writeTimeout)
+	}
+	if flush := c.flushDeadline.Load(); flush != 0 && (deadline.IsZero() || flush < deadline.UnixNano()) {
+		deadline = time.Unix(0, flush)
+	}
+	c.conn.SetWriteDeadline(deadline)
+
+	err := c.writeMessage(msg)
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		log.Printf("Closing connection %s for %s: write timed out", c.id, c.username)
+	} else if err != nil {
+		log.Printf("Error sending message: %v", err)
+	}
+	return err
+}
+
+// Stop accepting clients and flush every client's buffered messages, bounded by timeout
+func flushClients(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+
+	clientsMu.Lock()
+	shuttingDown = true
+	for client := range clients {
+		client.flushDeadline.Store(deadline.UnixNano())
+		removeClientLocked(client)
+	}
+	clientsMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		clientsWG.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Println("All client buffers flushed")
+	case <-time.After(time.Until(deadline) + time.Second):
+		log.Println("Warning: timed out flushing client buffers")
+	}
+}
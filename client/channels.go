@@ -0,0 +1,64 @@
+// channels.go
+
+package client
+
+// Logical channels of the server's messages. Content is chat messages and
+// the events that change them; control is everything about the connection
+// or the room, such as heartbeats, capabilities, typing, presence and
+// System notices.
+const (
+	ChannelContent = "content"
+	ChannelControl = "control"
+)
+
+// Event types on the content channel
+var contentEventTypes = map[string]bool{
+	"reaction":       true,
+	"file_deleted":   true,
+	"message_delete": true,
+	"stream_start":   true,
+	"stream_chunk":   true,
+	"stream_end":     true,
+	"thread_reply":   true,
+	"link_preview":   true,
+	"download_count": true,
+}
+
+// ChannelOf returns the channel of a message: the server's tag when the
+// connection was opened with Options.Channels, otherwise the same
+// classification worked out from the message type, so it also covers
+// compact events and servers that don't tag messages.
+func ChannelOf(msg Message) string {
+	if msg.Channel != "" {
+		return msg.Channel
+	}
+	if msg.Type == "" {
+		if msg.Username == "System" {
+			return ChannelControl
+		}
+		return ChannelContent
+	}
+	if contentEventTypes[msg.Type] {
+		return ChannelContent
+	}
+	return ChannelControl
+}
+
+// Demux returns a message handler that passes each message to control or
+// content by its channel; either may be nil to drop that channel.
+//
+//	c.OnMessage(client.Demux(
+//		func(msg client.Message) { log.Printf("control: %s", msg.Type) },
+//		func(msg client.Message) { fmt.Printf("%s: %s\n", msg.Username, msg.Content) },
+//	))
+func Demux(control, content func(Message)) func(Message) {
+	return func(msg Message) {
+		handler := content
+		if ChannelOf(msg) == ChannelControl {
+			handler = control
+		}
+		if handler != nil {
+			handler(msg)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+// channels_test.go
+
+package client
+
+import "testing"
+
+func TestChannelOf(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  Message
+		want string
+	}{
+		{"chat message", Message{Username: "alice", Content: "hi"}, ChannelContent},
+		{"System notice", Message{Username: "System", Content: "welcome"}, ChannelControl},
+		{"content event", Message{Type: "reaction"}, ChannelContent},
+		{"control event", Message{Type: "heartbeat"}, ChannelControl},
+		{"unknown event", Message{Type: "from_the_future"}, ChannelControl},
+		{"server tag wins", Message{Type: "from_the_future", Channel: ChannelContent}, ChannelContent},
+	}
+	for _, tt := range tests {
+		if got := ChannelOf(tt.msg); got != tt.want {
+			t.Errorf("%s: ChannelOf = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDemux(t *testing.T) {
+	var control, content []string
+	handle := Demux(
+		func(msg Message) { control = append(control, msg.ID) },
+		func(msg Message) { content = append(content, msg.ID) },
+	)
+	handle(Message{ID: "m1", Username: "alice", Content: "hi"})
+	handle(Message{ID: "h1", Type: "heartbeat"})
+	handle(Message{ID: "r1", Type: "reaction"})
+	handle(Message{ID: "x1", Type: "from_the_future", Channel: ChannelContent})
+	if len(control) != 1 || control[0] != "h1" {
+		t.Errorf("control got %v, want [h1]", control)
+	}
+	if len(content) != 3 {
+		t.Errorf("content got %v, want [m1 r1 x1]", content)
+	}
+
+	// A nil handler drops its channel
+	contentOnly := Demux(nil, func(msg Message) { content = append(content, msg.ID) })
+	contentOnly(Message{ID: "h2", Type: "heartbeat"})
+	contentOnly(Message{ID: "m2", Content: "hi"})
+	if last := content[len(content)-1]; len(content) != 4 || last != "m2" {
+		t.Errorf("content got %v, want m2 added and h2 dropped", content)
+	}
+}
@@ -0,0 +1,248 @@
+// chatclient.go
+
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// ChatClient is a client for driving the server in tests that makes
+// reconnects transparent: messages sent while disconnected are queued,
+// messages missed during an outage are caught up on, and messages whose
+// delivery wasn't confirmed are sent again.
+//
+// Catching up uses the server's history replay: each connection asks for
+// the last CatchUp messages of the room, and the ones the client has not
+// yet seen, by sequence number, are passed to OnMessage in order before
+// any live messages received meanwhile. Without history replay on the
+// server (HISTORY_REPLAY_MAX 0), nothing is caught up on.
+//
+// The server has no acknowledgments, so a chat message counts as
+// delivered once it comes back from the room, from Username with the same
+// content. Messages still unconfirmed after a reconnect are sent again, up
+// to MaxRetransmits times; one the server changes, e.g. through its word
+// filter, is never confirmed and is given up after that.
+//
+//	cc := &client.ChatClient{URL: "ws://localhost:8080", Username: "tester", Room: "general"}
+//	cc.OnMessage = func(msg client.Message) { log.Println(msg.Username, msg.Content) }
+//	if err := cc.Connect(ctx); err != nil {
+//		log.Fatal(err)
+//	}
+//	defer cc.Disconnect()
+type ChatClient struct {
+	URL      string // server base URL, as for Connect
+	Username string
+	Token    string // sent as a bearer token, e.g. the moderator token; optional
+	Room     string // the server's default room when empty
+
+	// Called for every message in order, without duplicates of stored
+	// messages; the server's replay bookkeeping is not passed on
+	OnMessage func(Message)
+
+	CatchUp        int // messages replayed after each connect (default 100)
+	MaxRetransmits int // times an unconfirmed message is sent again (default 3)
+
+	// Further connection settings; Room, History and the Authorization
+	// header are set from the fields above
+	Options Options
+
+	conn *Client
+
+	mu         sync.Mutex
+	generation int       // connections made so far
+	replaying  bool      // between connecting and the end of its replay
+	lastSeq    uint64    // newest stored message passed to OnMessage
+	held       []Message // live stored messages received while replaying
+	pending    []pendingMessage
+	lastID     int // of the pending messages
+}
+
+// A chat message sent but not yet seen coming back from the room
+type pendingMessage struct {
+	id         int
+	msg        Message
+	generation int // connection it was last sent on
+	sends      int // 0 until it is first sent
+}
+
+// Connect connects to the server; the first connection must succeed, and
+// after that the client reconnects on its own until Disconnect
+func (c *ChatClient) Connect(ctx context.Context) error {
+	if c.CatchUp <= 0 {
+		c.CatchUp = 100
+	}
+	if c.MaxRetransmits <= 0 {
+		c.MaxRetransmits = 3
+	}
+	opts := c.Options
+	opts.Room = c.Room
+	opts.History = c.CatchUp
+	opts.Header = opts.Header.Clone()
+	if c.Token != "" {
+		if opts.Header == nil {
+			opts.Header = http.Header{}
+		}
+		opts.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	conn, err := connect(ctx, c.URL, c.Username, &opts, []func(Message){c.handle})
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	return nil
+}
+
+// Send sends a message to the room. Chat messages are queued while the
+// client is reconnecting and sent again until they are seen in the room;
+// other messages, such as typing events, are sent at most once.
+func (c *ChatClient) Send(msg Message) error {
+	if c.conn == nil {
+		return ErrNotConnected
+	}
+	if msg.Type != "" {
+		return c.conn.Send(msg)
+	}
+
+	c.mu.Lock()
+	c.lastID++
+	id := c.lastID
+	c.pending = append(c.pending, pendingMessage{id: id, msg: msg})
+	generation := max(c.generation, 1) // the first connection is up
+	c.mu.Unlock()
+
+	err := c.conn.Send(msg)
+	if errors.Is(err, ErrNotConnected) {
+		return nil // sent once the client reconnects
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range c.pending {
+		if c.pending[i].id == id && c.pending[i].sends == 0 {
+			if err != nil {
+				c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			} else {
+				c.pending[i].generation = generation
+				c.pending[i].sends++
+			}
+			break
+		}
+	}
+	return err
+}
+
+// Disconnect closes the connection and stops reconnecting
+func (c *ChatClient) Disconnect() error {
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Close()
+}
+
+// Process a message from the connection
+func (c *ChatClient) handle(msg Message) {
+	switch {
+	case msg.Type == "capabilities":
+		// Sent once per connection, before its replay starts
+		c.mu.Lock()
+		c.generation++
+		c.replaying = true
+		c.mu.Unlock()
+		c.deliver(msg)
+
+	case msg.Type == "replay_end" || msg.Type == "nack" && msg.Code == "ERR_FEATURE_DISABLED" && msg.Reason == "history_replay":
+		// Without replay there is nothing to catch up on
+		c.mu.Lock()
+		c.replaying = false
+		if msg.Seq > c.lastSeq && c.generation == 1 {
+			// Messages from before the first connection are not caught up on
+			c.lastSeq = msg.Seq
+		}
+		held := c.held
+		c.held = nil
+		c.mu.Unlock()
+		for _, live := range held {
+			c.deliverStored(live)
+		}
+		c.retransmit()
+
+	case msg.Seq != 0 && msg.Type == "":
+		c.mu.Lock()
+		if msg.Replay && c.generation == 1 {
+			c.mu.Unlock()
+			return
+		}
+		if c.replaying && !msg.Replay {
+			c.held = append(c.held, msg)
+			c.mu.Unlock()
+			return
+		}
+		c.mu.Unlock()
+		c.deliverStored(msg)
+
+	default:
+		c.deliver(msg)
+	}
+}
+
+// Pass on a stored message unless it was already passed on, and confirm
+// it if it is one of the client's own
+func (c *ChatClient) deliverStored(msg Message) {
+	c.mu.Lock()
+	if msg.Seq <= c.lastSeq {
+		c.mu.Unlock()
+		return
+	}
+	c.lastSeq = msg.Seq
+	if msg.Username == c.Username {
+		for i, p := range c.pending {
+			if p.msg.Content == msg.Content && p.msg.FileURL == msg.FileURL {
+				c.pending = append(c.pending[:i], c.pending[i+1:]...)
+				break
+			}
+		}
+	}
+	c.mu.Unlock()
+	c.deliver(msg)
+}
+
+// Pass a message to OnMessage
+func (c *ChatClient) deliver(msg Message) {
+	if c.OnMessage != nil {
+		c.OnMessage(msg)
+	}
+}
+
+// Send again the messages not confirmed by the replay of this connection,
+// giving up on those sent too often already
+func (c *ChatClient) retransmit() {
+	c.mu.Lock()
+	generation := c.generation
+	var resend []Message
+	kept := c.pending[:0]
+	for _, p := range c.pending {
+		if p.generation == generation {
+			kept = append(kept, p)
+			continue
+		}
+		if p.sends > c.MaxRetransmits {
+			continue
+		}
+		p.generation = generation
+		p.sends++
+		kept = append(kept, p)
+		resend = append(resend, p.msg)
+	}
+	c.pending = kept
+	c.mu.Unlock()
+
+	for _, msg := range resend {
+		if err := c.conn.Send(msg); err != nil {
+			return // the next connection sends the rest
+		}
+	}
+}
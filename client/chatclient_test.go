@@ -0,0 +1,96 @@
+// chatclient_test.go
+
+package client
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Start a connection the way the server does with history replay: send
+// capabilities, wait for ready, then replay the stored messages
+func replayHistory(t *testing.T, conn *websocket.Conn, stored []Message) {
+	t.Helper()
+	conn.WriteJSON(Message{Type: "capabilities"})
+	readType(t, conn, "ready")
+	for _, msg := range stored {
+		msg.Replay = true
+		conn.WriteJSON(msg)
+	}
+	conn.WriteJSON(Message{Type: "replay_end", Seq: stored[len(stored)-1].Seq})
+}
+
+func TestChatClientReconnectCatchesUp(t *testing.T) {
+	old := Message{Seq: 1, Username: "bob", Content: "old"}
+	before := Message{Seq: 2, Username: "bob", Content: "before"}
+	missed := Message{Seq: 3, Username: "bob", Content: "missed"}
+	resent := make(chan Message, 2)
+	s := newTestServer(t, func(n int, conn *websocket.Conn, r *http.Request) {
+		if n == 1 {
+			replayHistory(t, conn, []Message{old})
+			conn.WriteJSON(before)
+			var msg Message
+			conn.ReadJSON(&msg)
+			return // drop the connection before storing the message
+		}
+		replayHistory(t, conn, []Message{old, before, missed})
+		for {
+			var msg Message
+			if conn.ReadJSON(&msg) != nil {
+				return
+			}
+			resent <- msg
+			conn.WriteJSON(Message{Seq: 4, Username: "alice", Content: msg.Content})
+		}
+	})
+
+	received := make(chan Message, 10)
+	cc := &ChatClient{
+		URL:      s.URL,
+		Username: "alice",
+		Options:  Options{MinReconnectDelay: 10 * time.Millisecond, MaxReconnectDelay: 50 * time.Millisecond},
+		OnMessage: func(msg Message) {
+			if msg.Type == "" {
+				received <- msg
+			}
+		},
+	}
+	if err := cc.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cc.Disconnect() })
+
+	if msg := receive(t, received); msg.Content != "before" {
+		t.Fatalf("first message %q, want the live one, not history from before connecting", msg.Content)
+	}
+	if err := cc.Send(Message{Content: "lost"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// After the reconnect the missed message is caught up on, without
+	// repeating the one already seen, and the unconfirmed send goes again
+	if msg := receive(t, resent); msg.Content != "lost" {
+		t.Errorf("resent %q, want the unconfirmed message", msg.Content)
+	}
+	if msg := receive(t, received); msg.Content != "missed" {
+		t.Errorf("caught up on %q, want the missed message", msg.Content)
+	}
+	if msg := receive(t, received); msg.Content != "lost" || msg.Username != "alice" {
+		t.Errorf("received %+v, want alice's resent message back from the room", msg)
+	}
+	cc.mu.Lock()
+	pending := len(cc.pending)
+	cc.mu.Unlock()
+	if pending != 0 {
+		t.Errorf("%d messages still pending, want the resent one confirmed", pending)
+	}
+	select {
+	case msg := <-resent:
+		t.Errorf("sent %q again after it was confirmed", msg.Content)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,407 @@
+// client.go
+
+// Package client is a Go client for the chat server's WebSocket protocol.
+// It handles the upgrade, keeps the connection alive with pings and
+// reconnects with backoff when the connection drops.
+//
+//	c, err := client.Connect(ctx, "ws://localhost:8080", "bot", &client.Options{Room: "general"})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer c.Close()
+//
+//	c.OnMessage(func(msg client.Message) {
+//		if msg.Type == "" && msg.Username != "bot" {
+//			c.Send(client.Message{Content: "echo: " + msg.Content, ReplyTo: msg.ID})
+//		}
+//	})
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrNotConnected is returned by Send while the client is reconnecting
+var ErrNotConnected = errors.New("client: not connected")
+
+// ErrClosed is returned by Send after Close
+var ErrClosed = errors.New("client: closed")
+
+// Message is a chat message or event as sent by the server. When sending,
+// only Type, Content, FileURL, FileName, ReplyTo, MessageID, StreamID and
+// ExpiresInSeconds are used; the server assigns the rest.
+type Message struct {
+	SchemaVersion    int               `json:"schemaVersion,omitempty"`
+	ID               string            `json:"id,omitempty"`
+	Type             string            `json:"type,omitempty"`
+	Room             string            `json:"room,omitempty"`
+	Seq              uint64            `json:"seq,omitempty"`
+	Username         string            `json:"username,omitempty"`
+	Content          string            `json:"content"`
+	FileURL          string            `json:"fileUrl,omitempty"`
+	FileName         string            `json:"fileName,omitempty"`
+	FileSize         int64             `json:"fileSize,omitempty"`
+	Caption          string            `json:"caption,omitempty"`
+	AltText          string            `json:"altText,omitempty"`
+	Timestamp        time.Time         `json:"timestamp,omitempty"`
+	ExpiresInSeconds int               `json:"expiresInSeconds,omitempty"`
+	ExpiresAt        *time.Time        `json:"expiresAt,omitempty"`
+	MessageID        string            `json:"messageId,omitempty"`
+	StreamID         string            `json:"streamId,omitempty"`
+	Reason           string            `json:"reason,omitempty"`
+	LatencyMs        float64           `json:"latencyMs,omitempty"`
+	ReplyTo          string            `json:"replyTo,omitempty"`
+	Reactions        map[string]int    `json:"reactions,omitempty"`
+	DownloadCount    int               `json:"downloadCount,omitempty"`
+	Count            int               `json:"count,omitempty"`
+	ExpandedContent  string            `json:"expandedContent,omitempty"`
+	Emoji            map[string]string `json:"emoji,omitempty"`
+	Nonce            string            `json:"nonce,omitempty"`
+	Algorithm        string            `json:"algorithm,omitempty"`
+	Hash             string            `json:"hash,omitempty"`
+	Signature        string            `json:"signature,omitempty"`
+	SignatureKeyID   string            `json:"signatureKeyId,omitempty"`
+	Channel          string            `json:"channel,omitempty"`
+	Replay           bool              `json:"replay,omitempty"`
+	Code             string            `json:"code,omitempty"`
+	// Raw capabilities of a "capabilities" or "server_capabilities"
+	// message; see Features
+	Capabilities json.RawMessage `json:"capabilities,omitempty"`
+}
+
+// Options configures a client; the zero value is usable
+type Options struct {
+	// Room to join; the server's default room when empty
+	Room string
+	// Ask the server for the compact encoding of typing, presence and
+	// reaction events, which Message decodes transparently
+	CompactEvents bool
+	// Ask the server to tag every message with its channel, content or
+	// control; see Demux
+	Channels bool
+	// Number of the room's recent messages the server replays after each
+	// connect, marked Replay and followed by a "replay_end" message; the
+	// client tells the server when it is ready for them
+	History int
+	// Extra headers sent with the upgrade request
+	Header http.Header
+	// Dialer used to connect; websocket.DefaultDialer when nil
+	Dialer *websocket.Dialer
+	// How often to ping the server (default 30s); the connection is
+	// considered dead if nothing arrives for twice this long
+	PingInterval time.Duration
+	// Delay before the first reconnect attempt (default 500ms), doubling
+	// up to MaxReconnectDelay (default 30s)
+	MinReconnectDelay time.Duration
+	MaxReconnectDelay time.Duration
+	// Called when the server closes the connection with a hint. The client
+	// waits at least the hint's RetryAfter before reconnecting, and stops
+	// reconnecting, as if closed, after a permanent close.
+	OnClose func(CloseHint)
+}
+
+// Client is a connection to the chat server that reconnects until closed
+type Client struct {
+	url      string
+	username string
+	opts     Options
+
+	mu       sync.Mutex
+	conn     *websocket.Conn // nil while reconnecting
+	handlers []func(Message)
+	closed   bool
+
+	writeMu sync.Mutex // serializes writes to conn
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// Connect dials the server at serverURL (ws://, wss://, http:// or https://,
+// without the /ws path) as username. The first connection must succeed;
+// after that the client reconnects on its own until Close is called.
+func Connect(ctx context.Context, serverURL, username string, opts *Options) (*Client, error) {
+	return connect(ctx, serverURL, username, opts, nil)
+}
+
+// Connect with handlers registered before the first message is read
+func connect(ctx context.Context, serverURL, username string, opts *Options, handlers []func(Message)) (*Client, error) {
+	c := &Client{username: username, handlers: handlers, done: make(chan struct{})}
+	if opts != nil {
+		c.opts = *opts
+	}
+	if c.opts.Dialer == nil {
+		c.opts.Dialer = websocket.DefaultDialer
+	}
+	if c.opts.PingInterval <= 0 {
+		c.opts.PingInterval = 30 * time.Second
+	}
+	if c.opts.MinReconnectDelay <= 0 {
+		c.opts.MinReconnectDelay = 500 * time.Millisecond
+	}
+	if c.opts.MaxReconnectDelay <= 0 {
+		c.opts.MaxReconnectDelay = 30 * time.Second
+	}
+
+	u, err := websocketURL(serverURL, username, c.opts)
+	if err != nil {
+		return nil, err
+	}
+	c.url = u
+
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	go c.run(runCtx, conn)
+	return c, nil
+}
+
+// Build the /ws URL for a server base URL
+func websocketURL(serverURL, username string, opts Options) (string, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return "", fmt.Errorf("client: invalid server URL: %w", err)
+	}
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	case "ws", "wss":
+	default:
+		return "", fmt.Errorf("client: unsupported URL scheme %q", u.Scheme)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/ws"
+
+	query := u.Query()
+	query.Set("username", username)
+	if opts.Room != "" {
+		query.Set("room", opts.Room)
+	}
+	if opts.CompactEvents {
+		query.Set("encoding", "compact")
+	}
+	if opts.Channels {
+		query.Set("channels", "true")
+	}
+	if opts.History > 0 {
+		query.Set("history", strconv.Itoa(opts.History))
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// OnMessage registers a handler called for every message received, in
+// order, from the client's read goroutine
+func (c *Client) OnMessage(handler func(Message)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handlers = append(c.handlers, handler)
+}
+
+// Send sends a message to the client's room. It returns ErrNotConnected
+// while the client is reconnecting.
+func (c *Client) Send(msg Message) error {
+	c.mu.Lock()
+	conn, closed := c.conn, c.closed
+	c.mu.Unlock()
+	if closed {
+		return ErrClosed
+	}
+	if conn == nil {
+		return ErrNotConnected
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(c.opts.PingInterval))
+	return conn.WriteJSON(msg)
+}
+
+// Close disconnects and stops reconnecting
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	conn := c.conn
+	c.mu.Unlock()
+
+	c.cancel()
+	var err error
+	if conn != nil {
+		c.writeMu.Lock()
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		c.writeMu.Unlock()
+		err = conn.Close()
+	}
+	<-c.done
+	return err
+}
+
+// Dial the server once
+func (c *Client) dial(ctx context.Context) (*websocket.Conn, error) {
+	conn, resp, err := c.opts.Dialer.DialContext(ctx, c.url, c.opts.Header)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("client: connecting: %w (HTTP %d)", err, resp.StatusCode)
+		}
+		return nil, fmt.Errorf("client: connecting: %w", err)
+	}
+	return conn, nil
+}
+
+// Read from the connection, reconnecting whenever it drops, until ctx is canceled
+func (c *Client) run(ctx context.Context, conn *websocket.Conn) {
+	defer close(c.done)
+	for {
+		err := c.serve(ctx, conn)
+
+		c.mu.Lock()
+		c.conn = nil
+		c.mu.Unlock()
+
+		delay := c.opts.MinReconnectDelay
+		if hint, ok := ParseCloseHint(err); ok {
+			if c.opts.OnClose != nil {
+				c.opts.OnClose(hint)
+			}
+			if hint.Permanent {
+				c.mu.Lock()
+				c.closed = true
+				c.mu.Unlock()
+				return
+			}
+			delay = max(delay, hint.RetryAfter)
+		}
+
+		conn = c.reconnect(ctx, delay)
+		if conn == nil {
+			return
+		}
+	}
+}
+
+// Read messages from one connection until it fails, pinging it meanwhile;
+// returns the error that ended it
+func (c *Client) serve(ctx context.Context, conn *websocket.Conn) error {
+	defer conn.Close()
+
+	deadline := 2 * c.opts.PingInterval
+	conn.SetReadDeadline(time.Now().Add(deadline))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(deadline))
+	})
+
+	stopPing := make(chan struct{})
+	defer close(stopPing)
+	go func() {
+		ticker := time.NewTicker(c.opts.PingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopPing:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				c.writeMu.Lock()
+				err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(c.opts.PingInterval))
+				c.writeMu.Unlock()
+				if err != nil {
+					conn.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		var msg Message
+		if err := conn.ReadJSON(&msg); err != nil {
+			return err
+		}
+		conn.SetReadDeadline(time.Now().Add(deadline))
+
+		// Acknowledge heartbeats so the server keeps the connection open,
+		// answer the connection challenge, and ask for history once the
+		// server has registered the connection
+		var reply *Message
+		switch msg.Type {
+		case "heartbeat":
+			reply = &Message{Type: "heartbeat_ack", Seq: msg.Seq}
+		case "challenge":
+			sum := sha256.Sum256([]byte(msg.Nonce + c.username))
+			reply = &Message{Type: "challenge_response", Hash: hex.EncodeToString(sum[:])}
+		case "capabilities":
+			if c.opts.History > 0 {
+				reply = &Message{Type: "ready"}
+			}
+		}
+		if reply != nil {
+			c.writeMu.Lock()
+			conn.SetWriteDeadline(time.Now().Add(c.opts.PingInterval))
+			err := conn.WriteJSON(reply)
+			c.writeMu.Unlock()
+			if err != nil {
+				return err
+			}
+		}
+
+		c.mu.Lock()
+		handlers := c.handlers
+		c.mu.Unlock()
+		for _, handler := range handlers {
+			handler(msg)
+		}
+	}
+}
+
+// Reconnect with exponential backoff, first waiting delay; returns nil
+// once ctx is canceled
+func (c *Client) reconnect(ctx context.Context, delay time.Duration) *websocket.Conn {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+
+		conn, err := c.dial(ctx)
+		if err == nil {
+			c.mu.Lock()
+			if c.closed {
+				c.mu.Unlock()
+				conn.Close()
+				return nil
+			}
+			c.conn = conn
+			c.mu.Unlock()
+			return conn
+		}
+
+		delay *= 2
+		if delay > c.opts.MaxReconnectDelay {
+			delay = c.opts.MaxReconnectDelay
+		}
+	}
+}
@@ -0,0 +1,243 @@
+// client_test.go
+
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// testServer is a WebSocket server standing in for the chat server; each
+// connection is handed to serve along with its upgrade request
+type testServer struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	conns int
+}
+
+// Start a test server that runs serve for every connection
+func newTestServer(t *testing.T, serve func(n int, conn *websocket.Conn, r *http.Request)) *testServer {
+	t.Helper()
+	s := &testServer{}
+	upgrader := websocket.Upgrader{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		s.mu.Lock()
+		s.conns++
+		n := s.conns
+		s.mu.Unlock()
+		serve(n, conn, r)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Number of connections accepted so far
+func (s *testServer) connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns
+}
+
+// Connect a client that reconnects quickly, closing it when the test ends
+func connectTest(t *testing.T, s *testServer, opts *Options, handlers ...func(Message)) *Client {
+	t.Helper()
+	if opts == nil {
+		opts = &Options{}
+	}
+	opts.MinReconnectDelay = 10 * time.Millisecond
+	opts.MaxReconnectDelay = 50 * time.Millisecond
+	c, err := connect(context.Background(), s.URL, "bot", opts, handlers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// Read messages from conn until one of the given type arrives
+func readType(t *testing.T, conn *websocket.Conn, msgType string) Message {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var msg Message
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Errorf("waiting for %q: %v", msgType, err)
+			return Message{}
+		}
+		if msg.Type == msgType {
+			return msg
+		}
+	}
+}
+
+// Wait for a value on ch or fail the test
+func receive[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out")
+	}
+	var zero T
+	return zero
+}
+
+func TestWebsocketURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		server  string
+		opts    Options
+		want    string
+		wantErr bool
+	}{
+		{"http", "http://chat.example", Options{}, "ws://chat.example/ws?username=bot", false},
+		{"https with path", "https://chat.example/api/", Options{Room: "dev"}, "wss://chat.example/api/ws?room=dev&username=bot", false},
+		{"ws with options", "ws://chat.example", Options{CompactEvents: true, Channels: true, History: 20}, "ws://chat.example/ws?channels=true&encoding=compact&history=20&username=bot", false},
+		{"unsupported scheme", "ftp://chat.example", Options{}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := websocketURL(tt.server, "bot", tt.opts)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("websocketURL(%q) = %q, %v; want %q", tt.server, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientSendAndReceive(t *testing.T) {
+	queries := make(chan url.Values, 1)
+	sent := make(chan Message, 1)
+	s := newTestServer(t, func(n int, conn *websocket.Conn, r *http.Request) {
+		queries <- r.URL.Query()
+		conn.WriteJSON(Message{ID: "m1", Username: "alice", Content: "hello"})
+		var msg Message
+		if conn.ReadJSON(&msg) == nil {
+			sent <- msg
+		}
+		conn.ReadMessage() // until the client closes
+	})
+
+	received := make(chan Message, 1)
+	c := connectTest(t, s, &Options{Room: "dev"}, func(msg Message) { received <- msg })
+
+	if query := receive(t, queries); query.Get("username") != "bot" || query.Get("room") != "dev" {
+		t.Errorf("connected with query %v, want username bot in room dev", query)
+	}
+	if msg := receive(t, received); msg.ID != "m1" || msg.Content != "hello" {
+		t.Errorf("received %+v, want the server's message", msg)
+	}
+	if err := c.Send(Message{Content: "hi", ReplyTo: "m1"}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := receive(t, sent); msg.Content != "hi" || msg.ReplyTo != "m1" {
+		t.Errorf("server got %+v, want the reply", msg)
+	}
+}
+
+func TestClientAnswersProtocolMessages(t *testing.T) {
+	replies := make(chan Message, 3)
+	s := newTestServer(t, func(n int, conn *websocket.Conn, r *http.Request) {
+		conn.WriteJSON(Message{Type: "challenge", Nonce: "abc"})
+		replies <- readType(t, conn, "challenge_response")
+		conn.WriteJSON(Message{Type: "heartbeat", Seq: 7})
+		replies <- readType(t, conn, "heartbeat_ack")
+		conn.WriteJSON(Message{Type: "capabilities"})
+		replies <- readType(t, conn, "ready")
+		conn.ReadMessage()
+	})
+	connectTest(t, s, &Options{History: 10})
+
+	sum := sha256.Sum256([]byte("abc" + "bot"))
+	if reply := receive(t, replies); reply.Hash != hex.EncodeToString(sum[:]) {
+		t.Errorf("challenge answered with %q, want the SHA-256 of nonce and username", reply.Hash)
+	}
+	if reply := receive(t, replies); reply.Seq != 7 {
+		t.Errorf("heartbeat acknowledged with seq %d, want 7", reply.Seq)
+	}
+	if reply := receive(t, replies); reply.Type != "ready" {
+		t.Errorf("capabilities answered with %q, want ready", reply.Type)
+	}
+}
+
+func TestClientReconnects(t *testing.T) {
+	received := make(chan Message, 2)
+	s := newTestServer(t, func(n int, conn *websocket.Conn, r *http.Request) {
+		conn.WriteJSON(Message{ID: "m", Seq: uint64(n)})
+		if n == 1 {
+			return // drop the first connection
+		}
+		conn.ReadMessage()
+	})
+	connectTest(t, s, nil, func(msg Message) { received <- msg })
+
+	if msg := receive(t, received); msg.Seq != 1 {
+		t.Fatalf("first message from connection %d, want 1", msg.Seq)
+	}
+	if msg := receive(t, received); msg.Seq != 2 {
+		t.Errorf("message after the drop from connection %d, want 2", msg.Seq)
+	}
+}
+
+func TestClientStopsAfterPermanentClose(t *testing.T) {
+	s := newTestServer(t, func(n int, conn *websocket.Conn, r *http.Request) {
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, `{"code":"room_deleted","reason":"room deleted","permanent":true}`))
+		conn.ReadMessage()
+	})
+	hints := make(chan CloseHint, 1)
+	c := connectTest(t, s, &Options{OnClose: func(hint CloseHint) { hints <- hint }})
+
+	if hint := receive(t, hints); hint.Code != "room_deleted" || !hint.Permanent {
+		t.Errorf("close hint %+v, want a permanent room_deleted", hint)
+	}
+	<-c.done
+	if err := c.Send(Message{Content: "hi"}); !errors.Is(err, ErrClosed) {
+		t.Errorf("Send after a permanent close = %v, want ErrClosed", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := s.connections(); n != 1 {
+		t.Errorf("client connected %d times, want no reconnect", n)
+	}
+}
+
+func TestClientWaitsForRetryAfter(t *testing.T) {
+	connected := make(chan time.Time, 2)
+	s := newTestServer(t, func(n int, conn *websocket.Conn, r *http.Request) {
+		connected <- time.Now()
+		if n == 1 {
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, `{"code":"slow_consumer","retryAfterMs":200}`))
+		}
+		conn.ReadMessage()
+	})
+	connectTest(t, s, nil)
+
+	first := receive(t, connected)
+	if wait := receive(t, connected).Sub(first); wait < 200*time.Millisecond {
+		t.Errorf("reconnected after %v, want at least the hinted 200ms", wait)
+	}
+}
+
+func TestConnectFails(t *testing.T) {
+	s := httptest.NewServer(http.NotFoundHandler())
+	defer s.Close()
+	if _, err := Connect(context.Background(), s.URL, "bot", nil); err == nil {
+		t.Error("Connect to a server without /ws succeeded")
+	}
+}
@@ -0,0 +1,49 @@
+// closehint.go
+
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// CloseHint is the server's explanation of why it closed a connection,
+// sent in the close frame. A permanent close means connecting again the
+// same way will fail again, e.g. because the room was deleted; after any
+// other close the client waits at least RetryAfter before reconnecting.
+type CloseHint struct {
+	Status     int    // WebSocket close status code
+	Code       string // e.g. "shutdown", "slow_consumer", "room_deleted"
+	Reason     string
+	Permanent  bool
+	RetryAfter time.Duration
+}
+
+// ParseCloseHint extracts the server's close hint from the error that
+// ended a connection. It reports false if the server didn't close the
+// connection or sent no hint, as it doesn't when CLOSE_HINTS is off.
+func ParseCloseHint(err error) (CloseHint, bool) {
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) {
+		return CloseHint{}, false
+	}
+	var payload struct {
+		Code         string `json:"code"`
+		Reason       string `json:"reason"`
+		Permanent    bool   `json:"permanent"`
+		RetryAfterMs int64  `json:"retryAfterMs"`
+	}
+	if json.Unmarshal([]byte(closeErr.Text), &payload) != nil || payload.Code == "" {
+		return CloseHint{}, false
+	}
+	return CloseHint{
+		Status:     closeErr.Code,
+		Code:       payload.Code,
+		Reason:     payload.Reason,
+		Permanent:  payload.Permanent,
+		RetryAfter: time.Duration(payload.RetryAfterMs) * time.Millisecond,
+	}, true
+}
@@ -0,0 +1,60 @@
+// closehint_test.go
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestParseCloseHint(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want CloseHint
+		ok   bool
+	}{
+		{
+			name: "transient",
+			err:  &websocket.CloseError{Code: websocket.CloseGoingAway, Text: `{"code":"shutdown","reason":"server shutting down","retryAfterMs":5000}`},
+			want: CloseHint{Status: websocket.CloseGoingAway, Code: "shutdown", Reason: "server shutting down", RetryAfter: 5 * time.Second},
+			ok:   true,
+		},
+		{
+			name: "permanent",
+			err:  &websocket.CloseError{Code: websocket.ClosePolicyViolation, Text: `{"code":"room_deleted","reason":"room deleted","permanent":true}`},
+			want: CloseHint{Status: websocket.ClosePolicyViolation, Code: "room_deleted", Reason: "room deleted", Permanent: true},
+			ok:   true,
+		},
+		{
+			name: "wrapped",
+			err:  fmt.Errorf("read: %w", &websocket.CloseError{Code: websocket.CloseTryAgainLater, Text: `{"code":"slow_consumer","retryAfterMs":1000}`}),
+			want: CloseHint{Status: websocket.CloseTryAgainLater, Code: "slow_consumer", RetryAfter: time.Second},
+			ok:   true,
+		},
+		{
+			name: "plain reason",
+			err:  &websocket.CloseError{Code: websocket.ClosePolicyViolation, Text: "invalid room"},
+		},
+		{
+			name: "JSON without a code",
+			err:  &websocket.CloseError{Code: websocket.CloseNormalClosure, Text: `{"reason":"bye"}`},
+		},
+		{
+			name: "not a close",
+			err:  errors.New("connection reset by peer"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ParseCloseHint(tt.err)
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("ParseCloseHint = %+v, %v, want %+v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
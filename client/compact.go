@@ -0,0 +1,43 @@
+// compact.go
+
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// UnmarshalJSON decodes a message in either of the server's encodings: a
+// JSON object, or the array that connections with Options.CompactEvents
+// receive for typing, presence and reaction events:
+//
+//	[type, room, username, timestamp, messageId, content, reactions, emoji]
+//
+// where timestamp is in Unix milliseconds and trailing empty elements may
+// be left out.
+func (m *Message) UnmarshalJSON(data []byte) error {
+	type plain Message // without this method, to avoid recursion
+	data = bytes.TrimLeft(data, " \t\r\n")
+	if len(data) == 0 || data[0] != '[' {
+		return json.Unmarshal(data, (*plain)(m))
+	}
+
+	var fields []json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	if len(fields) < 4 {
+		return fmt.Errorf("client: compact event has %d elements, want at least 4", len(fields))
+	}
+	var timestamp int64
+	targets := []any{&m.Type, &m.Room, &m.Username, &timestamp, &m.MessageID, &m.Content, &m.Reactions, &m.Emoji}
+	for i, field := range fields[:min(len(fields), len(targets))] {
+		if err := json.Unmarshal(field, targets[i]); err != nil {
+			return fmt.Errorf("client: compact event element %d: %w", i, err)
+		}
+	}
+	m.Timestamp = time.UnixMilli(timestamp)
+	return nil
+}
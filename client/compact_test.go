@@ -0,0 +1,61 @@
+// compact_test.go
+
+package client
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestUnmarshalCompactEvent(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want Message
+		err  bool
+	}{
+		{"object", `{"id":"m1","type":"","room":"general","username":"alice","content":"hi"}`,
+			Message{ID: "m1", Room: "general", Username: "alice", Content: "hi"}, false},
+		{"shortest event", `["typing","general","alice",1700000000123]`,
+			Message{Type: "typing", Room: "general", Username: "alice", Timestamp: time.UnixMilli(1700000000123)}, false},
+		{"leading whitespace", " \n[\"typing\",\"general\",\"alice\",0]",
+			Message{Type: "typing", Room: "general", Username: "alice", Timestamp: time.UnixMilli(0)}, false},
+		{"every element", `["reaction","general","bob",1,"m1","👍",{"👍":2},{":parrot:":"/emoji/parrot"}]`,
+			Message{Type: "reaction", Room: "general", Username: "bob", Timestamp: time.UnixMilli(1), MessageID: "m1", Content: "👍",
+				Reactions: map[string]int{"👍": 2}, Emoji: map[string]string{":parrot:": "/emoji/parrot"}}, false},
+		{"elements added later are ignored", `["typing","general","alice",1,"","",{},{},"future"]`,
+			Message{Type: "typing", Room: "general", Username: "alice", Timestamp: time.UnixMilli(1), Reactions: map[string]int{}, Emoji: map[string]string{}}, false},
+		{"too short", `["typing","general","alice"]`, Message{}, true},
+		{"wrong element type", `["typing","general","alice","yesterday"]`, Message{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Message
+			err := json.Unmarshal([]byte(tt.data), &got)
+			if (err != nil) != tt.err {
+				t.Fatalf("err = %v, want error %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if !got.Timestamp.Equal(tt.want.Timestamp) {
+				t.Errorf("timestamp = %v, want %v", got.Timestamp, tt.want.Timestamp)
+			}
+			got.Timestamp, tt.want.Timestamp = time.Time{}, time.Time{}
+			if gotJSON, wantJSON := mustJSON(t, got), mustJSON(t, tt.want); gotJSON != wantJSON {
+				t.Errorf("decoded %s, want %s", gotJSON, wantJSON)
+			}
+		})
+	}
+}
+
+// Encode v as JSON for comparing messages
+func mustJSON(t *testing.T, v any) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
@@ -0,0 +1,28 @@
+// features.go
+
+package client
+
+import "encoding/json"
+
+// Features returns the optional features listed in a "server_capabilities"
+// message, which the server sends first on every connection, and whether
+// each is enabled. It reports false for any other message.
+func (m Message) Features() (map[string]bool, bool) {
+	if m.Type != "server_capabilities" {
+		return nil, false
+	}
+	var features map[string]bool
+	if json.Unmarshal(m.Capabilities, &features) != nil {
+		return nil, false
+	}
+	return features, true
+}
+
+// FeatureDisabled reports whether m is the server refusing a message that
+// needs a disabled feature, and which feature
+func (m Message) FeatureDisabled() (string, bool) {
+	if m.Type != "nack" || m.Code != "ERR_FEATURE_DISABLED" {
+		return "", false
+	}
+	return m.Reason, true
+}
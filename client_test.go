@@ -0,0 +1,214 @@
+// client_test.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Find the connected client for a username, waiting briefly for it to register
+func clientFor(t *testing.T, username string) *Client {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		clientsMu.Lock()
+		for client := range clients {
+			if client.username == username {
+				clientsMu.Unlock()
+				return client
+			}
+		}
+		clientsMu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s never connected", username)
+	return nil
+}
+
+// Read chat messages until the connection closes, returning their
+// contents and the close error
+func readUntilClose(t *testing.T, ws *websocket.Conn) ([]string, *websocket.CloseError) {
+	t.Helper()
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var contents []string
+	for {
+		var msg Message
+		err := ws.ReadJSON(&msg)
+		var closeErr *websocket.CloseError
+		if errors.As(err, &closeErr) {
+			return contents, closeErr
+		}
+		if err != nil {
+			t.Fatalf("reading: %v", err)
+		}
+		if msg.Type == "" && msg.Username != "System" {
+			contents = append(contents, msg.Content)
+		}
+	}
+}
+
+func TestFlushClientsDeliversQueuedMessages(t *testing.T) {
+	server := useWSServer(t)
+	clientsMu.Lock()
+	savedShuttingDown := shuttingDown
+	clientsMu.Unlock()
+	t.Cleanup(func() {
+		clientsMu.Lock()
+		shuttingDown = savedShuttingDown
+		clientsMu.Unlock()
+	})
+
+	ws := server.connect("alice", "general")
+	client := clientFor(t, "alice")
+
+	// Queued while the client isn't reading, so they are still buffered
+	// when shutdown starts
+	const queued = 100
+	for i := range queued {
+		queueMessage(client, Message{Username: "bob", Content: fmt.Sprintf("message %d", i)})
+	}
+	flushClients(2 * time.Second)
+
+	contents, closeErr := readUntilClose(t, ws)
+	if len(contents) != queued {
+		t.Fatalf("got %d messages before the close frame, want %d", len(contents), queued)
+	}
+	for i, content := range contents {
+		if want := fmt.Sprintf("message %d", i); content != want {
+			t.Errorf("message %d = %q, want %q", i, content, want)
+		}
+	}
+	if closeErr.Code != websocket.CloseGoingAway {
+		t.Errorf("close status = %d, want %d (going away)", closeErr.Code, websocket.CloseGoingAway)
+	}
+
+	// Connections made after shutdown starts are turned away
+	late := server.connect("carol", "general")
+	if _, closeErr := readUntilClose(t, late); closeErr.Code != websocket.CloseGoingAway {
+		t.Errorf("late client close status = %d, want %d (going away)", closeErr.Code, websocket.CloseGoingAway)
+	}
+}
+
+func TestWriteTimeoutDisconnectsStuckClient(t *testing.T) {
+	server := useWSServer(t)
+	logs := captureLog(t)
+	savedTimeout, savedBuffer, savedHigh := writeTimeout, sendBufferSize, highWaterMark
+	// A buffer deep enough that the stuck client times out before it fills
+	writeTimeout, sendBufferSize, highWaterMark = 200*time.Millisecond, 10000, 0
+	t.Cleanup(func() { writeTimeout, sendBufferSize, highWaterMark = savedTimeout, savedBuffer, savedHigh })
+
+	server.connect("stuck", "general") // never reads
+	stuck := clientFor(t, "stuck")
+	ws := server.connect("alice", "general")
+	clientFor(t, "alice")
+
+	// Enough data to fill the stuck client's socket buffers
+	const count = 400
+	big := strings.Repeat("x", 64<<10)
+	go func() {
+		for i := range count {
+			publish(Message{Room: "general", Username: "System", Content: fmt.Sprintf("%d %s", i, big)})
+		}
+	}()
+
+	// The reading client gets every message while the stuck one blocks
+	readUntil(t, ws, func(msg Message) bool { return strings.HasPrefix(msg.Content, fmt.Sprint(count-1, " ")) })
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		clientsMu.Lock()
+		connected := clients[stuck]
+		clientsMu.Unlock()
+		if !connected {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("stuck client still connected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !strings.Contains(logs.String(), "stuck: write timed out") {
+		t.Errorf("log = %q, want a write timeout for the stuck client", logs.String())
+	}
+}
+
+func TestSlowClientBackpressure(t *testing.T) {
+	savedHigh, savedLow := highWaterMark, lowWaterMark
+	highWaterMark, lowWaterMark = 4, 2
+	t.Cleanup(func() { highWaterMark, lowWaterMark = savedHigh, savedLow })
+	// No write pump: nothing drains the buffer, like a client that stopped reading
+	client := &Client{username: "slow", send: make(chan Message, 8)}
+	useClients(t, client)
+
+	for i := range 4 {
+		queueMessage(client, Message{Content: fmt.Sprint(i)})
+	}
+	if !client.backpressured.Load() {
+		t.Fatal("client not backpressured at the high-water mark")
+	}
+	queueMessage(client, Message{Type: MessageTypeTyping, Username: "bob"}) // dropped while backpressured
+	for i := 4; i < 7; i++ {
+		queueMessage(client, Message{Content: fmt.Sprint(i)})
+	}
+
+	// The buffer is full; one more message disconnects the client
+	queueMessage(client, Message{Content: "overflow"})
+	clientsMu.Lock()
+	connected := clients[client]
+	clientsMu.Unlock()
+	if connected {
+		t.Fatal("client still connected with a full buffer")
+	}
+	if client.closing == nil || client.closing.Code != CloseCodeSlowConsumer {
+		t.Errorf("close hint = %+v, want %s", client.closing, CloseCodeSlowConsumer)
+	}
+
+	var got []string
+	for msg := range client.send {
+		if msg.Type == MessageTypeBackpressure {
+			got = append(got, "backpressure:"+msg.Content)
+		} else {
+			got = append(got, msg.Type+msg.Content)
+		}
+	}
+	want := "0 1 2 3 backpressure:slow_down 4 5 6"
+	if strings.Join(got, " ") != want {
+		t.Errorf("buffered %q, want %q", strings.Join(got, " "), want)
+	}
+}
+
+func TestBackpressureResumesWhenDrained(t *testing.T) {
+	savedHigh, savedLow := highWaterMark, lowWaterMark
+	highWaterMark, lowWaterMark = 4, 2
+	t.Cleanup(func() { highWaterMark, lowWaterMark = savedHigh, savedLow })
+	serverConn, ws, _ := wsPair(t, websocket.Upgrader{}, websocket.Dialer{})
+	client := &Client{conn: serverConn, username: "slow", send: make(chan Message, 8)}
+	useClients(t, client)
+
+	for i := range 4 {
+		queueMessage(client, Message{Content: fmt.Sprint(i)})
+	}
+	clientsWG.Add(1)
+	go client.writePump()
+	t.Cleanup(func() { removeClient(client) })
+
+	var got []string
+	for len(got) < 6 {
+		msg := readUntil(t, ws, func(Message) bool { return true })
+		got = append(got, msg.Type+":"+msg.Content)
+	}
+	// Resumes once drained to the low-water mark, but never before the
+	// slow_down signal queued behind the backlog has been sent
+	want := ":0 :1 :2 :3 " + MessageTypeBackpressure + ":slow_down " + MessageTypeBackpressure + ":resume"
+	if strings.Join(got, " ") != want {
+		t.Errorf("received %q, want %q", strings.Join(got, " "), want)
+	}
+	if client.backpressured.Load() {
+		t.Error("client still backpressured after draining")
+	}
+}
@@ -0,0 +1,118 @@
+// closehints.go
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+)
+
+// Why the server closed a connection, sent as the code of a close hint.
+// Transient closes carry a retryAfterMs suggesting how long to wait before
+// reconnecting; permanent ones mean reconnecting the same way will fail
+// again.
+const (
+	// Transient
+	CloseCodeShutdown     = "shutdown"       // the server is restarting or stopping
+	CloseCodeSlowConsumer = "slow_consumer"  // the send buffer filled up
+	CloseCodeStale        = "stale"          // heartbeats went unacknowledged
+	CloseCodeInternal     = "internal_error" // the connection hit a server bug
+	CloseCodeKicked       = "kicked"         // an admin closed the connection
+
+	// Permanent
+	CloseCodeBadRequest      = "bad_request"      // invalid connection parameters
+	CloseCodeChallengeFailed = "challenge_failed" // wrong or missing challenge response
+	CloseCodeRoomDeleted     = "room_deleted"     // the room no longer exists
+)
+
+// closeHint describes a close for the client. With close hints on, it is
+// sent as the close frame's reason in JSON, e.g.
+//
+//	{"code":"shutdown","reason":"server shutting down","retryAfterMs":5000}
+//	{"code":"room_deleted","reason":"room deleted","permanent":true}
+//
+// The WebSocket status code is unchanged, so clients that don't read hints
+// behave as before.
+type closeHint struct {
+	status int // WebSocket close status code
+
+	Code         string `json:"code"`
+	Reason       string `json:"reason,omitempty"`
+	Permanent    bool   `json:"permanent,omitempty"`
+	RetryAfterMs int64  `json:"retryAfterMs,omitempty"`
+}
+
+// Close hint settings
+var (
+	closeHints bool // send hints as JSON; off sends only the plain reason
+
+	// Suggested waits before reconnecting
+	shutdownRetryAfter  time.Duration
+	transientRetryAfter time.Duration // slow consumers, stale connections and internal errors
+	kickedRetryAfter    time.Duration
+)
+
+// Initialize close hints from environment variables
+func initCloseHints() {
+	closeHints = getEnvBool("CLOSE_HINTS", true)
+	shutdownRetryAfter = closeRetryAfterSetting("CLOSE_RETRY_AFTER_SHUTDOWN_MS", 5000)
+	transientRetryAfter = closeRetryAfterSetting("CLOSE_RETRY_AFTER_MS", 1000)
+	kickedRetryAfter = closeRetryAfterSetting("CLOSE_RETRY_AFTER_KICKED_MS", 30000)
+}
+
+// Read a retry-after setting in milliseconds
+func closeRetryAfterSetting(key string, def int) time.Duration {
+	ms := getEnvInt(key, def)
+	if ms < 0 {
+		log.Printf("Warning: %s must not be negative, using %d", key, def)
+		ms = def
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// Hint for a close the client may retry after wait
+func transientClose(status int, code, reason string, wait time.Duration) closeHint {
+	return closeHint{status: status, Code: code, Reason: reason, RetryAfterMs: max(wait.Milliseconds(), 1)}
+}
+
+// Hint for a close that retrying won't fix
+func permanentClose(status int, code, reason string) closeHint {
+	return closeHint{status: status, Code: code, Reason: reason, Permanent: true}
+}
+
+// Hint for closing connections because the server is shutting down
+func shutdownClose() closeHint {
+	return transientClose(websocket.CloseGoingAway, CloseCodeShutdown, "server shutting down", shutdownRetryAfter)
+}
+
+// Hint for rejecting invalid connection parameters
+func badRequestClose(reason string) closeHint {
+	return permanentClose(websocket.ClosePolicyViolation, CloseCodeBadRequest, reason)
+}
+
+// Encode a hint as a close frame payload, shortening the reason to fit the
+// frame if need be
+func (h closeHint) message() []byte {
+	if !closeHints {
+		return websocket.FormatCloseMessage(h.status, truncateCloseReason(h.Reason))
+	}
+	text, _ := json.Marshal(h)
+	for len(text) > maxCloseReasonBytes && h.Reason != "" {
+		over := len(text) - maxCloseReasonBytes
+		reason := h.Reason[:max(len(h.Reason)-over, 0)]
+		for !utf8.ValidString(reason) {
+			reason = reason[:len(reason)-1]
+		}
+		h.Reason = reason
+		text, _ = json.Marshal(h)
+	}
+	return websocket.FormatCloseMessage(h.status, string(text))
+}
+
+// Send a close frame with a hint
+func writeClose(ws *websocket.Conn, hint closeHint) {
+	ws.WriteControl(websocket.CloseMessage, hint.message(), time.Now().Add(time.Second))
+}
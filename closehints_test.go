@@ -0,0 +1,104 @@
+// closehints_test.go
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+)
+
+// Split a close frame payload into its status code and text
+func parseCloseMessage(t *testing.T, payload []byte) (int, string) {
+	t.Helper()
+	if len(payload) < 2 {
+		t.Fatalf("close payload %q has no status code", payload)
+	}
+	if len(payload) > 125 {
+		t.Errorf("close payload is %d bytes, more than a control frame holds", len(payload))
+	}
+	return int(binary.BigEndian.Uint16(payload)), string(payload[2:])
+}
+
+func TestCloseHintMessage(t *testing.T) {
+	saved := closeHints
+	t.Cleanup(func() { closeHints = saved })
+	long := strings.Repeat("é", maxCloseReasonBytes)
+
+	tests := []struct {
+		name   string
+		hints  bool
+		hint   closeHint
+		status int
+		want   closeHint // decoded, when hints are on
+		text   string    // when hints are off
+	}{
+		{
+			name:   "transient",
+			hints:  true,
+			hint:   transientClose(websocket.CloseTryAgainLater, CloseCodeSlowConsumer, "send buffer full", 1500*time.Millisecond),
+			status: websocket.CloseTryAgainLater,
+			want:   closeHint{Code: CloseCodeSlowConsumer, Reason: "send buffer full", RetryAfterMs: 1500},
+		},
+		{
+			name:   "retry after at least 1ms",
+			hints:  true,
+			hint:   transientClose(websocket.CloseGoingAway, CloseCodeShutdown, "", 0),
+			status: websocket.CloseGoingAway,
+			want:   closeHint{Code: CloseCodeShutdown, RetryAfterMs: 1},
+		},
+		{
+			name:   "permanent",
+			hints:  true,
+			hint:   badRequestClose("invalid room"),
+			status: websocket.ClosePolicyViolation,
+			want:   closeHint{Code: CloseCodeBadRequest, Reason: "invalid room", Permanent: true},
+		},
+		{
+			name:   "hints off",
+			hints:  false,
+			hint:   badRequestClose("invalid room"),
+			status: websocket.ClosePolicyViolation,
+			text:   "invalid room",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			closeHints = tt.hints
+			status, text := parseCloseMessage(t, tt.hint.message())
+			if status != tt.status {
+				t.Errorf("status = %d, want %d", status, tt.status)
+			}
+			if !tt.hints {
+				if text != tt.text {
+					t.Errorf("text = %q, want %q", text, tt.text)
+				}
+				return
+			}
+			var got closeHint
+			if err := json.Unmarshal([]byte(text), &got); err != nil {
+				t.Fatalf("close text %q is not a hint: %v", text, err)
+			}
+			if got != tt.want {
+				t.Errorf("hint = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("long reason shortened to fit", func(t *testing.T) {
+		closeHints = true
+		hint := transientClose(websocket.ClosePolicyViolation, CloseCodeKicked, long, kickedRetryAfter)
+		_, text := parseCloseMessage(t, hint.message())
+		var got closeHint
+		if err := json.Unmarshal([]byte(text), &got); err != nil {
+			t.Fatalf("close text %q is not a hint: %v", text, err)
+		}
+		if got.Code != CloseCodeKicked || got.Reason == "" || !strings.HasPrefix(long, got.Reason) || !utf8.ValidString(got.Reason) {
+			t.Errorf("hint = %+v, want the kicked code and a prefix of the reason", got)
+		}
+	})
+}
@@ -0,0 +1,102 @@
+// coalesce.go
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// eventCoalescer collapses rapid events of the same type from the same user.
+// The first event in a window is delivered immediately; later events within
+// the window replace each other and only the latest is delivered when the
+// window ends, so each recipient sees at most one event per user per window.
+type eventCoalescer struct {
+	mu       sync.Mutex
+	window   time.Duration
+	lastSent map[string]time.Time
+	pending  map[string]Message
+	deliver  func(Message)
+	pruned   time.Time // when lastSent was last pruned
+}
+
+// Coalescer for typing/presence events (nil when coalescing is disabled)
+var typingCoalescer *eventCoalescer
+
+// Create a coalescer delivering events through deliver
+func newEventCoalescer(window time.Duration, deliver func(Message)) *eventCoalescer {
+	return &eventCoalescer{
+		window:   window,
+		lastSent: make(map[string]time.Time),
+		pending:  make(map[string]Message),
+		deliver:  deliver,
+	}
+}
+
+// Initialize event coalescing from environment variables
+func initCoalescing() {
+	window := time.Duration(getEnvInt("COALESCE_WINDOW_MS", 500)) * time.Millisecond
+	if window <= 0 {
+		return
+	}
+	typingCoalescer = newEventCoalescer(window, fanOut)
+}
+
+// Report whether events of this type may be coalesced; chat messages never are
+func isCoalescable(msgType string) bool {
+	return msgType == MessageTypeTyping || msgType == MessageTypePresence
+}
+
+// Submit an event for (possibly delayed) delivery
+func (ec *eventCoalescer) Submit(msg Message) {
+	key := msg.Type + "\x00" + msg.Room + "\x00" + msg.Username
+
+	ec.mu.Lock()
+	now := time.Now()
+	ec.pruneLocked(now)
+	if _, waiting := ec.pending[key]; waiting {
+		// A flush is already scheduled; just keep the latest event
+		ec.pending[key] = msg
+		ec.mu.Unlock()
+		return
+	}
+	if last, ok := ec.lastSent[key]; ok && now.Sub(last) < ec.window {
+		ec.pending[key] = msg
+		time.AfterFunc(ec.window-now.Sub(last), func() { ec.flush(key) })
+		ec.mu.Unlock()
+		return
+	}
+	ec.lastSent[key] = now
+	ec.mu.Unlock()
+
+	ec.deliver(msg)
+}
+
+// Deliver the pending event for key at the end of its window
+func (ec *eventCoalescer) flush(key string) {
+	ec.mu.Lock()
+	msg, ok := ec.pending[key]
+	if !ok {
+		ec.mu.Unlock()
+		return
+	}
+	delete(ec.pending, key)
+	ec.lastSent[key] = time.Now()
+	ec.mu.Unlock()
+
+	ec.deliver(msg)
+}
+
+// Forget when events were last sent for keys whose window has ended, at
+// most once per window, so lastSent doesn't grow with every user and room
+// ever seen. The caller must hold mu.
+func (ec *eventCoalescer) pruneLocked(now time.Time) {
+	if now.Sub(ec.pruned) < ec.window {
+		return
+	}
+	ec.pruned = now
+	for key, last := range ec.lastSent {
+		if now.Sub(last) >= ec.window {
+			delete(ec.lastSent, key)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// coalesce_test.go
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// Collects the events a coalescer delivers
+type deliveries struct {
+	mu   sync.Mutex
+	msgs []Message
+}
+
+func (d *deliveries) deliver(msg Message) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.msgs = append(d.msgs, msg)
+}
+
+func (d *deliveries) contents() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var contents []string
+	for _, msg := range d.msgs {
+		contents = append(contents, msg.Content)
+	}
+	return contents
+}
+
+func TestEventCoalescer(t *testing.T) {
+	const window = 50 * time.Millisecond
+	var got deliveries
+	ec := newEventCoalescer(window, got.deliver)
+
+	typing := func(username, content string) Message {
+		return Message{Type: MessageTypeTyping, Room: "general", Username: username, Content: content}
+	}
+	ec.Submit(typing("bob", "1"))   // delivered at once
+	ec.Submit(typing("bob", "2"))   // replaced by 3
+	ec.Submit(typing("bob", "3"))   // delivered when the window ends
+	ec.Submit(typing("alice", "a")) // other users aren't held back
+
+	if contents := got.contents(); len(contents) != 2 || contents[0] != "1" || contents[1] != "a" {
+		t.Fatalf("delivered %v at once, want [1 a]", contents)
+	}
+	time.Sleep(2 * window)
+	if contents := got.contents(); len(contents) != 3 || contents[2] != "3" {
+		t.Fatalf("delivered %v after the window, want [1 a 3]", contents)
+	}
+}
+
+func TestEventCoalescerPrunes(t *testing.T) {
+	const window = 20 * time.Millisecond
+	ec := newEventCoalescer(window, func(Message) {})
+	for _, username := range []string{"a", "b", "c"} {
+		ec.Submit(Message{Type: MessageTypePresence, Username: username})
+	}
+	time.Sleep(2 * window)
+	ec.Submit(Message{Type: MessageTypePresence, Username: "d"})
+
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+	if len(ec.lastSent) != 1 {
+		t.Errorf("lastSent holds %d keys, want only the latest", len(ec.lastSent))
+	}
+}
+
+func TestIsCoalescable(t *testing.T) {
+	for msgType, want := range map[string]bool{
+		MessageTypeTyping:   true,
+		MessageTypePresence: true,
+		"":                  false,
+		MessageTypeNack:     false,
+	} {
+		if got := isCoalescable(msgType); got != want {
+			t.Errorf("isCoalescable(%q) = %v, want %v", msgType, got, want)
+		}
+	}
+}
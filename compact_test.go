@@ -0,0 +1,104 @@
+// compact_test.go
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"go-chat/client"
+)
+
+func TestCompactEventRoundTrip(t *testing.T) {
+	at := time.UnixMilli(1700000000123)
+
+	tests := []struct {
+		name    string
+		msg     Message
+		encoded string
+	}{
+		{"typing", Message{ID: "e1", SchemaVersion: CurrentSchemaVersion, Type: MessageTypeTyping, Room: "general", Username: "alice", Timestamp: at},
+			`["typing","general","alice",1700000000123]`},
+		{"presence", Message{Type: MessageTypePresence, Room: "general", Username: "alice", Content: "away", Timestamp: at},
+			`["presence","general","alice",1700000000123,"","away"]`},
+		{"reaction", Message{Type: MessageTypeReaction, Room: "general", Username: "bob", MessageID: "m1", Content: "👍", Reactions: map[string]int{"👍": 2, ":parrot:": 1}, Emoji: map[string]string{":parrot:": "/emoji/parrot"}, Timestamp: at},
+			`["reaction","general","bob",1700000000123,"m1","👍",{":parrot:":1,"👍":2},{":parrot:":"/emoji/parrot"}]`},
+		{"reaction removed", Message{Type: MessageTypeReaction, Room: "general", Username: "bob", MessageID: "m1", Content: "👍", Reactions: map[string]int{}, Timestamp: at},
+			`["reaction","general","bob",1700000000123,"m1","👍"]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(compactEvent(tt.msg))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.encoded {
+				t.Errorf("encoded %s, want %s", data, tt.encoded)
+			}
+
+			var got client.Message
+			if err := json.Unmarshal(data, &got); err != nil {
+				t.Fatalf("client decoding %s: %v", data, err)
+			}
+			want := client.Message{
+				Type:      tt.msg.Type,
+				Room:      tt.msg.Room,
+				Username:  tt.msg.Username,
+				Timestamp: tt.msg.Timestamp,
+				MessageID: tt.msg.MessageID,
+				Content:   tt.msg.Content,
+				Reactions: tt.msg.Reactions,
+				Emoji:     tt.msg.Emoji,
+			}
+			if len(want.Reactions) == 0 {
+				want.Reactions = nil
+			}
+			if !got.Timestamp.Equal(want.Timestamp) {
+				t.Errorf("decoded timestamp %v, want %v", got.Timestamp, want.Timestamp)
+			}
+			got.Timestamp = want.Timestamp
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("decoded %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestCompactConnection(t *testing.T) {
+	server := useWSServer(t)
+	ws, _, err := server.dial(url.Values{"username": {"alice"}, "room": {"general"}, "encoding": {encodingCompact}}.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitConnected(t, "alice")
+
+	// Events are arrays and chat messages objects; the client decodes both
+	publish(Message{Type: MessageTypeTyping, Room: "general", Username: "bob", Timestamp: time.Now()})
+	publish(Message{ID: "m1", Room: "general", Username: "bob", Content: "hi", Timestamp: time.Now()})
+	var typing, chat bool
+	ws.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for !typing || !chat {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatalf("reading: %v", err)
+		}
+		var msg client.Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatalf("client decoding %s: %v", data, err)
+		}
+		switch {
+		case msg.Type == MessageTypeTyping:
+			typing = true
+			if data[0] != '[' || msg.Username != "bob" || msg.Room != "general" {
+				t.Errorf("typing event %s decoded as %+v, want a compact event from bob", data, msg)
+			}
+		case msg.ID == "m1":
+			chat = true
+			if data[0] != '{' || msg.Content != "hi" {
+				t.Errorf("chat message %s decoded as %+v, want a full object", data, msg)
+			}
+		}
+	}
+}
@@ -0,0 +1,145 @@
+// compress.go
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"log"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Supported algorithms for compressing stored message text
+const (
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// Compression settings for stored message text
+var (
+	compressThreshold    int    // compress text longer than this many bytes; 0 disables
+	compressionAlgorithm string // CompressionGzip or CompressionZstd
+
+	// Shared zstd coders; both are safe for concurrent EncodeAll/DecodeAll
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+)
+
+// Compressed text of a stored message. Content and ExpandedContent are
+// cleared on the stored copy while it holds one of these.
+type compressedText struct {
+	algorithm string
+	content   []byte // nil when Content was stored as is
+	expanded  []byte // nil when ExpandedContent was stored as is
+}
+
+// Initialize message compression from environment variables
+func initCompression() {
+	compressThreshold = getEnvInt("COMPRESS_THRESHOLD_BYTES", 0)
+	if compressThreshold < 0 {
+		log.Printf("Warning: COMPRESS_THRESHOLD_BYTES must not be negative, disabling compression")
+		compressThreshold = 0
+	}
+
+	compressionAlgorithm = os.Getenv("COMPRESSION_ALGORITHM")
+	switch compressionAlgorithm {
+	case "":
+		compressionAlgorithm = CompressionGzip
+	case CompressionGzip, CompressionZstd:
+	default:
+		log.Printf("Warning: unknown COMPRESSION_ALGORITHM %q, using gzip", compressionAlgorithm)
+		compressionAlgorithm = CompressionGzip
+	}
+
+	var err error
+	if zstdEncoder, err = zstd.NewWriter(nil); err != nil {
+		log.Fatalf("Error creating zstd encoder: %v", err)
+	}
+	if zstdDecoder, err = zstd.NewReader(nil); err != nil {
+		log.Fatalf("Error creating zstd decoder: %v", err)
+	}
+
+	if compressThreshold > 0 {
+		log.Printf("Compressing stored messages over %d bytes with %s", compressThreshold, compressionAlgorithm)
+	}
+}
+
+// Compress the text of a message about to be stored when it is over the
+// threshold and compression makes it smaller
+func compressMessage(msg *Message) {
+	if compressThreshold <= 0 {
+		return
+	}
+	text := compressedText{algorithm: compressionAlgorithm}
+	if len(msg.Content) > compressThreshold {
+		if data, ok := compressText(msg.Content); ok {
+			text.content = data
+			msg.Content = ""
+		}
+	}
+	if len(msg.ExpandedContent) > compressThreshold {
+		if data, ok := compressText(msg.ExpandedContent); ok {
+			text.expanded = data
+			msg.ExpandedContent = ""
+		}
+	}
+	if text.content != nil || text.expanded != nil {
+		msg.compressed = &text
+	}
+}
+
+// Restore the text of a stored message that was compressed
+func decompressMessage(msg *Message) {
+	text := msg.compressed
+	if text == nil {
+		return
+	}
+	msg.compressed = nil
+	if text.content != nil {
+		msg.Content = decompressText(msg.ID, text.algorithm, text.content)
+	}
+	if text.expanded != nil {
+		msg.ExpandedContent = decompressText(msg.ID, text.algorithm, text.expanded)
+	}
+}
+
+// Compress s with the configured algorithm; ok is false when that would
+// not save space
+func compressText(s string) (data []byte, ok bool) {
+	switch compressionAlgorithm {
+	case CompressionZstd:
+		data = zstdEncoder.EncodeAll([]byte(s), nil)
+	default:
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write([]byte(s))
+		zw.Close()
+		data = buf.Bytes()
+	}
+	return data, len(data) < len(s)
+}
+
+// Decompress text stored with the given algorithm. The algorithm is kept
+// per message so changing COMPRESSION_ALGORITHM doesn't break old messages.
+func decompressText(messageID, algorithm string, data []byte) string {
+	var (
+		text []byte
+		err  error
+	)
+	switch algorithm {
+	case CompressionZstd:
+		text, err = zstdDecoder.DecodeAll(data, nil)
+	default:
+		var zr *gzip.Reader
+		if zr, err = gzip.NewReader(bytes.NewReader(data)); err == nil {
+			text, err = io.ReadAll(zr)
+		}
+	}
+	if err != nil {
+		log.Printf("Error decompressing message %s: %v", messageID, err)
+		return ""
+	}
+	return string(text)
+}
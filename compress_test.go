@@ -0,0 +1,110 @@
+// compress_test.go
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compress stored text over threshold bytes with algorithm for a test
+func useCompression(t *testing.T, threshold int, algorithm string) {
+	t.Helper()
+	savedThreshold, savedAlgorithm := compressThreshold, compressionAlgorithm
+	compressThreshold, compressionAlgorithm = threshold, algorithm
+	t.Cleanup(func() { compressThreshold, compressionAlgorithm = savedThreshold, savedAlgorithm })
+	if zstdEncoder == nil {
+		zstdEncoder, _ = zstd.NewWriter(nil)
+		zstdDecoder, _ = zstd.NewReader(nil)
+	}
+}
+
+// Text that compression can't shrink
+func incompressibleText(n int) string {
+	data := make([]byte, n)
+	rand.Read(data)
+	return base64.StdEncoding.EncodeToString(data)[:n]
+}
+
+func TestCompressMessage(t *testing.T) {
+	tests := []struct {
+		name       string
+		content    string
+		compressed bool
+	}{
+		{"under the threshold", strings.Repeat("a", 100), false},
+		{"at the threshold", strings.Repeat("a", 200), false},
+		{"over the threshold", strings.Repeat("a", 201), true},
+		{"long text that doesn't shrink", incompressibleText(300), false},
+	}
+	for _, algorithm := range []string{CompressionGzip, CompressionZstd} {
+		for _, tt := range tests {
+			t.Run(algorithm+"/"+tt.name, func(t *testing.T) {
+				useCompression(t, 200, algorithm)
+				msg := Message{ID: "m1", Content: tt.content}
+				compressMessage(&msg)
+				if compressed := msg.compressed != nil; compressed != tt.compressed {
+					t.Fatalf("compressed = %v, want %v", compressed, tt.compressed)
+				}
+				if tt.compressed && (msg.Content != "" || msg.compressed.algorithm != algorithm) {
+					t.Errorf("stored content %q with %s, want it cleared and %s recorded", msg.Content, msg.compressed.algorithm, algorithm)
+				}
+
+				decompressMessage(&msg)
+				if msg.Content != tt.content || msg.compressed != nil {
+					t.Errorf("round trip changed the content to %q", msg.Content)
+				}
+			})
+		}
+	}
+}
+
+func TestCompressionDisabled(t *testing.T) {
+	useCompression(t, 0, CompressionGzip)
+	msg := Message{Content: strings.Repeat("a", 10000)}
+	compressMessage(&msg)
+	if msg.compressed != nil {
+		t.Error("compressed with the threshold at 0")
+	}
+}
+
+func TestCompressedMessageStoreRoundTrip(t *testing.T) {
+	useMemoryStore(t)
+	useCompression(t, 64, CompressionGzip)
+	ctx := context.Background()
+	content := strings.Repeat("the quick brown fox ", 20)
+	expanded := strings.Repeat("jumps over the lazy dog ", 20)
+
+	if err := messageStore.Insert(ctx, &Message{ID: "m1", Room: "general", Username: "alice", Content: content, ExpandedContent: expanded}); err != nil {
+		t.Fatal(err)
+	}
+	if err := messageStore.Insert(ctx, &Message{ID: "m2", Room: "general", Username: "alice", Content: "short"}); err != nil {
+		t.Fatal(err)
+	}
+
+	stored := messageStore.(*memoryStore).rooms["general"]
+	if stored[0].compressed == nil || stored[0].Content != "" || stored[1].compressed != nil {
+		t.Errorf("stored %+v, want only the long message compressed", stored)
+	}
+
+	// Messages stored before the algorithm changed still read back
+	compressionAlgorithm = CompressionZstd
+	msg, err := messageStore.Get(ctx, "m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Content != content || msg.ExpandedContent != expanded {
+		t.Errorf("got %q / %q, want the original text", msg.Content, msg.ExpandedContent)
+	}
+	recent, err := messageStore.Recent(ctx, "general", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recent) != 2 || recent[0].Content != content || recent[1].Content != "short" {
+		t.Errorf("recent = %+v, want both messages' text", recent)
+	}
+}
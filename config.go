@@ -0,0 +1,45 @@
+// config.go
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+)
+
+// Read an integer environment variable, falling back to def when unset or invalid
+func getEnvInt(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Warning: invalid value for %s (%q), using default %d", key, value, def)
+		return def
+	}
+	return n
+}
+
+// Read a string environment variable, falling back to def when unset; an
+// empty value is kept
+func getEnvString(key, def string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return def
+}
+
+// Read a boolean environment variable, falling back to def when unset or invalid
+func getEnvBool(key string, def bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Warning: invalid value for %s (%q), using default %t", key, value, def)
+		return def
+	}
+	return b
+}
@@ -0,0 +1,103 @@
+// connections.go
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// Longest reason a close frame can carry: control frame payloads are
+// limited to 125 bytes, two of which hold the close code
+const maxCloseReasonBytes = 123
+
+// Close reason used when the admin gives none
+const defaultDisconnectReason = "disconnected by admin"
+
+// ConnectionInfo describes one open WebSocket connection
+type ConnectionInfo struct {
+	ID          string    `json:"id"`
+	Username    string    `json:"username"`
+	Room        string    `json:"room"`
+	ConnectedAt time.Time `json:"connectedAt"`
+}
+
+// List open connections, oldest first, optionally only those of ?username=
+func handleListConnections(c *gin.Context) {
+	username := c.Query("username")
+
+	clientsMu.Lock()
+	connections := []ConnectionInfo{}
+	for client := range clients {
+		if username == "" || client.username == username {
+			connections = append(connections, ConnectionInfo{
+				ID:          client.id,
+				Username:    client.username,
+				Room:        client.room,
+				ConnectedAt: client.connectedAt,
+			})
+		}
+	}
+	clientsMu.Unlock()
+
+	sort.Slice(connections, func(i, j int) bool {
+		return connections[i].ConnectedAt.Before(connections[j].ConnectedAt)
+	})
+	c.JSON(http.StatusOK, gin.H{"connections": connections})
+}
+
+// Close a single connection, leaving the user's other connections open. The
+// optional ?reason= is sent in the close frame.
+func handleCloseConnection(c *gin.Context) {
+	id := c.Param("id")
+	reason := truncateCloseReason(strings.TrimSpace(c.Query("reason")))
+	if reason == "" {
+		reason = defaultDisconnectReason
+	}
+
+	clientsMu.Lock()
+	var target *Client
+	for client := range clients {
+		if client.id == id {
+			target = client
+			break
+		}
+	}
+	if target != nil {
+		// Messages already queued are still delivered before the close frame
+		hint := transientClose(websocket.ClosePolicyViolation, CloseCodeKicked, reason, kickedRetryAfter)
+		target.closing = &hint
+		removeClientLocked(target)
+	}
+	clientsMu.Unlock()
+
+	if target == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Connection not found"})
+		return
+	}
+	log.Printf("Admin closed connection %s of %s (room %s): %s", id, target.username, target.room, reason)
+	c.JSON(http.StatusOK, gin.H{
+		"id":       id,
+		"username": target.username,
+		"room":     target.room,
+		"reason":   reason,
+	})
+}
+
+// Cut a close reason to fit in a close frame without splitting a character
+func truncateCloseReason(reason string) string {
+	if len(reason) <= maxCloseReasonBytes {
+		return reason
+	}
+	reason = reason[:maxCloseReasonBytes]
+	for !utf8.ValidString(reason) {
+		reason = reason[:len(reason)-1]
+	}
+	return reason
+}
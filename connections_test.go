@@ -0,0 +1,90 @@
+// connections_test.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandleListConnections(t *testing.T) {
+	start := time.Now()
+	useClients(t,
+		&Client{id: "c2", username: "bob", room: "random", connectedAt: start.Add(time.Second)},
+		&Client{id: "c1", username: "bob", room: "general", connectedAt: start},
+		&Client{id: "c3", username: "alice", room: "general", connectedAt: start.Add(2 * time.Second)},
+	)
+
+	tests := []struct {
+		target string
+		want   string // connection IDs, oldest first
+	}{
+		{"/admin/connections", "c1,c2,c3"},
+		{"/admin/connections?username=bob", "c1,c2"},
+		{"/admin/connections?username=carol", ""},
+	}
+	for _, tt := range tests {
+		rec := serveTest(http.MethodGet, "/admin/connections", tt.target, nil, handleListConnections)
+		var resp struct {
+			Connections []ConnectionInfo `json:"connections"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, conn := range resp.Connections {
+			ids = append(ids, conn.ID)
+		}
+		if got := strings.Join(ids, ","); got != tt.want {
+			t.Errorf("%s: connections = %s, want %s", tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestHandleCloseConnection(t *testing.T) {
+	kicked := &Client{id: "c1", username: "bob", room: "general", send: make(chan Message, 1)}
+	other := &Client{id: "c2", username: "bob", room: "random", send: make(chan Message, 1)}
+	useClients(t, kicked, other)
+
+	rec := serveTest(http.MethodDelete, "/admin/connections/:id", "/admin/connections/c1?reason=spamming", nil, handleCloseConnection)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if _, open := <-kicked.send; open {
+		t.Error("send buffer of the closed connection is still open")
+	}
+	if kicked.closing == nil || kicked.closing.Code != CloseCodeKicked || kicked.closing.Reason != "spamming" {
+		t.Errorf("close hint = %+v, want kicked for spamming", kicked.closing)
+	}
+	clientsMu.Lock()
+	if clients[kicked] || !clients[other] {
+		t.Error("want only the closed connection removed")
+	}
+	clientsMu.Unlock()
+
+	rec = serveTest(http.MethodDelete, "/admin/connections/:id", "/admin/connections/c1", nil, handleCloseConnection)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("closing again: status = %d, want 404", rec.Code)
+	}
+}
+
+func TestTruncateCloseReason(t *testing.T) {
+	tests := []struct {
+		name   string
+		reason string
+		want   int // bytes kept
+	}{
+		{"short", "bye", 3},
+		{"exactly the limit", strings.Repeat("a", maxCloseReasonBytes), maxCloseReasonBytes},
+		{"too long", strings.Repeat("a", maxCloseReasonBytes+10), maxCloseReasonBytes},
+		{"multi-byte character at the limit", strings.Repeat("a", maxCloseReasonBytes-1) + "é", maxCloseReasonBytes - 1},
+	}
+	for _, tt := range tests {
+		got := truncateCloseReason(tt.reason)
+		if len(got) != tt.want || !strings.HasPrefix(tt.reason, got) {
+			t.Errorf("%s: kept %d bytes, want %d", tt.name, len(got), tt.want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+// crosspost_test.go
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Replace the normal-priority broadcast lane for a test, so what a handler
+// publishes can be inspected
+func useBroadcastQueue(t *testing.T) *broadcastQueue {
+	t.Helper()
+	saved := broadcast
+	broadcast = newBroadcastQueue(0, 0)
+	t.Cleanup(func() { broadcast = saved })
+	return broadcast
+}
+
+// Take the rooms of everything published so far
+func publishedRooms(q *broadcastQueue) string {
+	var rooms []string
+	for {
+		msg, ok := q.Pop()
+		if !ok {
+			return strings.Join(rooms, ",")
+		}
+		rooms = append(rooms, msg.Room)
+	}
+}
+
+func TestHandleCrossPost(t *testing.T) {
+	useMemoryStore(t)
+	queue := useBroadcastQueue(t)
+	useSlowMode(t, "slow", time.Minute)
+	messageStore.Insert(context.Background(), &Message{ID: "m1", Room: "stored", Content: "hi"})
+	useClients(t, &Client{username: "carol", room: "live"})
+	adminToken = "admin-secret"
+	t.Cleanup(func() { adminToken = "" })
+	liveConfigMu.Lock()
+	savedFilter := wordFilter
+	wordFilter = NewWordFilter([]string{"darn"}, true, true, "***")
+	liveConfigMu.Unlock()
+	t.Cleanup(func() {
+		liveConfigMu.Lock()
+		wordFilter = savedFilter
+		liveConfigMu.Unlock()
+	})
+
+	steps := []struct {
+		name      string
+		body      string
+		admin     bool
+		code      int
+		published string
+	}{
+		{"rooms sorted and deduplicated", `{"username":"bob","rooms":["b","a","a"],"content":"hi"}`, false, http.StatusCreated, "a,b"},
+		{"all rooms needs admin", `{"username":"bob","rooms":"all","content":"hi"}`, false, http.StatusForbidden, ""},
+		{"all rooms", `{"username":"bob","rooms":"all","content":"hi"}`, true, http.StatusCreated, "live,stored"},
+		{"not all", `{"username":"bob","rooms":"some","content":"hi"}`, true, http.StatusBadRequest, ""},
+		{"no rooms", `{"username":"bob","rooms":[],"content":"hi"}`, false, http.StatusBadRequest, ""},
+		{"invalid room", `{"username":"bob","rooms":["a","no such room!"],"content":"hi"}`, false, http.StatusBadRequest, ""},
+		{"missing username", `{"rooms":["a"],"content":"hi"}`, false, http.StatusBadRequest, ""},
+		{"blocked word behind markup", `{"username":"bob","rooms":["a"],"content":"d<b></b>arn"}`, false, http.StatusBadRequest, ""},
+		{"slow mode room", `{"username":"bob","rooms":["a","slow"],"content":"hi"}`, false, http.StatusCreated, "a,slow"},
+		{"too soon in one room posts to none", `{"username":"bob","rooms":["a","slow"],"content":"hi again"}`, false, http.StatusTooManyRequests, ""},
+		{"other rooms still open", `{"username":"bob","rooms":["a"],"content":"hi again"}`, false, http.StatusCreated, "a"},
+	}
+	for _, step := range steps {
+		req := httptest.NewRequest(http.MethodPost, "/messages/broadcast", strings.NewReader(step.body))
+		if step.admin {
+			req.Header.Set("Authorization", "Bearer admin-secret")
+		}
+		rec := serveTestRequest("/messages/broadcast", req, handleCrossPost)
+		if rec.Code != step.code {
+			t.Errorf("%s: status = %d, want %d: %s", step.name, rec.Code, step.code, rec.Body)
+		}
+		if got := publishedRooms(queue); got != step.published {
+			t.Errorf("%s: published to %q, want %q", step.name, got, step.published)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// dedup.go
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// User metadata field holding the hex SHA-256 of an uploaded file
+const contentHashMetadata = "Sha256"
+
+// Uploads that reused an identical file instead of storing a new object
+var uploadDuplicatesTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "chat_upload_duplicates_total",
+	Help: "Uploads whose content matched a file already stored in the same room",
+})
+
+// Upload deduplication settings and index
+var (
+	uploadDedup bool
+
+	// room + "/" + content hash -> object holding that content. Entries
+	// may outlive their object; lookups check it still exists.
+	fileHashes   = make(map[string]string)
+	fileHashesMu sync.Mutex
+)
+
+// Initialize upload deduplication from environment variables and index
+// the content hashes of the files already stored. UPLOAD_DEDUP=false
+// stores every upload as a new object.
+func initDedup(ctx context.Context) {
+	uploadDedup = getEnvBool("UPLOAD_DEDUP", true)
+	if !uploadDedup {
+		return
+	}
+
+	index := make(map[string]string)
+	for obj := range minioClient.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
+		Recursive:    true,
+		WithMetadata: true,
+	}) {
+		if obj.Err != nil {
+			log.Printf("Warning: could not index stored files for deduplication: %v", obj.Err)
+			return
+		}
+		room := roomOfObject(obj.Key)
+		hash := objectMetadata(obj.UserMetadata, contentHashMetadata)
+		if strings.HasPrefix(obj.Key, ".") || room == "" || hash == "" {
+			continue
+		}
+		index[room+"/"+hash] = obj.Key
+	}
+	fileHashesMu.Lock()
+	fileHashes = index
+	fileHashesMu.Unlock()
+	log.Printf("Indexed %d stored files for upload deduplication", len(index))
+}
+
+// Compute the hex SHA-256 of a file, reading it in a streaming fashion,
+// and rewind it for the upload
+func contentHash(file io.ReadSeeker) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Return the object in a room already holding content with this hash, and
+// its upload info, if there is one
+func findDuplicateUpload(ctx context.Context, room, hash string) (string, minio.UploadInfo, bool) {
+	fileHashesMu.Lock()
+	objectName, ok := fileHashes[room+"/"+hash]
+	fileHashesMu.Unlock()
+	if !ok {
+		return "", minio.UploadInfo{}, false
+	}
+
+	info, err := minioClient.StatObject(ctx, bucketName, objectName, minio.StatObjectOptions{})
+	if err != nil || objectMetadata(info.UserMetadata, contentHashMetadata) != hash {
+		// Deleted, expired or overwritten since it was indexed
+		fileHashesMu.Lock()
+		if fileHashes[room+"/"+hash] == objectName {
+			delete(fileHashes, room+"/"+hash)
+		}
+		fileHashesMu.Unlock()
+		return "", minio.UploadInfo{}, false
+	}
+	return objectName, minio.UploadInfo{Bucket: bucketName, Key: objectName, ETag: info.ETag, Size: info.Size, VersionID: info.VersionID}, true
+}
+
+// Remember which object holds content with this hash
+func recordUploadHash(room, hash, objectName string) {
+	fileHashesMu.Lock()
+	defer fileHashesMu.Unlock()
+	fileHashes[room+"/"+hash] = objectName
+}
@@ -0,0 +1,107 @@
+// dedup_test.go
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// Deduplicate uploads for a test, starting with an empty index
+func useDedup(t *testing.T, enabled bool) {
+	t.Helper()
+	fileHashesMu.Lock()
+	savedEnabled, savedHashes := uploadDedup, fileHashes
+	uploadDedup, fileHashes = enabled, make(map[string]string)
+	fileHashesMu.Unlock()
+	t.Cleanup(func() {
+		fileHashesMu.Lock()
+		uploadDedup, fileHashes = savedEnabled, savedHashes
+		fileHashesMu.Unlock()
+	})
+}
+
+// Upload a file to a room and return the object the message links to
+func uploadForDedup(t *testing.T, queue *broadcastQueue, room, fileName, data string) string {
+	t.Helper()
+	req := newUploadForm("/upload", map[string]string{"username": "alice", "room": room}, fileName, data)
+	if rec := serveTestRequest("/upload", req, handleFileUpload); rec.Code != http.StatusOK {
+		t.Fatalf("upload status = %d: %s", rec.Code, rec.Body)
+	}
+	msg, ok := queue.Pop()
+	if !ok {
+		t.Fatal("upload was not broadcast")
+	}
+	return msg.objectName
+}
+
+func TestUploadDedupReusesObject(t *testing.T) {
+	useMemoryStore(t)
+	useUploadLimiter(t, 100, 100)
+	useDedup(t, true)
+	fake := useFakeS3(t, bucketName)
+	queue := useBroadcastQueue(t)
+	duplicates := testutil.ToFloat64(uploadDuplicatesTotal)
+
+	first := uploadForDedup(t, queue, "general", "report.pdf", "%PDF-1.7 quarterly numbers")
+	second := uploadForDedup(t, queue, "general", "report (1).pdf", "%PDF-1.7 quarterly numbers")
+	if second != first || fake.putCount() != 1 {
+		t.Errorf("identical upload stored as %q (%d puts), want %q reused", second, fake.putCount(), first)
+	}
+	if got := testutil.ToFloat64(uploadDuplicatesTotal) - duplicates; got != 1 {
+		t.Errorf("duplicates counted %v, want 1", got)
+	}
+
+	// Other rooms and other content get their own objects
+	if other := uploadForDedup(t, queue, "random", "report.pdf", "%PDF-1.7 quarterly numbers"); other == first {
+		t.Error("upload to another room reused the object")
+	}
+	if changed := uploadForDedup(t, queue, "general", "report.pdf", "%PDF-1.7 revised numbers"); changed == first {
+		t.Error("different content reused the object")
+	}
+
+	// Once the object is gone the content is stored again
+	if err := minioClient.RemoveObject(context.Background(), bucketName, first, minio.RemoveObjectOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if again := uploadForDedup(t, queue, "general", "report.pdf", "%PDF-1.7 quarterly numbers"); again == first || fake.object(bucketName, again) == nil {
+		t.Errorf("upload after deletion linked to %q, want a new object", again)
+	}
+}
+
+func TestUploadDedupDisabled(t *testing.T) {
+	useMemoryStore(t)
+	useUploadLimiter(t, 100, 100)
+	useDedup(t, false)
+	fake := useFakeS3(t, bucketName)
+	queue := useBroadcastQueue(t)
+
+	first := uploadForDedup(t, queue, "general", "a.txt", "same")
+	second := uploadForDedup(t, queue, "general", "a.txt", "same")
+	if first == second || fake.putCount() != 2 {
+		t.Errorf("stored %q and %q in %d puts, want two objects", first, second, fake.putCount())
+	}
+}
+
+func TestInitDedupIndexesStoredFiles(t *testing.T) {
+	captureLog(t)
+	useMemoryStore(t)
+	useUploadLimiter(t, 100, 100)
+	useDedup(t, true)
+	fake := useFakeS3(t, bucketName)
+	queue := useBroadcastQueue(t)
+
+	// Stored before a restart
+	first := uploadForDedup(t, queue, "general", "a.txt", "hello")
+	fileHashesMu.Lock()
+	fileHashes = make(map[string]string)
+	fileHashesMu.Unlock()
+	initDedup(context.Background())
+
+	if second := uploadForDedup(t, queue, "general", "a.txt", "hello"); second != first || fake.putCount() != 1 {
+		t.Errorf("upload after restart stored as %q (%d puts), want %q reused", second, fake.putCount(), first)
+	}
+}
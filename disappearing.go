@@ -0,0 +1,163 @@
+// disappearing.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Object in the state bucket listing the rooms where disappearing messages
+// are turned off
+const disappearingPolicyObject = "config/disappearing.json"
+
+// Bounds for a message's self-destruct timer
+const (
+	minExpiresInSeconds = 10
+	maxExpiresInSeconds = 7 * 24 * 60 * 60
+)
+
+// Code of the nack or HTTP error for a timer outside those bounds
+const errExpiresInOutOfRange = "ERR_EXPIRES_IN_OUT_OF_RANGE"
+
+// Disappearing message settings
+var (
+	disappearingEnabled  bool
+	disappearingInterval time.Duration
+
+	disappearingDisabledRooms = make(map[string]bool) // rooms where timers are ignored
+	disappearingMu            sync.RWMutex
+)
+
+// Initialize disappearing messages from environment variables. The feature
+// is off unless DISAPPEARING_MESSAGES is true; rooms can still opt out.
+func initDisappearing(ctx context.Context) {
+	disappearingEnabled = getEnvBool("DISAPPEARING_MESSAGES", false)
+	disappearingInterval = time.Duration(getEnvInt("DISAPPEARING_CHECK_INTERVAL_SECONDS", 5)) * time.Second
+	if disappearingInterval <= 0 {
+		log.Printf("Warning: DISAPPEARING_CHECK_INTERVAL_SECONDS must be positive, using 5")
+		disappearingInterval = 5 * time.Second
+	}
+
+	var rooms []string
+	if err := loadConfigObject(ctx, disappearingPolicyObject, &rooms); err != nil {
+		log.Fatalf("Error loading disappearing message settings: %v", err)
+	}
+	disappearingMu.Lock()
+	for _, room := range rooms {
+		disappearingDisabledRooms[room] = true
+	}
+	disappearingMu.Unlock()
+}
+
+// Report whether messages in a room may set a self-destruct timer
+func disappearingAllowed(room string) bool {
+	if !disappearingEnabled {
+		return false
+	}
+	disappearingMu.RLock()
+	defer disappearingMu.RUnlock()
+	return !disappearingDisabledRooms[room]
+}
+
+// Report whether a requested timer is within the allowed range
+func validExpiresIn(seconds int) bool {
+	return seconds >= minExpiresInSeconds && seconds <= maxExpiresInSeconds
+}
+
+// Nack for a message whose timer is outside the allowed range
+func expiresInNack() Message {
+	return Message{
+		ID:        uuid.New().String(),
+		Type:      MessageTypeNack,
+		Username:  "System",
+		Content:   fmt.Sprintf("expiresInSeconds must be between %d and %d.", minExpiresInSeconds, maxExpiresInSeconds),
+		Reason:    "expires_in_seconds",
+		Code:      errExpiresInOutOfRange,
+		Timestamp: time.Now(),
+	}
+}
+
+// Set a message's expiry from its requested timer. Senders' timers are
+// checked with validExpiresIn first; others are clamped to the allowed
+// range. The timer is cleared where disappearing messages are not allowed.
+func applyExpiry(msg *Message) {
+	if msg.ExpiresInSeconds == 0 || !disappearingAllowed(msg.Room) {
+		msg.ExpiresInSeconds = 0
+		msg.ExpiresAt = nil
+		return
+	}
+	msg.ExpiresInSeconds = min(max(msg.ExpiresInSeconds, minExpiresInSeconds), maxExpiresInSeconds)
+	expiresAt := msg.Timestamp.Add(time.Duration(msg.ExpiresInSeconds) * time.Second)
+	msg.ExpiresAt = &expiresAt
+}
+
+// Enable or disable disappearing messages in a room and store the setting
+func setDisappearingAllowed(ctx context.Context, room string, allowed bool) error {
+	disappearingMu.Lock()
+	defer disappearingMu.Unlock()
+
+	if disappearingDisabledRooms[room] == !allowed {
+		return nil
+	}
+	rooms := make([]string, 0, len(disappearingDisabledRooms)+1)
+	for name := range disappearingDisabledRooms {
+		if name != room {
+			rooms = append(rooms, name)
+		}
+	}
+	if !allowed {
+		rooms = append(rooms, room)
+	}
+	if err := saveConfigObject(ctx, disappearingPolicyObject, rooms); err != nil {
+		return err
+	}
+	if allowed {
+		delete(disappearingDisabledRooms, room)
+	} else {
+		disappearingDisabledRooms[room] = true
+	}
+	log.Printf("Disappearing messages in room %s allowed: %t", room, allowed)
+	return nil
+}
+
+// Delete expired messages periodically until ctx is canceled
+func runDisappearing(ctx context.Context) {
+	if !disappearingEnabled {
+		return
+	}
+	ticker := time.NewTicker(disappearingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			deleteExpiredMessages(ctx, now)
+		}
+	}
+}
+
+// Delete messages whose timer has run out and tell their rooms
+func deleteExpiredMessages(ctx context.Context, now time.Time) {
+	expired, err := messageStore.DeleteExpired(ctx, now)
+	if err != nil {
+		log.Printf("Error deleting expired messages: %v", err)
+		return
+	}
+	for _, msg := range expired {
+		publish(Message{
+			ID:        uuid.New().String(),
+			Type:      MessageTypeMessageDelete,
+			Room:      msg.Room,
+			Username:  "System",
+			MessageID: msg.ID,
+			Reason:    "expired",
+			Timestamp: now,
+		})
+	}
+}
@@ -0,0 +1,179 @@
+// disappearing_test.go
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Turn disappearing messages on for a test
+func useDisappearing(t *testing.T) {
+	t.Helper()
+	saved := disappearingEnabled
+	disappearingEnabled = true
+	t.Cleanup(func() { disappearingEnabled = saved })
+}
+
+func TestValidExpiresIn(t *testing.T) {
+	for seconds, want := range map[int]bool{
+		-10:                     false,
+		0:                       false,
+		minExpiresInSeconds - 1: false,
+		minExpiresInSeconds:     true,
+		3600:                    true,
+		maxExpiresInSeconds:     true,
+		maxExpiresInSeconds + 1: false,
+	} {
+		if got := validExpiresIn(seconds); got != want {
+			t.Errorf("validExpiresIn(%d) = %v, want %v", seconds, got, want)
+		}
+	}
+}
+
+func TestApplyExpiry(t *testing.T) {
+	useDisappearing(t)
+	disappearingMu.Lock()
+	disappearingDisabledRooms["quiet"] = true
+	disappearingMu.Unlock()
+	t.Cleanup(func() {
+		disappearingMu.Lock()
+		delete(disappearingDisabledRooms, "quiet")
+		disappearingMu.Unlock()
+	})
+
+	sent := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		room    string
+		seconds int
+		want    int // timer left on the message, 0 for none
+	}{
+		{"general", 0, 0},
+		{"general", 60, 60},
+		{"general", 1, minExpiresInSeconds},
+		{"general", maxExpiresInSeconds * 2, maxExpiresInSeconds},
+		{"quiet", 60, 0},
+	}
+	for _, tt := range tests {
+		msg := Message{Room: tt.room, Timestamp: sent, ExpiresInSeconds: tt.seconds}
+		applyExpiry(&msg)
+		if msg.ExpiresInSeconds != tt.want {
+			t.Errorf("%s, %ds: timer = %d, want %d", tt.room, tt.seconds, msg.ExpiresInSeconds, tt.want)
+		}
+		if tt.want == 0 && msg.ExpiresAt != nil {
+			t.Errorf("%s, %ds: expires at %v, want never", tt.room, tt.seconds, msg.ExpiresAt)
+		}
+		if want := sent.Add(time.Duration(tt.want) * time.Second); tt.want != 0 && (msg.ExpiresAt == nil || !msg.ExpiresAt.Equal(want)) {
+			t.Errorf("%s, %ds: expires at %v, want %v", tt.room, tt.seconds, msg.ExpiresAt, want)
+		}
+	}
+}
+
+func TestDeleteExpiredMessages(t *testing.T) {
+	useMemoryStore(t)
+	queue := useBroadcastQueue(t)
+	ctx := context.Background()
+	now := time.Now()
+	past, future := now.Add(-time.Second), now.Add(time.Minute)
+	for _, msg := range []Message{
+		{ID: "expired", Room: "general", Content: "gone", ExpiresAt: &past},
+		{ID: "due now", Room: "random", Content: "gone", ExpiresAt: &now},
+		{ID: "later", Room: "general", Content: "still here", ExpiresAt: &future},
+		{ID: "forever", Room: "general", Content: "still here"},
+	} {
+		if err := messageStore.Insert(ctx, &msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deleteExpiredMessages(ctx, now)
+
+	for id, kept := range map[string]bool{"expired": false, "due now": false, "later": true, "forever": true} {
+		if _, err := messageStore.Get(ctx, id); (err == nil) != kept {
+			t.Errorf("%s kept = %v, want %v", id, err == nil, kept)
+		}
+	}
+	var deleted []string
+	for {
+		msg, ok := queue.Pop()
+		if !ok {
+			break
+		}
+		if msg.Type != MessageTypeMessageDelete || msg.Reason != "expired" {
+			t.Errorf("published %+v, want an expired message_delete", msg)
+		}
+		deleted = append(deleted, msg.Room+"/"+msg.MessageID)
+	}
+	if got := strings.Join(deleted, ","); got != "general/expired,random/due now" && got != "random/due now,general/expired" {
+		t.Errorf("announced deletions %s, want both expired messages", got)
+	}
+}
+
+func TestHandlePostMessageExpiresInBounds(t *testing.T) {
+	useMemoryStore(t)
+	useDisappearing(t)
+
+	tests := []struct {
+		value string
+		code  int
+	}{
+		{strconv.Itoa(minExpiresInSeconds - 1), http.StatusBadRequest},
+		{strconv.Itoa(minExpiresInSeconds), http.StatusCreated},
+		{strconv.Itoa(maxExpiresInSeconds), http.StatusCreated},
+		{strconv.Itoa(maxExpiresInSeconds + 1), http.StatusBadRequest},
+		{"-60", http.StatusBadRequest},
+		{"soon", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		form := url.Values{"username": {"bob"}, "content": {"hi"}, "expires_in_seconds": {tt.value}}
+		req := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := serveTestRequest("/messages", req, handlePostMessage)
+		if rec.Code != tt.code {
+			t.Errorf("expires_in_seconds=%s: status = %d, want %d", tt.value, rec.Code, tt.code)
+		}
+		if tt.code == http.StatusBadRequest && tt.value != "soon" && !strings.Contains(rec.Body.String(), errExpiresInOutOfRange) {
+			t.Errorf("expires_in_seconds=%s: body = %s, want code %s", tt.value, rec.Body, errExpiresInOutOfRange)
+		}
+	}
+}
+
+func TestWebSocketExpiresInBounds(t *testing.T) {
+	server := useWSServer(t)
+	useDisappearing(t)
+	ws := server.connect("alice", "general")
+
+	tests := []struct {
+		seconds int
+		ok      bool
+	}{
+		{minExpiresInSeconds - 1, false},
+		{minExpiresInSeconds, true},
+		{maxExpiresInSeconds, true},
+		{maxExpiresInSeconds + 1, false},
+		{-60, false},
+	}
+	for _, tt := range tests {
+		content := "timer " + strconv.Itoa(tt.seconds)
+		if err := ws.WriteJSON(Message{Content: content, ExpiresInSeconds: tt.seconds}); err != nil {
+			t.Fatal(err)
+		}
+		reply := readUntil(t, ws, func(msg Message) bool {
+			return msg.Type == MessageTypeNack || msg.Content == content
+		})
+		if tt.ok {
+			if reply.Type == MessageTypeNack || reply.ExpiresInSeconds != tt.seconds || reply.ExpiresAt == nil {
+				t.Errorf("%ds: got %+v, want the message with its timer", tt.seconds, reply)
+			}
+			continue
+		}
+		if reply.Type != MessageTypeNack || reply.Code != errExpiresInOutOfRange {
+			t.Errorf("%ds: got %+v, want a %s nack", tt.seconds, reply, errExpiresInOutOfRange)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+// download.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go/v7"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Download streaming metrics
+var (
+	downloadRetriesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "chat_download_retries_total",
+		Help: "Number of times a download was resumed after the storage stream failed.",
+	})
+	downloadPartialFailuresTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "chat_download_partial_failures_total",
+		Help: "Number of downloads aborted after part of the file was sent.",
+	})
+)
+
+// Download settings
+var (
+	downloadMaxRetries int  // how many times a broken storage stream is resumed per download
+	directDownloads    bool // serve /download/<object name> as well as by message
+)
+
+// Path, under /download/, of URLs that name a file message instead of an object
+const byMessagePrefix = "by-message/"
+
+// errClientGone wraps write errors: the client went away, so there is
+// nothing to retry
+var errClientGone = errors.New("client connection lost")
+
+// Initialize download settings from environment variables. With
+// DIRECT_DOWNLOADS=false, files are only served through their message
+// (/download/by-message/<id>) and new file messages link there, so
+// download URLs no longer reveal object names. Left on (the default),
+// the object name URLs of older messages keep working.
+func initDownloads() {
+	downloadMaxRetries = getEnvInt("DOWNLOAD_MAX_RETRIES", 2)
+	directDownloads = getEnvBool("DIRECT_DOWNLOADS", true)
+}
+
+// Return the download URL for a new file message. Direct URLs are pinned to
+// the uploaded version, so a later upload of the same name doesn't change
+// what an older message serves. Without an object name, e.g. for a message
+// already linked by ID, the URL is always by message.
+func fileDownloadURL(messageID, objectName, versionID string) string {
+	if !directDownloads || objectName == "" {
+		return "/download/" + byMessagePrefix + messageID
+	}
+	if versionID == "" {
+		return "/download/" + objectName
+	}
+	return "/download/" + objectName + "?version=" + url.QueryEscape(versionID)
+}
+
+// Return the object holding a message's file, or "" if it has none. Older
+// and imported messages only carry it in their FileURL.
+func messageObjectName(msg Message) string {
+	if msg.objectName != "" {
+		return msg.objectName
+	}
+	objectName, ok := strings.CutPrefix(msg.FileURL, "/download/")
+	objectName, _, _ = strings.Cut(objectName, "?")
+	if !ok || strings.HasPrefix(objectName, byMessagePrefix) {
+		return ""
+	}
+	return objectName
+}
+
+// Serve the file attached to a message, pinned to the version the message
+// was sent with. The caller (?username=) must be able to access the
+// message's room, and the file's room too: clients choose the fileUrl of
+// messages they send, so a message may point into another room.
+func handleDownloadByMessage(c *gin.Context, id string) {
+	ctx := c.Request.Context()
+	msg, err := messageStore.Get(ctx, id)
+	if errors.Is(err, ErrMessageNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve file"})
+		log.Printf("Error loading message %s: %v", id, err)
+		return
+	}
+
+	username := c.Query("username")
+	objectName := messageObjectName(msg)
+	if !canAccessRoom(ctx, msg.Room, username) || !canAccessRoom(ctx, roomOfObject(objectName), username) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not a member of this room"})
+		return
+	}
+	if msg.FileDeleted {
+		respondFileGone(c, msg.FileDeletedReason)
+		return
+	}
+	if objectName == "" || isReservedObjectName(objectName) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	if serveObject(c, objectName, msg.VersionID, true) {
+		recordDownload(msg, username, c.ClientIP())
+	}
+}
+
+// Respond that a message's file was deleted, or expired
+func respondFileGone(c *gin.Context, reason string) {
+	if reason == fileExpiredReason {
+		c.JSON(http.StatusGone, gin.H{"error": "File expired", "code": "ERR_FILE_EXPIRED"})
+		return
+	}
+	c.JSON(http.StatusGone, gin.H{"error": "File deleted", "code": "ERR_FILE_DELETED"})
+}
+
+// Stream an object (a specific version if versionID is set) to the client.
+// ?disposition= chooses between attachment (default) and inline. Reports
+// whether the whole file was sent. stored says versionID is the one a
+// message was stored with; only then does a missing object mark the
+// messages sharing it expired. A version taken from the request is never
+// acted on, and other missing files are left to the expiry sweep.
+func serveObject(c *gin.Context, objectName, versionID string, stored bool) bool {
+	// Attachment by default; inline lets browsers render e.g. images in place
+	disposition := c.DefaultQuery("disposition", "attachment")
+	if disposition != "attachment" && disposition != "inline" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "disposition must be inline or attachment"})
+		return false
+	}
+
+	// Get object from MinIO
+	ctx := c.Request.Context()
+	object, err := minioClient.GetObject(ctx, bucketName, objectName, minio.GetObjectOptions{
+		VersionID: versionID,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve file"})
+		log.Printf("Error getting object: %v", err)
+		return false
+	}
+	defer object.Close()
+
+	// Get object info
+	info, err := object.Stat()
+	if err != nil {
+		// A shared file that has disappeared expired; mark its messages so
+		// clients stop offering the download
+		if stored && isMissingObject(err) && broadcastFileDeleted(ctx, objectName, versionID, fileExpiredReason) > 0 {
+			respondFileGone(c, fileExpiredReason)
+			return false
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		log.Printf("Error getting object info: %v", err)
+		return false
+	}
+
+	// Set headers
+	contentType := info.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	c.Header("Content-Description", "File Transfer")
+	c.Header("Content-Disposition", mime.FormatMediaType(contentDisposition(disposition, contentType), map[string]string{"filename": path.Base(info.Key)}))
+	c.Header("Content-Type", contentType)
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Content-Length", fmt.Sprintf("%d", info.Size))
+
+	// Stream the file to the response. If storage fails for good partway,
+	// abort the connection so the client sees an error rather than a
+	// complete-looking truncated file.
+	sent, err := streamObject(ctx, c.Writer, object, info)
+	if err != nil {
+		log.Printf("Error streaming file %s after %d of %d bytes: %v", objectName, sent, info.Size, err)
+		if !errors.Is(err, errClientGone) {
+			panic(http.ErrAbortHandler)
+		}
+		return false
+	}
+	return true
+}
+
+// Copy an object to w. If reading from storage fails partway, the rest is
+// requested again from the first unsent byte, pinned to the same version
+// and ETag so the pieces belong to one file. Returns the number of bytes
+// written; errors wrapping errClientGone mean writing to w failed.
+func streamObject(ctx context.Context, w io.Writer, object *minio.Object, info minio.ObjectInfo) (int64, error) {
+	current := object
+	defer func() {
+		if current != object {
+			current.Close()
+		}
+	}()
+
+	buf := make([]byte, 32<<10)
+	var sent int64
+	retries := 0
+	for sent < info.Size {
+		n, err := current.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return sent, errors.Join(errClientGone, werr)
+			}
+			sent += int64(n)
+		}
+		if err == nil || (err == io.EOF && sent == info.Size) {
+			continue
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+
+		// Storage stream broke; resume unless the client is gone or we are out of retries
+		if ctx.Err() != nil {
+			return sent, errors.Join(errClientGone, ctx.Err())
+		}
+		if retries >= downloadMaxRetries {
+			downloadPartialFailuresTotal.Inc()
+			return sent, err
+		}
+		retries++
+		downloadRetriesTotal.Inc()
+		log.Printf("Download of %s failed after %d bytes, resuming (attempt %d): %v", info.Key, sent, retries, err)
+
+		opts := minio.GetObjectOptions{VersionID: info.VersionID}
+		if info.ETag != "" {
+			opts.SetMatchETag(info.ETag)
+		}
+		if err := opts.SetRange(sent, 0); err != nil {
+			downloadPartialFailuresTotal.Inc()
+			return sent, err
+		}
+		next, err := minioClient.GetObject(ctx, bucketName, info.Key, opts)
+		if err != nil {
+			downloadPartialFailuresTotal.Inc()
+			return sent, err
+		}
+		if current != object {
+			current.Close()
+		}
+		current = next
+	}
+	return sent, nil
+}
@@ -0,0 +1,323 @@
+// download_test.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestFileDownloadURL(t *testing.T) {
+	t.Cleanup(func() { directDownloads = true })
+	tests := []struct {
+		name      string
+		direct    bool
+		versionID string
+		want      string
+	}{
+		{"direct", true, "", "/download/general/a.png"},
+		{"direct, pinned to a version", true, "3/L4kqtJl", "/download/general/a.png?version=3%2FL4kqtJl"},
+		{"by message", false, "v1", "/download/by-message/m1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			directDownloads = tt.direct
+			got := fileDownloadURL("m1", "general/a.png", tt.versionID)
+			if got != tt.want {
+				t.Errorf("fileDownloadURL = %q, want %q", got, tt.want)
+			}
+			if tt.direct && messageObjectName(Message{FileURL: got}) != "general/a.png" {
+				t.Errorf("messageObjectName(%q) = %q", got, messageObjectName(Message{FileURL: got}))
+			}
+		})
+	}
+}
+
+func TestHandleListFileVersionsAccess(t *testing.T) {
+	useMemoryStore(t)
+	privateRooms = true
+	t.Cleanup(func() { privateRooms = false })
+
+	tests := []struct {
+		name   string
+		target string
+		code   int
+	}{
+		{"reserved object", "/files/.config/versions", http.StatusNotFound},
+		{"not a member", "/files/secret%2Fa.png/versions?username=bob", http.StatusForbidden},
+		{"anonymous", "/files/secret%2Fa.png/versions", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serveTest(http.MethodGet, "/files/:id/versions", tt.target, nil, handleListFileVersions)
+			if rec.Code != tt.code {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.code, rec.Body)
+			}
+		})
+	}
+}
+
+func TestHandleFileDownloadRefusals(t *testing.T) {
+	useMemoryStore(t)
+	ctx := context.Background()
+	for _, msg := range []Message{
+		{ID: "0b7c6a0e-3bfc-4bd6-9d0f-7a8f6c9e2d11", Room: "general", FileURL: "/download/general/a.png", FileName: "a.png"},
+		{ID: "1c8d7b1f-4cad-4ce7-8e1a-8b9a7dae3e22", Room: "general", FileURL: "/download/general/b.png", FileName: "b.png"},
+		{ID: "2d9e8c2a-5dbe-4df8-9f2b-9cab8ebf4f33", Room: "general", FileURL: "/download/general/c.png", FileName: "c.png"},
+		{ID: "3eaf9d3b-6ecf-4e09-8a3c-adbc9fca5a44", Room: "general", Content: "no file"},
+		// Points into a room its sender can't access
+		{ID: "4fbaae4c-7fda-4f1a-9b4d-becdaadb6b55", Room: "general", FileURL: "/download/secret/d.png", FileName: "d.png"},
+	} {
+		if err := messageStore.Insert(ctx, &msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	messageStore.RemoveFile(ctx, "1c8d7b1f-4cad-4ce7-8e1a-8b9a7dae3e22", "")
+	messageStore.RemoveFile(ctx, "2d9e8c2a-5dbe-4df8-9f2b-9cab8ebf4f33", fileExpiredReason)
+	messageStore.JoinRoom(ctx, "general", "bob")
+	t.Cleanup(func() { privateRooms, directDownloads = false, true })
+
+	tests := []struct {
+		name    string
+		private bool
+		direct  bool
+		target  string
+		code    int
+		errCode string
+	}{
+		{"deleted file", false, true, "/download/by-message/1c8d7b1f-4cad-4ce7-8e1a-8b9a7dae3e22", http.StatusGone, "ERR_FILE_DELETED"},
+		{"expired file", false, true, "/download/by-message/2d9e8c2a-5dbe-4df8-9f2b-9cab8ebf4f33", http.StatusGone, "ERR_FILE_EXPIRED"},
+		{"message without a file", false, true, "/download/by-message/3eaf9d3b-6ecf-4e09-8a3c-adbc9fca5a44", http.StatusNotFound, ""},
+		{"unknown message", false, true, "/download/by-message/5acbbf5d-8aeb-4a2b-8c5e-cfdebbec7c66", http.StatusNotFound, ""},
+		{"not a member", true, true, "/download/by-message/0b7c6a0e-3bfc-4bd6-9d0f-7a8f6c9e2d11?username=carol", http.StatusForbidden, ""},
+		{"file in another room", true, true, "/download/by-message/4fbaae4c-7fda-4f1a-9b4d-becdaadb6b55?username=bob", http.StatusForbidden, ""},
+		{"reserved object", false, true, "/download/.config/retention.json", http.StatusNotFound, ""},
+		{"direct downloads off", false, false, "/download/general/a.png", http.StatusNotFound, ""},
+		{"direct, not a member", true, true, "/download/general/a.png?username=carol", http.StatusForbidden, ""},
+		{"bad disposition", false, true, "/download/general/a.png?disposition=evil", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			privateRooms, directDownloads = tt.private, tt.direct
+			rec := serveTest(http.MethodGet, "/download/*filename", tt.target, nil, handleFileDownload)
+			if rec.Code != tt.code {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.code, rec.Body)
+			}
+			if tt.errCode != "" && !strings.Contains(rec.Body.String(), tt.errCode) {
+				t.Errorf("body = %s, want code %s", rec.Body, tt.errCode)
+			}
+		})
+	}
+}
+
+func TestContentDisposition(t *testing.T) {
+	tests := []struct {
+		requested   string
+		contentType string
+		want        string
+	}{
+		{"inline", "image/png", "inline"},
+		{"inline", "application/pdf", "inline"},
+		{"attachment", "image/png", "attachment"},
+		{"", "image/png", "attachment"},
+		{"inline", "text/html", "attachment"},
+		{"inline", "text/html; charset=utf-8", "attachment"},
+		{"inline", "TEXT/HTML", "attachment"},
+		{"inline", "application/xhtml+xml", "attachment"},
+		{"inline", "image/svg+xml", "attachment"},
+		{"inline", "text/xml", "attachment"},
+		{"inline", "application/javascript", "attachment"},
+		{"inline", "not a media type", "attachment"},
+	}
+	for _, tt := range tests {
+		if got := contentDisposition(tt.requested, tt.contentType); got != tt.want {
+			t.Errorf("contentDisposition(%q, %q) = %q, want %q", tt.requested, tt.contentType, got, tt.want)
+		}
+	}
+}
+
+func TestDownloadForcesUnsafeTypesToAttachment(t *testing.T) {
+	useMemoryStore(t)
+	useBroadcastQueue(t)
+	useUploadLimiter(t, 60, 10)
+	useFakeS3(t, bucketName)
+	savedDirect := directDownloads
+	directDownloads = true
+	t.Cleanup(func() { directDownloads = savedDirect })
+
+	tests := []struct {
+		name     string
+		fileName string
+		data     string
+		want     string
+	}{
+		{"HTML", "page.html", "<!DOCTYPE html><html><script>alert(1)</script></html>", "attachment"},
+		{"HTML with a harmless name", "notes.txt", "<html><body><script>alert(1)</script></body></html>", "attachment"},
+		{"SVG", "logo.svg", `<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`, "attachment"},
+		{"PNG", "dot.png", "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR", "inline"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newUploadForm("/upload", map[string]string{"username": "alice", "room": "general"}, tt.fileName, tt.data)
+			rec := serveTestRequest("/upload", req, handleFileUpload)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("upload: status = %d: %s", rec.Code, rec.Body)
+			}
+			var uploaded struct {
+				FileURL string `json:"fileUrl"`
+			}
+			json.Unmarshal(rec.Body.Bytes(), &uploaded)
+
+			rec = serveTest(http.MethodGet, "/download/*filename", uploaded.FileURL+"?disposition=inline", nil, handleFileDownload)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("download: status = %d: %s", rec.Code, rec.Body)
+			}
+			disposition := rec.Header().Get("Content-Disposition")
+			if !strings.HasPrefix(disposition, tt.want+";") {
+				t.Errorf("Content-Disposition = %q (Content-Type %q), want %s", disposition, rec.Header().Get("Content-Type"), tt.want)
+			}
+			if rec.Header().Get("X-Content-Type-Options") != "nosniff" {
+				t.Error("download not marked nosniff")
+			}
+		})
+	}
+}
+
+func TestDownloadAbortsWhenRequestCanceled(t *testing.T) {
+	useMemoryStore(t)
+	fake := useFakeS3(t, bucketName)
+	fake.putObject(bucketName, "general/a.txt", []byte("hello"), nil)
+	fake.delay = 10 * time.Second
+	savedDirect := directDownloads
+	directDownloads = true
+	t.Cleanup(func() { directDownloads = savedDirect })
+
+	// The client goes away while MinIO is still answering
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "/download/general/a.txt", nil).WithContext(ctx)
+
+	start := time.Now()
+	rec := serveTestRequest("/download/*filename", req, handleFileDownload)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("download took %v after the request was canceled, want the MinIO call aborted", elapsed)
+	}
+	if rec.Code == http.StatusOK {
+		t.Errorf("status = %d, want an error for the canceled request", rec.Code)
+	}
+}
+
+// Serve direct downloads of one object from a fake MinIO whose GETs are
+// passed to onGet, resuming broken streams up to retries times
+func useBrokenDownload(t *testing.T, data []byte, retries int, onGet func(fake *fakeS3, attempt int, r *http.Request) bool) *fakeS3 {
+	t.Helper()
+	useMemoryStore(t)
+	fake := useFakeS3(t, bucketName)
+	fake.putObject(bucketName, "general/big.bin", data, nil)
+	attempts := 0
+	fake.onGet = func(r *http.Request) bool {
+		attempts++
+		return onGet(fake, attempts, r)
+	}
+	savedDirect, savedRetries := directDownloads, downloadMaxRetries
+	directDownloads, downloadMaxRetries = true, retries
+	t.Cleanup(func() { directDownloads, downloadMaxRetries = savedDirect, savedRetries })
+	return fake
+}
+
+// Serve a download that is expected to abort the connection partway,
+// returning what was sent before it did
+func serveAbortedDownload(t *testing.T, target string) (rec *httptest.ResponseRecorder) {
+	t.Helper()
+	rec = httptest.NewRecorder()
+	defer func() {
+		if r := recover(); r != http.ErrAbortHandler {
+			t.Errorf("download ended with %v, want the connection aborted", r)
+		}
+	}()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/download/*filename", handleFileDownload)
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	return rec
+}
+
+func TestStreamObjectResumesWithRange(t *testing.T) {
+	captureLog(t)
+	data := []byte(strings.Repeat("0123456789", 20000))
+	var resumed *http.Request
+	fake := useBrokenDownload(t, data, 2, func(fake *fakeS3, attempt int, r *http.Request) bool {
+		if attempt == 2 {
+			resumed = r
+		}
+		return attempt > 1
+	})
+	retries := testutil.ToFloat64(downloadRetriesTotal)
+
+	rec := serveTest(http.MethodGet, "/download/*filename", "/download/general/big.bin", nil, handleFileDownload)
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), data) {
+		t.Fatalf("download: status %d, %d of %d bytes intact", rec.Code, rec.Body.Len(), len(data))
+	}
+	if resumed == nil {
+		t.Fatal("broken stream was not resumed")
+	}
+	// The resumed request asks for the rest of this exact file
+	var start int
+	if n, _ := fmt.Sscanf(resumed.Header.Get("Range"), "bytes=%d-", &start); n != 1 || start <= 0 || start > len(data)/2 {
+		t.Errorf("resumed with Range %q, want the bytes after the first half", resumed.Header.Get("Range"))
+	}
+	if etag := strings.Trim(resumed.Header.Get("If-Match"), `"`); etag != fake.object(bucketName, "general/big.bin").etag {
+		t.Errorf("resumed with If-Match %q, want the object's ETag", resumed.Header.Get("If-Match"))
+	}
+	if n := testutil.ToFloat64(downloadRetriesTotal) - retries; n != 1 {
+		t.Errorf("chat_download_retries_total increased by %v, want 1", n)
+	}
+}
+
+func TestStreamObjectStopsWhenFileChanges(t *testing.T) {
+	captureLog(t)
+	data := []byte(strings.Repeat("0123456789", 20000))
+	useBrokenDownload(t, data, 2, func(fake *fakeS3, attempt int, r *http.Request) bool {
+		if attempt == 1 {
+			// Replaced while the first half was being sent
+			fake.putObject(bucketName, "general/big.bin", []byte(strings.Repeat("x", len(data))), nil)
+			return false
+		}
+		return true
+	})
+	failures := testutil.ToFloat64(downloadPartialFailuresTotal)
+
+	rec := serveAbortedDownload(t, "/download/general/big.bin")
+	if rec.Body.Len() >= len(data) || bytes.Contains(rec.Body.Bytes(), []byte("x")) {
+		t.Errorf("download sent %d bytes, want only the first part of the original file", rec.Body.Len())
+	}
+	if n := testutil.ToFloat64(downloadPartialFailuresTotal) - failures; n != 1 {
+		t.Errorf("chat_download_partial_failures_total increased by %v, want 1", n)
+	}
+}
+
+func TestStreamObjectGivesUpAfterRetries(t *testing.T) {
+	captureLog(t)
+	data := []byte(strings.Repeat("0123456789", 20000))
+	useBrokenDownload(t, data, 2, func(fake *fakeS3, attempt int, r *http.Request) bool {
+		return false
+	})
+	failures := testutil.ToFloat64(downloadPartialFailuresTotal)
+
+	rec := serveAbortedDownload(t, "/download/general/big.bin")
+	if rec.Body.Len() >= len(data) {
+		t.Errorf("download sent %d bytes from a stream that always breaks", rec.Body.Len())
+	}
+	if n := testutil.ToFloat64(downloadPartialFailuresTotal) - failures; n != 1 {
+		t.Errorf("chat_download_partial_failures_total increased by %v, want 1", n)
+	}
+}
@@ -0,0 +1,82 @@
+// downloadcount.go
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FileDownload records one completed download of a shared file
+type FileDownload struct {
+	MessageID    string    `json:"messageId"` // the file message
+	Username     string    `json:"username,omitempty"`
+	DownloadedAt time.Time `json:"downloadedAt"`
+	IPAddress    string    `json:"ipAddress"`
+}
+
+// Download records of each file message; a file's download count is the
+// number of its records
+var (
+	fileDownloads   = make(map[string][]FileDownload) // message ID -> downloads, oldest first
+	fileDownloadsMu sync.Mutex
+)
+
+// Record a completed download of a message's file and tell its room the
+// new count. Every download counts, including repeats by the same user.
+func recordDownload(msg Message, username, ip string) {
+	fileDownloadsMu.Lock()
+	fileDownloads[msg.ID] = append(fileDownloads[msg.ID], FileDownload{
+		MessageID:    msg.ID,
+		Username:     username,
+		DownloadedAt: time.Now(),
+		IPAddress:    ip,
+	})
+	count := len(fileDownloads[msg.ID])
+	fileDownloadsMu.Unlock()
+
+	publish(Message{
+		ID:        uuid.New().String(),
+		Type:      MessageTypeDownloadCount,
+		Room:      msg.Room,
+		Username:  "System",
+		MessageID: msg.ID,
+		Count:     count,
+		Timestamp: time.Now(),
+	})
+}
+
+// Record a completed download of an object by name. It counts for the
+// newest message that shared the object (the version, if one was asked
+// for), since deduplicated uploads may be shared by several messages.
+func recordObjectDownload(ctx context.Context, objectName, versionID, username, ip string) {
+	messages, err := messagesSharingObject(ctx, objectName, versionID)
+	if err != nil {
+		log.Printf("Error finding the message of downloaded file %s: %v", objectName, err)
+		return
+	}
+	if len(messages) == 0 {
+		return
+	}
+	recordDownload(messages[len(messages)-1], username, ip)
+}
+
+// Return how many times a message's file was downloaded
+func downloadCount(messageID string) int {
+	fileDownloadsMu.Lock()
+	defer fileDownloadsMu.Unlock()
+	return len(fileDownloads[messageID])
+}
+
+// Set the download counts of the file messages in a history listing
+func withDownloadCounts(messages []Message) []Message {
+	for i := range messages {
+		if messages[i].FileURL != "" {
+			messages[i].DownloadCount = downloadCount(messages[i].ID)
+		}
+	}
+	return messages
+}
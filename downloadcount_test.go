@@ -0,0 +1,72 @@
+// downloadcount_test.go
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+// Start a test with no downloads recorded
+func useDownloadCounts(t *testing.T) {
+	t.Helper()
+	fileDownloadsMu.Lock()
+	saved := fileDownloads
+	fileDownloads = make(map[string][]FileDownload)
+	fileDownloadsMu.Unlock()
+	t.Cleanup(func() {
+		fileDownloadsMu.Lock()
+		fileDownloads = saved
+		fileDownloadsMu.Unlock()
+	})
+}
+
+func TestRepeatDownloadsCount(t *testing.T) {
+	tests := []struct {
+		name   string
+		direct bool
+	}{
+		{"by message", false},
+		{"by object", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useMemoryStore(t)
+			useFakeS3(t, bucketName)
+			useUploadLimiter(t, 60, 10)
+			useDownloadCounts(t)
+			queue := useBroadcastQueue(t)
+			savedDirect := directDownloads
+			directDownloads = tt.direct
+			t.Cleanup(func() { directDownloads = savedDirect })
+
+			req := newUploadForm("/upload", map[string]string{"username": "alice", "room": "general"}, "notes.txt", "meeting notes")
+			if rec := serveTestRequest("/upload", req, handleFileUpload); rec.Code != http.StatusOK {
+				t.Fatalf("upload: status = %d: %s", rec.Code, rec.Body)
+			}
+			// Stored as the broadcaster would
+			uploaded := waitPublished(t, queue)
+			if err := messageStore.Insert(context.Background(), &uploaded); err != nil {
+				t.Fatal(err)
+			}
+
+			// The same user downloading twice counts twice
+			for want := 1; want <= 2; want++ {
+				rec := serveTest(http.MethodGet, "/download/*filename", uploaded.FileURL+"?username=bob", nil, handleFileDownload)
+				if rec.Code != http.StatusOK {
+					t.Fatalf("download %d: status = %d: %s", want, rec.Code, rec.Body)
+				}
+				msg := waitPublished(t, queue)
+				if msg.Type != MessageTypeDownloadCount || msg.MessageID != uploaded.ID || msg.Count != want || msg.Room != "general" {
+					t.Errorf("download %d published %+v, want count %d for %s", want, msg, want, uploaded.ID)
+				}
+			}
+			if n := downloadCount(uploaded.ID); n != 2 {
+				t.Errorf("download count = %d, want 2", n)
+			}
+			if listed := withDownloadCounts([]Message{uploaded}); listed[0].DownloadCount != 2 {
+				t.Errorf("history lists %d downloads, want 2", listed[0].DownloadCount)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+// duplicates.go
+package main
+
+import (
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// duplicateDetector remembers each user's last message per room, so an
+// identical message sent again within the window can be dropped
+type duplicateDetector struct {
+	mu     sync.Mutex
+	window time.Duration
+	last   map[string]sentMessage // room + username -> last message
+}
+
+// A message as compared for duplicates
+type sentMessage struct {
+	content string
+	fileURL string
+	at      time.Time
+}
+
+// Detector for double-sent messages (nil when detection is disabled), and
+// whether senders are told when a duplicate is dropped
+var (
+	duplicates      *duplicateDetector
+	notifyDuplicate bool
+)
+
+// Initialize duplicate detection from environment variables. A chat message
+// identical to the sender's previous one in the same room is dropped when it
+// arrives within DUPLICATE_MESSAGE_WINDOW_MS (0 disables detection). With
+// DUPLICATE_MESSAGE_ACTION=notify the sender is told the message was dropped;
+// the default, drop, discards it silently.
+func initDuplicates() {
+	window := time.Duration(getEnvInt("DUPLICATE_MESSAGE_WINDOW_MS", 0)) * time.Millisecond
+	if window <= 0 {
+		return
+	}
+	duplicates = &duplicateDetector{window: window, last: make(map[string]sentMessage)}
+
+	switch action := os.Getenv("DUPLICATE_MESSAGE_ACTION"); action {
+	case "", "drop":
+	case "notify":
+		notifyDuplicate = true
+	default:
+		log.Printf("Warning: invalid value for DUPLICATE_MESSAGE_ACTION (%q), using drop", action)
+	}
+}
+
+// Record a message and report whether it repeats the sender's previous
+// message in the room within the window. Duplicates do not extend the
+// window, so a message repeated steadily still gets through once per window.
+func (d *duplicateDetector) IsDuplicate(msg Message) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	for key, sent := range d.last {
+		if now.Sub(sent.at) >= d.window {
+			delete(d.last, key)
+		}
+	}
+
+	key := msg.Room + "\x00" + msg.Username
+	if prev, ok := d.last[key]; ok && prev.content == msg.Content && prev.fileURL == msg.FileURL {
+		return true
+	}
+	d.last[key] = sentMessage{content: msg.Content, fileURL: msg.FileURL, at: now}
+	return false
+}
+
+// Tell a user that their message was dropped as a duplicate
+func notifyDuplicateDropped(username string) {
+	sendToUser(username, Message{
+		ID:        uuid.New().String(),
+		Username:  "System",
+		Content:   "Your message was not sent because it repeats your previous message.",
+		Timestamp: time.Now(),
+	})
+}
@@ -0,0 +1,71 @@
+// duplicates_test.go
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Drop duplicates within window for a test, notifying senders if notify is set
+func useDuplicates(t *testing.T, window time.Duration, notify bool) {
+	t.Helper()
+	savedDetector, savedNotify := duplicates, notifyDuplicate
+	duplicates = &duplicateDetector{window: window, last: make(map[string]sentMessage)}
+	notifyDuplicate = notify
+	t.Cleanup(func() { duplicates, notifyDuplicate = savedDetector, savedNotify })
+}
+
+func TestIsDuplicate(t *testing.T) {
+	d := &duplicateDetector{window: time.Hour, last: make(map[string]sentMessage)}
+	msg := Message{Room: "general", Username: "alice", Content: "hi"}
+
+	tests := []struct {
+		name string
+		msg  Message
+		want bool
+	}{
+		{"first", msg, false},
+		{"repeated", msg, true},
+		{"other user", Message{Room: "general", Username: "bob", Content: "hi"}, false},
+		{"other room", Message{Room: "random", Username: "alice", Content: "hi"}, false},
+		{"same text with a file", Message{Room: "general", Username: "alice", Content: "hi", FileURL: "/download/a.png"}, false},
+		{"different text", Message{Room: "general", Username: "alice", Content: "hello"}, false},
+		{"earlier text again", msg, false},
+	}
+	for _, tt := range tests {
+		if got := d.IsDuplicate(tt.msg); got != tt.want {
+			t.Errorf("%s: IsDuplicate = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDuplicateDroppedWithinWindow(t *testing.T) {
+	useMemoryStore(t)
+	useDuplicates(t, 200*time.Millisecond, true)
+	captureLog(t)
+	alice := &Client{username: "alice", room: "general", send: make(chan Message, 10)}
+	bob := &Client{username: "bob", room: "general", send: make(chan Message, 10)}
+	useClients(t, alice, bob)
+
+	handleMessage(Message{ID: "m1", Room: "general", Username: "alice", Content: "hi"})
+	handleMessage(Message{ID: "m2", Room: "general", Username: "alice", Content: "hi"})
+	if msg := <-bob.send; msg.ID != "m1" {
+		t.Fatalf("bob got %s, want m1", msg.ID)
+	}
+	if len(bob.send) != 0 {
+		t.Errorf("bob got the double-sent message: %+v", <-bob.send)
+	}
+
+	// The sender is told, and nobody else is
+	<-alice.send // m1
+	if msg := <-alice.send; msg.Username != "System" || msg.ID == "m2" {
+		t.Errorf("alice got %+v, want a notice that m2 was dropped", msg)
+	}
+
+	// Sent again after the window, it goes through
+	time.Sleep(250 * time.Millisecond)
+	handleMessage(Message{ID: "m3", Room: "general", Username: "alice", Content: "hi"})
+	if msg := <-bob.send; msg.ID != "m3" {
+		t.Errorf("bob got %s, want m3 sent after the window", msg.ID)
+	}
+}
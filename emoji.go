@@ -0,0 +1,309 @@
+// emoji.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go/v7"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// Custom emoji images are stored under this prefix, which is not a valid
+// room name so it can't collide with room uploads
+const emojiPrefix = ".emoji/"
+
+// Largest accepted custom emoji image
+const maxEmojiBytes = 256 << 10
+
+// Custom emoji are stored as emojiSize x emojiSize images
+const emojiSize = 64
+
+// Custom emoji names, used as :name: in messages and reactions
+var emojiNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_]{2,32}$`)
+
+// Image types accepted for custom emoji, detected from the file contents
+var emojiContentTypes = map[string]bool{
+	"image/png":  true,
+	"image/gif":  true,
+	"image/jpeg": true,
+	"image/webp": true,
+}
+
+// CustomEmoji is an uploaded image usable as :name:
+type CustomEmoji struct {
+	Name        string    `json:"name"`
+	URL         string    `json:"url"`
+	ContentType string    `json:"contentType,omitempty"`
+	Uploader    string    `json:"uploader,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// Custom emoji registry
+var (
+	customEmoji   = make(map[string]CustomEmoji) // name -> emoji
+	customEmojiMu sync.RWMutex
+)
+
+// Load the custom emoji already stored in MinIO
+func initEmoji(ctx context.Context) {
+	customEmojiMu.Lock()
+	defer customEmojiMu.Unlock()
+
+	for object := range minioClient.ListObjects(ctx, bucketName, minio.ListObjectsOptions{Prefix: emojiPrefix, WithMetadata: true}) {
+		if object.Err != nil {
+			log.Printf("Error listing custom emoji: %v", object.Err)
+			return
+		}
+		name := strings.TrimPrefix(object.Key, emojiPrefix)
+		customEmoji[name] = CustomEmoji{
+			Name:        name,
+			URL:         emojiURL(name),
+			ContentType: object.ContentType,
+			Uploader:    objectMetadata(object.UserMetadata, "Uploader"),
+			CreatedAt:   object.LastModified,
+		}
+	}
+	if len(customEmoji) > 0 {
+		log.Printf("Loaded %d custom emoji", len(customEmoji))
+	}
+}
+
+// URL a custom emoji image is served from
+func emojiURL(name string) string {
+	return "/emoji/" + name
+}
+
+// Look up a custom emoji by name
+func lookupEmoji(name string) (CustomEmoji, bool) {
+	customEmojiMu.RLock()
+	defer customEmojiMu.RUnlock()
+	emoji, ok := customEmoji[name]
+	return emoji, ok
+}
+
+// Return the image URLs of the custom emoji referenced as :name: in text,
+// or nil if there are none. Standard shortcodes take precedence, so a custom
+// emoji sharing a standard shortcode's name is not used.
+func customEmojiRefs(text string) map[string]string {
+	table := shortcodes
+	if table == nil {
+		table = defaultShortcodes
+	}
+	var refs map[string]string
+	for _, match := range shortcodePattern.FindAllStringSubmatch(text, -1) {
+		if _, standard := table[match[1]]; standard {
+			continue
+		}
+		if emoji, ok := lookupEmoji(match[1]); ok {
+			if refs == nil {
+				refs = make(map[string]string)
+			}
+			refs[match[0]] = emoji.URL
+		}
+	}
+	return refs
+}
+
+// Report whether a :name: reaction refers to a known shortcode or custom emoji
+func knownShortcode(reaction string) bool {
+	if !strings.HasPrefix(reaction, ":") || !strings.HasSuffix(reaction, ":") || len(reaction) < 3 {
+		return false
+	}
+	name := reaction[1 : len(reaction)-1]
+	table := shortcodes
+	if table == nil {
+		table = defaultShortcodes
+	}
+	if _, ok := table[name]; ok {
+		return true
+	}
+	_, ok := lookupEmoji(name)
+	return ok
+}
+
+// List the custom emoji, sorted by name
+func handleListEmoji(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"emoji": listCustomEmoji()})
+}
+
+// Return the custom emoji, sorted by name
+func listCustomEmoji() []CustomEmoji {
+	customEmojiMu.RLock()
+	list := make([]CustomEmoji, 0, len(customEmoji))
+	for _, emoji := range customEmoji {
+		list = append(list, emoji)
+	}
+	customEmojiMu.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Scale an emoji image to fit emojiSize x emojiSize, keeping its aspect
+// ratio on a transparent background. Images already that size are kept as
+// they are, so animated GIFs stay animated; others are re-encoded as PNG.
+func resizeEmoji(data []byte, contentType string) ([]byte, string, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	if config.Width == emojiSize && config.Height == emojiSize {
+		return data, contentType, nil
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	width, height := emojiSize, emojiSize
+	if config.Width > config.Height {
+		height = max(1, config.Height*emojiSize/config.Width)
+	} else if config.Height > config.Width {
+		width = max(1, config.Width*emojiSize/config.Height)
+	}
+	offset := image.Pt((emojiSize-width)/2, (emojiSize-height)/2)
+
+	dst := image.NewNRGBA(image.Rect(0, 0, emojiSize, emojiSize))
+	draw.CatmullRom.Scale(dst, image.Rectangle{Min: offset, Max: offset.Add(image.Pt(width, height))}, src, src.Bounds(), draw.Over, nil)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, dst); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), "image/png", nil
+}
+
+// Upload a custom emoji image (admin only); uploading an existing name
+// replaces its image. Images that are not 64x64 are resized. The uploader
+// is recorded from ?username=.
+func handleUploadEmoji(c *gin.Context) {
+	name := c.PostForm("name")
+	if !emojiNamePattern.MatchString(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Emoji names must be 2-32 letters, digits or underscores"})
+		return
+	}
+
+	file, _, err := c.Request.FormFile("file")
+	if err != nil {
+		if limit, ok := isBodyTooLarge(err); ok {
+			abortBodyTooLarge(c, limit)
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No file provided"})
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxEmojiBytes+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read file"})
+		return
+	}
+	if len(data) > maxEmojiBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Emoji images are limited to %d bytes", maxEmojiBytes)})
+		return
+	}
+
+	// Trust the file contents, not the name or declared type
+	contentType := http.DetectContentType(data)
+	if !emojiContentTypes[contentType] {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Emoji must be a PNG, GIF, JPEG or WebP image"})
+		return
+	}
+	data, contentType, err = resizeEmoji(data, contentType)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to decode image"})
+		return
+	}
+
+	uploader := c.Query("username")
+	if uploader == "" {
+		uploader = "admin"
+	}
+	_, err = minioClient.PutObject(c.Request.Context(), bucketName, emojiPrefix+name, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType:  contentType,
+		UserMetadata: map[string]string{"uploader": url.PathEscape(uploader)},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload emoji to storage"})
+		log.Printf("Error uploading emoji: %v", err)
+		return
+	}
+
+	emoji := CustomEmoji{Name: name, URL: emojiURL(name), ContentType: contentType, Uploader: uploader, CreatedAt: time.Now()}
+	customEmojiMu.Lock()
+	customEmoji[name] = emoji
+	customEmojiMu.Unlock()
+
+	c.JSON(http.StatusOK, emoji)
+}
+
+// Remove a custom emoji (admin only). Messages that used it keep its name
+// as plain text.
+func handleDeleteEmoji(c *gin.Context) {
+	name := c.Param("name")
+	if _, ok := lookupEmoji(name); !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Emoji not found"})
+		return
+	}
+
+	if err := minioClient.RemoveObject(c.Request.Context(), bucketName, emojiPrefix+name, minio.RemoveObjectOptions{}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete emoji"})
+		log.Printf("Error deleting emoji: %v", err)
+		return
+	}
+	customEmojiMu.Lock()
+	delete(customEmoji, name)
+	customEmojiMu.Unlock()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Emoji deleted", "name": name})
+}
+
+// Serve a custom emoji image
+func handleGetEmoji(c *gin.Context) {
+	name := c.Param("name")
+	if _, ok := lookupEmoji(name); !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Emoji not found"})
+		return
+	}
+
+	object, err := minioClient.GetObject(c.Request.Context(), bucketName, emojiPrefix+name, minio.GetObjectOptions{})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve emoji"})
+		log.Printf("Error getting emoji: %v", err)
+		return
+	}
+	defer object.Close()
+
+	info, err := object.Stat()
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Emoji not found"})
+		log.Printf("Error getting emoji info: %v", err)
+		return
+	}
+
+	c.Header("Content-Type", info.ContentType)
+	c.Header("Content-Length", fmt.Sprintf("%d", info.Size))
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.Header("X-Content-Type-Options", "nosniff")
+	if _, err := io.Copy(c.Writer, object); err != nil {
+		log.Printf("Error streaming emoji: %v", err)
+	}
+}
@@ -0,0 +1,228 @@
+// emoji_test.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// Register custom emoji for the duration of a test
+func useCustomEmoji(t *testing.T, names ...string) {
+	t.Helper()
+	customEmojiMu.Lock()
+	saved := customEmoji
+	customEmoji = make(map[string]CustomEmoji)
+	for _, name := range names {
+		customEmoji[name] = CustomEmoji{Name: name, URL: emojiURL(name)}
+	}
+	customEmojiMu.Unlock()
+	t.Cleanup(func() {
+		customEmojiMu.Lock()
+		customEmoji = saved
+		customEmojiMu.Unlock()
+	})
+}
+
+func TestCustomEmojiRefs(t *testing.T) {
+	useCustomEmoji(t, "parrot", "shipit", "smile")
+
+	tests := []struct {
+		name string
+		in   string
+		want map[string]string
+	}{
+		{"one", "party :parrot:", map[string]string{":parrot:": "/emoji/parrot"}},
+		{"several", ":parrot: :shipit: :parrot:", map[string]string{":parrot:": "/emoji/parrot", ":shipit:": "/emoji/shipit"}},
+		{"standard shortcode wins", ":smile:", nil},
+		{"unknown", ":nope:", nil},
+		{"none", "plain text", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := customEmojiRefs(tt.in)
+			if len(got) != len(tt.want) {
+				t.Fatalf("customEmojiRefs(%q) = %v, want %v", tt.in, got, tt.want)
+			}
+			for ref, url := range tt.want {
+				if got[ref] != url {
+					t.Errorf("customEmojiRefs(%q)[%q] = %q, want %q", tt.in, ref, got[ref], url)
+				}
+			}
+		})
+	}
+}
+
+func TestCustomEmojiInMessages(t *testing.T) {
+	useMemoryStore(t)
+	useCustomEmoji(t, "parrot")
+	client := &Client{username: "bob", room: "general", send: make(chan Message, 10)}
+	useClients(t, client)
+
+	handleMessage(Message{ID: "m1", Room: "general", Username: "alice", Content: "party :parrot: :nope:"})
+	msg := <-client.send
+	if len(msg.Emoji) != 1 || msg.Emoji[":parrot:"] != "/emoji/parrot" {
+		t.Errorf("emoji = %v, want only :parrot: resolved to its image", msg.Emoji)
+	}
+	if msg.Content != "party :parrot: :nope:" {
+		t.Errorf("content = %q, want the raw text kept", msg.Content)
+	}
+}
+
+func TestCustomEmojiReactions(t *testing.T) {
+	useMemoryStore(t)
+	useCustomEmoji(t, "parrot")
+	if err := messageStore.Insert(context.Background(), &Message{ID: "m1", Room: "general", Username: "bob", Content: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		reaction string
+		ok       bool
+		emoji    string
+	}{
+		{"custom emoji", ":parrot:", true, "/emoji/parrot"},
+		{"standard shortcode", ":smile:", true, ""},
+		{"plain emoji", "👍", true, ""},
+		{"unknown shortcode", ":nope:", false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := Message{Type: MessageTypeReaction, Room: "general", Username: "alice", MessageID: "m1", Content: tt.reaction}
+			if ok := applyReaction(context.Background(), &msg); ok != tt.ok {
+				t.Fatalf("applyReaction(%q) = %v, want %v", tt.reaction, ok, tt.ok)
+			}
+			if !tt.ok {
+				return
+			}
+			if msg.Reactions[tt.reaction] != 1 {
+				t.Errorf("reactions = %v, want %q counted once", msg.Reactions, tt.reaction)
+			}
+			if msg.Emoji[tt.reaction] != tt.emoji {
+				t.Errorf("emoji[%q] = %q, want %q", tt.reaction, msg.Emoji[tt.reaction], tt.emoji)
+			}
+		})
+	}
+}
+
+// Encode an opaque width x height image in the given format
+func testImage(t *testing.T, format string, width, height int) []byte {
+	t.Helper()
+	img := image.NewPaletted(image.Rect(0, 0, width, height), color.Palette{color.RGBA{255, 0, 0, 255}})
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "png":
+		err = png.Encode(&buf, img)
+	case "gif":
+		err = gif.Encode(&buf, img, nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestResizeEmoji(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		in     string
+		out    string
+		kept   bool     // returned unchanged
+		opaque [][2]int // points that must hold the image, not the transparent padding
+		clear  [][2]int // points that must be transparent padding
+	}{
+		{"already 64x64", testImage(t, "png", 64, 64), "image/png", "image/png", true, nil, nil},
+		{"animated size kept as GIF", testImage(t, "gif", 64, 64), "image/gif", "image/gif", true, nil, nil},
+		{"larger square", testImage(t, "png", 256, 256), "image/png", "image/png", false, [][2]int{{0, 0}, {63, 63}}, nil},
+		{"smaller", testImage(t, "png", 16, 16), "image/png", "image/png", false, [][2]int{{0, 0}, {63, 63}}, nil},
+		{"wide", testImage(t, "png", 128, 32), "image/png", "image/png", false, [][2]int{{0, 32}, {63, 32}}, [][2]int{{32, 0}, {32, 63}}},
+		{"tall GIF", testImage(t, "gif", 20, 80), "image/gif", "image/png", false, [][2]int{{32, 0}, {32, 63}}, [][2]int{{0, 32}, {63, 32}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, contentType, err := resizeEmoji(tt.data, tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if contentType != tt.out {
+				t.Errorf("content type = %q, want %q", contentType, tt.out)
+			}
+			if kept := bytes.Equal(data, tt.data); kept != tt.kept {
+				t.Errorf("image kept unchanged = %v, want %v", kept, tt.kept)
+			}
+			img, _, err := image.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size := img.Bounds().Size(); size.X != emojiSize || size.Y != emojiSize {
+				t.Fatalf("resized to %v, want %dx%d", size, emojiSize, emojiSize)
+			}
+			for _, p := range tt.opaque {
+				if _, _, _, a := img.At(p[0], p[1]).RGBA(); a == 0 {
+					t.Errorf("pixel %v is transparent, want the image", p)
+				}
+			}
+			for _, p := range tt.clear {
+				if _, _, _, a := img.At(p[0], p[1]).RGBA(); a != 0 {
+					t.Errorf("pixel %v is opaque, want transparent padding", p)
+				}
+			}
+		})
+	}
+
+	if _, _, err := resizeEmoji([]byte("not an image"), "image/png"); err == nil {
+		t.Error("resizing garbage succeeded")
+	}
+}
+
+func TestUploadEmojiRecordsUploader(t *testing.T) {
+	useCustomEmoji(t)
+	fake := useFakeS3(t, bucketName)
+
+	req := newUploadForm("/emoji?username=carol", map[string]string{"name": "parrot"}, "parrot.png", string(testImage(t, "png", 128, 128)))
+	rec := serveTestRequest("/emoji", req, handleUploadEmoji)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var uploaded CustomEmoji
+	json.Unmarshal(rec.Body.Bytes(), &uploaded)
+	if uploaded.Uploader != "carol" || uploaded.ContentType != "image/png" {
+		t.Errorf("uploaded %+v, want a PNG from carol", uploaded)
+	}
+
+	// The stored image is resized and carries its uploader
+	obj := fake.object(bucketName, emojiPrefix+"parrot")
+	if obj == nil {
+		t.Fatal("emoji not stored")
+	}
+	if config, err := png.DecodeConfig(bytes.NewReader(obj.data)); err != nil || config.Width != emojiSize || config.Height != emojiSize {
+		t.Errorf("stored image is %dx%d (%v), want %dx%d", config.Width, config.Height, err, emojiSize, emojiSize)
+	}
+	if uploader := obj.metadata.Get("X-Amz-Meta-Uploader"); uploader != "carol" {
+		t.Errorf("stored uploader = %q, want carol", uploader)
+	}
+
+	// A restart reads the uploader back from storage
+	useCustomEmoji(t)
+	initEmoji(context.Background())
+	if emoji, ok := lookupEmoji("parrot"); !ok || emoji.Uploader != "carol" {
+		t.Errorf("reloaded emoji = %+v, %v; want carol's parrot", emoji, ok)
+	}
+
+	// Without a username the upload is credited to the admin
+	req = newUploadForm("/emoji", map[string]string{"name": "shipit"}, "shipit.png", string(testImage(t, "png", 64, 64)))
+	rec = serveTestRequest("/emoji", req, handleUploadEmoji)
+	if !strings.Contains(rec.Body.String(), `"uploader":"admin"`) {
+		t.Errorf("response = %s, want the upload credited to admin", rec.Body)
+	}
+}
@@ -0,0 +1,251 @@
+// files.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Per-user concurrent upload tracking
+var (
+	activeUploads     = make(map[string]int) // username -> uploads in progress
+	activeUploadsMu   sync.Mutex
+	maxUploadsPerUser int // 0 means unlimited
+)
+
+// Bytes of an upload form kept in memory; larger files spill to temporary files
+var uploadFormMemory int64
+
+// Initialize upload limits from environment variables
+func initUploads() {
+	maxUploadsPerUser = getEnvInt("MAX_CONCURRENT_UPLOADS_PER_USER", 3)
+	uploadFormMemory = int64(getEnvInt("UPLOAD_FORM_MEMORY_BYTES", 8<<20))
+	if uploadFormMemory <= 0 {
+		log.Printf("Warning: UPLOAD_FORM_MEMORY_BYTES must be positive, using %d", 8<<20)
+		uploadFormMemory = 8 << 20
+	}
+}
+
+// Parse a multipart upload form, keeping at most uploadFormMemory bytes in
+// memory. When the body can't be parsed, responds with the reason and
+// returns false:
+//
+//   - 413 when the body is over the size limit, or ERR_FORM_TOO_LARGE
+//     when it has too many parts or too much non-file data
+//   - 415 ERR_NOT_MULTIPART when the body is not multipart/form-data
+//   - 400 ERR_MALFORMED_MULTIPART when the body is cut off or garbled
+//
+// Temporary files of a partly parsed body are removed by the parser; the
+// caller removes those of a parsed form with MultipartForm.RemoveAll.
+func parseUploadForm(c *gin.Context) bool {
+	err := c.Request.ParseMultipartForm(uploadFormMemory)
+	if err == nil {
+		return true
+	}
+	if limit, ok := isBodyTooLarge(err); ok {
+		abortBodyTooLarge(c, limit)
+		return false
+	}
+	switch {
+	case errors.Is(err, http.ErrNotMultipart):
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Request must be multipart/form-data", "code": "ERR_NOT_MULTIPART"})
+	case errors.Is(err, multipart.ErrMessageTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Form has too many parts or fields", "code": "ERR_FORM_TOO_LARGE"})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Malformed multipart body", "code": "ERR_MALFORMED_MULTIPART"})
+		log.Printf("Error parsing upload form: %v", err)
+	}
+	return false
+}
+
+// Reserve an upload slot for a user; returns false if the user is at the limit
+func acquireUploadSlot(username string) bool {
+	activeUploadsMu.Lock()
+	defer activeUploadsMu.Unlock()
+	if maxUploadsPerUser > 0 && activeUploads[username] >= maxUploadsPerUser {
+		return false
+	}
+	activeUploads[username]++
+	return true
+}
+
+// Release an upload slot reserved with acquireUploadSlot
+func releaseUploadSlot(username string) {
+	activeUploadsMu.Lock()
+	defer activeUploadsMu.Unlock()
+	activeUploads[username]--
+	if activeUploads[username] <= 0 {
+		delete(activeUploads, username)
+	}
+}
+
+// Content types that can run script when rendered by a browser; these are
+// never served inline, whatever the client asks for
+var unsafeInlineTypes = map[string]bool{
+	"text/html":              true,
+	"application/xhtml+xml":  true,
+	"image/svg+xml":          true,
+	"text/xml":               true,
+	"application/xml":        true,
+	"text/javascript":        true,
+	"application/javascript": true,
+}
+
+// Detect the content type of an uploaded file from its first 512 bytes,
+// falling back to the file extension when sniffing is inconclusive
+func detectContentType(file multipart.File, filename string) string {
+	buf := make([]byte, 512)
+	n, _ := io.ReadFull(file, buf)
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "application/octet-stream"
+	}
+
+	contentType := http.DetectContentType(buf[:n])
+	if contentType == "application/octet-stream" || strings.HasPrefix(contentType, "text/plain") {
+		if byExt := mime.TypeByExtension(filepath.Ext(filename)); byExt != "" {
+			return byExt
+		}
+	}
+	return contentType
+}
+
+// Choose the Content-Disposition type for a download; unsafe types are always attachments
+func contentDisposition(requested, contentType string) string {
+	if requested != "inline" {
+		return "attachment"
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || unsafeInlineTypes[mediaType] {
+		return "attachment"
+	}
+	return "inline"
+}
+
+// Scan an uploaded file and store it in MinIO under the room's prefix,
+// returning its object name. The name is unique per upload, or stable when
+// an idempotency key is given or versioning is enabled so retries and
+// re-uploads land on the same object. A file identical to one already in
+// the room is not stored again; its object is returned instead (see
+// dedup.go). progress, if not nil, is read as the file is sent (see
+// minio.PutObjectOptions).
+func putUploadedFile(ctx context.Context, username, room, idempotencyKey string, file multipart.File, header *multipart.FileHeader, progress io.Reader) (string, minio.UploadInfo, error) {
+	objectName := fmt.Sprintf("%s-%s%s", time.Now().Format("20060102-150405"), uuid.New().String()[0:8], filepath.Ext(header.Filename))
+	if idempotencyKey != "" {
+		objectName = idempotentObjectName(username, idempotencyKey, header.Filename)
+	} else if storageVersioning {
+		objectName = versionedObjectName(username, header.Filename)
+	}
+	objectName = room + "/" + objectName
+
+	// Versioned uploads always store a new version, even of the same content
+	hash, err := contentHash(file)
+	if err != nil {
+		return objectName, minio.UploadInfo{}, err
+	}
+	if uploadDedup && !storageVersioning {
+		if existing, info, ok := findDuplicateUpload(ctx, room, hash); ok {
+			uploadDuplicatesTotal.Inc()
+			return existing, info, nil
+		}
+	}
+
+	// Rejected files go to quarantine instead of the room
+	if err := scanUpload(ctx, username, objectName, file, header); err != nil {
+		return objectName, minio.UploadInfo{}, err
+	}
+
+	ctx, span := tracer.Start(ctx, "minio.PutObject", trace.WithAttributes(attribute.String("minio.object", objectName)))
+	defer span.End()
+
+	info, err := minioClient.PutObject(ctx, bucketName, objectName, file, header.Size, uploadPutOptions(minio.PutObjectOptions{
+		ContentType: detectContentType(file, header.Filename),
+		UserMetadata: map[string]string{
+			"uploader": url.PathEscape(username),
+			"filename": url.PathEscape(header.Filename),
+
+			contentHashMetadata: hash,
+		},
+		Progress: progress,
+	}))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "upload failed")
+		return objectName, info, err
+	}
+	if uploadDedup {
+		recordUploadHash(room, hash, objectName)
+	}
+	return objectName, info, nil
+}
+
+// UserFile describes a file a user shared in a message
+type UserFile struct {
+	MessageID  string    `json:"messageId"`
+	Key        string    `json:"key,omitempty"`
+	FileName   string    `json:"fileName"`
+	Room       string    `json:"room"`
+	Size       int64     `json:"size"`
+	FileURL    string    `json:"fileUrl"`
+	UploadedAt time.Time `json:"uploadedAt"`
+}
+
+// Read an upload's user metadata field; listings return metadata keys with
+// their X-Amz-Meta- prefix while StatObject strips it
+func objectMetadata(metadata map[string]string, name string) string {
+	value, ok := metadata[name]
+	if !ok {
+		value = metadata["X-Amz-Meta-"+name]
+	}
+	value, _ = url.PathUnescape(value)
+	return value
+}
+
+// List the files a user shared, newest first, from their file messages.
+// Users may only list their own files (?username=) unless they are an
+// admin.
+func handleListUserFiles(c *gin.Context) {
+	username := c.Param("username")
+	if c.Query("username") != username && !isAdmin(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can only list your own files"})
+		return
+	}
+
+	messages, err := messageStore.UserFiles(c.Request.Context(), username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list files"})
+		log.Printf("Error listing user files: %v", err)
+		return
+	}
+	files := []UserFile{}
+	for _, msg := range messages {
+		objectName := messageObjectName(msg)
+		files = append(files, UserFile{
+			MessageID:  msg.ID,
+			Key:        objectName,
+			FileName:   msg.FileName,
+			Room:       msg.Room,
+			Size:       msg.FileSize,
+			FileURL:    fileDownloadURL(msg.ID, objectName, msg.VersionID),
+			UploadedAt: msg.Timestamp,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"username": username, "files": files})
+}
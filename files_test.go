@@ -0,0 +1,237 @@
+// files_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestHandleListUserFiles(t *testing.T) {
+	useMemoryStore(t)
+	adminToken = "admin-secret"
+	savedDirect := directDownloads
+	t.Cleanup(func() { adminToken, directDownloads = "", savedDirect })
+	ctx := context.Background()
+	now := time.Now()
+	for _, msg := range []Message{
+		{ID: "m1", Room: "general", Username: "alice", FileURL: "/download/general/a.png", FileName: "a.png", FileSize: 10, Timestamp: now.Add(-time.Hour)},
+		{ID: "m2", Room: "random", Username: "alice", FileURL: "/download/random/b.pdf", FileName: "b.pdf", FileSize: 20, VersionID: "v2", Timestamp: now},
+		{ID: "m3", Room: "general", Username: "alice", Content: "no file", Timestamp: now},
+		{ID: "m4", Room: "general", Username: "bob", FileURL: "/download/general/c.png", FileName: "c.png", Timestamp: now},
+		{ID: "m5", Room: "general", Username: "alice", FileURL: "/download/general/d.png", FileName: "d.png", Timestamp: now},
+	} {
+		if err := messageStore.Insert(ctx, &msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	messageStore.RemoveFile(ctx, "m5", "")
+
+	tests := []struct {
+		name   string
+		target string
+		admin  bool
+		direct bool
+		code   int
+		urls   []string // newest first
+	}{
+		{"self", "/users/alice/files?username=alice", false, true, http.StatusOK, []string{"/download/random/b.pdf?version=v2", "/download/general/a.png"}},
+		{"self, direct downloads off", "/users/alice/files?username=alice", false, false, http.StatusOK, []string{"/download/by-message/m2", "/download/by-message/m1"}},
+		{"admin", "/users/alice/files", true, true, http.StatusOK, []string{"/download/random/b.pdf?version=v2", "/download/general/a.png"}},
+		{"another user", "/users/alice/files?username=bob", false, true, http.StatusForbidden, nil},
+		{"anonymous", "/users/alice/files", false, true, http.StatusForbidden, nil},
+		{"no files", "/users/carol/files?username=carol", false, true, http.StatusOK, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			directDownloads = tt.direct
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.admin {
+				req.Header.Set("Authorization", "Bearer admin-secret")
+			}
+			rec := serveTestRequest("/users/:username/files", req, handleListUserFiles)
+			if rec.Code != tt.code {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.code, rec.Body)
+			}
+			if tt.code != http.StatusOK {
+				return
+			}
+			var resp struct {
+				Files []UserFile `json:"files"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			urls := []string{}
+			for _, file := range resp.Files {
+				urls = append(urls, file.FileURL)
+			}
+			if strings.Join(urls, " ") != strings.Join(tt.urls, " ") {
+				t.Errorf("file URLs = %v, want %v", urls, tt.urls)
+			}
+		})
+	}
+}
+
+func TestUserFileLinksServeWithoutDirectDownloads(t *testing.T) {
+	useMemoryStore(t)
+	fake := useFakeS3(t, bucketName)
+	fake.putObject(bucketName, "general/a.png", []byte("png"), nil)
+	savedDirect := directDownloads
+	directDownloads = false
+	t.Cleanup(func() { directDownloads = savedDirect })
+	msg := Message{ID: "0b7c6a0e-3bfc-4bd6-9d0f-7a8f6c9e2d11", Room: "general", Username: "alice", FileURL: "/download/general/a.png", FileName: "a.png"}
+	if err := messageStore.Insert(context.Background(), &msg); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := serveTest(http.MethodGet, "/users/:username/files", "/users/alice/files?username=alice", nil, handleListUserFiles)
+	var resp struct {
+		Files []UserFile `json:"files"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || len(resp.Files) != 1 {
+		t.Fatalf("listing = %s, want one file", rec.Body)
+	}
+	rec = serveTest(http.MethodGet, "/download/*filename", resp.Files[0].FileURL+"?username=alice", nil, handleFileDownload)
+	if rec.Code != http.StatusOK || rec.Body.String() != "png" {
+		t.Errorf("GET %s: status = %d, body %q, want the file", resp.Files[0].FileURL, rec.Code, rec.Body)
+	}
+}
+
+func TestParseUploadFormErrors(t *testing.T) {
+	const boundary = "chatboundary"
+	multipartType := "multipart/form-data; boundary=" + boundary
+	valid := "--" + boundary + "\r\nContent-Disposition: form-data; name=\"username\"\r\n\r\nalice\r\n--" + boundary + "--\r\n"
+	var manyFields strings.Builder
+	for i := range 1001 {
+		fmt.Fprintf(&manyFields, "--%s\r\nContent-Disposition: form-data; name=\"f%d\"\r\n\r\nx\r\n", boundary, i)
+	}
+	manyFields.WriteString("--" + boundary + "--\r\n")
+	const maxBody = 1 << 20
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		chunked     bool // send without a Content-Length
+		code        int
+		errCode     string
+	}{
+		{"valid form", multipartType, valid, false, http.StatusOK, ""},
+		{"JSON body", "application/json", `{"username":"alice"}`, false, http.StatusUnsupportedMediaType, "ERR_NOT_MULTIPART"},
+		{"no content type", "", valid, false, http.StatusUnsupportedMediaType, "ERR_NOT_MULTIPART"},
+		{"missing boundary", "multipart/form-data", valid, false, http.StatusBadRequest, "ERR_MALFORMED_MULTIPART"},
+		{"cut off", multipartType, valid[:len(valid)-20], false, http.StatusBadRequest, "ERR_MALFORMED_MULTIPART"},
+		{"garbled", multipartType, "not a multipart body at all", false, http.StatusBadRequest, "ERR_MALFORMED_MULTIPART"},
+		{"too many parts", multipartType, manyFields.String(), false, http.StatusRequestEntityTooLarge, "ERR_FORM_TOO_LARGE"},
+		{"over the size limit", multipartType, strings.Replace(valid, "alice", strings.Repeat("x", maxBody), 1), true, http.StatusRequestEntityTooLarge, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			router := gin.New()
+			router.POST("/upload", MaxBytesMiddleware(maxBody), func(c *gin.Context) {
+				if parseUploadForm(c) {
+					defer c.Request.MultipartForm.RemoveAll()
+					c.String(http.StatusOK, c.Request.FormValue("username"))
+				}
+			})
+			req := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			if tt.chunked {
+				req.ContentLength = -1
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.code {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.code, rec.Body)
+			}
+			if tt.code == http.StatusOK {
+				if rec.Body.String() != "alice" {
+					t.Errorf("username = %q, want alice", rec.Body)
+				}
+				return
+			}
+			var resp struct {
+				Code     string `json:"code"`
+				MaxBytes int64  `json:"maxBytes"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Code != tt.errCode {
+				t.Errorf("code = %q, want %q", resp.Code, tt.errCode)
+			}
+			if tt.errCode == "" && resp.MaxBytes != maxBody {
+				t.Errorf("maxBytes = %d, want %d", resp.MaxBytes, maxBody)
+			}
+		})
+	}
+}
+
+func TestConcurrentUploadLimit(t *testing.T) {
+	useMemoryStore(t)
+	useBroadcastQueue(t)
+	useUploadLimiter(t, 600, 100)
+	fake := useFakeS3(t, bucketName)
+	savedMax := maxUploadsPerUser
+	maxUploadsPerUser = 2
+	t.Cleanup(func() { maxUploadsPerUser = savedMax })
+
+	// Hold uploads in storage until released
+	started, release := make(chan string, 10), make(chan struct{})
+	fake.onPut = func(key string) bool {
+		started <- key
+		<-release
+		return true
+	}
+	upload := func(username string) *httptest.ResponseRecorder {
+		req := newUploadForm("/upload", map[string]string{"username": username, "room": "general"}, "notes.txt", "notes")
+		return serveTestRequest("/upload", req, handleFileUpload)
+	}
+
+	results := make(chan int, 3)
+	for _, username := range []string{"alice", "alice", "bob"} {
+		go func() { results <- upload(username).Code }()
+	}
+	for range 3 {
+		select {
+		case <-started:
+		case <-time.After(3 * time.Second):
+			t.Fatal("uploads did not reach storage")
+		}
+	}
+
+	// alice is at the limit; bob's upload has its own
+	rec := upload("alice")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("third concurrent upload: status %d, Retry-After %q; want 429 with Retry-After", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if fake.putCount() != 0 {
+		t.Error("rejected upload reached storage")
+	}
+
+	close(release)
+	for range 3 {
+		if code := <-results; code != http.StatusOK {
+			t.Errorf("upload within the limit: status %d, want 200", code)
+		}
+	}
+	// Finished uploads give their slots back
+	fake.mu.Lock()
+	fake.onPut = nil
+	fake.mu.Unlock()
+	if rec := upload("alice"); rec.Code != http.StatusOK {
+		t.Errorf("upload after the others finished: status %d, want 200", rec.Code)
+	}
+}
@@ -0,0 +1,139 @@
+// filter.go
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+
+	"go-chat/sanitize"
+)
+
+// WordFilter masks or rejects messages containing configured terms
+type WordFilter struct {
+	pattern     *regexp.Regexp
+	reject      bool
+	replacement string
+}
+
+// Global word filter (nil when filtering is disabled); replaced on a
+// config reload, so read it with currentWordFilter
+var wordFilter *WordFilter
+
+// Initialize the word filter from environment variables
+func initWordFilter() {
+	filter, err := loadWordFilter()
+	if err != nil {
+		log.Fatalf("Error configuring word filter: %v", err)
+	}
+	wordFilter = filter
+}
+
+// Strip HTML from user text and apply the word filter; reports false if
+// the filter rejects the text
+func moderateContent(content string) (string, bool) {
+	content = sanitize.Content(content)
+	if filter := currentWordFilter(); filter != nil {
+		return filter.Apply(content)
+	}
+	return content, true
+}
+
+// Build the word filter from environment variables and the filter words
+// file; nil when filtering is disabled
+func loadWordFilter() (*WordFilter, error) {
+	if !getEnvBool("FILTER_ENABLED", false) {
+		return nil, nil
+	}
+
+	terms := strings.Split(os.Getenv("FILTER_WORDS"), ",")
+	if path := os.Getenv("FILTER_WORDS_FILE"); path != "" {
+		fileTerms, err := loadFilterTerms(path)
+		if err != nil {
+			return nil, fmt.Errorf("loading filter words file: %w", err)
+		}
+		terms = append(terms, fileTerms...)
+	}
+
+	mode := os.Getenv("FILTER_MODE")
+	if mode == "" {
+		mode = "mask"
+	}
+	if mode != "mask" && mode != "reject" {
+		return nil, fmt.Errorf("invalid FILTER_MODE %q (expected mask or reject)", mode)
+	}
+
+	replacement := os.Getenv("FILTER_REPLACEMENT")
+	if replacement == "" {
+		replacement = "***"
+	}
+
+	filter := NewWordFilter(terms, os.Getenv("FILTER_MATCH") != "substring", mode == "reject", replacement)
+	if filter == nil {
+		log.Println("Warning: FILTER_ENABLED is set but no filter words are configured")
+		return nil, nil
+	}
+	log.Printf("Word filter enabled (mode: %s)", mode)
+	return filter, nil
+}
+
+// Read filter terms from a file, one term per line; blank lines and lines starting with # are ignored
+func loadFilterTerms(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var terms []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		terms = append(terms, line)
+	}
+	return terms, scanner.Err()
+}
+
+// NewWordFilter builds a case-insensitive filter for the given terms.
+// It returns nil when no non-empty terms are provided.
+func NewWordFilter(terms []string, wholeWord, reject bool, replacement string) *WordFilter {
+	var quoted []string
+	for _, term := range terms {
+		term = strings.TrimSpace(term)
+		if term != "" {
+			quoted = append(quoted, regexp.QuoteMeta(term))
+		}
+	}
+	if len(quoted) == 0 {
+		return nil
+	}
+
+	expr := "(?i)(?:" + strings.Join(quoted, "|") + ")"
+	if wholeWord {
+		expr = `(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`
+	}
+
+	return &WordFilter{
+		pattern:     regexp.MustCompile(expr),
+		reject:      reject,
+		replacement: replacement,
+	}
+}
+
+// Apply filters content, returning the (possibly masked) content and false
+// if the message should be rejected
+func (f *WordFilter) Apply(content string) (string, bool) {
+	if !f.pattern.MatchString(content) {
+		return content, true
+	}
+	if f.reject {
+		return content, false
+	}
+	return f.pattern.ReplaceAllLiteralString(content, f.replacement), true
+}
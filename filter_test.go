@@ -0,0 +1,146 @@
+// filter_test.go
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWordFilterApply(t *testing.T) {
+	tests := []struct {
+		name      string
+		terms     []string
+		wholeWord bool
+		reject    bool
+		content   string
+		want      string
+		ok        bool
+	}{
+		{"clean", []string{"darn"}, true, false, "hello there", "hello there", true},
+		{"masked", []string{"darn"}, true, false, "oh darn it", "oh *** it", true},
+		{"ignores case", []string{"darn"}, true, false, "DARN", "***", true},
+		{"every match", []string{"darn", "heck"}, true, false, "darn and heck", "*** and ***", true},
+		{"whole word only", []string{"ass"}, true, false, "class assignment", "class assignment", true},
+		{"substring", []string{"ass"}, false, false, "class", "cl***", true},
+		{"term is literal", []string{"a.b"}, true, false, "axb a.b", "axb ***", true},
+		{"blank terms ignored", []string{" ", "darn "}, true, false, "darn", "***", true},
+		{"rejected", []string{"darn"}, true, true, "oh darn", "oh darn", false},
+		{"reject lets clean text through", []string{"darn"}, true, true, "oh well", "oh well", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter := NewWordFilter(tt.terms, tt.wholeWord, tt.reject, "***")
+			got, ok := filter.Apply(tt.content)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("Apply(%q) = %q, %v, want %q, %v", tt.content, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestNewWordFilterWithoutTerms(t *testing.T) {
+	if filter := NewWordFilter([]string{"", "  "}, true, false, "***"); filter != nil {
+		t.Error("NewWordFilter with no terms returned a filter")
+	}
+}
+
+func TestModerateContent(t *testing.T) {
+	liveConfigMu.Lock()
+	saved := wordFilter
+	wordFilter = NewWordFilter([]string{"darn"}, true, false, "***")
+	liveConfigMu.Unlock()
+	t.Cleanup(func() {
+		liveConfigMu.Lock()
+		wordFilter = saved
+		liveConfigMu.Unlock()
+	})
+
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{"hey darn there", "hey *** there"},
+		// Markup can't hide a word from the filter
+		{"hey d<b></b>arn there", "hey *** there"},
+		{"hey <i>darn</i> there", "hey *** there"},
+		{"&lt;b&gt;darn", "***"},
+	}
+	for _, tt := range tests {
+		got, ok := moderateContent(tt.content)
+		if got != tt.want || !ok {
+			t.Errorf("moderateContent(%q) = %q, %v, want %q", tt.content, got, ok, tt.want)
+		}
+	}
+}
+
+func TestHandlePostMessageBlockedWords(t *testing.T) {
+	useMemoryStore(t)
+	liveConfigMu.Lock()
+	saved := wordFilter
+	wordFilter = NewWordFilter([]string{"darn"}, true, true, "***")
+	liveConfigMu.Unlock()
+	t.Cleanup(func() {
+		liveConfigMu.Lock()
+		wordFilter = saved
+		liveConfigMu.Unlock()
+	})
+
+	for _, content := range []string{"oh darn", "oh d<b></b>arn", "oh <i>darn</i>"} {
+		form := url.Values{"username": {"bob"}, "content": {content}}
+		req := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := serveTestRequest("/messages", req, handlePostMessage)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("POST /messages %q: status = %d, want 400", content, rec.Code)
+		}
+	}
+}
+
+func TestLoadFilterTerms(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "words.txt")
+	if err := os.WriteFile(path, []byte("# comment\ndarn\n\n  heck  \n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	terms, err := loadFilterTerms(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"darn", "heck"}; !reflect.DeepEqual(terms, want) {
+		t.Errorf("loadFilterTerms = %q, want %q", terms, want)
+	}
+}
+
+func TestLoadWordFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		enabled bool
+		wantErr bool
+	}{
+		{"disabled", map[string]string{"FILTER_WORDS": "darn"}, false, false},
+		{"enabled", map[string]string{"FILTER_ENABLED": "true", "FILTER_WORDS": "darn"}, true, false},
+		{"no words", map[string]string{"FILTER_ENABLED": "true"}, false, false},
+		{"bad mode", map[string]string{"FILTER_ENABLED": "true", "FILTER_WORDS": "darn", "FILTER_MODE": "drop"}, false, true},
+		{"missing file", map[string]string{"FILTER_ENABLED": "true", "FILTER_WORDS_FILE": "/nonexistent/words.txt"}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"FILTER_ENABLED", "FILTER_WORDS", "FILTER_WORDS_FILE", "FILTER_MODE", "FILTER_MATCH", "FILTER_REPLACEMENT"} {
+				t.Setenv(key, tt.env[key])
+			}
+			filter, err := loadWordFilter()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadWordFilter error = %v, want error %v", err, tt.wantErr)
+			}
+			if (filter != nil) != tt.enabled {
+				t.Errorf("loadWordFilter enabled = %v, want %v", filter != nil, tt.enabled)
+			}
+		})
+	}
+}
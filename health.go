@@ -0,0 +1,156 @@
+// health.go
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Webhook event sent when a dependency goes down or recovers
+const WebhookEventHealth = "health"
+
+// Dependency is an external service the server needs to work
+type Dependency struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// HealthChecker periodically checks dependencies and stops accepting new
+// WebSocket connections while any of them is down
+type HealthChecker struct {
+	deps      []Dependency
+	interval  time.Duration
+	threshold int // consecutive failures before a dependency counts as down
+
+	mu       sync.RWMutex
+	failures map[string]int
+	down     map[string]bool
+}
+
+// Global health checker
+var healthChecker *HealthChecker
+
+// Create a health checker for the given dependencies
+func NewHealthChecker(interval time.Duration, threshold int, deps ...Dependency) *HealthChecker {
+	return &HealthChecker{
+		deps:      deps,
+		interval:  interval,
+		threshold: threshold,
+		failures:  make(map[string]int),
+		down:      make(map[string]bool),
+	}
+}
+
+// Initialize the health checker from environment variables
+func initHealthChecker() {
+	healthChecker = NewHealthChecker(
+		time.Duration(getEnvInt("HEALTH_CHECK_INTERVAL_SECONDS", 30))*time.Second,
+		getEnvInt("HEALTH_CHECK_FAILURE_THRESHOLD", 3),
+		Dependency{Name: "minio", Check: func(ctx context.Context) error {
+			_, err := minioClient.BucketExists(ctx, bucketName)
+			return err
+		}},
+	)
+}
+
+// Run checks every interval until ctx is canceled
+func (h *HealthChecker) Run(ctx context.Context) {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.checkAll(ctx)
+		}
+	}
+}
+
+// Check every dependency once and update its state
+func (h *HealthChecker) checkAll(ctx context.Context) {
+	for _, dep := range h.deps {
+		checkCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		err := dep.Check(checkCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+
+		h.mu.Lock()
+		wasDown := h.down[dep.Name]
+		if err != nil {
+			h.failures[dep.Name]++
+			if h.failures[dep.Name] >= h.threshold {
+				h.down[dep.Name] = true
+			}
+		} else {
+			h.failures[dep.Name] = 0
+			h.down[dep.Name] = false
+		}
+		isDown, failures := h.down[dep.Name], h.failures[dep.Name]
+		h.mu.Unlock()
+
+		switch {
+		case isDown && !wasDown:
+			log.Printf("CRITICAL: %s failed %d consecutive health checks, refusing new connections: %v", dep.Name, failures, err)
+			notifyHealthChange(dep.Name, "down")
+		case !isDown && wasDown:
+			log.Printf("RECOVERY: %s is healthy again, accepting connections", dep.Name)
+			notifyHealthChange(dep.Name, "up")
+		case err != nil:
+			log.Printf("Health check for %s failed (%d/%d): %v", dep.Name, failures, h.threshold, err)
+		}
+	}
+}
+
+// Send a dependency state change to the admin webhooks
+func notifyHealthChange(name, status string) {
+	notifyWebhooks(WebhookEventHealth, Message{
+		ID:        uuid.New().String(),
+		Username:  "System",
+		Content:   name + " is " + status,
+		Timestamp: time.Now(),
+	})
+}
+
+// Report whether all dependencies are healthy enough to accept connections
+func (h *HealthChecker) Healthy() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, down := range h.down {
+		if down {
+			return false
+		}
+	}
+	return true
+}
+
+// Return the state ("up" or "down") of every dependency
+func (h *HealthChecker) Status() map[string]string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	status := make(map[string]string, len(h.deps))
+	for _, dep := range h.deps {
+		status[dep.Name] = "up"
+		if h.down[dep.Name] {
+			status[dep.Name] = "down"
+		}
+	}
+	return status
+}
+
+// Readiness probe reporting per-dependency health
+func handleReadyz(c *gin.Context) {
+	status, code := "ready", http.StatusOK
+	if !healthChecker.Healthy() {
+		status, code = "unavailable", http.StatusServiceUnavailable
+	}
+	c.JSON(code, gin.H{"status": status, "dependencies": healthChecker.Status()})
+}
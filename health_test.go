@@ -0,0 +1,72 @@
+// health_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthGatesReadinessAndConnections(t *testing.T) {
+	server := useWSServer(t)
+	useWebhooks(t, &Webhook{URL: "http://hooks.example.com", Secret: "s3cret", Events: []string{WebhookEventHealth}})
+	var failing atomic.Bool
+	healthChecker = NewHealthChecker(time.Hour, 2, Dependency{Name: "minio", Check: func(ctx context.Context) error {
+		if failing.Load() {
+			return errors.New("connection refused")
+		}
+		return nil
+	}})
+
+	check := func(wantCode int, wantStatus string) {
+		t.Helper()
+		rec := serveTest(http.MethodGet, "/readyz", "/readyz", nil, handleReadyz)
+		var body struct {
+			Status       string            `json:"status"`
+			Dependencies map[string]string `json:"dependencies"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		if rec.Code != wantCode || body.Dependencies["minio"] != wantStatus {
+			t.Errorf("readyz = %d %s, want %d with minio %s", rec.Code, rec.Body, wantCode, wantStatus)
+		}
+
+		ws, resp, err := server.dial(url.Values{"username": {"alice"}, "room": {"general"}}.Encode())
+		if wantCode == http.StatusOK {
+			if err != nil {
+				t.Errorf("connecting while ready: %v", err)
+			} else {
+				ws.Close()
+			}
+			return
+		}
+		if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("connecting while not ready: %v, want the upgrade refused with 503", err)
+		}
+	}
+
+	healthChecker.checkAll(context.Background())
+	check(http.StatusOK, "up")
+
+	// One failure is below the threshold
+	failing.Store(true)
+	healthChecker.checkAll(context.Background())
+	check(http.StatusOK, "up")
+
+	healthChecker.checkAll(context.Background())
+	check(http.StatusServiceUnavailable, "down")
+	if len(webhookQueue) != 1 {
+		t.Errorf("queued %d health webhooks, want 1 for going down", len(webhookQueue))
+	}
+
+	failing.Store(false)
+	healthChecker.checkAll(context.Background())
+	check(http.StatusOK, "up")
+	if len(webhookQueue) != 2 {
+		t.Errorf("queued %d health webhooks, want 2 after recovering", len(webhookQueue))
+	}
+}
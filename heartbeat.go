@@ -0,0 +1,120 @@
+// heartbeat.go
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Application-level heartbeat settings. Unlike WebSocket pings, heartbeats
+// go through the same JSON pipeline as chat messages, so an ack shows the
+// client is still processing messages. The time from a heartbeat to its ack
+// is the connection's round-trip latency.
+var (
+	heartbeatInterval  time.Duration // 0 disables heartbeats
+	heartbeatMaxMissed int           // unacked heartbeats before a connection is closed; 0 never closes
+	reportLatency      bool          // include the client's last measured latency in heartbeats
+
+	heartbeatSeq    atomic.Uint64 // sequence number of the last heartbeat sent
+	heartbeatSentAt atomic.Int64  // when it was sent, as UnixNano
+)
+
+// Round-trip latency measured by heartbeat acks
+var heartbeatRoundTrip = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    "chat_heartbeat_round_trip_seconds",
+	Help:    "Time from sending an application-level heartbeat to receiving its ack.",
+	Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+})
+
+// Initialize heartbeats from environment variables
+func initHeartbeats() {
+	heartbeatInterval = time.Duration(getEnvInt("HEARTBEAT_INTERVAL_SECONDS", 60)) * time.Second
+	heartbeatMaxMissed = getEnvInt("HEARTBEAT_MAX_MISSED", 3)
+	reportLatency = getEnvBool("HEARTBEAT_REPORT_LATENCY", true)
+}
+
+// Send heartbeats periodically until ctx is canceled
+func runHeartbeats(ctx context.Context) {
+	if heartbeatInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			sendHeartbeats(now)
+		}
+	}
+}
+
+// Close connections that missed too many heartbeats and send the next
+// heartbeat to the rest
+func sendHeartbeats(now time.Time) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	sent := heartbeatSeq.Load()
+	seq := heartbeatSeq.Add(1)
+	heartbeatSentAt.Store(now.UnixNano())
+	for client := range clients {
+		missed := sent - client.heartbeatAck.Load()
+		if heartbeatMaxMissed > 0 && missed >= uint64(heartbeatMaxMissed) {
+			log.Printf("Closing stale connection for %s: %d heartbeats unacknowledged, last ack %s",
+				client.username, missed, time.Unix(0, client.lastAckAt.Load()).Format(time.RFC3339))
+			hint := transientClose(websocket.CloseTryAgainLater, CloseCodeStale, "heartbeats unacknowledged", transientRetryAfter)
+			client.closing = &hint
+			removeClientLocked(client)
+			continue
+		}
+		heartbeat := Message{
+			Type:       MessageTypeHeartbeat,
+			Seq:        seq,
+			Username:   "System",
+			ServerTime: &now,
+			Timestamp:  now,
+		}
+		if rtt := client.roundTrip.Load(); reportLatency && rtt > 0 {
+			heartbeat.LatencyMs = math.Round(float64(rtt)/float64(time.Millisecond)*10) / 10
+		}
+		queueMessageLocked(client, heartbeat)
+	}
+}
+
+// Record a client's acknowledgement of heartbeat seq; acks for heartbeats
+// not sent yet, or older than one already acked, are ignored. An ack of the
+// latest heartbeat measures the client's round-trip latency.
+func (c *Client) ackHeartbeat(seq uint64, now time.Time) {
+	latest := heartbeatSeq.Load()
+	sentAt := heartbeatSentAt.Load()
+	if seq > latest {
+		return
+	}
+	for {
+		acked := c.heartbeatAck.Load()
+		if seq <= acked {
+			return
+		}
+		if c.heartbeatAck.CompareAndSwap(acked, seq) {
+			break
+		}
+	}
+	c.lastAckAt.Store(now.UnixNano())
+
+	if seq == latest {
+		rtt := now.Sub(time.Unix(0, sentAt))
+		if rtt >= 0 {
+			c.roundTrip.Store(int64(rtt))
+			heartbeatRoundTrip.Observe(rtt.Seconds())
+		}
+	}
+}
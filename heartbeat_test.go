@@ -0,0 +1,181 @@
+// heartbeat_test.go
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Configure heartbeats for a test; the sequence restarts from zero
+func useHeartbeats(t *testing.T, maxMissed int, latency bool) {
+	t.Helper()
+	savedMissed, savedLatency := heartbeatMaxMissed, reportLatency
+	savedSeq, savedSentAt := heartbeatSeq.Load(), heartbeatSentAt.Load()
+	heartbeatMaxMissed, reportLatency = maxMissed, latency
+	heartbeatSeq.Store(0)
+	heartbeatSentAt.Store(0)
+	t.Cleanup(func() {
+		heartbeatMaxMissed, reportLatency = savedMissed, savedLatency
+		heartbeatSeq.Store(savedSeq)
+		heartbeatSentAt.Store(savedSentAt)
+	})
+}
+
+// Take the heartbeats queued for a client, returning the last one's sequence number
+func drainHeartbeats(client *Client) uint64 {
+	var seq uint64
+	for len(client.send) > 0 {
+		if msg := <-client.send; msg.Type == MessageTypeHeartbeat {
+			seq = msg.Seq
+		}
+	}
+	return seq
+}
+
+func TestHeartbeatClosesStaleClients(t *testing.T) {
+	captureLog(t)
+	useHeartbeats(t, 3, false)
+	alice := &Client{username: "alice", room: "general", send: make(chan Message, 10)}
+	bob := &Client{username: "bob", room: "general", send: make(chan Message, 10)}
+	useClients(t, alice, bob)
+
+	// Alice acks every heartbeat, bob none
+	for range 3 {
+		sendHeartbeats(time.Now())
+		alice.ackHeartbeat(drainHeartbeats(alice), time.Now())
+		drainHeartbeats(bob)
+	}
+	clientsMu.Lock()
+	connected := clients[bob]
+	clientsMu.Unlock()
+	if !connected {
+		t.Fatal("bob closed before missing the limit of heartbeats")
+	}
+
+	sendHeartbeats(time.Now())
+	clientsMu.Lock()
+	aliceConnected, bobConnected := clients[alice], clients[bob]
+	clientsMu.Unlock()
+	if !aliceConnected {
+		t.Error("alice closed despite acking every heartbeat")
+	}
+	if bobConnected {
+		t.Fatal("bob still connected after missing 3 heartbeats")
+	}
+	if bob.closing == nil || bob.closing.Code != CloseCodeStale {
+		t.Errorf("bob closed with hint %+v, want %s", bob.closing, CloseCodeStale)
+	}
+}
+
+func TestHeartbeatAckCatchesUp(t *testing.T) {
+	captureLog(t)
+	useHeartbeats(t, 2, false)
+	client := &Client{username: "alice", room: "general", send: make(chan Message, 10)}
+	useClients(t, client)
+
+	// A slow client acking only the latest heartbeat is still alive
+	sendHeartbeats(time.Now())
+	sendHeartbeats(time.Now())
+	client.ackHeartbeat(drainHeartbeats(client), time.Now())
+	sendHeartbeats(time.Now())
+
+	clientsMu.Lock()
+	connected := clients[client]
+	clientsMu.Unlock()
+	if !connected {
+		t.Error("client closed after acking the latest heartbeat")
+	}
+}
+
+func TestHeartbeatDisconnectsSilentConnection(t *testing.T) {
+	captureLog(t)
+	server := useWSServer(t)
+	useHeartbeats(t, 2, false)
+	ws := server.connect("bob", "general")
+
+	// Wait for the connection to be registered
+	for deadline := time.Now().Add(3 * time.Second); !isConnectedUser("bob"); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("bob never connected")
+		}
+	}
+	for range 3 {
+		sendHeartbeats(time.Now())
+	}
+
+	_, closeErr := readUntilClose(t, ws)
+	if closeErr.Code != websocket.CloseTryAgainLater || !strings.Contains(closeErr.Text, "heartbeats unacknowledged") {
+		t.Errorf("closed with %d %q, want %d for unacknowledged heartbeats", closeErr.Code, closeErr.Text, websocket.CloseTryAgainLater)
+	}
+}
+
+func TestHeartbeatLatency(t *testing.T) {
+	useHeartbeats(t, 0, true)
+	client := &Client{username: "alice", room: "general", send: make(chan Message, 10)}
+	useClients(t, client)
+	sent := time.Now()
+
+	sendHeartbeats(sent)
+	first := <-client.send
+	if first.LatencyMs != 0 || first.ServerTime == nil || !first.ServerTime.Equal(sent) {
+		t.Errorf("first heartbeat = %+v, want the server time and no latency yet", first)
+	}
+	client.ackHeartbeat(first.Seq, sent.Add(42*time.Millisecond))
+	if rtt := time.Duration(client.roundTrip.Load()); rtt != 42*time.Millisecond {
+		t.Errorf("round trip = %v, want 42ms", rtt)
+	}
+
+	// The next heartbeat reports the measured latency
+	sendHeartbeats(sent.Add(time.Second))
+	if msg := <-client.send; msg.LatencyMs != 42 {
+		t.Errorf("heartbeat latency = %vms, want 42ms", msg.LatencyMs)
+	}
+}
+
+func TestHeartbeatLatencyIgnoresStaleAcks(t *testing.T) {
+	useHeartbeats(t, 0, true)
+	client := &Client{username: "alice", room: "general", send: make(chan Message, 10)}
+	useClients(t, client)
+	sent := time.Now()
+
+	sendHeartbeats(sent)
+	sendHeartbeats(sent.Add(time.Second))
+	drainHeartbeats(client)
+
+	tests := []struct {
+		name string
+		seq  uint64
+		at   time.Time
+		want time.Duration
+	}{
+		// Only an ack of the latest heartbeat can be timed
+		{"older heartbeat", 1, sent.Add(1100 * time.Millisecond), 0},
+		{"latest heartbeat", 2, sent.Add(1010 * time.Millisecond), 10 * time.Millisecond},
+		{"repeated ack", 2, sent.Add(5 * time.Second), 10 * time.Millisecond},
+		{"heartbeat not sent yet", 3, sent.Add(1020 * time.Millisecond), 10 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		client.ackHeartbeat(tt.seq, tt.at)
+		if rtt := time.Duration(client.roundTrip.Load()); rtt != tt.want {
+			t.Errorf("%s: round trip = %v, want %v", tt.name, rtt, tt.want)
+		}
+	}
+	if acked := client.heartbeatAck.Load(); acked != 2 {
+		t.Errorf("acked heartbeat %d, want 2", acked)
+	}
+}
+
+func TestHeartbeatLatencyNotReported(t *testing.T) {
+	useHeartbeats(t, 0, false)
+	client := &Client{username: "alice", room: "general", send: make(chan Message, 10)}
+	useClients(t, client)
+	client.roundTrip.Store(int64(42 * time.Millisecond))
+
+	sendHeartbeats(time.Now())
+	if msg := <-client.send; msg.LatencyMs != 0 {
+		t.Errorf("heartbeat latency = %vms with reporting off, want none", msg.LatencyMs)
+	}
+}
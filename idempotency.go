@@ -0,0 +1,74 @@
+// idempotency.go
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Outcome of an upload made with an idempotency key
+type idempotencyRecord struct {
+	msg     *Message // nil while the first upload is still in progress
+	expires time.Time
+}
+
+// Idempotency key tracking for uploads
+var (
+	idempotencyRecords = make(map[string]*idempotencyRecord) // username + key -> record
+	idempotencyMu      sync.Mutex
+	idempotencyWindow  time.Duration
+)
+
+// Initialize idempotency settings from environment variables
+func initIdempotency() {
+	idempotencyWindow = time.Duration(getEnvInt("IDEMPOTENCY_WINDOW_SECONDS", 24*60*60)) * time.Second
+}
+
+// Derive the object name for an upload from its idempotency key, so a
+// retried upload writes the same object instead of creating a new one
+func idempotentObjectName(username, key, filename string) string {
+	sum := sha256.Sum256([]byte(username + "\x00" + key))
+	return "idem-" + hex.EncodeToString(sum[:12]) + filepath.Ext(filename)
+}
+
+// Claim an idempotency key for an upload. If the key was already used
+// successfully, the earlier file message is returned. inProgress reports
+// that another request with the same key has not finished yet.
+func claimIdempotencyKey(username, key string) (existing *Message, inProgress bool) {
+	idempotencyMu.Lock()
+	defer idempotencyMu.Unlock()
+
+	now := time.Now()
+	for k, record := range idempotencyRecords {
+		if now.After(record.expires) {
+			delete(idempotencyRecords, k)
+		}
+	}
+
+	id := username + "\x00" + key
+	if record, ok := idempotencyRecords[id]; ok {
+		if record.msg == nil {
+			return nil, true
+		}
+		return record.msg, false
+	}
+	idempotencyRecords[id] = &idempotencyRecord{expires: now.Add(idempotencyWindow)}
+	return nil, false
+}
+
+// Record the result of an upload claimed with claimIdempotencyKey; a nil
+// message releases the key so the client can retry
+func completeIdempotencyKey(username, key string, msg *Message) {
+	idempotencyMu.Lock()
+	defer idempotencyMu.Unlock()
+
+	id := username + "\x00" + key
+	if msg == nil {
+		delete(idempotencyRecords, id)
+		return
+	}
+	idempotencyRecords[id] = &idempotencyRecord{msg: msg, expires: time.Now().Add(idempotencyWindow)}
+}
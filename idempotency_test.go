@@ -0,0 +1,108 @@
+// idempotency_test.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Start a test with no idempotency records and the given window
+func useIdempotency(t *testing.T, window time.Duration) {
+	t.Helper()
+	idempotencyMu.Lock()
+	savedRecords, savedWindow := idempotencyRecords, idempotencyWindow
+	idempotencyRecords, idempotencyWindow = make(map[string]*idempotencyRecord), window
+	idempotencyMu.Unlock()
+	t.Cleanup(func() {
+		idempotencyMu.Lock()
+		idempotencyRecords, idempotencyWindow = savedRecords, savedWindow
+		idempotencyMu.Unlock()
+	})
+}
+
+// Upload a file with an idempotency key, returning the response and its decoded body
+func idempotentUpload(t *testing.T, username, key string) (*httptest.ResponseRecorder, map[string]any) {
+	t.Helper()
+	req := newUploadForm("/upload", map[string]string{"username": username, "room": "general"}, "report.pdf", "%PDF-1.4 report")
+	req.Header.Set("Idempotency-Key", key)
+	rec := serveTestRequest("/upload", req, handleFileUpload)
+	var body map[string]any
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	return rec, body
+}
+
+func TestUploadIdempotencyKey(t *testing.T) {
+	useMemoryStore(t)
+	useUploadLimiter(t, 600, 100)
+	queue := useBroadcastQueue(t)
+	fake := useFakeS3(t, bucketName)
+	useIdempotency(t, time.Hour)
+
+	rec, first := idempotentUpload(t, "alice", "key-1")
+	if rec.Code != http.StatusOK || first["duplicate"] != nil {
+		t.Fatalf("first upload: %d %v", rec.Code, first)
+	}
+	rec, retry := idempotentUpload(t, "alice", "key-1")
+	if rec.Code != http.StatusOK || retry["duplicate"] != true || retry["fileUrl"] != first["fileUrl"] {
+		t.Errorf("retried upload: %d %v, want the first result marked duplicate", rec.Code, retry)
+	}
+	if fake.putCount() != 1 {
+		t.Errorf("stored %d times, want once", fake.putCount())
+	}
+	if published := publishedRooms(queue); published != "general" {
+		t.Errorf("published for rooms %q, want one message", published)
+	}
+
+	// Keys belong to a user
+	if _, other := idempotentUpload(t, "bob", "key-1"); other["duplicate"] != nil || fake.putCount() != 2 {
+		t.Errorf("another user's upload with the same key: %v, %d puts; want a new upload", other, fake.putCount())
+	}
+
+	// A request still running holds the key
+	claimIdempotencyKey("alice", "key-2")
+	if rec, _ := idempotentUpload(t, "alice", "key-2"); rec.Code != http.StatusConflict {
+		t.Errorf("upload while the key is in progress: status %d, want 409", rec.Code)
+	}
+}
+
+func TestUploadIdempotencyKeyReleasedOnFailure(t *testing.T) {
+	useMemoryStore(t)
+	useUploadLimiter(t, 600, 100)
+	useBroadcastQueue(t)
+	fake := useFakeS3(t, bucketName)
+	useIdempotency(t, time.Hour)
+
+	fake.onPut = func(string) bool { return false }
+	if rec, _ := idempotentUpload(t, "alice", "key-1"); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("failing upload: status %d, want 500", rec.Code)
+	}
+	fake.mu.Lock()
+	fake.onPut = nil
+	fake.mu.Unlock()
+	if rec, body := idempotentUpload(t, "alice", "key-1"); rec.Code != http.StatusOK || body["duplicate"] != nil || fake.putCount() != 1 {
+		t.Errorf("retry after a failure: %d %v, %d puts; want the file stored", rec.Code, body, fake.putCount())
+	}
+}
+
+func TestIdempotencyKeysExpire(t *testing.T) {
+	useIdempotency(t, 20*time.Millisecond)
+	claimIdempotencyKey("alice", "old")
+	completeIdempotencyKey("alice", "old", &Message{ID: "m1"})
+	claimIdempotencyKey("bob", "unfinished")
+
+	time.Sleep(30 * time.Millisecond)
+	// Expired records are evicted when the next key is claimed
+	claimIdempotencyKey("carol", "new")
+	idempotencyMu.Lock()
+	count := len(idempotencyRecords)
+	idempotencyMu.Unlock()
+	if count != 1 {
+		t.Errorf("%d records kept, want only the new one", count)
+	}
+	if existing, inProgress := claimIdempotencyKey("alice", "old"); existing != nil || inProgress {
+		t.Errorf("expired key returned %v, in progress %v; want a fresh claim", existing, inProgress)
+	}
+}
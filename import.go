@@ -0,0 +1,88 @@
+// import.go
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Number of messages per BatchInsert call during imports (BATCH_INSERT_SIZE)
+var batchInsertSize = 500
+
+// Import historical messages from an NDJSON body, one Message per line (admin only).
+//
+// The message store lives in the server process, so imports go through this
+// endpoint rather than a separate command-line tool.
+func handleImportMessages(c *gin.Context) {
+	var (
+		imported int
+		skipped  int
+		batch    = make([]Message, 0, batchInsertSize)
+	)
+
+	flush := func() bool {
+		if len(batch) == 0 {
+			return true
+		}
+		if err := messageStore.BatchInsert(c.Request.Context(), batch); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store messages", "imported": imported})
+			log.Printf("Error importing messages: %v", err)
+			return false
+		}
+		imported += len(batch)
+		batch = batch[:0]
+		return true
+	}
+
+	scanner := bufio.NewScanner(c.Request.Body)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		var msg Message
+		if err := json.Unmarshal(line, &msg); err != nil || msg.Username == "" {
+			skipped++
+			continue
+		}
+		if msg.ID == "" {
+			msg.ID = uuid.New().String()
+		}
+		migrateMessage(&msg)
+		if msg.Room == "" {
+			msg.Room = defaultRoom
+		}
+		if msg.Timestamp.IsZero() {
+			msg.Timestamp = time.Now()
+		}
+		msg.Type = ""
+		msg.Seq = 0
+
+		batch = append(batch, msg)
+		if len(batch) == batchInsertSize && !flush() {
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if limit, ok := isBodyTooLarge(err); ok {
+			abortBodyTooLarge(c, limit)
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read import body", "imported": imported})
+		return
+	}
+	if !flush() {
+		return
+	}
+
+	log.Printf("Imported %d messages (%d skipped)", imported, skipped)
+	c.JSON(http.StatusOK, gin.H{"imported": imported, "skipped": skipped})
+}
@@ -3,12 +3,16 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -17,29 +21,224 @@ import (
 	"github.com/joho/godotenv"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// Message represents a chat message
+// Message priorities; higher priorities are delivered first
+const (
+	PriorityNormal   uint8 = 0
+	PriorityHigh     uint8 = 1
+	PriorityCritical uint8 = 2
+)
+
+// Message types; chat messages leave Type empty
+const (
+	MessageTypeTyping   = "typing"
+	MessageTypePresence = "presence"
+	MessageTypeReaction = "reaction"
+
+	// Tombstone sent when a shared file's object is deleted; MessageID is
+	// the file message, FileName the deleted file and Reason "expired"
+	// when the object was found missing rather than deleted
+	MessageTypeFileDeleted = "file_deleted"
+
+	// Sent to a single client whose send buffer is filling up; Content is
+	// "slow_down" past the high-water mark and "resume" once it drains
+	MessageTypeBackpressure = "backpressure"
+
+	// Sent when a stored message is deleted; MessageID is the deleted
+	// message and Reason why, e.g. "expired"
+	MessageTypeMessageDelete = "message_delete"
+
+	// A message streamed in chunks: stream_start opens it, each
+	// stream_chunk carries the next part of Content, and stream_end carries
+	// the stored message; StreamID ties them together
+	MessageTypeStreamStart = "stream_start"
+	MessageTypeStreamChunk = "stream_chunk"
+	MessageTypeStreamEnd   = "stream_end"
+
+	// Connection handshake: the server sends a challenge with a Nonce
+	// before registering the client, which answers with a
+	// challenge_response whose Hash is the hex SHA-256 of the nonce
+	// followed by its username
+	MessageTypeChallenge         = "challenge"
+	MessageTypeChallengeResponse = "challenge_response"
+
+	// Application-level heartbeat sent to every client with the server's
+	// time, the connection's latency and a Seq that clients echo back in a
+	// heartbeat_ack
+	MessageTypeHeartbeat    = "heartbeat"
+	MessageTypeHeartbeatAck = "heartbeat_ack"
+
+	// Sent to a client once it connects; Capabilities lists what the
+	// server offers, such as its custom emoji
+	MessageTypeCapabilities = "capabilities"
+
+	// A room's slow mode changed; SlowModeSeconds is the new minimum gap
+	// between each user's messages, 0 once slow mode is off
+	MessageTypeSlowMode = "slow_mode"
+
+	// Sent to a client whose message was refused; Reason says why, e.g.
+	// "slow_mode", and RetryAfterMs when it may send again. A message that
+	// needs a disabled feature gets Code ERR_FEATURE_DISABLED and the
+	// feature's name as Reason; one whose expiresInSeconds is out of range
+	// gets ERR_EXPIRES_IN_OUT_OF_RANGE.
+	MessageTypeNack = "nack"
+
+	// A reply was posted in a thread; RootID is the thread's root message,
+	// ReplyCount its updated number of replies and MessageID the reply
+	MessageTypeThreadReply = "thread_reply"
+
+	// A shared file was downloaded; MessageID is the file message and
+	// Count its updated number of downloads
+	MessageTypeDownloadCount = "download_count"
+
+	// History replay (see replay.go): a client that connected with
+	// ?history=N sends ready when it can take the replayed messages, and
+	// replay_end follows the last of them with its Seq, or with a Reason
+	// when history could not be replayed
+	MessageTypeReady     = "ready"
+	MessageTypeReplayEnd = "replay_end"
+)
+
+// Message represents a chat message or event
 type Message struct {
+	SchemaVersion int `json:"schemaVersion"`
+
 	ID        string    `json:"id"`
+	Type      string    `json:"type,omitempty"`
+	Room      string    `json:"room,omitempty"` // empty for server-wide messages
+	Seq       uint64    `json:"seq,omitempty"`  // per-room sequence number of stored messages
 	Username  string    `json:"username"`
 	Content   string    `json:"content"`
 	FileURL   string    `json:"fileUrl,omitempty"`
 	FileName  string    `json:"fileName,omitempty"`
+	FileSize  int64     `json:"fileSize,omitempty"`
+	VersionID string    `json:"versionId,omitempty"`
+	Priority  uint8     `json:"priority,omitempty"`
 	Timestamp time.Time `json:"timestamp"`
+
+	// Set on file messages whose file was deleted from storage, with
+	// "expired" as the reason when it disappeared without being deleted
+	// through the server, e.g. by a bucket lifecycle rule
+	FileDeleted       bool   `json:"fileDeleted,omitempty"`
+	FileDeletedReason string `json:"fileDeletedReason,omitempty"`
+
+	// Preview data for the attached file, e.g. a voice message's waveform
+	Metadata *AttachmentMetadata `json:"metadata,omitempty"`
+
+	// Uploader's caption and text description of the attached file
+	Caption string `json:"caption,omitempty"`
+	AltText string `json:"altText,omitempty"`
+
+	// Self-destruct timer requested by the sender, and when the message
+	// will be deleted; only set where disappearing messages are allowed
+	ExpiresInSeconds int        `json:"expiresInSeconds,omitempty"`
+	ExpiresAt        *time.Time `json:"expiresAt,omitempty"`
+
+	// ID of the message an event refers to
+	MessageID string `json:"messageId,omitempty"`
+
+	// ID of the stream a streaming event belongs to
+	StreamID string `json:"streamId,omitempty"`
+
+	// Why the referenced message was deleted, or why a message was refused
+	Reason string `json:"reason,omitempty"`
+
+	// Challenge nonce and hash algorithm, and the client's answer
+	Nonce     string `json:"nonce,omitempty"`
+	Algorithm string `json:"algorithm,omitempty"`
+	Hash      string `json:"hash,omitempty"`
+
+	// A room's slow-mode interval in slow_mode events, and how long to
+	// wait before sending again in nacks
+	SlowModeSeconds *int  `json:"slowModeSeconds,omitempty"`
+	RetryAfterMs    int64 `json:"retryAfterMs,omitempty"`
+
+	// Error code of a nack, e.g. ERR_FEATURE_DISABLED
+	Code string `json:"code,omitempty"`
+
+	// What the server offers, in capabilities messages
+	Capabilities *ServerCapabilities `json:"capabilities,omitempty"`
+
+	// Server clock when a heartbeat was sent, and the connection's last
+	// measured round-trip latency
+	ServerTime *time.Time `json:"serverTime,omitempty"`
+	LatencyMs  float64    `json:"latencyMs,omitempty"`
+
+	// ID of the message this one replies to
+	ReplyTo string `json:"replyTo,omitempty"`
+
+	// Root message of the thread this message was posted in; empty for
+	// top-level messages
+	ThreadRootID string `json:"threadRootId,omitempty"`
+
+	// Number of replies and when the latest was sent, on thread roots
+	ThreadReplyCount  int        `json:"threadReplyCount,omitempty"`
+	ThreadLastReplyAt *time.Time `json:"threadLastReplyAt,omitempty"`
+
+	// Thread a thread_reply event is about and its updated reply count
+	RootID     string `json:"rootId,omitempty"`
+	ReplyCount int    `json:"replyCount,omitempty"`
+
+	// Reaction counts per emoji
+	Reactions map[string]int `json:"reactions,omitempty"`
+
+	// Times the attached file was downloaded, on file messages in history
+	// listings, and the new count in download_count events
+	DownloadCount int `json:"downloadCount,omitempty"`
+	Count         int `json:"count,omitempty"`
+
+	// Content with :shortcodes: expanded; empty when nothing was expanded
+	ExpandedContent string `json:"expandedContent,omitempty"`
+
+	// Image URLs of the custom emoji used in the content or reactions, keyed by :name:
+	Emoji map[string]string `json:"emoji,omitempty"`
+
+	// Open Graph metadata for a link in the referenced message
+	Preview *LinkPreview `json:"preview,omitempty"`
+
+	// Server signature of the message and the ID of the key that made it,
+	// when message signing is on (see signing.go)
+	Signature      string `json:"signature,omitempty"`
+	SignatureKeyID string `json:"signatureKeyId,omitempty"`
+
+	// Set on messages sent again from history (see replay.go)
+	Replay bool `json:"replay,omitempty"`
+
+	// Logical channel of the message, "content" or "control"; only sent to
+	// connections that asked for it (see channels.go)
+	Channel string `json:"channel,omitempty"`
+
+	// Span the message was sent under, linked from its broadcast span
+	spanContext trace.SpanContext
+
+	// Object holding the attached file; FileURL may not reveal it (see
+	// fileDownloadURL)
+	objectName string
+
+	// Set on the stored copy of a message whose text was compressed
+	compressed *compressedText
 }
 
 // Global variables
 var (
-	clients   = make(map[*websocket.Conn]string) // connected clients (websocket -> username)
-	broadcast = make(chan Message)               // broadcast channel
-	upgrader  = websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool {
-			return true // Allow all connections
-		},
+	// Broadcast lanes, one per priority (see handleMessages)
+	broadcast         = newBroadcastQueue(0, 0) // normal priority; limits set by initBroadcastQueue
+	highBroadcast     = make(chan Message, 64)
+	criticalBroadcast = make(chan Message, 16)
+
+	upgrader = websocket.Upgrader{
+		CheckOrigin: checkOrigin, // see origin.go
 	}
 	minioClient *minio.Client
 	bucketName  = "chat-files"
+
+	// permessage-deflate settings (see initWebSocket)
+	compressionEnabled bool
+	compressionLevel   int
 )
 
 func main() {
@@ -49,11 +248,99 @@ func main() {
 		log.Println("Warning: .env file not found, using environment variables")
 	}
 
+	// Canceled on interrupt; stops background workers and triggers shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Restart background workers that panic; shut down if they keep crashing
+	supervisor = NewSupervisor(ctx, stop)
+
 	// Initialize MinIO client
+	logOutboundProxy()
 	initMinIO()
+	initStateBucket(context.Background())
+
+	// Report panics to Sentry and export traces if configured
+	initSentry()
+	shutdownTracing := initTracing(ctx)
+
+	// Configure admin authentication
+	initAuth()
+	initSigning()
+
+	// Initialize message history
+	initCompression()
+	initStore()
+	initSummaries()
+	initRooms()
+	initUsers()
+	initActivity()
+	initBots()
+	initRetention(ctx)
+	supervisor.Go("retention", runRetention)
+	initDisappearing(ctx)
+	supervisor.Go("disappearing", runDisappearing)
+	initScheduler(ctx)
+	initSlowMode(ctx)
+
+	// Configure WebSocket upgrader
+	initWebSocket()
+	initBroadcastQueue()
+	initOrigins()
+	initChallenge()
+	initHeartbeats()
+	supervisor.Go("heartbeats", runHeartbeats)
+	initReplay()
+
+	// Configure content filtering
+	initWordFilter()
+	initShortcodes()
+	initEmoji(ctx)
+	initCoalescing()
+	initDuplicates()
+	initStreams()
+	initPresence()
+	initLinkPreviews()
+	initSearch()
+	initCloseHints()
+
+	// Configure integrations and uploads
+	initWebhooks()
+	initUploads()
+	initDedup(ctx)
+	initStorageClass(ctx)
+	initAudio()
+	initScanning(ctx)
+	initIdempotency()
+	initDownloads()
+	initSlackImport()
+	initUploadRateLimit()
+	initWatch()
+	supervisor.Go("watch", runWatch)
+
+	// Reload the filter, bots and rate limits on SIGHUP
+	supervisor.Go("reload", runReloadOnSIGHUP)
+
+	// Monitor external dependencies
+	initHealthChecker()
+	supervisor.Go("health", healthChecker.Run)
 
 	// Initialize the Gin router
-	router := gin.Default()
+	router := gin.New()
+	router.Use(gin.Logger(), RecoveryMiddleware(), TracingMiddleware())
+	initTrustedProxies(router)
+
+	// Send plain HTTP and ws:// clients to HTTPS/WSS before any handler runs
+	initTLS()
+	router.Use(TLSRedirectMiddleware())
+
+	// Match routes on the escaped path so object names containing an
+	// encoded slash (room/name) fit in a single path parameter
+	router.UseRawPath = true
+
+	// Bound request bodies before any handler reads them
+	maxRequestBodyBytes = int64(getEnvInt("MAX_REQUEST_BODY_BYTES", defaultMaxRequestBodyBytes))
+	router.Use(MaxBytesMiddleware(maxRequestBodyBytes))
 
 	// Serve static files
 	router.Static("/static", "./static")
@@ -61,21 +348,123 @@ func main() {
 
 	// API routes
 	router.GET("/ws", handleConnections)
-	router.POST("/upload", handleFileUpload)
-	router.GET("/download/:filename", handleFileDownload)
+	router.GET("/readyz", handleReadyz)
+	router.GET("/signing-keys", handleListSigningKeys)
+	router.POST("/signing-keys/verify", handleVerifyMessage)
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	router.POST("/upload", UploadRateLimitMiddleware(), handleFileUpload)
+	router.POST("/upload/stream", UploadRateLimitMiddleware(), handleFileUploadStream)
+	router.GET("/messages", handleListMessages)
+	router.GET("/messages/search", handleSearchMessages)
+	router.GET("/messages/:id/thread", handleGetThread)
+	router.POST("/messages/:id/report", MaxBytesMiddleware(smallRequestBodyBytes), handleReportMessage)
+	router.POST("/messages", handlePostMessage)
+	router.POST("/messages/broadcast", MaxBytesMiddleware(smallRequestBodyBytes), handleCrossPost)
+	router.POST("/messages/schedule", MaxBytesMiddleware(smallRequestBodyBytes), handleScheduleMessage)
+	router.GET("/messages/schedule", handleListScheduled)
+	router.DELETE("/messages/schedule/:id", handleCancelScheduled)
+	router.GET("/download/*filename", handleFileDownload)
+	router.GET("/files/:id/versions", handleListFileVersions)
+	router.DELETE("/files/:id", AdminRequired(), handleDeleteFile)
+	router.DELETE("/files/:id/versions/:versionId", AdminRequired(), handleDeleteFileVersion)
+	router.GET("/unread", handleGetUnread)
+	router.POST("/read", MaxBytesMiddleware(smallRequestBodyBytes), handleMarkRead)
+	router.GET("/users", handleListUsers)
+	router.GET("/users/:username/files", handleListUserFiles)
+	router.GET("/users/:username/activity", handleUserActivity)
+	router.GET("/rooms/:room/messages", handleListRoomMessages)
+	router.GET("/rooms/:room/summary", handleRoomSummary)
+	router.GET("/rooms/:room/files", handleListRoomFiles)
+	router.GET("/rooms/:room/users", handleListRoomUsers)
+	router.GET("/rooms/:room/members", handleListRoomMembers)
+	router.GET("/rooms/:room/members/:username", handleGetRoomMember)
+	router.GET("/rooms/:room/retention", handleGetRoomRetention)
+	router.DELETE("/rooms/:room", AdminRequired(), handleDeleteRoom)
+	router.PATCH("/rooms/:room", AdminRequired(), MaxBytesMiddleware(smallRequestBodyBytes), handlePatchRoom)
+	router.PUT("/rooms/:room/slow-mode", ModeratorRequired(), MaxBytesMiddleware(smallRequestBodyBytes), handleSetSlowMode)
+	router.POST("/admin/import", AdminRequired(), handleImportMessages)
+	router.POST("/rooms/:room/import", AdminRequired(), handleSlackImport)
+	router.GET("/admin/retention/events", AdminRequired(), handleListRetentionEvents)
+	router.GET("/admin/quarantine", AdminRequired(), handleListQuarantine)
+	router.GET("/admin/reports", ModeratorRequired(), handleListReports)
+	router.GET("/admin/connections", AdminRequired(), handleListConnections)
+	router.DELETE("/admin/connections/:id", AdminRequired(), handleCloseConnection)
+	router.GET("/admin/config/reload", AdminRequired(), handleReloadConfig)
+	router.POST("/webhooks/test", AdminRequired(), MaxBytesMiddleware(smallRequestBodyBytes), handleTestWebhook)
+	router.GET("/emoji", handleListEmoji)
+	router.GET("/emoji/:name", handleGetEmoji)
+	router.POST("/emoji", AdminRequired(), MaxBytesMiddleware(maxEmojiBytes+smallRequestBodyBytes), handleUploadEmoji)
+	router.DELETE("/emoji/:name", AdminRequired(), handleDeleteEmoji)
 
 	// Start listening for incoming messages
-	go handleMessages()
+	supervisor.Go("broadcast", func(context.Context) { handleMessages() })
 
 	// Start the server
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	port := serverPort()
+	srv := &http.Server{
+		Addr:    ":" + port,
+		Handler: router,
 	}
-	log.Printf("Server starting on port %s...", port)
-	if err := router.Run(":" + port); err != nil {
-		log.Fatal("Error starting server: ", err)
+	go func() {
+		log.Printf("Server starting on port %s...", port)
+		var err error
+		if tlsEnabled() {
+			err = srv.ListenAndServeTLS(tlsCertFile, tlsKeyFile)
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatal("Error starting server: ", err)
+		}
+	}()
+
+	// Plain HTTP listener whose every request is redirected by
+	// TLSRedirectMiddleware
+	var redirectSrv *http.Server
+	if httpRedirectPort != "" {
+		redirectSrv = &http.Server{
+			Addr:    ":" + httpRedirectPort,
+			Handler: router,
+		}
+		go func() {
+			log.Printf("Redirecting HTTP on port %s to HTTPS", httpRedirectPort)
+			if err := redirectSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatal("Error starting HTTP redirect server: ", err)
+			}
+		}()
 	}
+
+	// Wait for an interrupt signal to shut down gracefully
+	<-ctx.Done()
+	log.Println("Shutting down server...")
+
+	// Warn connected clients before the server goes away
+	fanOut(Message{
+		ID:        uuid.New().String(),
+		Username:  "System",
+		Content:   "Server is restarting, please reconnect shortly.",
+		Priority:  PriorityCritical,
+		Timestamp: time.Now(),
+	})
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error shutting down server: %v", err)
+	}
+	if redirectSrv != nil {
+		if err := redirectSrv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Error shutting down HTTP redirect server: %v", err)
+		}
+	}
+
+	// Deliver messages still queued for WebSocket clients before closing them
+	flushClients(shutdownFlushTimeout)
+	flushSentry(2 * time.Second)
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		log.Printf("Error flushing traces: %v", err)
+	}
+	log.Println("Server stopped")
 }
 
 // Initialize MinIO client
@@ -98,8 +487,9 @@ func initMinIO() {
 	// Initialize MinIO client
 	var err error
 	minioClient, err = minio.New(endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
-		Secure: useSSL,
+		Creds:     credentials.NewStaticV4(accessKey, secretKey, ""),
+		Secure:    useSSL,
+		Transport: outboundTransport(useSSL),
 	})
 	if err != nil {
 		log.Fatalf("Error initializing MinIO client: %v", err)
@@ -112,33 +502,191 @@ func initMinIO() {
 		log.Fatalf("Error checking if bucket exists: %v", err)
 	}
 	if !exists {
+		// Another instance starting at the same time may create the bucket
+		// between the check and here. MakeBucket is atomic, so one of them
+		// creates it and the others carry on with the bucket as they find it.
 		err = minioClient.MakeBucket(ctx, bucketName, minio.MakeBucketOptions{})
-		if err != nil {
+		switch {
+		case isBucketExistsError(err):
+			log.Printf("Bucket %s was created by another instance", bucketName)
+		case err != nil:
 			log.Fatalf("Error creating bucket: %v", err)
+		default:
+			log.Printf("Created bucket: %s", bucketName)
 		}
-		log.Printf("Created bucket: %s", bucketName)
-
-		// Set bucket policy to allow public read access
-		policy := `{
-			"Version": "2012-10-17",
-			"Statement": [
-				{
-					"Effect": "Allow",
-					"Principal": {"AWS": ["*"]},
-					"Action": ["s3:GetObject"],
-					"Resource": ["arn:aws:s3:::` + bucketName + `/*"]
-				}
-			]
-		}`
-		err = minioClient.SetBucketPolicy(ctx, bucketName, policy)
-		if err != nil {
-			log.Fatalf("Error setting bucket policy: %v", err)
+	}
+
+	// Files are only served through the server, which checks room
+	// membership, so the bucket must not be readable by everyone
+	if err := removePublicReadPolicy(ctx, bucketName); err != nil {
+		log.Fatalf("Error removing public read access from bucket %s: %v", bucketName, err)
+	}
+
+	// Enable object versioning if configured
+	initVersioning(ctx)
+}
+
+// Remove the statements of a bucket's policy that let anyone read its
+// objects. Buckets created by older versions of the server were made
+// publicly readable, which exposed files in private rooms to anyone who
+// guessed their names.
+func removePublicReadPolicy(ctx context.Context, bucket string) error {
+	policy, err := minioClient.GetBucketPolicy(ctx, bucket)
+	if err != nil || policy == "" {
+		return err
+	}
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(policy), &doc); err != nil {
+		return fmt.Errorf("parsing bucket policy: %w", err)
+	}
+	var statements []json.RawMessage
+	if err := json.Unmarshal(doc["Statement"], &statements); err != nil {
+		return fmt.Errorf("parsing bucket policy: %w", err)
+	}
+
+	kept := statements[:0]
+	for _, raw := range statements {
+		if !isPublicReadStatement(raw) {
+			kept = append(kept, raw)
 		}
 	}
+	if len(kept) == len(statements) {
+		return nil
+	}
+	log.Printf("Removing public read access from bucket %s", bucket)
+	if len(kept) == 0 {
+		return minioClient.SetBucketPolicy(ctx, bucket, "")
+	}
+	doc["Statement"], _ = json.Marshal(kept)
+	updated, _ := json.Marshal(doc)
+	return minioClient.SetBucketPolicy(ctx, bucket, string(updated))
+}
+
+// Report whether a bucket policy statement allows anyone to get objects
+func isPublicReadStatement(raw json.RawMessage) bool {
+	var statement struct {
+		Effect    string
+		Principal json.RawMessage
+		Action    json.RawMessage
+	}
+	if json.Unmarshal(raw, &statement) != nil || statement.Effect != "Allow" {
+		return false
+	}
+	var principal struct{ AWS json.RawMessage }
+	if !slices.Contains(policyStrings(statement.Principal), "*") &&
+		(json.Unmarshal(statement.Principal, &principal) != nil || !slices.Contains(policyStrings(principal.AWS), "*")) {
+		return false
+	}
+	for _, action := range policyStrings(statement.Action) {
+		if action == "s3:GetObject" || action == "s3:*" || action == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Decode a policy field that may be a string or a list of strings
+func policyStrings(raw json.RawMessage) []string {
+	var one string
+	if json.Unmarshal(raw, &one) == nil {
+		return []string{one}
+	}
+	var list []string
+	json.Unmarshal(raw, &list)
+	return list
+}
+
+// Report whether err from MakeBucket means the bucket already exists
+func isBucketExistsError(err error) bool {
+	if err == nil {
+		return false
+	}
+	code := minio.ToErrorResponse(err).Code
+	return code == "BucketAlreadyOwnedByYou" || code == "BucketAlreadyExists"
+}
+
+// Configure WebSocket options from environment variables.
+//
+// Compression is opt-in: permessage-deflate trades CPU time on every write for
+// smaller frames, and at high fan-out the deflate cost is paid once per
+// recipient. Each message is compressed on its own (no context takeover), so
+// short chat messages shrink little. BenchmarkBroadcastCompression, writing
+// ~250-byte chat messages to one recipient at 10k msg/s on one core:
+//
+//	off:      256 B/msg,  ~5 µs/msg,  ~5% of a core
+//	level 1:  208 B/msg, ~19 µs/msg, ~19% of a core
+//	level 6:  202 B/msg, ~22 µs/msg, ~22% of a core
+//
+// That is a ~20% bandwidth saving for about four times the CPU per recipient,
+// so it is only worth turning on for clients on slow or metered links. Level
+// 1 gets nearly all of the saving of level 6 for less CPU.
+func initWebSocket() {
+	compressionEnabled = getEnvBool("WS_COMPRESSION", false)
+	compressionLevel = getEnvInt("WS_COMPRESSION_LEVEL", 6)
+	if compressionLevel < 1 || compressionLevel > 9 {
+		log.Printf("Warning: WS_COMPRESSION_LEVEL must be between 1 and 9, using 6")
+		compressionLevel = 6
+	}
+
+	// Only negotiated with clients that offer permessage-deflate in
+	// Sec-WebSocket-Extensions; other clients connect uncompressed.
+	upgrader.EnableCompression = compressionEnabled
+	if compressionEnabled {
+		log.Printf("WebSocket compression enabled (level %d)", compressionLevel)
+	}
+
+	// I/O buffer sizes per connection. Each connection holds its own
+	// buffers, so at tens of thousands of connections smaller buffers save
+	// a lot of memory; messages larger than a buffer are still sent, just
+	// in more frames/reads. The defaults match gorilla/websocket's, so
+	// even large events go out in one write. BenchmarkConnectionMemory
+	// measures ~11.3 KB of heap per connection with them and ~7.6 KB with
+	// a 1024-byte write buffer, which still fits a typical ~250-byte chat
+	// message: worth ~37 MB per 10k read-mostly subscribers.
+	upgrader.ReadBufferSize = getEnvInt("WS_READ_BUFFER_SIZE", 4096)
+	upgrader.WriteBufferSize = getEnvInt("WS_WRITE_BUFFER_SIZE", 4096)
+
+	sendBufferSize = getEnvInt("WS_SEND_BUFFER_SIZE", 256)
+	if sendBufferSize < 1 {
+		log.Printf("Warning: WS_SEND_BUFFER_SIZE must be positive, using 256")
+		sendBufferSize = 256
+	}
+
+	// Backpressure water marks, in queued messages. The high-water mark is
+	// kept below the buffer size so the signal itself still fits.
+	highWaterMark = getEnvInt("WS_BACKPRESSURE_HIGH_WATER", sendBufferSize*3/4)
+	lowWaterMark = getEnvInt("WS_BACKPRESSURE_LOW_WATER", sendBufferSize/4)
+	if highWaterMark >= sendBufferSize {
+		log.Printf("Warning: WS_BACKPRESSURE_HIGH_WATER must be below WS_SEND_BUFFER_SIZE, using %d", sendBufferSize-1)
+		highWaterMark = sendBufferSize - 1
+	}
+	if highWaterMark > 0 && (lowWaterMark < 0 || lowWaterMark >= highWaterMark) {
+		log.Printf("Warning: WS_BACKPRESSURE_LOW_WATER must be below the high-water mark, using %d", highWaterMark/2)
+		lowWaterMark = highWaterMark / 2
+	}
+	shutdownFlushTimeout = time.Duration(getEnvInt("SHUTDOWN_FLUSH_TIMEOUT_SECONDS", 5)) * time.Second
+
+	writeTimeout = time.Duration(getEnvInt("WS_WRITE_TIMEOUT_MS", 10000)) * time.Millisecond
+	if writeTimeout < 0 {
+		log.Printf("Warning: WS_WRITE_TIMEOUT_MS must not be negative, using 10000")
+		writeTimeout = 10 * time.Second
+	}
 }
 
 // Handle WebSocket connections
 func handleConnections(c *gin.Context) {
+	// Refuse new connections while a dependency is down
+	if !healthChecker.Healthy() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Service temporarily unavailable"})
+		return
+	}
+
+	// Generated anonymous names are always allowed, so only check given names
+	username, ok := checkUsername(c, c.Query("username"))
+	if !ok {
+		return
+	}
+
 	// Upgrade GET request to WebSocket
 	ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
@@ -146,38 +694,109 @@ func handleConnections(c *gin.Context) {
 		return
 	}
 	defer ws.Close()
+	defer recoverWebSocket(ws, c.GetString("requestId"), username, c.ClientIP())
 
-	// Read username from the URL query parameter
-	username := c.Query("username")
+	// Enable compression for this connection (no-op if not negotiated)
+	if compressionEnabled {
+		ws.EnableWriteCompression(true)
+		if err := ws.SetCompressionLevel(compressionLevel); err != nil {
+			log.Printf("Error setting compression level: %v", err)
+		}
+	}
+
+	// Clients that give no username get an anonymous one
 	if username == "" {
-		username = "anonymous-" + uuid.New().String()[0:8]
+		username = anonymousUsername()
+	}
+
+	// Read room from the URL query parameter; clients that don't give one
+	// join the default room, if auto-join is on
+	room := c.Query("room")
+	if room == "" {
+		if !autoJoinDefaultRoom {
+			writeClose(ws, badRequestClose("room required"))
+			return
+		}
+		room = defaultRoom
+	}
+	if !validRoomName(room) {
+		writeClose(ws, badRequestClose("invalid room name"))
+		return
+	}
+	if roomDeleting(room) {
+		writeClose(ws, roomDeletedClose)
+		return
+	}
+
+	// Read the event encoding (see compact.go)
+	encoding := c.DefaultQuery("encoding", encodingFull)
+	if encoding != encodingFull && encoding != encodingCompact {
+		writeClose(ws, badRequestClose("invalid encoding"))
+		return
+	}
+
+	// Read how many recent messages to replay (see replay.go)
+	history := 0
+	if value := c.Query("history"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			writeClose(ws, badRequestClose("invalid history"))
+			return
+		}
+		history = min(n, replayMax)
+	}
+
+	// Keep out clients that can't answer the handshake
+	if challengeEnabled && !runChallenge(ws, c.Query("username")) {
+		return
+	}
+
+	// Tell the client which optional features are on (see capabilities.go)
+	if err := sendServerCapabilities(ws, c.Query("channels") == "true"); err != nil {
+		log.Printf("Error sending server capabilities: %v", err)
+		return
 	}
 
 	// Register new client
-	clients[ws] = username
-	log.Printf("New client connected: %s", username)
+	client := addClient(ws, username, room, clientOptions{
+		moderator: isModerator(c),
+		compact:   encoding == encodingCompact,
+		channels:  c.Query("channels") == "true", // see channels.go
+		history:   history,
+	})
+	if client == nil {
+		log.Printf("Rejecting client %s: server is shutting down", username)
+		writeClose(ws, shutdownClose())
+		return
+	}
+	log.Printf("New client connected: %s (room %s, %s, connection %s)", username, room, c.ClientIP(), client.id)
+
+	// Record membership and start counting unread messages for this room
+	if err := messageStore.JoinRoom(c.Request.Context(), room, username); err != nil {
+		log.Printf("Error recording room membership: %v", err)
+	}
+	if err := messageStore.InitReadMarker(c.Request.Context(), username, room); err != nil {
+		log.Printf("Error initializing read marker: %v", err)
+	}
 
 	// Send welcome message
-	welcomeMsg := Message{
+	queueMessage(client, Message{
 		ID:        uuid.New().String(),
 		Username:  "System",
 		Content:   fmt.Sprintf("Welcome, %s! You are now connected.", username),
 		Timestamp: time.Now(),
-	}
-	err = ws.WriteJSON(welcomeMsg)
-	if err != nil {
-		log.Printf("Error sending welcome message: %v", err)
-		delete(clients, ws)
-		return
-	}
+	})
+	queueMessage(client, Message{
+		Type:         MessageTypeCapabilities,
+		Username:     "System",
+		Capabilities: currentCapabilities(),
+		Timestamp:    time.Now(),
+	})
 
 	// Notify all clients about new user
-	broadcast <- Message{
-		ID:        uuid.New().String(),
-		Username:  "System",
-		Content:   fmt.Sprintf("%s has joined the chat", username),
-		Timestamp: time.Now(),
-	}
+	announcePresence(room, username, true)
+	notifyWebhooks(WebhookEventJoin, Message{ID: uuid.New().String(), Room: room, Username: username, Timestamp: time.Now()})
+	recordActivity(username, ActivityJoin, room, nil)
 
 	// Listen for messages from this client
 	for {
@@ -185,130 +804,428 @@ func handleConnections(c *gin.Context) {
 		err := ws.ReadJSON(&msg)
 		if err != nil {
 			log.Printf("Error reading message: %v", err)
-			delete(clients, ws)
+			removeClient(client)
 			// Notify all clients about disconnected user
-			broadcast <- Message{
-				ID:        uuid.New().String(),
-				Username:  "System",
-				Content:   fmt.Sprintf("%s has left the chat", username),
-				Timestamp: time.Now(),
-			}
+			announcePresence(room, username, false)
+			notifyWebhooks(WebhookEventLeave, Message{ID: uuid.New().String(), Room: room, Username: username, Timestamp: time.Now()})
+			recordActivity(username, ActivityLeave, room, nil)
 			break
 		}
 
+		// Heartbeat acks are bookkeeping, not messages
+		if msg.Type == MessageTypeHeartbeatAck {
+			client.ackHeartbeat(msg.Seq, time.Now())
+			continue
+		}
+		if msg.Type == MessageTypeReady {
+			if replayMax == 0 {
+				queueMessage(client, featureDisabledNack(FeatureHistoryReplay))
+				continue
+			}
+			startReplay(c.Request.Context(), client)
+			continue
+		}
+
+		// Keep only the fields clients may set; everything else is server-assigned
+		switch msg.Type {
+		case MessageTypeTyping:
+			msg = Message{Type: MessageTypeTyping}
+		case MessageTypeReaction:
+			msg = Message{Type: MessageTypeReaction, MessageID: msg.MessageID, Content: msg.Content}
+		case MessageTypeStreamStart, MessageTypeStreamChunk, MessageTypeStreamEnd:
+			msg = Message{Type: msg.Type, StreamID: msg.StreamID, Content: msg.Content}
+		default:
+			msg = Message{Content: msg.Content, FileURL: msg.FileURL, FileName: msg.FileName, ReplyTo: msg.ReplyTo, ThreadRootID: msg.ThreadRootID, ExpiresInSeconds: msg.ExpiresInSeconds}
+		}
+
+		// Self-destruct timers need disappearing messages on
+		if msg.ExpiresInSeconds != 0 && !disappearingEnabled {
+			queueMessage(client, featureDisabledNack(FeatureDisappearingMessages))
+			continue
+		}
+		if msg.ExpiresInSeconds != 0 && !validExpiresIn(msg.ExpiresInSeconds) {
+			queueMessage(client, expiresInNack())
+			continue
+		}
+
+		// Moderators are exempt from slow mode
+		if !client.moderator && (msg.Type == "" || msg.Type == MessageTypeStreamStart) {
+			now := time.Now()
+			if wait := slowModeWait(room, username, now); wait > 0 {
+				queueMessage(client, slowModeNack(wait))
+				continue
+			}
+			recordSlowModeSend(room, username, now)
+		}
+
 		// Set message properties
 		msg.ID = uuid.New().String()
+		msg.Room = room
 		msg.Username = username
 		msg.Timestamp = time.Now()
 
+		// Trace each received message under the connection's span
+		_, span := tracer.Start(c.Request.Context(), "ws.receive", trace.WithAttributes(attribute.String("chat.message_type", msg.Type)))
+		msg.spanContext = span.SpanContext()
+		span.End()
+
 		// Send message to all clients
-		broadcast <- msg
+		publish(msg)
+	}
+}
+
+// Queue a message on the broadcast lane matching its priority
+func publish(msg Message) {
+	switch msg.Priority {
+	case PriorityCritical:
+		criticalBroadcast <- msg
+	case PriorityHigh:
+		highBroadcast <- msg
+	default:
+		broadcast.Push(msg)
+	}
+}
+
+// Take the next message, always preferring higher-priority lanes.
+//
+// Tradeoffs: a steady stream of high-priority messages starves the normal
+// lane, and messages on different lanes may be delivered out of publish
+// order. Both are acceptable because high and critical traffic is rare
+// (system alerts) and only needs to overtake a backlog of chat, not
+// interleave with it. The lanes are buffered so publishers of urgent
+// messages don't queue behind blocked chat publishers; the normal lane
+// never blocks publishers and sheds its oldest messages instead (see
+// broadcastqueue.go).
+func nextMessage() Message {
+	for {
+		select {
+		case msg := <-criticalBroadcast:
+			return msg
+		default:
+		}
+		select {
+		case msg := <-criticalBroadcast:
+			return msg
+		case msg := <-highBroadcast:
+			return msg
+		default:
+		}
+		select {
+		case msg := <-criticalBroadcast:
+			return msg
+		case msg := <-highBroadcast:
+			return msg
+		case <-broadcast.ready:
+			if msg, ok := broadcast.Pop(); ok {
+				return msg
+			}
+		}
 	}
 }
 
 // Handle messages broadcast to all clients
 func handleMessages() {
 	for {
-		// Grab the next message from the broadcast channel
-		msg := <-broadcast
-
-		// Send it to every client
-		for client := range clients {
-			err := client.WriteJSON(msg)
-			if err != nil {
-				log.Printf("Error sending message: %v", err)
-				client.Close()
-				delete(clients, client)
+		// Grab the next message from the broadcast lanes
+		handleMessage(nextMessage())
+	}
+}
+
+// Filter, store and fan out one message. Messages outlive the requests
+// that sent them, so the store calls run under the message's own span
+// rather than a request context.
+func handleMessage(msg Message) {
+	ctx, span := startMessageSpan("broadcast", msg)
+	defer span.End()
+
+	// Rooms being deleted take no new messages
+	if msg.Room != "" && roomDeleting(msg.Room) {
+		return
+	}
+
+	// Critical messages skip filtering and go straight to every client
+	if msg.Priority == PriorityCritical {
+		fanOut(msg)
+		return
+	}
+
+	// Relay streamed messages chunk by chunk
+	if isStreamEvent(msg.Type) {
+		handleStreamEvent(ctx, msg)
+		return
+	}
+
+	// Record reactions; the event carries the updated counts
+	if msg.Type == MessageTypeReaction && !applyReaction(ctx, &msg) {
+		return
+	}
+
+	// Collapse bursts of typing/presence events
+	if typingCoalescer != nil && isCoalescable(msg.Type) {
+		typingCoalescer.Submit(msg)
+		return
+	}
+
+	// Strip HTML before anything inspects the text, so markup can't split
+	// a blocked word that stripping would join again
+	if msg.Type == "" && msg.Username != "System" {
+		sanitizeMessage(&msg)
+	}
+
+	// Drop accidental double-sends
+	if duplicates != nil && msg.Type == "" && msg.Username != "System" && duplicates.IsDuplicate(msg) {
+		log.Printf("Duplicate message from %s dropped", msg.Username)
+		if notifyDuplicate {
+			notifyDuplicateDropped(msg.Username)
+		}
+		return
+	}
+
+	// Apply the word filter to user messages before fan-out
+	if filter := currentWordFilter(); filter != nil && msg.Type == "" && msg.Username != "System" {
+		content, ok := filter.Apply(msg.Content)
+		if !ok {
+			log.Printf("Message from %s rejected by word filter", msg.Username)
+			notifyRejected(msg.Username)
+			return
+		}
+		msg.Content = content
+	}
+
+	// Expand shortcodes, keeping the raw content alongside
+	if shortcodes != nil && msg.Type == "" && msg.Username != "System" {
+		if expanded := expandShortcodes(msg.Content, shortcodes); expanded != msg.Content {
+			msg.ExpandedContent = expanded
+		}
+	}
+	if msg.Type == "" && msg.Username != "System" {
+		msg.Emoji = customEmojiRefs(msg.Content)
+	}
+
+	// Keep chat messages in history and pass them on to integrations
+	if msg.Type == "" && msg.Username != "System" {
+		if msg.ReplyTo != "" {
+			if target, err := messageStore.Get(ctx, msg.ReplyTo); err != nil || target.Room != msg.Room {
+				msg.ReplyTo = ""
 			}
 		}
+		if msg.ThreadRootID != "" {
+			msg.ThreadRootID = threadRoot(ctx, msg.Room, msg.ThreadRootID)
+		}
+		applyExpiry(&msg)
+		signMessage(&msg)
+		insertCtx, insertSpan := tracer.Start(ctx, "store.Insert")
+		if err := messageStore.Insert(insertCtx, &msg); err != nil {
+			log.Printf("Error storing message: %v", err)
+		}
+		insertSpan.End()
+		if msg.FileURL != "" {
+			notifyWebhooks(WebhookEventFile, msg)
+		} else {
+			notifyWebhooks(WebhookEventMessage, msg)
+		}
+		recordMessageActivity(msg)
 	}
+
+	fanOut(msg)
+
+	// Let the room update the thread's preview without loading its replies
+	if msg.ThreadRootID != "" && msg.Seq != 0 {
+		fanOutThreadReply(ctx, msg)
+	}
+
+	// Let bots answer chat messages once they are in history
+	if msg.Type == "" && msg.Username != "System" && msg.Seq != 0 {
+		dispatchToBots(msg)
+	}
+
+	// Previews are fetched in the background and sent as a follow-up event
+	if linkPreviewsEnabled && msg.Type == "" && msg.Username != "System" {
+		startLinkPreview(msg)
+	}
+}
+
+// Queue a message for every client in the message's room (or every client
+// for server-wide messages), skipping members the room's broadcast filter
+// rejects
+func fanOut(msg Message) {
+	if msg.Signature == "" {
+		signMessage(&msg)
+	}
+
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	var allowed map[string]bool
+	if msg.Room != "" && msg.Priority != PriorityCritical {
+		if filter := roomBroadcastFilter(msg.Room); filter != nil {
+			allowed = filterRecipientsLocked(filter, msg)
+		}
+	}
+	for client := range clients {
+		if msg.Room != "" && client.room != msg.Room {
+			continue
+		}
+		if allowed != nil && !allowed[client.username] {
+			continue
+		}
+		queueMessageLocked(client, msg)
+	}
+}
+
+// Tell a user that their message was rejected by the word filter
+func notifyRejected(username string) {
+	sendToUser(username, Message{
+		ID:        uuid.New().String(),
+		Username:  "System",
+		Content:   "Your message was not sent because it contains blocked words.",
+		Timestamp: time.Now(),
+	})
 }
 
 // Handle file uploads to MinIO
 func handleFileUpload(c *gin.Context) {
+	// Parse the body up front: the form accessors below ignore parse errors
+	if !parseUploadForm(c) {
+		return
+	}
+	defer c.Request.MultipartForm.RemoveAll()
+
 	// Get username from form
-	username := c.PostForm("username")
+	username, ok := checkUsername(c, c.PostForm("username"))
+	if !ok {
+		return
+	}
 	if username == "" {
-		username = "anonymous-" + uuid.New().String()[0:8]
+		username = anonymousUsername()
+	}
+	room := c.DefaultPostForm("room", defaultRoom)
+	if !validRoomName(room) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid room name"})
+		return
+	}
+	if !canAccessRoom(c.Request.Context(), room, username) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not a member of this room"})
+		return
+	}
+	caption, altText, ok := attachmentText(c)
+	if !ok {
+		return
+	}
+
+	// Limit how many uploads a single user can run at once
+	if !acquireUploadSlot(username) {
+		c.Header("Retry-After", "5")
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many concurrent uploads"})
+		return
+	}
+	defer releaseUploadSlot(username)
+
+	// A retried upload with the same idempotency key reuses the first result
+	idempotencyKey := c.GetHeader("Idempotency-Key")
+	var uploaded *Message
+	if idempotencyKey != "" {
+		existing, inProgress := claimIdempotencyKey(username, idempotencyKey)
+		if inProgress {
+			c.JSON(http.StatusConflict, gin.H{"error": "An upload with this idempotency key is already in progress"})
+			return
+		}
+		if existing != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"message":   "File already uploaded",
+				"fileUrl":   existing.FileURL,
+				"fileName":  existing.FileName,
+				"versionId": existing.VersionID,
+				"duplicate": true,
+			})
+			return
+		}
+		defer func() { completeIdempotencyKey(username, idempotencyKey, uploaded) }()
 	}
 
-	// Get the file from the request
+	// Get the file from the parsed form
 	file, header, err := c.Request.FormFile("file")
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No file provided"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No file provided", "code": "ERR_FILE_MISSING"})
 		return
 	}
 	defer file.Close()
 
-	// Generate a unique filename
-	fileExt := filepath.Ext(header.Filename)
-	objectName := fmt.Sprintf("%s-%s%s", time.Now().Format("20060102-150405"), uuid.New().String()[0:8], fileExt)
+	// Voice messages get a waveform for previews
+	metadata, err := analyzeUpload(c.Request.Context(), file, header)
+	if err != nil {
+		respondAnalyzeError(c, err)
+		return
+	}
 
-	// Upload the file to MinIO
-	ctx := context.Background()
-	_, err = minioClient.PutObject(ctx, bucketName, objectName, file, header.Size, minio.PutObjectOptions{
-		ContentType: http.DetectContentType(make([]byte, 512)), // Detect content type
-	})
+	// Upload the file to MinIO; a client that disconnects cancels the upload
+	objectName, info, err := putUploadedFile(c.Request.Context(), username, room, idempotencyKey, file, header, nil)
 	if err != nil {
+		if respondScanError(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload file to storage"})
 		log.Printf("Error uploading file: %v", err)
 		return
 	}
 
 	// Generate file URL
-	fileURL := fmt.Sprintf("/download/%s", objectName)
+	id := uuid.New().String()
+	fileURL := fileDownloadURL(id, objectName, info.VersionID)
 
 	// Create a message with the file information
 	msg := Message{
-		ID:        uuid.New().String(),
+		ID:        id,
+		Room:      room,
 		Username:  username,
 		Content:   fmt.Sprintf("shared a file: %s", header.Filename),
 		FileURL:   fileURL,
 		FileName:  header.Filename,
+		FileSize:  header.Size,
+		VersionID: info.VersionID,
+		Metadata:  metadata,
+		Caption:   caption,
+		AltText:   altText,
 		Timestamp: time.Now(),
+
+		spanContext: trace.SpanContextFromContext(c.Request.Context()),
+		objectName:  objectName,
 	}
 
 	// Broadcast the message
-	broadcast <- msg
+	publish(msg)
+	uploaded = &msg
 
 	// Return success response
 	c.JSON(http.StatusOK, gin.H{
-		"message":  "File uploaded successfully",
-		"fileUrl":  fileURL,
-		"fileName": header.Filename,
+		"message":   "File uploaded successfully",
+		"fileUrl":   fileURL,
+		"fileName":  header.Filename,
+		"versionId": info.VersionID,
+		"caption":   caption,
+		"altText":   altText,
 	})
 }
 
-// Handle file downloads from MinIO
+// Handle file downloads from MinIO. /download/by-message/<id> resolves a
+// file message's attachment; any other path names the object directly.
 func handleFileDownload(c *gin.Context) {
-	filename := c.Param("filename")
-
-	// Get object from MinIO
-	ctx := context.Background()
-	object, err := minioClient.GetObject(ctx, bucketName, filename, minio.GetObjectOptions{})
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve file"})
-		log.Printf("Error getting object: %v", err)
+	filename := strings.TrimPrefix(c.Param("filename"), "/")
+	if id, ok := strings.CutPrefix(filename, byMessagePrefix); ok && uuid.Validate(id) == nil {
+		handleDownloadByMessage(c, id)
 		return
 	}
-	defer object.Close()
-
-	// Get object info
-	info, err := object.Stat()
-	if err != nil {
+	if !directDownloads || isReservedObjectName(filename) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
-		log.Printf("Error getting object info: %v", err)
 		return
 	}
 
-	// Set headers
-	c.Header("Content-Description", "File Transfer")
-	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", info.Key))
-	c.Header("Content-Type", info.ContentType)
-	c.Header("Content-Length", fmt.Sprintf("%d", info.Size))
-
-	// Stream the file to the response
-	if _, err := io.Copy(c.Writer, object); err != nil {
-		log.Printf("Error streaming file: %v", err)
+	// In private mode only room members may download a room's files
+	if !canAccessRoom(c.Request.Context(), roomOfObject(filename), c.Query("username")) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not a member of this room"})
+		return
+	}
+	if serveObject(c, filename, c.Query("version"), false) {
+		recordObjectDownload(c.Request.Context(), filename, c.Query("version"), c.Query("username"), c.ClientIP())
 	}
 }
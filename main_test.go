@@ -0,0 +1,1060 @@
+// main_test.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/notification"
+)
+
+// Replace the message store with an empty in-memory one for a test
+func useMemoryStore(t *testing.T) {
+	t.Helper()
+	saved := messageStore
+	messageStore = newMemoryStore(100)
+	t.Cleanup(func() { messageStore = saved })
+}
+
+// Serve one request through a router with handler on route, routing on
+// the raw path like the server's router so object names may hold %2F
+func serveTest(method, route, target string, body io.Reader, handler gin.HandlerFunc) *httptest.ResponseRecorder {
+	return serveTestRequest(route, httptest.NewRequest(method, target, body), handler)
+}
+
+// Serve a prepared request, e.g. one with headers set, like serveTest
+func serveTestRequest(route string, req *http.Request, handler gin.HandlerFunc) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.UseRawPath = true
+	router.Handle(req.Method, route, handler)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+// Build a multipart POST request from form fields, with a file when
+// fileName is set
+func newUploadForm(target string, fields map[string]string, fileName, fileData string) *http.Request {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for name, value := range fields {
+		form.WriteField(name, value)
+	}
+	if fileName != "" {
+		part, _ := form.CreateFormFile("file", fileName)
+		part.Write([]byte(fileData))
+	}
+	form.Close()
+	req := httptest.NewRequest(http.MethodPost, target, &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return req
+}
+
+// Replace the connected clients for a test
+func useClients(t *testing.T, connected ...*Client) {
+	t.Helper()
+	clientsMu.Lock()
+	saved := clients
+	clients = make(map[*Client]bool)
+	for _, client := range connected {
+		clients[client] = true
+	}
+	clientsMu.Unlock()
+	t.Cleanup(func() {
+		clientsMu.Lock()
+		clients = saved
+		clientsMu.Unlock()
+	})
+}
+
+// logBuffer collects log output; safe for concurrent writers
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// Capture the standard logger's output for a test
+func captureLog(t *testing.T) *logBuffer {
+	t.Helper()
+	buf := &logBuffer{}
+	saved := log.Writer()
+	log.SetOutput(buf)
+	t.Cleanup(func() { log.SetOutput(saved) })
+	return buf
+}
+
+// wsTestServer runs /ws like the server does, with a broadcaster, for
+// tests that talk to it over real connections
+type wsTestServer struct {
+	t      *testing.T
+	server *httptest.Server
+	router *gin.Engine
+}
+
+// Start a WebSocket test server with an empty store, no clients and the
+// server's default connection settings
+func useWSServer(t *testing.T) *wsTestServer {
+	t.Helper()
+	useMemoryStore(t)
+	useClients(t)
+	queue := useBroadcastQueue(t)
+
+	savedHealth, savedBuffer := healthChecker, sendBufferSize
+	healthChecker = NewHealthChecker(time.Hour, 1)
+	if sendBufferSize == 0 {
+		sendBufferSize = 256
+	}
+	t.Cleanup(func() { healthChecker, sendBufferSize = savedHealth, savedBuffer })
+
+	// Broadcast like handleMessages, until the test ends
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-stop:
+				return
+			case msg := <-criticalBroadcast:
+				handleMessage(msg)
+			case msg := <-highBroadcast:
+				handleMessage(msg)
+			case <-queue.ready:
+				if msg, ok := queue.Pop(); ok {
+					handleMessage(msg)
+				}
+			}
+		}
+	}()
+
+	// Handlers outlive their connections briefly; wait for them before
+	// the globals they use are restored
+	var handlers sync.WaitGroup
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.UseRawPath = true
+	router.GET("/ws", func(c *gin.Context) {
+		handlers.Add(1)
+		defer handlers.Done()
+		handleConnections(c)
+	})
+	server := httptest.NewServer(router)
+	t.Cleanup(func() {
+		server.Close()
+		handlers.Wait()
+		close(stop)
+		<-stopped
+	})
+	return &wsTestServer{t: t, server: server, router: router}
+}
+
+// Connect to /ws with a query string; the connection is closed when the
+// test ends
+func (s *wsTestServer) dial(query string) (*websocket.Conn, *http.Response, error) {
+	ws, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.server.URL, "http")+"/ws?"+query, nil)
+	if ws != nil {
+		s.t.Cleanup(func() { ws.Close() })
+	}
+	return ws, resp, err
+}
+
+// Connect as a user to a room, failing the test if the server refuses
+func (s *wsTestServer) connect(username, room string) *websocket.Conn {
+	s.t.Helper()
+	ws, _, err := s.dial(url.Values{"username": {username}, "room": {room}}.Encode())
+	if err != nil {
+		s.t.Fatalf("connecting as %s: %v", username, err)
+	}
+	return ws
+}
+
+// Read messages until one matches, failing the test after a few seconds
+func readUntil(t *testing.T, ws *websocket.Conn, match func(Message) bool) Message {
+	t.Helper()
+	ws.SetReadDeadline(time.Now().Add(3 * time.Second))
+	defer ws.SetReadDeadline(time.Time{})
+	for {
+		var msg Message
+		if err := ws.ReadJSON(&msg); err != nil {
+			t.Fatalf("reading: %v", err)
+		}
+		if match(msg) {
+			return msg
+		}
+	}
+}
+
+// Wait until users are registered as connected, failing the test after a
+// few seconds
+func waitConnected(t *testing.T, usernames ...string) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for _, username := range usernames {
+		for !isConnectedUser(username) {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s never connected", username)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+}
+
+// fakeS3 is an in-memory stand-in for MinIO, speaking just enough of the
+// S3 API for the calls the server makes: buckets and their policies,
+// object puts, gets with ranges, stats, copies, deletes, listings and
+// bucket notifications
+type fakeS3 struct {
+	mu      sync.Mutex
+	buckets map[string]*fakeBucket
+	puts    int // object puts, for checking uploads were reused
+
+	// Code MakeBucket fails with after creating the bucket anyway, to
+	// simulate another instance creating it first
+	makeBucketErr string
+	// Delay before answering each object request
+	delay time.Duration
+	// Called on each object GET; returning false cuts the response short
+	// after half the body
+	onGet func(r *http.Request) bool
+	// Called on each object put before it is stored; returning false
+	// fails the put
+	onPut func(key string) bool
+
+	listeners []chan notification.Info
+	server    *httptest.Server
+}
+
+type fakeBucket struct {
+	objects   map[string]*fakeObject
+	policy    string
+	lifecycle []byte
+}
+
+type fakeObject struct {
+	data        []byte
+	contentType string
+	metadata    http.Header // X-Amz-Meta-* headers
+	modified    time.Time
+	etag        string
+
+	storageClass string // X-Amz-Storage-Class it was put with
+	tags         string // X-Amz-Tagging it was put with
+}
+
+// Start a fake S3 server with the given buckets and point minioClient at it
+func useFakeS3(t *testing.T, buckets ...string) *fakeS3 {
+	t.Helper()
+	fake := &fakeS3{buckets: make(map[string]*fakeBucket)}
+	for _, name := range buckets {
+		fake.buckets[name] = &fakeBucket{objects: make(map[string]*fakeObject)}
+	}
+	server := httptest.NewTLSServer(fake)
+	fake.server = server
+	t.Cleanup(server.Close)
+	t.Cleanup(fake.closeListeners)
+
+	client, err := minio.New(strings.TrimPrefix(server.URL, "https://"), &minio.Options{
+		Creds:     credentials.NewStaticV4("test", "test", ""),
+		Secure:    true,
+		Region:    "us-east-1",
+		Transport: server.Client().Transport,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := minioClient
+	minioClient = client
+	t.Cleanup(func() { minioClient = saved })
+	return fake
+}
+
+// Point the MINIO_* environment at the fake, trusting its certificate, for
+// code that builds its own client
+func (f *fakeS3) setEnv(t *testing.T) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "fake-s3.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: f.server.Certificate().Raw})
+	if err := os.WriteFile(path, cert, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SSL_CERT_FILE", path)
+	t.Setenv("MINIO_ENDPOINT", strings.TrimPrefix(f.server.URL, "https://"))
+	t.Setenv("MINIO_USE_SSL", "true")
+}
+
+// Fetch an object anonymously, as anyone who knows its name could
+func (f *fakeS3) anonymousGet(t *testing.T, bucket, key string) int {
+	t.Helper()
+	resp, err := f.server.Client().Get(f.server.URL + "/" + bucket + "/" + key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// Store an object directly, as another MinIO client would
+func (f *fakeS3) putObject(bucket, key string, data []byte, metadata map[string]string) {
+	header := make(http.Header)
+	for name, value := range metadata {
+		header.Set("X-Amz-Meta-"+name, value)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.storeLocked(bucket, key, &fakeObject{data: data, contentType: "application/octet-stream", metadata: header})
+}
+
+// Return a stored object, or nil
+func (f *fakeS3) object(bucket, key string) *fakeObject {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if b := f.buckets[bucket]; b != nil {
+		return b.objects[key]
+	}
+	return nil
+}
+
+// Return the keys stored in a bucket, sorted
+func (f *fakeS3) keys(bucket string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var keys []string
+	if b := f.buckets[bucket]; b != nil {
+		for key := range b.objects {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Return the number of object puts so far
+func (f *fakeS3) putCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.puts
+}
+
+func (f *fakeS3) storeLocked(bucket, key string, obj *fakeObject) {
+	sum := md5.Sum(obj.data)
+	obj.etag = hex.EncodeToString(sum[:])
+	obj.modified = time.Now().UTC().Truncate(time.Second)
+	f.buckets[bucket].objects[key] = obj
+	f.puts++
+
+	event := notification.Info{Records: []notification.Event{{EventName: "s3:ObjectCreated:Put"}}}
+	event.Records[0].S3.Bucket.Name = bucket
+	event.Records[0].S3.Object.Key = url.QueryEscape(key)
+	event.Records[0].S3.Object.Size = int64(len(obj.data))
+	event.Records[0].S3.Object.ETag = obj.etag
+	for _, listener := range f.listeners {
+		select {
+		case listener <- event:
+		default:
+		}
+	}
+}
+
+func (f *fakeS3) closeListeners() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, listener := range f.listeners {
+		close(listener)
+	}
+	f.listeners = nil
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	query := r.URL.Query()
+	if key == "" {
+		f.serveBucket(w, r, bucket, query)
+		return
+	}
+	if f.delay > 0 {
+		select {
+		case <-time.After(f.delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	f.serveObject(w, r, bucket, key)
+}
+
+func (f *fakeS3) serveBucket(w http.ResponseWriter, r *http.Request, bucket string, query url.Values) {
+	if query.Has("location") {
+		io.WriteString(w, `<LocationConstraint xmlns="http://s3.amazonaws.com/doc/2006-03-01/"></LocationConstraint>`)
+		return
+	}
+	if query.Has("events") {
+		f.serveNotifications(w, r)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b := f.buckets[bucket]
+	if r.Method == http.MethodPut && len(query) == 0 {
+		switch {
+		case f.makeBucketErr != "":
+			if b == nil {
+				f.buckets[bucket] = &fakeBucket{objects: make(map[string]*fakeObject)}
+			}
+			s3Error(w, http.StatusConflict, f.makeBucketErr)
+		case b != nil:
+			s3Error(w, http.StatusConflict, "BucketAlreadyOwnedByYou")
+		default:
+			f.buckets[bucket] = &fakeBucket{objects: make(map[string]*fakeObject)}
+		}
+		return
+	}
+	if b == nil {
+		s3Error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodHead:
+	case query.Has("policy"):
+		switch r.Method {
+		case http.MethodGet:
+			if b.policy == "" {
+				s3Error(w, http.StatusNotFound, "NoSuchBucketPolicy")
+				return
+			}
+			io.WriteString(w, b.policy)
+		case http.MethodPut:
+			policy, _ := io.ReadAll(r.Body)
+			b.policy = string(policy)
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodDelete:
+			b.policy = ""
+			w.WriteHeader(http.StatusNoContent)
+		}
+	case query.Has("lifecycle"):
+		switch r.Method {
+		case http.MethodGet:
+			if b.lifecycle == nil {
+				s3Error(w, http.StatusNotFound, "NoSuchLifecycleConfiguration")
+				return
+			}
+			w.Write(b.lifecycle)
+		case http.MethodPut:
+			b.lifecycle, _ = io.ReadAll(r.Body)
+		case http.MethodDelete:
+			b.lifecycle = nil
+			w.WriteHeader(http.StatusNoContent)
+		}
+	case query.Has("versioning"):
+		io.WriteString(w, `<VersioningConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/"></VersioningConfiguration>`)
+	case r.Method == http.MethodGet:
+		f.serveListLocked(w, b, query)
+	default:
+		s3Error(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+// Answer ListObjectsV2, with user metadata when ?metadata=true as MinIO does
+func (f *fakeS3) serveListLocked(w http.ResponseWriter, b *fakeBucket, query url.Values) {
+	type entry struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int
+		StorageClass string
+	}
+	type result struct {
+		XMLName        xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+		Name           string
+		Prefix         string
+		KeyCount       int
+		MaxKeys        int
+		IsTruncated    bool
+		Contents       []entry
+		CommonPrefixes []struct{ Prefix string }
+	}
+	prefix, delimiter := query.Get("prefix"), query.Get("delimiter")
+	res := result{Prefix: prefix, MaxKeys: 1000}
+	seen := make(map[string]bool)
+	keys := make([]string, 0, len(b.objects))
+	for key := range b.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				common := key[:len(prefix)+i+len(delimiter)]
+				if !seen[common] {
+					seen[common] = true
+					res.CommonPrefixes = append(res.CommonPrefixes, struct{ Prefix string }{common})
+				}
+				continue
+			}
+		}
+		obj := b.objects[key]
+		res.Contents = append(res.Contents, entry{
+			Key:          key,
+			LastModified: obj.modified.Format("2006-01-02T15:04:05.000Z"),
+			ETag:         `"` + obj.etag + `"`,
+			Size:         len(obj.data),
+			StorageClass: "STANDARD",
+		})
+	}
+	res.KeyCount = len(res.Contents)
+	data, _ := xml.Marshal(res)
+	if query.Get("metadata") == "true" {
+		// Splice each object's metadata in, which encoding/xml can't
+		// express as a map
+		for _, e := range res.Contents {
+			var meta bytes.Buffer
+			meta.WriteString("<UserMetadata>")
+			for name, values := range b.objects[e.Key].metadata {
+				meta.WriteString("<" + name + ">")
+				xml.EscapeText(&meta, []byte(values[0]))
+				meta.WriteString("</" + name + ">")
+			}
+			meta.WriteString("</UserMetadata>")
+			var escaped bytes.Buffer
+			xml.EscapeText(&escaped, []byte(e.Key))
+			marker := "<Key>" + escaped.String() + "</Key>"
+			data = bytes.Replace(data, []byte(marker), []byte(marker+meta.String()), 1)
+		}
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write(data)
+}
+
+// Stream ObjectCreated events to a ListenBucketNotification caller
+func (f *fakeS3) serveNotifications(w http.ResponseWriter, r *http.Request) {
+	events := make(chan notification.Info, 16)
+	f.mu.Lock()
+	f.listeners = append(f.listeners, events)
+	f.mu.Unlock()
+
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			json.NewEncoder(w).Encode(event)
+			w.(http.Flusher).Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (f *fakeS3) serveObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	f.mu.Lock()
+	b := f.buckets[bucket]
+	if b == nil {
+		f.mu.Unlock()
+		s3Error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		obj := &fakeObject{
+			contentType:  r.Header.Get("Content-Type"),
+			metadata:     make(http.Header),
+			storageClass: r.Header.Get("X-Amz-Storage-Class"),
+			tags:         r.Header.Get("X-Amz-Tagging"),
+		}
+		for name, values := range r.Header {
+			if strings.HasPrefix(name, "X-Amz-Meta-") {
+				obj.metadata[name] = values
+			}
+		}
+		if source := r.Header.Get("X-Amz-Copy-Source"); source != "" {
+			source, _ = url.PathUnescape(source)
+			fromBucket, fromKey, _ := strings.Cut(strings.TrimPrefix(source, "/"), "/")
+			from := f.buckets[fromBucket].objects[fromKey]
+			if from == nil {
+				f.mu.Unlock()
+				s3Error(w, http.StatusNotFound, "NoSuchKey")
+				return
+			}
+			obj.data, obj.contentType = from.data, from.contentType
+			if r.Header.Get("X-Amz-Metadata-Directive") != "REPLACE" {
+				obj.metadata = from.metadata
+			}
+			f.storeLocked(bucket, key, obj)
+			f.mu.Unlock()
+			fmt.Fprintf(w, `<CopyObjectResult><ETag>"%s"</ETag><LastModified>%s</LastModified></CopyObjectResult>`,
+				obj.etag, obj.modified.Format("2006-01-02T15:04:05.000Z"))
+			return
+		}
+		onPut := f.onPut
+		f.mu.Unlock()
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			return
+		}
+		if onPut != nil && !onPut(key) {
+			s3Error(w, http.StatusForbidden, "AccessDenied")
+			return
+		}
+		obj.data = data
+		f.mu.Lock()
+		f.storeLocked(bucket, key, obj)
+		f.mu.Unlock()
+		w.Header().Set("ETag", `"`+obj.etag+`"`)
+		return
+	case http.MethodDelete:
+		delete(b.objects, key)
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// Anonymous reads need a bucket policy allowing them
+	anonymous := r.Header.Get("Authorization") == "" && !r.URL.Query().Has("X-Amz-Signature")
+	if anonymous && !strings.Contains(b.policy, "s3:GetObject") {
+		f.mu.Unlock()
+		s3Error(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+	obj := b.objects[key]
+	onGet := f.onGet
+	f.mu.Unlock()
+	if obj == nil {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		s3Error(w, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+	if match := r.Header.Get("If-Match"); match != "" && strings.Trim(match, `"`) != obj.etag {
+		s3Error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+		return
+	}
+
+	data := obj.data
+	status := http.StatusOK
+	if rng := r.Header.Get("Range"); rng != "" {
+		var start, end int
+		if n, _ := fmt.Sscanf(rng, "bytes=%d-%d", &start, &end); n == 0 || start >= len(data) {
+			s3Error(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange")
+			return
+		} else if n == 1 || end >= len(data) {
+			end = len(data) - 1
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+		data = data[start : end+1]
+		status = http.StatusPartialContent
+	}
+	for name, values := range obj.metadata {
+		w.Header()[name] = values
+	}
+	w.Header().Set("Content-Type", obj.contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("ETag", `"`+obj.etag+`"`)
+	w.Header().Set("Last-Modified", obj.modified.Format(http.TimeFormat))
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	if onGet != nil && !onGet(r) {
+		w.Write(data[:len(data)/2])
+		// Drop the connection so the client sees the body end early
+		panic(http.ErrAbortHandler)
+	}
+	w.Write(data)
+}
+
+func s3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<Error><Code>%s</Code><Message>%s</Message></Error>`, code, code)
+}
+
+// The public-read policy older versions of the server gave the bucket
+const legacyPublicReadPolicy = `{
+	"Version": "2012-10-17",
+	"Statement": [
+		{
+			"Effect": "Allow",
+			"Principal": {"AWS": ["*"]},
+			"Action": ["s3:GetObject"],
+			"Resource": ["arn:aws:s3:::chat-files/*"]
+		}
+	]
+}`
+
+func TestInitMinIORoomFilesArePrivate(t *testing.T) {
+	const writeOnly = `{"Effect":"Allow","Principal":{"AWS":["arn:aws:iam::1:user/uploader"]},"Action":["s3:PutObject"],"Resource":["arn:aws:s3:::chat-files/*"]}`
+	tests := []struct {
+		name       string
+		exists     bool
+		policy     string
+		wantPolicy string // what must survive of the policy
+	}{
+		{"new bucket", false, "", ""},
+		{"existing private bucket", true, "", ""},
+		{"legacy public bucket", true, legacyPublicReadPolicy, ""},
+		{"public read among other statements", true, `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":"*","Action":"s3:GetObject","Resource":"arn:aws:s3:::chat-files/*"},` + writeOnly + `]}`, "s3:PutObject"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fake *fakeS3
+			if tt.exists {
+				fake = useFakeS3(t, bucketName)
+				fake.buckets[bucketName].policy = tt.policy
+			} else {
+				fake = useFakeS3(t)
+			}
+			fake.setEnv(t)
+			initMinIO()
+
+			policy := fake.buckets[bucketName].policy
+			if strings.Contains(policy, "s3:GetObject") || !strings.Contains(policy, tt.wantPolicy) {
+				t.Errorf("bucket policy = %q, want no public reads, keeping %q", policy, tt.wantPolicy)
+			}
+
+			// A file in a private room can't be fetched from storage by
+			// name, bypassing the membership check
+			fake.putObject(bucketName, "secret/"+versionedObjectName("alice", "plans.pdf"), []byte("plans"), nil)
+			if code := fake.anonymousGet(t, bucketName, "secret/"+versionedObjectName("alice", "plans.pdf")); code != http.StatusForbidden {
+				t.Errorf("anonymous storage read: status = %d, want 403", code)
+			}
+		})
+	}
+}
+
+func TestRoomFileDownloadNonMember(t *testing.T) {
+	useMemoryStore(t)
+	fake := useFakeS3(t, bucketName)
+	objectName := "secret/" + versionedObjectName("alice", "plans.pdf")
+	fake.putObject(bucketName, objectName, []byte("plans"), map[string]string{"Uploader": "alice"})
+	messageStore.JoinRoom(context.Background(), "secret", "alice")
+	savedDirect := directDownloads
+	privateRooms, directDownloads = true, true
+	t.Cleanup(func() { privateRooms, directDownloads = false, savedDirect })
+
+	target := "/download/" + url.PathEscape(objectName)
+	if rec := serveTest(http.MethodGet, "/download/*filename", target+"?username=mallory", nil, handleFileDownload); rec.Code != http.StatusForbidden {
+		t.Errorf("non-member download: status = %d, want 403", rec.Code)
+	}
+	if code := fake.anonymousGet(t, bucketName, objectName); code != http.StatusForbidden {
+		t.Errorf("anonymous storage read: status = %d, want 403", code)
+	}
+	rec := serveTest(http.MethodGet, "/download/*filename", target+"?username=alice", nil, handleFileDownload)
+	if rec.Code != http.StatusOK || rec.Body.String() != "plans" {
+		t.Errorf("member download: status = %d, body %q, want 200 with the file", rec.Code, rec.Body)
+	}
+}
+
+// countingListener counts the bytes written to the connections it accepts
+type countingListener struct {
+	net.Listener
+	written *atomic.Int64
+}
+
+func (l countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return countingConn{Conn: conn, written: l.written}, nil
+}
+
+type countingConn struct {
+	net.Conn
+	written *atomic.Int64
+}
+
+func (c countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.written.Add(int64(n))
+	return n, err
+}
+
+// Open a WebSocket connection through a test server using the given
+// upgrader and dialer. Returns the server's end, the client's end and a
+// count of the bytes the server has sent since the handshake.
+func wsPair(tb testing.TB, up websocket.Upgrader, dialer websocket.Dialer) (*websocket.Conn, *websocket.Conn, *atomic.Int64) {
+	tb.Helper()
+	accepted := make(chan *websocket.Conn, 1)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := up.Upgrade(w, r, nil)
+		if err != nil {
+			tb.Errorf("upgrading: %v", err)
+			return
+		}
+		accepted <- ws
+	}))
+	sent := &atomic.Int64{}
+	server.Listener = countingListener{Listener: server.Listener, written: sent}
+	server.Start()
+	tb.Cleanup(server.Close)
+
+	client, _, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		tb.Fatalf("dialing: %v", err)
+	}
+	serverConn := <-accepted
+	tb.Cleanup(func() {
+		client.Close()
+		serverConn.Close()
+	})
+	sent.Store(0) // don't count the handshake
+	return serverConn, client, sent
+}
+
+// Typical chat messages, for benchmarks
+func benchmarkMessages(n int) []Message {
+	messages := make([]Message, n)
+	for i := range messages {
+		messages[i] = Message{
+			SchemaVersion: CurrentSchemaVersion,
+			ID:            uuid.New().String(),
+			Room:          "general",
+			Seq:           uint64(i + 1),
+			Username:      fmt.Sprintf("user%d", i%20),
+			Content:       fmt.Sprintf("Has anyone looked at ticket #%d yet? The deploy on staging failed again after the last merge.", 1000+i),
+			Timestamp:     time.Date(2026, 1, 1, 12, 0, i, 0, time.UTC),
+		}
+	}
+	return messages
+}
+
+// Measures one recipient's share of a broadcast: the server writing chat
+// messages to a connection with and without permessage-deflate. Besides
+// time per message it reports the bytes sent per message and the share of
+// one CPU core the writes take at 10,000 messages a second. The client
+// discards the raw bytes without decoding them, so only the server's work
+// is timed.
+func BenchmarkBroadcastCompression(b *testing.B) {
+	messages := benchmarkMessages(100)
+	for _, bc := range []struct {
+		name  string
+		level int // 0 for no compression
+	}{
+		{"off", 0},
+		{"level=1", 1},
+		{"level=6", 6},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			up := websocket.Upgrader{EnableCompression: bc.level > 0}
+			serverConn, clientConn, sent := wsPair(b, up, websocket.Dialer{EnableCompression: bc.level > 0})
+			if bc.level > 0 {
+				serverConn.EnableWriteCompression(true)
+				serverConn.SetCompressionLevel(bc.level)
+			}
+			client := &Client{conn: serverConn}
+
+			go io.Copy(io.Discard, clientConn.NetConn())
+
+			b.ResetTimer()
+			for i := range b.N {
+				if err := client.writeMessage(messages[i%len(messages)]); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+
+			b.ReportMetric(float64(sent.Load())/float64(b.N), "wire-B/msg")
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N)*10000/1e9*100, "%cpu@10k/s")
+		})
+	}
+}
+
+// Measures the heap held per open connection at 1000 connections, with
+// the default buffer sizes and with a 1024-byte write buffer. Each
+// connection has been sent one message. Client connections use small
+// fixed buffers, so differences come from the server's end.
+func BenchmarkConnectionMemory(b *testing.B) {
+	const conns = 1000
+	msg := benchmarkMessages(1)[0]
+	for _, bc := range []struct {
+		name        string
+		read, write int
+	}{
+		{"default", 4096, 4096},
+		{"write=1024", 4096, 1024},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			up := websocket.Upgrader{ReadBufferSize: bc.read, WriteBufferSize: bc.write}
+			accepted := make(chan *websocket.Conn, conns)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ws, err := up.Upgrade(w, r, nil)
+				if err != nil {
+					b.Errorf("upgrading: %v", err)
+					return
+				}
+				accepted <- ws
+			}))
+			defer server.Close()
+			dialer := websocket.Dialer{ReadBufferSize: 256, WriteBufferSize: 256}
+			target := "ws" + strings.TrimPrefix(server.URL, "http")
+
+			var perConn float64
+			for range b.N {
+				var before, after runtime.MemStats
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+
+				var open []*websocket.Conn
+				for range conns {
+					client, _, err := dialer.Dial(target, nil)
+					if err != nil {
+						b.Fatalf("dialing: %v", err)
+					}
+					ws := <-accepted
+					if err := ws.WriteJSON(msg); err != nil {
+						b.Fatal(err)
+					}
+					open = append(open, client, ws)
+				}
+
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				perConn = float64(after.HeapAlloc-before.HeapAlloc) / conns
+				for _, ws := range open {
+					ws.Close()
+				}
+			}
+			b.ReportMetric(perConn, "heap-B/conn")
+		})
+	}
+}
+
+func TestCriticalMessagesOvertakeBacklog(t *testing.T) {
+	useMemoryStore(t)
+	useBroadcastQueue(t)
+	savedHigh := highWaterMark
+	highWaterMark = 0
+	t.Cleanup(func() { highWaterMark = savedHigh })
+	const backlog = 20000
+	client := &Client{username: "alice", room: "general", send: make(chan Message, backlog+10)}
+	useClients(t, client)
+
+	// Flood the normal lane before the broadcaster starts
+	for i := range backlog {
+		publish(Message{Room: "general", Username: "bob", Content: fmt.Sprintf("chat %d", i)})
+	}
+
+	// Broadcast like handleMessages until the stop message
+	const stop = "stop broadcasting"
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			msg := nextMessage()
+			if msg.Content == stop {
+				return
+			}
+			handleMessage(msg)
+		}
+	}()
+	t.Cleanup(func() {
+		publish(Message{Priority: PriorityCritical, Content: stop})
+		<-stopped
+	})
+
+	start := time.Now()
+	publish(Message{Priority: PriorityCritical, Username: "System", Content: "server is restarting"})
+	publish(Message{Room: "general", Priority: PriorityHigh, Username: "System", Content: "room is read-only"})
+	before, urgent := 0, 0
+	for msg := range client.send {
+		if msg.Priority == PriorityNormal {
+			before++
+			continue
+		}
+		if urgent++; urgent == 2 {
+			break
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("critical and high messages delivered after %v, want within 100ms", elapsed)
+	}
+	if before >= backlog/2 {
+		t.Errorf("urgent messages delivered after %d of %d queued chat messages, want them to overtake", before, backlog)
+	}
+}
+
+func TestInitMinIOBucketCreatedConcurrently(t *testing.T) {
+	for _, code := range []string{"BucketAlreadyOwnedByYou", "BucketAlreadyExists"} {
+		t.Run(code, func(t *testing.T) {
+			logs := captureLog(t)
+			fake := useFakeS3(t)
+			fake.makeBucketErr = code
+			fake.setEnv(t)
+
+			// Returns instead of exiting the process
+			initMinIO()
+			if !strings.Contains(logs.String(), "created by another instance") {
+				t.Errorf("log = %q, want the concurrent creation noted", logs.String())
+			}
+			if exists, err := minioClient.BucketExists(context.Background(), bucketName); err != nil || !exists {
+				t.Errorf("bucket exists = %v, %v; want the bucket usable", exists, err)
+			}
+		})
+	}
+}
+
+func TestIsBucketExistsError(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{minio.ErrorResponse{Code: "BucketAlreadyOwnedByYou"}, true},
+		{minio.ErrorResponse{Code: "BucketAlreadyExists"}, true},
+		{minio.ErrorResponse{Code: "AccessDenied"}, false},
+		{fmt.Errorf("dial tcp: connection refused"), false},
+	} {
+		if got := isBucketExistsError(tt.err); got != tt.want {
+			t.Errorf("isBucketExistsError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
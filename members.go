@@ -0,0 +1,181 @@
+// members.go
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Member roles
+const (
+	RoleMember    = "member"
+	RoleModerator = "moderator" // connected with the moderator or admin token
+)
+
+// Member statuses
+const (
+	StatusOnline  = "online"
+	StatusOffline = "offline"
+)
+
+// RoomMember is a user who has joined a room
+type RoomMember struct {
+	Username string    `json:"username"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joinedAt"`
+	Status   string    `json:"status"`
+	// When the member last posted in the room; nil if no message of theirs
+	// is in the room's history
+	LastActive *time.Time `json:"lastActive,omitempty"`
+}
+
+// Build a room's member list, sorted by username
+func buildRoomMembers(ctx context.Context, room string) ([]RoomMember, error) {
+	joined, err := messageStore.Members(ctx, room)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := messageStore.Recent(ctx, room, 0)
+	if err != nil {
+		return nil, err
+	}
+	lastActive := make(map[string]time.Time)
+	for _, msg := range messages {
+		if msg.Timestamp.After(lastActive[msg.Username]) {
+			lastActive[msg.Username] = msg.Timestamp
+		}
+	}
+
+	online := make(map[string]bool)
+	moderators := make(map[string]bool)
+	clientsMu.Lock()
+	for client := range clients {
+		if client.room == room {
+			online[client.username] = true
+			if client.moderator {
+				moderators[client.username] = true
+			}
+		}
+	}
+	clientsMu.Unlock()
+
+	members := make([]RoomMember, 0, len(joined))
+	for username, joinedAt := range joined {
+		member := RoomMember{Username: username, Role: RoleMember, JoinedAt: joinedAt, Status: StatusOffline}
+		if moderators[username] {
+			member.Role = RoleModerator
+		}
+		if online[username] {
+			member.Status = StatusOnline
+		}
+		if t, ok := lastActive[username]; ok {
+			member.LastActive = &t
+		}
+		members = append(members, member)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Username < members[j].Username })
+	return members, nil
+}
+
+// Respond with 403 and return false unless ?username= has joined the room.
+// Admins may look at any room.
+func requireRoomMember(c *gin.Context, room string) bool {
+	if isAdmin(c) {
+		return true
+	}
+	member, err := messageStore.IsMember(c.Request.Context(), room, c.Query("username"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check room membership"})
+		log.Printf("Error checking room membership: %v", err)
+		return false
+	}
+	if !member {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not a member of this room"})
+		return false
+	}
+	return true
+}
+
+// List a room's members, paginated like the roster. ?status=online limits
+// the list to connected members; ?status=all (default) includes everyone
+// who has joined.
+func handleListRoomMembers(c *gin.Context) {
+	room := c.Param("room")
+	status := c.DefaultQuery("status", "all")
+	if status != "all" && status != StatusOnline {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be online or all"})
+		return
+	}
+	limit := defaultRosterLimit
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		limit = min(n, maxRosterLimit)
+	}
+	if !requireRoomMember(c, room) {
+		return
+	}
+
+	members, err := buildRoomMembers(c.Request.Context(), room)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load room members"})
+		log.Printf("Error loading room members: %v", err)
+		return
+	}
+	if status == StatusOnline {
+		onlineMembers := members[:0]
+		for _, member := range members {
+			if member.Status == StatusOnline {
+				onlineMembers = append(onlineMembers, member)
+			}
+		}
+		members = onlineMembers
+	}
+
+	start := 0
+	if cursor := c.Query("cursor"); cursor != "" {
+		start = sort.Search(len(members), func(i int) bool { return members[i].Username > cursor })
+	}
+	end := min(start+limit, len(members))
+
+	nextCursor := ""
+	if end < len(members) {
+		nextCursor = members[end-1].Username
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"members":    members[start:end],
+		"total":      len(members),
+		"nextCursor": nextCursor,
+	})
+}
+
+// Look up a single member of a room
+func handleGetRoomMember(c *gin.Context) {
+	room := c.Param("room")
+	if !requireRoomMember(c, room) {
+		return
+	}
+
+	members, err := buildRoomMembers(c.Request.Context(), room)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load room members"})
+		log.Printf("Error loading room members: %v", err)
+		return
+	}
+	username := c.Param("username")
+	i := sort.Search(len(members), func(i int) bool { return members[i].Username >= username })
+	if i == len(members) || members[i].Username != username {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return
+	}
+	c.JSON(http.StatusOK, members[i])
+}
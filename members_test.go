@@ -0,0 +1,102 @@
+// members_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandleListRoomMembers(t *testing.T) {
+	useMemoryStore(t)
+	ctx := context.Background()
+	for _, username := range []string{"carol", "alice", "bob"} {
+		messageStore.JoinRoom(ctx, "general", username)
+	}
+	messageStore.JoinRoom(ctx, "random", "dave")
+	posted := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	messageStore.Insert(ctx, &Message{ID: "m1", Room: "general", Username: "bob", Content: "hi", Timestamp: posted})
+	useClients(t,
+		&Client{username: "alice", room: "general"},
+		&Client{username: "bob", room: "general", moderator: true},
+		&Client{username: "carol", room: "random"},
+	)
+	adminToken = "admin-secret"
+	t.Cleanup(func() { adminToken = "" })
+
+	tests := []struct {
+		name   string
+		target string
+		admin  bool
+		code   int
+		want   string // username:role:status, comma-separated
+		next   string
+	}{
+		{"everyone", "/rooms/general/members?username=alice", false, http.StatusOK, "alice:member:online,bob:moderator:online,carol:member:offline", ""},
+		{"online only", "/rooms/general/members?username=alice&status=online", false, http.StatusOK, "alice:member:online,bob:moderator:online", ""},
+		{"paged", "/rooms/general/members?username=alice&limit=1&cursor=alice", false, http.StatusOK, "bob:moderator:online", "bob"},
+		{"admin need not be a member", "/rooms/general/members", true, http.StatusOK, "alice:member:online,bob:moderator:online,carol:member:offline", ""},
+		{"not a member", "/rooms/general/members?username=dave", false, http.StatusForbidden, "", ""},
+		{"bad status", "/rooms/general/members?username=alice&status=away", false, http.StatusBadRequest, "", ""},
+		{"bad limit", "/rooms/general/members?username=alice&limit=0", false, http.StatusBadRequest, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.admin {
+				req.Header.Set("Authorization", "Bearer admin-secret")
+			}
+			rec := serveTestRequest("/rooms/:room/members", req, handleListRoomMembers)
+			if rec.Code != tt.code {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.code, rec.Body)
+			}
+			if tt.code != http.StatusOK {
+				return
+			}
+			var resp struct {
+				Members    []RoomMember `json:"members"`
+				NextCursor string       `json:"nextCursor"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, member := range resp.Members {
+				got = append(got, member.Username+":"+member.Role+":"+member.Status)
+				if (member.LastActive != nil) != (member.Username == "bob") {
+					t.Errorf("%s lastActive = %v", member.Username, member.LastActive)
+				}
+			}
+			if strings.Join(got, ",") != tt.want || resp.NextCursor != tt.next {
+				t.Errorf("members = %v (next %q), want %s (next %q)", got, resp.NextCursor, tt.want, tt.next)
+			}
+		})
+	}
+}
+
+func TestHandleGetRoomMember(t *testing.T) {
+	useMemoryStore(t)
+	ctx := context.Background()
+	messageStore.JoinRoom(ctx, "general", "alice")
+	messageStore.JoinRoom(ctx, "general", "bob")
+	useClients(t)
+
+	tests := []struct {
+		target string
+		code   int
+	}{
+		{"/rooms/general/members/bob?username=alice", http.StatusOK},
+		{"/rooms/general/members/carol?username=alice", http.StatusNotFound},
+		{"/rooms/general/members/bob?username=carol", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		rec := serveTest(http.MethodGet, "/rooms/:room/members/:username", tt.target, nil, handleGetRoomMember)
+		if rec.Code != tt.code {
+			t.Errorf("%s: status = %d, want %d: %s", tt.target, rec.Code, tt.code, rec.Body)
+		}
+	}
+}
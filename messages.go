@@ -0,0 +1,335 @@
+// messages.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Send a message over HTTP, optionally with a file, in one request. The
+// message is only broadcast once the file is stored, so clients never see
+// a message pointing at a missing upload. Form fields: username, room,
+// content, reply_to, thread_root_id, expires_in_seconds, file, and caption
+// and alt_text for the file.
+func handlePostMessage(c *gin.Context) {
+	// Parse multipart bodies up front like uploads, so malformed ones get
+	// the same error codes; the form accessors below ignore parse errors
+	if c.ContentType() == "multipart/form-data" {
+		if !parseUploadForm(c) {
+			return
+		}
+		defer c.Request.MultipartForm.RemoveAll()
+	}
+
+	username, ok := checkUsername(c, c.PostForm("username"))
+	if !ok {
+		return
+	}
+	if username == "" {
+		username = anonymousUsername()
+	}
+	room := c.DefaultPostForm("room", defaultRoom)
+	if !validRoomName(room) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid room name"})
+		return
+	}
+	if !canAccessRoom(c.Request.Context(), room, username) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not a member of this room"})
+		return
+	}
+
+	content := c.PostForm("content")
+	file, header, err := c.Request.FormFile("file")
+	if err != nil && err != http.ErrMissingFile && err != http.ErrNotMultipart {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file"})
+		return
+	}
+	if file != nil {
+		defer file.Close()
+		// Files sent here count against the same limit as /upload
+		if !allowUpload(c) {
+			return
+		}
+	}
+	if content == "" && file == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "content or file is required"})
+		return
+	}
+	caption, altText, ok := attachmentText(c)
+	if !ok {
+		return
+	}
+
+	// Check everything that could stop the message before storing the file
+	if _, ok := moderateContent(content); !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Message contains blocked words"})
+		return
+	}
+	replyTo := c.PostForm("reply_to")
+	if replyTo != "" {
+		target, err := messageStore.Get(c.Request.Context(), replyTo)
+		if err != nil || target.Room != room {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "reply_to must be a message in the same room"})
+			return
+		}
+	}
+	threadRootID := c.PostForm("thread_root_id")
+	if threadRootID != "" {
+		if threadRootID = threadRoot(c.Request.Context(), room, threadRootID); threadRootID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "thread_root_id must be a message in the same room"})
+			return
+		}
+	}
+
+	expiresIn := 0
+	if value := c.PostForm("expires_in_seconds"); value != "" {
+		if !disappearingEnabled {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Disappearing messages are disabled", "code": errFeatureDisabled, "feature": FeatureDisappearingMessages})
+			return
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || !validExpiresIn(n) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":      "expires_in_seconds is out of range",
+				"code":       errExpiresInOutOfRange,
+				"minSeconds": minExpiresInSeconds,
+				"maxSeconds": maxExpiresInSeconds,
+			})
+			return
+		}
+		expiresIn = n
+	}
+	if !checkSlowMode(c, room, username) {
+		return
+	}
+
+	msg := Message{
+		SchemaVersion: CurrentSchemaVersion,
+		ID:            uuid.New().String(),
+		Room:          room,
+		Username:      username,
+		Content:       content,
+		ReplyTo:       replyTo,
+		ThreadRootID:  threadRootID,
+		Timestamp:     time.Now(),
+
+		ExpiresInSeconds: expiresIn,
+
+		spanContext: trace.SpanContextFromContext(c.Request.Context()),
+	}
+
+	if file != nil {
+		if !acquireUploadSlot(username) {
+			c.Header("Retry-After", "5")
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many concurrent uploads"})
+			return
+		}
+		defer releaseUploadSlot(username)
+
+		metadata, err := analyzeUpload(c.Request.Context(), file, header)
+		if err != nil {
+			respondAnalyzeError(c, err)
+			return
+		}
+
+		objectName, info, err := putUploadedFile(c.Request.Context(), username, room, "", file, header, nil)
+		if err != nil {
+			if respondScanError(c, err) {
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload file to storage"})
+			log.Printf("Error uploading file: %v", err)
+			return
+		}
+		msg.FileURL = fileDownloadURL(msg.ID, objectName, info.VersionID)
+		msg.objectName = objectName
+		msg.FileName = header.Filename
+		msg.FileSize = header.Size
+		msg.VersionID = info.VersionID
+		msg.Metadata = metadata
+		msg.Caption = caption
+		msg.AltText = altText
+		if msg.Content == "" {
+			msg.Content = fmt.Sprintf("shared a file: %s", header.Filename)
+		}
+	}
+
+	applyExpiry(&msg)
+	publish(msg)
+	recordSlowModeSend(room, username, time.Now())
+	c.JSON(http.StatusCreated, msg)
+}
+
+// Page size limits for message history
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 500
+)
+
+// Parse ?limit= for history listings; responds with 400 and returns false
+// when it is invalid
+func historyLimit(c *gin.Context) (int, bool) {
+	limit := defaultHistoryLimit
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return 0, false
+		}
+		limit = min(n, maxHistoryLimit)
+	}
+	return limit, true
+}
+
+// Return the most recent messages in a room (?room=, default room),
+// oldest first; ?limit= sets how many
+func handleListMessages(c *gin.Context) {
+	room := c.DefaultQuery("room", defaultRoom)
+	if !canAccessRoom(c.Request.Context(), room, c.Query("username")) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not a member of this room"})
+		return
+	}
+
+	limit, ok := historyLimit(c)
+	if !ok {
+		return
+	}
+
+	messages, err := messageStore.Recent(c.Request.Context(), room, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load messages"})
+		log.Printf("Error loading messages: %v", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"room": room, "messages": withDownloadCounts(messages)})
+}
+
+// Most rooms one cross-post may list
+const maxCrossPostRooms = 100
+
+// Body of POST /messages/broadcast. Rooms is a list of room names or the
+// string "all".
+type crossPostRequest struct {
+	Username string          `json:"username"`
+	Rooms    json.RawMessage `json:"rooms" binding:"required"`
+	Content  string          `json:"content" binding:"required"`
+}
+
+// Post one message to several rooms. Each room gets its own copy, stored
+// with that room's next sequence number. The sender must be able to access
+// every listed room; sending to "all" rooms requires the admin token.
+func handleCrossPost(c *gin.Context) {
+	var req crossPostRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		if limit, ok := isBodyTooLarge(err); ok {
+			abortBodyTooLarge(c, limit)
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if req.Username == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "username is required"})
+		return
+	}
+	username, ok := checkUsername(c, req.Username)
+	if !ok {
+		return
+	}
+	req.Username = username
+
+	var rooms []string
+	var all string
+	if err := json.Unmarshal(req.Rooms, &all); err == nil {
+		if all != "all" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": `rooms must be a list of rooms or "all"`})
+			return
+		}
+		if !isAdmin(c) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required to post to all rooms"})
+			return
+		}
+		var err error
+		if rooms, err = activeRooms(c.Request.Context()); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list rooms"})
+			log.Printf("Error listing rooms: %v", err)
+			return
+		}
+	} else if err := json.Unmarshal(req.Rooms, &rooms); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": `rooms must be a list of rooms or "all"`})
+		return
+	} else {
+		slices.Sort(rooms)
+		rooms = slices.Compact(rooms)
+		if len(rooms) == 0 || len(rooms) > maxCrossPostRooms {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("rooms must list between 1 and %d rooms", maxCrossPostRooms)})
+			return
+		}
+		for _, room := range rooms {
+			if !validRoomName(room) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid room name", "room": room})
+				return
+			}
+			if !isAdmin(c) && !canAccessRoom(c.Request.Context(), room, req.Username) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Not a member of this room", "room": room})
+				return
+			}
+		}
+	}
+
+	if _, ok := moderateContent(req.Content); !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Message contains blocked words"})
+		return
+	}
+	// Check every room before the post counts against any of them
+	for _, room := range rooms {
+		if !checkSlowMode(c, room, req.Username) {
+			return
+		}
+	}
+
+	now := time.Now()
+	messages := make([]Message, 0, len(rooms))
+	for _, room := range rooms {
+		msg := Message{
+			SchemaVersion: CurrentSchemaVersion,
+			ID:            uuid.New().String(),
+			Room:          room,
+			Username:      req.Username,
+			Content:       req.Content,
+			Timestamp:     now,
+
+			spanContext: trace.SpanContextFromContext(c.Request.Context()),
+		}
+		publish(msg)
+		recordSlowModeSend(room, req.Username, now)
+		messages = append(messages, msg)
+	}
+	c.JSON(http.StatusCreated, gin.H{"messages": messages})
+}
+
+// Return every room with stored messages or connected clients
+func activeRooms(ctx context.Context) ([]string, error) {
+	rooms, err := messageStore.Rooms(ctx)
+	if err != nil {
+		return nil, err
+	}
+	clientsMu.Lock()
+	for client := range clients {
+		rooms = append(rooms, client.room)
+	}
+	clientsMu.Unlock()
+
+	slices.Sort(rooms)
+	return slices.Compact(rooms), nil
+}
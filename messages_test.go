@@ -0,0 +1,149 @@
+// messages_test.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Build a POST /messages request from form fields, with a file when
+// fileName is set
+func newMessageForm(fields map[string]string, fileName, fileData string) *http.Request {
+	return newUploadForm("/messages", fields, fileName, fileData)
+}
+
+func TestHandlePostMessageWithFile(t *testing.T) {
+	useMemoryStore(t)
+	useUploadLimiter(t, 60, 10)
+	queue := useBroadcastQueue(t)
+	fake := useFakeS3(t, bucketName)
+
+	// Nothing may be broadcast before the file is in storage
+	var stored bool
+	fake.onPut = func(key string) bool {
+		if msg, ok := queue.Pop(); ok {
+			t.Errorf("message %s broadcast before its file was stored", msg.ID)
+		}
+		stored = true
+		return true
+	}
+
+	req := newMessageForm(map[string]string{"username": "alice", "room": "general", "content": "the notes", "caption": "from today"}, "notes.txt", "some notes")
+	rec := serveTestRequest("/messages", req, handlePostMessage)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want 201: %s", rec.Code, rec.Body)
+	}
+	if !stored {
+		t.Fatal("file was not stored")
+	}
+
+	msg, ok := queue.Pop()
+	if !ok {
+		t.Fatal("message was not broadcast")
+	}
+	var resp Message
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if msg.ID != resp.ID || msg.Content != "the notes" || msg.FileName != "notes.txt" || msg.Caption != "from today" {
+		t.Errorf("broadcast %+v, want the created message", msg)
+	}
+	obj := fake.object(bucketName, msg.objectName)
+	if !strings.HasPrefix(msg.objectName, "general/") || obj == nil || string(obj.data) != "some notes" {
+		t.Errorf("object %q = %v, want the file in the room", msg.objectName, obj)
+	}
+	if _, more := queue.Pop(); more {
+		t.Error("message broadcast more than once")
+	}
+}
+
+func TestHandlePostMessageFailures(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     func() *http.Request
+		failPut bool
+		code    int
+		errCode string
+	}{
+		{
+			name: "storage fails",
+			req: func() *http.Request {
+				return newMessageForm(map[string]string{"username": "alice", "content": "hi"}, "a.txt", "a")
+			},
+			failPut: true,
+			code:    http.StatusInternalServerError,
+		},
+		{
+			name: "blocked words",
+			req: func() *http.Request {
+				return newMessageForm(map[string]string{"username": "alice", "content": "oh darn"}, "a.txt", "a")
+			},
+			code: http.StatusBadRequest,
+		},
+		{
+			name: "reply to another room",
+			req: func() *http.Request {
+				return newMessageForm(map[string]string{"username": "alice", "content": "hi", "reply_to": "elsewhere"}, "a.txt", "a")
+			},
+			code: http.StatusBadRequest,
+		},
+		{
+			name: "neither content nor file",
+			req:  func() *http.Request { return newMessageForm(map[string]string{"username": "alice"}, "", "") },
+			code: http.StatusBadRequest,
+		},
+		{
+			name: "missing boundary",
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader("--x\r\n"))
+				req.Header.Set("Content-Type", "multipart/form-data")
+				return req
+			},
+			code:    http.StatusBadRequest,
+			errCode: "ERR_MALFORMED_MULTIPART",
+		},
+		{
+			name: "body cut off",
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader("--x\r\nContent-Disposition: form-data; name=\"file\"; filename=\"a.txt\"\r\n\r\npartial"))
+				req.Header.Set("Content-Type", "multipart/form-data; boundary=x")
+				return req
+			},
+			code:    http.StatusBadRequest,
+			errCode: "ERR_MALFORMED_MULTIPART",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useMemoryStore(t)
+			useUploadLimiter(t, 60, 10)
+			queue := useBroadcastQueue(t)
+			fake := useFakeS3(t, bucketName)
+			fake.onPut = func(string) bool { return !tt.failPut }
+			liveConfigMu.Lock()
+			savedFilter := wordFilter
+			wordFilter = NewWordFilter([]string{"darn"}, true, true, "***")
+			liveConfigMu.Unlock()
+			t.Cleanup(func() {
+				liveConfigMu.Lock()
+				wordFilter = savedFilter
+				liveConfigMu.Unlock()
+			})
+
+			rec := serveTestRequest("/messages", tt.req(), handlePostMessage)
+			if rec.Code != tt.code {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.code, rec.Body)
+			}
+			if tt.errCode != "" && !strings.Contains(rec.Body.String(), tt.errCode) {
+				t.Errorf("body = %s, want code %s", rec.Body, tt.errCode)
+			}
+			if rooms := publishedRooms(queue); rooms != "" {
+				t.Errorf("failed request broadcast to %s", rooms)
+			}
+			if keys := fake.keys(bucketName); !tt.failPut && len(keys) != 0 {
+				t.Errorf("failed request stored %v", keys)
+			}
+		})
+	}
+}
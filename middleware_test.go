@@ -0,0 +1,65 @@
+// middleware_test.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMaxBytesMiddlewareRejectsBeforeStorage(t *testing.T) {
+	useMemoryStore(t)
+	useBroadcastQueue(t)
+	fake := useFakeS3(t, bucketName)
+	const limit = 64 << 10
+
+	tests := []struct {
+		name    string
+		size    int
+		chunked bool // send without a Content-Length
+		code    int
+	}{
+		{"within the limit", 1 << 10, false, http.StatusOK},
+		{"declared too large", 2 * limit, false, http.StatusRequestEntityTooLarge},
+		{"chunked too large", 2 * limit, true, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			puts := fake.putCount()
+			gin.SetMode(gin.TestMode)
+			router := gin.New()
+			router.Use(MaxBytesMiddleware(limit))
+			router.POST("/upload", handleFileUpload)
+
+			req := newUploadForm("/upload", map[string]string{"username": "alice", "room": "general"}, "notes.txt", strings.Repeat("x", tt.size))
+			if tt.chunked {
+				req.ContentLength = -1
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.code {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.code, rec.Body)
+			}
+			if tt.code == http.StatusOK {
+				if fake.putCount() != puts+1 {
+					t.Error("file within the limit not stored")
+				}
+				return
+			}
+			var resp struct {
+				MaxBytes int64 `json:"maxBytes"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.MaxBytes != limit {
+				t.Errorf("body = %s, want maxBytes %d", rec.Body, limit)
+			}
+			if fake.putCount() != puts {
+				t.Error("oversized upload reached storage")
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+// openai.go
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Prefix that addresses a message to the OpenAI bot
+const openAIPrefix = "@ai "
+
+// Number of recent room messages sent to the model as context
+const openAIContextMessages = 10
+
+// OpenAIBot answers messages starting with "@ai " using the OpenAI Chat
+// Completions API, streaming the answer into the room as it is generated
+type OpenAIBot struct {
+	name         string
+	apiKey       string
+	baseURL      string
+	model        string
+	systemPrompt string
+	client       *http.Client
+	limiter      *ipRateLimiter // keyed by username
+}
+
+// One message of a chat completion request
+type openAIMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// One server-sent event of a streamed chat completion
+type openAIStreamEvent struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+	} `json:"choices"`
+}
+
+// Create the OpenAI bot from environment variables, or return nil when it
+// is disabled. OPENAI_ENABLED=true turns it on and requires OPENAI_API_KEY;
+// each user may ask it OPENAI_REQUESTS_PER_HOUR (default 10) questions an
+// hour. OPENAI_BASE_URL points it at a compatible API. Answers are streamed
+// and so are cut off after STREAM_MAX_SECONDS. An error means the bot is
+// enabled but can't run.
+func newOpenAIBot() (*OpenAIBot, error) {
+	if !getEnvBool("OPENAI_ENABLED", false) {
+		return nil, nil
+	}
+	apiKey := os.Getenv("OPENAI_API_KEY")
+	if apiKey == "" {
+		return nil, errors.New("OPENAI_ENABLED requires OPENAI_API_KEY, disabling the OpenAI bot")
+	}
+	perHour := getEnvInt("OPENAI_REQUESTS_PER_HOUR", 10)
+	if perHour < 1 {
+		log.Printf("Warning: OPENAI_REQUESTS_PER_HOUR must be positive, using 10")
+		perHour = 10
+	}
+
+	bot := &OpenAIBot{
+		name:         os.Getenv("OPENAI_BOT_NAME"),
+		apiKey:       apiKey,
+		baseURL:      strings.TrimSuffix(os.Getenv("OPENAI_BASE_URL"), "/"),
+		model:        os.Getenv("OPENAI_MODEL"),
+		systemPrompt: os.Getenv("OPENAI_SYSTEM_PROMPT"),
+		client:       &http.Client{},
+		limiter: &ipRateLimiter{
+			rate:    float64(perHour) / 3600,
+			burst:   float64(perHour),
+			buckets: make(map[string]*tokenBucket),
+		},
+	}
+	if bot.name == "" {
+		bot.name = "ai"
+	}
+	if bot.baseURL == "" {
+		bot.baseURL = "https://api.openai.com/v1"
+	}
+	if bot.model == "" {
+		bot.model = "gpt-4"
+	}
+	if bot.systemPrompt == "" {
+		bot.systemPrompt = "You are a helpful assistant taking part in a group chat. Messages from users are prefixed with their username."
+	}
+	log.Printf("OpenAI bot enabled as %s using %s", bot.name, bot.model)
+	return bot, nil
+}
+
+// Name returns the username the bot posts as
+func (b *OpenAIBot) Name() string {
+	return b.name
+}
+
+// Handle answers msg in the background if it is addressed to the bot and
+// its sender is under the rate limit
+func (b *OpenAIBot) Handle(msg Message) {
+	if !strings.HasPrefix(msg.Content, openAIPrefix) {
+		return
+	}
+	question := strings.TrimSpace(strings.TrimPrefix(msg.Content, openAIPrefix))
+	if question == "" {
+		return
+	}
+	if ok, retryAfter := b.limiter.allow(msg.Username, time.Now()); !ok {
+		sendToUser(msg.Username, Message{
+			ID:        uuid.New().String(),
+			Username:  "System",
+			Content:   fmt.Sprintf("You have asked %s too many questions. Try again in %v.", b.name, retryAfter.Round(time.Minute)),
+			Timestamp: time.Now(),
+		})
+		return
+	}
+	go b.answer(msg, question)
+}
+
+// Ask the model about a message and stream its answer into the room
+func (b *OpenAIBot) answer(msg Message, question string) {
+	ctx, cancel := context.WithTimeout(context.Background(), maxStreamDuration)
+	defer cancel()
+
+	history, err := messageStore.Recent(ctx, msg.Room, openAIContextMessages)
+	if err != nil {
+		log.Printf("Error loading context for %s: %v", b.name, err)
+		return
+	}
+	messages := []openAIMessage{{Role: "system", Content: b.systemPrompt}}
+	for _, m := range history {
+		switch {
+		case m.ID == msg.ID:
+			// Asked last, below
+		case m.Username == b.name:
+			messages = append(messages, openAIMessage{Role: "assistant", Content: m.Content})
+		case m.Content != "":
+			messages = append(messages, openAIMessage{Role: "user", Content: m.Username + ": " + m.Content})
+		}
+	}
+	messages = append(messages, openAIMessage{Role: "user", Content: msg.Username + ": " + question})
+
+	streamID := uuid.New().String()
+	started := false
+	err = b.complete(ctx, messages, func(chunk string) {
+		if !started {
+			publish(Message{ID: uuid.New().String(), Type: MessageTypeStreamStart, StreamID: streamID, Room: msg.Room, Username: b.name, Timestamp: time.Now()})
+			started = true
+		}
+		publish(Message{ID: uuid.New().String(), Type: MessageTypeStreamChunk, StreamID: streamID, Room: msg.Room, Username: b.name, Content: chunk, Timestamp: time.Now()})
+	})
+	if started {
+		publish(Message{ID: uuid.New().String(), Type: MessageTypeStreamEnd, StreamID: streamID, Room: msg.Room, Username: b.name, Timestamp: time.Now()})
+	}
+	if err != nil {
+		log.Printf("Error answering %s for %s: %v", msg.Username, b.name, err)
+		if !started {
+			sendToUser(msg.Username, Message{
+				ID:        uuid.New().String(),
+				Username:  "System",
+				Content:   b.name + " could not answer your question. Try again later.",
+				Timestamp: time.Now(),
+			})
+		}
+	}
+}
+
+// Request a streamed chat completion, calling onChunk with each piece of
+// the answer as it arrives
+func (b *OpenAIBot) complete(ctx context.Context, messages []openAIMessage, onChunk func(string)) error {
+	body, err := json.Marshal(map[string]any{
+		"model":    b.model,
+		"messages": messages,
+		"stream":   true,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.baseURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+b.apiKey)
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return fmt.Errorf("OpenAI API returned %s: %s", resp.Status, bytes.TrimSpace(detail))
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		if data == "[DONE]" {
+			return nil
+		}
+		var event openAIStreamEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return fmt.Errorf("invalid stream event: %w", err)
+		}
+		if len(event.Choices) > 0 && event.Choices[0].Delta.Content != "" {
+			onChunk(event.Choices[0].Delta.Content)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return errors.New("stream ended without [DONE]")
+}
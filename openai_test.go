@@ -0,0 +1,211 @@
+// openai_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// A chat completion request as the mock OpenAI server received it
+type openAIRequest struct {
+	Model    string          `json:"model"`
+	Stream   bool            `json:"stream"`
+	Messages []openAIMessage `json:"messages"`
+}
+
+// Start a mock OpenAI API that answers with handler and return a bot
+// configured against it, with the given hourly limit per user
+func useMockOpenAI(t *testing.T, perHour int, handler http.HandlerFunc) *OpenAIBot {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	savedDuration := maxStreamDuration
+	maxStreamDuration = time.Minute
+	t.Cleanup(func() { maxStreamDuration = savedDuration })
+	t.Setenv("OPENAI_ENABLED", "true")
+	t.Setenv("OPENAI_API_KEY", "sk-test")
+	t.Setenv("OPENAI_BASE_URL", server.URL+"/")
+	t.Setenv("OPENAI_MODEL", "test-model")
+	t.Setenv("OPENAI_REQUESTS_PER_HOUR", fmt.Sprint(perHour))
+	bot, err := newOpenAIBot()
+	if err != nil || bot == nil {
+		t.Fatalf("newOpenAIBot = %v, %v", bot, err)
+	}
+	return bot
+}
+
+// Answer a chat completion with chunks as a stream, ending it with [DONE]
+// if done is set
+func streamCompletion(w http.ResponseWriter, chunks []string, done bool) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	for _, chunk := range chunks {
+		event, _ := json.Marshal(map[string]any{"choices": []any{map[string]any{"delta": map[string]string{"content": chunk}}}})
+		fmt.Fprintf(w, "data: %s\n\n", event)
+	}
+	if done {
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}
+}
+
+// Take the messages published so far
+func drainPublished(queue *broadcastQueue) []Message {
+	var published []Message
+	for {
+		msg, ok := queue.Pop()
+		if !ok {
+			return published
+		}
+		published = append(published, msg)
+	}
+}
+
+func TestOpenAIBotAnswers(t *testing.T) {
+	captureLog(t)
+	useMemoryStore(t)
+	queue := useBroadcastQueue(t)
+	requests := make(chan openAIRequest, 1)
+	bot := useMockOpenAI(t, 10, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/chat/completions" || r.Header.Get("Authorization") != "Bearer sk-test" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var req openAIRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		requests <- req
+		streamCompletion(w, []string{"Four", "."}, true)
+	})
+
+	ctx := context.Background()
+	messageStore.Insert(ctx, &Message{ID: "m1", Room: "general", Username: "bob", Content: "we're doing math"})
+	messageStore.Insert(ctx, &Message{ID: "m2", Room: "general", Username: "ai", Content: "Sounds fun!"})
+	question := Message{ID: "m3", Room: "general", Username: "alice", Content: "@ai what is 2+2?"}
+	messageStore.Insert(ctx, &question)
+	bot.answer(question, "what is 2+2?")
+
+	req := <-requests
+	if req.Model != "test-model" || !req.Stream {
+		t.Errorf("requested model %q, stream %v; want a streamed test-model completion", req.Model, req.Stream)
+	}
+	want := []openAIMessage{
+		{Role: "system", Content: bot.systemPrompt},
+		{Role: "user", Content: "bob: we're doing math"},
+		{Role: "assistant", Content: "Sounds fun!"},
+		{Role: "user", Content: "alice: what is 2+2?"},
+	}
+	if fmt.Sprint(req.Messages) != fmt.Sprint(want) {
+		t.Errorf("sent messages %+v, want %+v", req.Messages, want)
+	}
+
+	var types, answer []string
+	for _, msg := range drainPublished(queue) {
+		if msg.Room != "general" || msg.Username != "ai" {
+			t.Errorf("published %+v, want it from ai in general", msg)
+		}
+		types = append(types, msg.Type)
+		answer = append(answer, msg.Content)
+	}
+	wantTypes := []string{MessageTypeStreamStart, MessageTypeStreamChunk, MessageTypeStreamChunk, MessageTypeStreamEnd}
+	if fmt.Sprint(types) != fmt.Sprint(wantTypes) || strings.Join(answer, "") != "Four." {
+		t.Errorf("published %v %q, want the answer streamed as %v", types, answer, wantTypes)
+	}
+}
+
+func TestOpenAIBotErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		chunks  int  // chunks published before the error
+		notice  bool // the asker is told the bot couldn't answer
+	}{
+		{"API error", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, `{"error": "quota exceeded"}`, http.StatusTooManyRequests)
+		}, 0, true},
+		{"invalid event", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "data: {not json\n\n")
+		}, 0, true},
+		{"stream cut off", func(w http.ResponseWriter, r *http.Request) {
+			streamCompletion(w, []string{"Par", "tial"}, false)
+		}, 2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLog(t)
+			useMemoryStore(t)
+			queue := useBroadcastQueue(t)
+			alice := &Client{username: "alice", room: "general", send: make(chan Message, 10)}
+			useClients(t, alice)
+			bot := useMockOpenAI(t, 10, tt.handler)
+
+			bot.answer(Message{ID: "m1", Room: "general", Username: "alice", Content: "@ai hi"}, "hi")
+
+			chunks, ended := 0, false
+			for _, msg := range drainPublished(queue) {
+				switch msg.Type {
+				case MessageTypeStreamChunk:
+					chunks++
+				case MessageTypeStreamEnd:
+					ended = true
+				}
+			}
+			if chunks != tt.chunks || ended != (tt.chunks > 0) {
+				t.Errorf("published %d chunks (ended %v), want %d", chunks, ended, tt.chunks)
+			}
+			notified := len(alice.send) > 0 && strings.Contains((<-alice.send).Content, "could not answer")
+			if notified != tt.notice {
+				t.Errorf("alice notified = %v, want %v", notified, tt.notice)
+			}
+			if !strings.Contains(logs.String(), "Error answering alice for ai") {
+				t.Errorf("log = %q, want the error logged", logs)
+			}
+		})
+	}
+}
+
+func TestOpenAIBotHandle(t *testing.T) {
+	captureLog(t)
+	useMemoryStore(t)
+	queue := useBroadcastQueue(t)
+	alice := &Client{username: "alice", room: "general", send: make(chan Message, 10)}
+	useClients(t, alice)
+	var calls atomic.Int32
+	bot := useMockOpenAI(t, 1, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		streamCompletion(w, []string{"ok"}, true)
+	})
+
+	// Only messages addressed to the bot with a question are answered
+	bot.Handle(Message{ID: "m1", Room: "general", Username: "alice", Content: "hello @ai"})
+	bot.Handle(Message{ID: "m2", Room: "general", Username: "alice", Content: "@ai  "})
+	bot.Handle(Message{ID: "m3", Room: "general", Username: "alice", Content: "@ai hi"})
+	for waitPublished(t, queue).Type != MessageTypeStreamEnd {
+		// Wait for the answer to finish
+	}
+
+	// Over the hourly limit the asker is told to wait
+	bot.Handle(Message{ID: "m4", Room: "general", Username: "alice", Content: "@ai again"})
+	if msg := <-alice.send; msg.Username != "System" || !strings.Contains(msg.Content, "too many questions") {
+		t.Errorf("alice got %+v, want a rate limit notice", msg)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("API called %d times, want 1", n)
+	}
+}
+
+func TestNewOpenAIBotRequiresKey(t *testing.T) {
+	t.Setenv("OPENAI_ENABLED", "true")
+	t.Setenv("OPENAI_API_KEY", "")
+	if bot, err := newOpenAIBot(); bot != nil || err == nil {
+		t.Errorf("newOpenAIBot without a key = %v, %v; want an error", bot, err)
+	}
+	t.Setenv("OPENAI_ENABLED", "false")
+	if bot, err := newOpenAIBot(); bot != nil || err != nil {
+		t.Errorf("disabled newOpenAIBot = %v, %v; want nothing", bot, err)
+	}
+}
@@ -0,0 +1,130 @@
+// origin.go
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// originPattern is one ALLOWED_ORIGINS entry. Scheme and port are empty
+// when the entry leaves them out.
+type originPattern struct {
+	scheme   string
+	host     string // without the "*." of wildcard entries
+	port     string
+	wildcard bool // matches subdomains of host, not host itself
+}
+
+// WebSocket origin checking settings
+var (
+	allowedOrigins []originPattern // nil allows every origin
+	rejectOrigins  bool            // refuse mismatched origins instead of only logging them
+)
+
+// Initialize origin checking from environment variables. ALLOWED_ORIGINS is
+// a comma-separated list like "chat.example.com, *.example.com,
+// http://localhost:8080"; when unset every origin is allowed. Mismatches
+// are logged with ORIGIN_MISMATCH_ACTION=warn (default) and refused with
+// ORIGIN_MISMATCH_ACTION=reject.
+func initOrigins() {
+	allowedOrigins = nil
+	for _, entry := range strings.Split(os.Getenv("ALLOWED_ORIGINS"), ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		pattern, ok := parseOriginPattern(entry)
+		if !ok {
+			log.Fatalf("Invalid ALLOWED_ORIGINS entry %q", entry)
+		}
+		allowedOrigins = append(allowedOrigins, pattern)
+	}
+
+	switch action := os.Getenv("ORIGIN_MISMATCH_ACTION"); action {
+	case "", "warn":
+		rejectOrigins = false
+	case "reject":
+		rejectOrigins = true
+	default:
+		log.Printf("Warning: invalid value for ORIGIN_MISMATCH_ACTION (%q), using warn", action)
+		rejectOrigins = false
+	}
+}
+
+// Parse an ALLOWED_ORIGINS entry: a host or *.host, optionally with a
+// scheme and port
+func parseOriginPattern(entry string) (originPattern, bool) {
+	var pattern originPattern
+	entry = strings.ToLower(entry)
+	if scheme, rest, ok := strings.Cut(entry, "://"); ok {
+		if scheme != "http" && scheme != "https" {
+			return pattern, false
+		}
+		pattern.scheme = scheme
+		entry = rest
+	}
+	entry = strings.TrimSuffix(entry, "/")
+
+	pattern.host = entry
+	if host, port, err := net.SplitHostPort(entry); err == nil {
+		pattern.host, pattern.port = host, port
+	}
+	if host, ok := strings.CutPrefix(pattern.host, "*."); ok {
+		pattern.host, pattern.wildcard = host, true
+	}
+	if pattern.host == "" || strings.ContainsAny(pattern.host, "*/") {
+		return pattern, false
+	}
+	return pattern, true
+}
+
+// Report whether an Origin header value matches one of the patterns.
+// Hosts compare case-insensitively. An entry without a port only matches
+// the default port of the origin's scheme, so "example.com" allows
+// https://example.com and https://example.com:443 but not
+// https://example.com:8443.
+func originAllowed(origin string, patterns []originPattern) bool {
+	u, err := url.Parse(strings.ToLower(origin))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return false
+	}
+	host, port := u.Hostname(), u.Port()
+	defaultPort := "80"
+	if u.Scheme == "https" {
+		defaultPort = "443"
+	}
+	if port == "" {
+		port = defaultPort
+	}
+
+	for _, pattern := range patterns {
+		if pattern.scheme != "" && pattern.scheme != u.Scheme {
+			continue
+		}
+		if pattern.port == "" && port != defaultPort || pattern.port != "" && pattern.port != port {
+			continue
+		}
+		if pattern.wildcard && strings.HasSuffix(host, "."+pattern.host) || !pattern.wildcard && host == pattern.host {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckOrigin for the WebSocket upgrader. Requests without an Origin header
+// come from non-browser clients and are always allowed.
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if allowedOrigins == nil || origin == "" || originAllowed(origin, allowedOrigins) {
+		return true
+	}
+	if rejectOrigins {
+		log.Printf("Rejected WebSocket connection from origin %q", origin)
+		return false
+	}
+	log.Printf("Warning: WebSocket connection from origin %q is not in ALLOWED_ORIGINS", origin)
+	return true
+}
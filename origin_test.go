@@ -0,0 +1,98 @@
+// origin_test.go
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOriginAllowed(t *testing.T) {
+	patterns := func(entries ...string) []originPattern {
+		var out []originPattern
+		for _, entry := range entries {
+			pattern, ok := parseOriginPattern(entry)
+			if !ok {
+				t.Fatalf("parseOriginPattern(%q) failed", entry)
+			}
+			out = append(out, pattern)
+		}
+		return out
+	}
+
+	tests := []struct {
+		name    string
+		allowed []originPattern
+		origin  string
+		want    bool
+	}{
+		{"exact host", patterns("chat.example.com"), "https://chat.example.com", true},
+		{"exact host over http", patterns("chat.example.com"), "http://chat.example.com", true},
+		{"host case-insensitive", patterns("Chat.Example.com"), "https://CHAT.example.COM", true},
+		{"other host", patterns("chat.example.com"), "https://evil.example.com", false},
+		{"suffix is not a match", patterns("example.com"), "https://evilexample.com", false},
+		{"wildcard subdomain", patterns("*.example.com"), "https://chat.example.com", true},
+		{"wildcard nested subdomain", patterns("*.example.com"), "https://a.b.example.com", true},
+		{"wildcard excludes apex", patterns("*.example.com"), "https://example.com", false},
+		{"wildcard other domain", patterns("*.example.com"), "https://example.com.evil.net", false},
+		{"scheme matches", patterns("https://chat.example.com"), "https://chat.example.com", true},
+		{"scheme mismatch", patterns("https://chat.example.com"), "http://chat.example.com", false},
+		{"default port given", patterns("chat.example.com"), "https://chat.example.com:443", true},
+		{"port omitted rejects other ports", patterns("chat.example.com"), "https://chat.example.com:8443", false},
+		{"port matches", patterns("http://localhost:8080"), "http://localhost:8080", true},
+		{"port mismatch", patterns("http://localhost:8080"), "http://localhost:3000", false},
+		{"port required", patterns("http://localhost:8080"), "http://localhost", false},
+		{"any of several", patterns("a.example.com", "*.example.org"), "https://chat.example.org", true},
+		{"not a web origin", patterns("chat.example.com"), "file://chat.example.com", false},
+		{"null origin", patterns("chat.example.com"), "null", false},
+		{"no patterns", nil, "https://chat.example.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := originAllowed(tt.origin, tt.allowed); got != tt.want {
+				t.Errorf("originAllowed(%q) = %v, want %v", tt.origin, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseOriginPatternInvalid(t *testing.T) {
+	for _, entry := range []string{"ftp://example.com", "*", "*.", "chat.*.example.com", "https://", "example.com/path"} {
+		if _, ok := parseOriginPattern(entry); ok {
+			t.Errorf("parseOriginPattern(%q) succeeded, want an error", entry)
+		}
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	savedAllowed, savedReject := allowedOrigins, rejectOrigins
+	t.Cleanup(func() { allowedOrigins, rejectOrigins = savedAllowed, savedReject })
+
+	tests := []struct {
+		name   string
+		env    string // ALLOWED_ORIGINS
+		action string // ORIGIN_MISMATCH_ACTION
+		origin string
+		want   bool
+	}{
+		{"empty ALLOWED_ORIGINS allows all", "", "reject", "https://evil.example.com", true},
+		{"listed origin", "chat.example.com", "reject", "https://chat.example.com", true},
+		{"mismatch rejected", "chat.example.com", "reject", "https://evil.example.com", false},
+		{"mismatch only warned", "chat.example.com", "warn", "https://evil.example.com", true},
+		{"no Origin header", "chat.example.com", "reject", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ALLOWED_ORIGINS", tt.env)
+			t.Setenv("ORIGIN_MISMATCH_ACTION", tt.action)
+			initOrigins()
+
+			req := httptest.NewRequest("GET", "/ws", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if got := checkOrigin(req); got != tt.want {
+				t.Errorf("checkOrigin(%q) = %v, want %v", tt.origin, got, tt.want)
+			}
+		})
+	}
+}
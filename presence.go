@@ -0,0 +1,146 @@
+// presence.go
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Most names spelled out in a join/leave summary before "and N others"
+const presenceSummaryNames = 3
+
+// Join/leave announcements gathered during a room's batching window
+type presenceBatch struct {
+	changes map[string]int // username -> joins minus leaves
+	order   []string       // usernames in first-seen order
+}
+
+// presenceBatcher debounces join/leave announcements per room. The first
+// announcement in a quiet room goes out immediately; the ones that follow
+// within the window are combined into a single summary when it ends, so a
+// reconnect storm produces one message instead of hundreds.
+type presenceBatcher struct {
+	mu      sync.Mutex
+	window  time.Duration
+	batches map[string]*presenceBatch // room -> batch, present while its window is open
+	publish func(Message)
+}
+
+// Join/leave batcher (nil when batching is disabled)
+var presenceAnnouncer *presenceBatcher
+
+// Initialize join/leave batching from environment variables
+func initPresence() {
+	window := time.Duration(getEnvInt("PRESENCE_COALESCE_WINDOW_MS", 2000)) * time.Millisecond
+	if window <= 0 {
+		return
+	}
+	presenceAnnouncer = &presenceBatcher{
+		window:  window,
+		batches: make(map[string]*presenceBatch),
+		publish: publish,
+	}
+}
+
+// Announce that a user joined or left a room
+func announcePresence(room, username string, joined bool) {
+	if presenceAnnouncer == nil {
+		publish(presenceMessage(room, username, joined))
+		return
+	}
+	presenceAnnouncer.Announce(room, username, joined)
+}
+
+// Build the announcement for a single join or leave
+func presenceMessage(room, username string, joined bool) Message {
+	content := fmt.Sprintf("%s has left the chat", username)
+	if joined {
+		content = fmt.Sprintf("%s has joined the chat", username)
+	}
+	return Message{
+		ID:        uuid.New().String(),
+		Room:      room,
+		Username:  "System",
+		Content:   content,
+		Timestamp: time.Now(),
+	}
+}
+
+// Announce a join or leave, immediately if the room's window is not open
+func (pb *presenceBatcher) Announce(room, username string, joined bool) {
+	pb.mu.Lock()
+	batch, open := pb.batches[room]
+	if !open {
+		pb.batches[room] = &presenceBatch{changes: make(map[string]int)}
+		time.AfterFunc(pb.window, func() { pb.flush(room) })
+		pb.mu.Unlock()
+
+		pb.publish(presenceMessage(room, username, joined))
+		return
+	}
+	if _, seen := batch.changes[username]; !seen {
+		batch.order = append(batch.order, username)
+	}
+	if joined {
+		batch.changes[username]++
+	} else {
+		batch.changes[username]--
+	}
+	pb.mu.Unlock()
+}
+
+// Close a room's window and publish a summary of the changes made during it.
+// A user who left and rejoined (or the reverse) within the window cancels out.
+func (pb *presenceBatcher) flush(room string) {
+	pb.mu.Lock()
+	batch := pb.batches[room]
+	delete(pb.batches, room)
+	pb.mu.Unlock()
+
+	var joined, left []string
+	for _, username := range batch.order {
+		switch change := batch.changes[username]; {
+		case change > 0:
+			joined = append(joined, username)
+		case change < 0:
+			left = append(left, username)
+		}
+	}
+	if len(joined)+len(left) == 0 {
+		return
+	}
+	if len(joined)+len(left) == 1 {
+		pb.publish(presenceMessage(room, append(joined, left...)[0], len(joined) == 1))
+		return
+	}
+
+	var parts []string
+	if len(joined) > 0 {
+		parts = append(parts, summarizeNames(joined)+" joined the chat")
+	}
+	if len(left) > 0 {
+		parts = append(parts, summarizeNames(left)+" left the chat")
+	}
+	pb.publish(Message{
+		ID:        uuid.New().String(),
+		Room:      room,
+		Username:  "System",
+		Content:   strings.Join(parts, "; "),
+		Timestamp: time.Now(),
+	})
+}
+
+// Format names as "a, b and c", or "a, b, c and N others" for long lists
+func summarizeNames(names []string) string {
+	if len(names) == 1 {
+		return names[0]
+	}
+	if len(names) > presenceSummaryNames {
+		return fmt.Sprintf("%s and %d others", strings.Join(names[:presenceSummaryNames], ", "), len(names)-presenceSummaryNames)
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
@@ -0,0 +1,106 @@
+// presence_test.go
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Batcher whose announcements are collected instead of broadcast
+func newTestPresenceBatcher(window time.Duration) (*presenceBatcher, func() []Message) {
+	var mu sync.Mutex
+	var published []Message
+	pb := &presenceBatcher{
+		window:  window,
+		batches: make(map[string]*presenceBatch),
+		publish: func(msg Message) {
+			mu.Lock()
+			published = append(published, msg)
+			mu.Unlock()
+		},
+	}
+	return pb, func() []Message {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]Message(nil), published...)
+	}
+}
+
+func TestPresenceReconnectStorm(t *testing.T) {
+	pb, published := newTestPresenceBatcher(200 * time.Millisecond)
+
+	// 200 users drop and reconnect, and 5 new ones join, within the window
+	pb.Announce("general", "first", true)
+	if got := published(); len(got) != 1 || got[0].Content != "first has joined the chat" {
+		t.Fatalf("published %+v before the window closed, want only the first join", got)
+	}
+	for i := range 200 {
+		pb.Announce("general", fmt.Sprintf("user%d", i), false)
+		pb.Announce("general", fmt.Sprintf("user%d", i), true)
+	}
+	for i := range 5 {
+		pb.Announce("general", fmt.Sprintf("new%d", i), true)
+	}
+	if got := published(); len(got) != 1 {
+		t.Fatalf("published %d messages while the window was open, want 1", len(got))
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(published()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	got := published()
+	if len(got) != 2 {
+		t.Fatalf("published %d messages, want the first join and one summary", len(got))
+	}
+	if want := "new0, new1, new2 and 2 others joined the chat"; got[1].Content != want {
+		t.Errorf("summary = %q, want %q", got[1].Content, want)
+	}
+	if got[1].Room != "general" || got[1].Username != "System" {
+		t.Errorf("summary sent to %s by %s, want general by System", got[1].Room, got[1].Username)
+	}
+}
+
+func TestPresenceWindowPerRoom(t *testing.T) {
+	pb, published := newTestPresenceBatcher(time.Hour)
+
+	pb.Announce("general", "alice", true)
+	pb.Announce("random", "alice", true)
+	pb.Announce("general", "bob", true)
+	if got := published(); len(got) != 2 || got[0].Room != "general" || got[1].Room != "random" {
+		t.Errorf("published %+v, want the first announcement in each room", got)
+	}
+}
+
+func TestPresenceFlushSummaries(t *testing.T) {
+	tests := []struct {
+		name    string
+		changes []string // "+name" joins, "-name" leaves
+		want    string   // "" for no summary
+	}{
+		{"canceled out", []string{"-alice", "+alice"}, ""},
+		{"single join", []string{"+alice"}, "alice has joined the chat"},
+		{"single leave", []string{"-alice"}, "alice has left the chat"},
+		{"joins and leaves", []string{"+alice", "+bob", "-carol"}, "alice and bob joined the chat; carol left the chat"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pb, published := newTestPresenceBatcher(time.Hour)
+			pb.Announce("general", "opener", true)
+			for _, change := range tt.changes {
+				pb.Announce("general", change[1:], change[0] == '+')
+			}
+			pb.flush("general")
+
+			got := published()[1:]
+			switch {
+			case tt.want == "" && len(got) != 0:
+				t.Errorf("published %+v, want no summary", got)
+			case tt.want != "" && (len(got) != 1 || got[0].Content != tt.want):
+				t.Errorf("published %+v, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,295 @@
+// preview.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/net/html"
+)
+
+// Event type carrying a link preview for an earlier message
+const MessageTypeLinkPreview = "link_preview"
+
+// LinkPreview holds Open Graph metadata for a URL found in a message
+type LinkPreview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Image       string `json:"image,omitempty"`
+}
+
+// Matches the first http(s) URL in message content
+var urlPattern = regexp.MustCompile(`https?://[^\s<>"']+`)
+
+// Link preview settings
+var (
+	linkPreviewsEnabled bool
+	previewMaxBytes     int64
+	previewAllowlist    []string
+	previewDenylist     []string
+	previewClient       *http.Client
+	previewSlots        chan struct{} // one token per preview being fetched
+)
+
+var linkPreviewsSkippedTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "chat_link_previews_skipped_total",
+	Help: "Number of messages that got no link preview because the maximum number of fetches were running.",
+})
+
+// Address ranges that aren't public but that net.IP has no predicate for
+var nonPublicNets = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),     // "this network"
+	mustParseCIDR("100.64.0.0/10"), // carrier-grade NAT
+}
+
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
+
+// Initialize link previews from environment variables
+func initLinkPreviews() {
+	linkPreviewsEnabled = getEnvBool("LINK_PREVIEWS_ENABLED", false)
+	if !linkPreviewsEnabled {
+		return
+	}
+
+	previewMaxBytes = int64(getEnvInt("LINK_PREVIEW_MAX_BYTES", 512<<10))
+	previewAllowlist = splitHostList(os.Getenv("LINK_PREVIEW_ALLOWLIST"))
+	previewDenylist = splitHostList(os.Getenv("LINK_PREVIEW_DENYLIST"))
+
+	maxConcurrent := getEnvInt("LINK_PREVIEW_MAX_CONCURRENT", 8)
+	if maxConcurrent < 1 {
+		log.Printf("Warning: LINK_PREVIEW_MAX_CONCURRENT must be positive, using 8")
+		maxConcurrent = 8
+	}
+	previewSlots = make(chan struct{}, maxConcurrent)
+
+	// Every dial (including redirects) is checked against the resolved
+	// address, so DNS names pointing at internal hosts are refused too
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || isInternalIP(ip) {
+				return fmt.Errorf("link preview: refusing to connect to internal address %s", host)
+			}
+			return nil
+		},
+	}
+	previewClient = &http.Client{
+		Timeout: time.Duration(getEnvInt("LINK_PREVIEW_TIMEOUT_MS", 3000)) * time.Millisecond,
+		Transport: &http.Transport{
+			Proxy:       nil, // a proxy would bypass the address check above
+			DialContext: dialer.DialContext,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 3 {
+				return errors.New("too many redirects")
+			}
+			if !previewHostAllowed(req.URL) {
+				return errors.New("redirect to disallowed host")
+			}
+			return nil
+		},
+	}
+	log.Println("Link previews enabled")
+}
+
+// Split a comma-separated host list into lowercase entries
+func splitHostList(value string) []string {
+	var hosts []string
+	for _, host := range strings.Split(value, ",") {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// Report whether host equals one of the entries or is a subdomain of one
+func hostInList(host string, list []string) bool {
+	for _, entry := range list {
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check a URL against the scheme, allowlist and denylist
+func previewHostAllowed(u *url.URL) bool {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	if hostInList(host, previewDenylist) {
+		return false
+	}
+	return len(previewAllowlist) == 0 || hostInList(host, previewAllowlist)
+}
+
+// Report whether an address is loopback, private, link-local or otherwise not public
+func isInternalIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast() {
+		return true
+	}
+	for _, network := range nonPublicNets {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Fetch a message's link preview in the background. At most
+// LINK_PREVIEW_MAX_CONCURRENT fetches run at once; messages with a link
+// that arrive while all of them are busy get no preview, so a flood of
+// links can't open unbounded outgoing connections.
+func startLinkPreview(msg Message) {
+	if !urlPattern.MatchString(msg.Content) {
+		return
+	}
+	select {
+	case previewSlots <- struct{}{}:
+	default:
+		linkPreviewsSkippedTotal.Inc()
+		return
+	}
+	go func() {
+		defer func() { <-previewSlots }()
+		publishLinkPreview(msg)
+	}()
+}
+
+// Fetch a preview for the first URL in a message and send it to the
+// message's room as an update event
+func publishLinkPreview(msg Message) {
+	rawURL := urlPattern.FindString(msg.Content)
+	if rawURL == "" {
+		return
+	}
+	preview, err := fetchLinkPreview(rawURL)
+	if err != nil {
+		log.Printf("Error fetching link preview for %s: %v", rawURL, err)
+		return
+	}
+	if preview.Title == "" && preview.Description == "" && preview.Image == "" {
+		return
+	}
+
+	publish(Message{
+		ID:        uuid.New().String(),
+		Type:      MessageTypeLinkPreview,
+		Room:      msg.Room,
+		Username:  msg.Username,
+		MessageID: msg.ID,
+		Preview:   preview,
+		Timestamp: time.Now(),
+	})
+}
+
+// Fetch a page and extract its Open Graph metadata
+func fetchLinkPreview(rawURL string) (*LinkPreview, error) {
+	pageURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if !previewHostAllowed(pageURL) {
+		return nil, errors.New("host not allowed")
+	}
+
+	resp, err := previewClient.Get(pageURL.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		return nil, errors.New("not an HTML page")
+	}
+
+	preview := parseOpenGraph(io.LimitReader(resp.Body, previewMaxBytes))
+	preview.URL = rawURL
+	if preview.Image != "" {
+		// og:image may be relative to the page
+		if imageURL, err := resp.Request.URL.Parse(preview.Image); err == nil {
+			preview.Image = imageURL.String()
+		}
+	}
+	return preview, nil
+}
+
+// Extract og:title, og:description and og:image (falling back to <title>
+// and the description meta tag) from an HTML document
+func parseOpenGraph(r io.Reader) *LinkPreview {
+	preview := &LinkPreview{}
+	var title, description string
+
+	tokenizer := html.NewTokenizer(r)
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			if preview.Title == "" {
+				preview.Title = title
+			}
+			if preview.Description == "" {
+				preview.Description = description
+			}
+			return preview
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			switch token.Data {
+			case "title":
+				if tokenizer.Next() == html.TextToken {
+					title = strings.TrimSpace(tokenizer.Token().Data)
+				}
+			case "meta":
+				var key, content string
+				for _, attr := range token.Attr {
+					switch attr.Key {
+					case "property", "name":
+						key = strings.ToLower(attr.Val)
+					case "content":
+						content = strings.TrimSpace(attr.Val)
+					}
+				}
+				switch key {
+				case "og:title":
+					preview.Title = content
+				case "og:description":
+					preview.Description = content
+				case "og:image":
+					preview.Image = content
+				case "description":
+					description = content
+				}
+			}
+		}
+	}
+}
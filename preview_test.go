@@ -0,0 +1,230 @@
+// preview_test.go
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// Turn link previews on for a test, configured from env like the server
+func useLinkPreviews(t *testing.T, env map[string]string) {
+	t.Helper()
+	savedEnabled, savedClient, savedSlots := linkPreviewsEnabled, previewClient, previewSlots
+	savedMax, savedAllow, savedDeny := previewMaxBytes, previewAllowlist, previewDenylist
+	t.Cleanup(func() {
+		linkPreviewsEnabled, previewClient, previewSlots = savedEnabled, savedClient, savedSlots
+		previewMaxBytes, previewAllowlist, previewDenylist = savedMax, savedAllow, savedDeny
+	})
+	t.Setenv("LINK_PREVIEWS_ENABLED", "true")
+	for _, name := range []string{"LINK_PREVIEW_MAX_BYTES", "LINK_PREVIEW_ALLOWLIST", "LINK_PREVIEW_DENYLIST", "LINK_PREVIEW_MAX_CONCURRENT"} {
+		t.Setenv(name, env[name])
+	}
+	initLinkPreviews()
+}
+
+// Let the preview client reach the loopback mock server, keeping its
+// timeout and redirect checks
+func allowLoopbackPreviews() {
+	previewClient.Transport = &http.Transport{}
+}
+
+// Serve Open Graph pages on a mock server
+func startOpenGraphServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	page := func(body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprint(w, body)
+		}
+	}
+	mux.HandleFunc("/og", page(`<html><head>
+		<meta property="og:title" content=" Launch day ">
+		<meta property="og:description" content="We shipped.">
+		<meta property="og:image" content="/img/cover.png">
+		<title>ignored</title></head></html>`))
+	mux.HandleFunc("/plain", page(`<html><head><title>Plain page</title><meta name="description" content="No Open Graph here"></head></html>`))
+	mux.HandleFunc("/late", page(`<html><head>`+strings.Repeat("<!-- padding -->", 100)+`<meta property="og:title" content="Too far in"></head></html>`))
+	mux.HandleFunc("/json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"title":"not html"}`)
+	})
+	mux.HandleFunc("/redirect-denied", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://denied.example/og", http.StatusFound)
+	})
+	mux.HandleFunc("/redirect-ok", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/og", http.StatusFound)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestIsInternalIP(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"127.0.0.1":         true,
+		"10.1.2.3":          true,
+		"172.16.0.1":        true,
+		"192.168.1.1":       true,
+		"169.254.169.254":   true,
+		"0.0.0.0":           true,
+		"0.1.2.3":           true,
+		"100.64.0.1":        true,
+		"100.127.255.254":   true,
+		"::ffff:100.64.0.1": true,
+		"224.0.0.1":         true,
+		"::1":               true,
+		"fe80::1":           true,
+		"fc00::1":           true,
+		"100.63.255.255":    false,
+		"100.128.0.1":       false,
+		"8.8.8.8":           false,
+		"2001:4860::8888":   false,
+	} {
+		if got := isInternalIP(net.ParseIP(addr)); got != want {
+			t.Errorf("isInternalIP(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestFetchLinkPreview(t *testing.T) {
+	server := startOpenGraphServer(t)
+	useLinkPreviews(t, map[string]string{"LINK_PREVIEW_MAX_BYTES": "1024", "LINK_PREVIEW_DENYLIST": "denied.example"})
+	allowLoopbackPreviews()
+
+	tests := []struct {
+		path    string
+		want    LinkPreview
+		wantErr bool
+	}{
+		{"/og", LinkPreview{Title: "Launch day", Description: "We shipped.", Image: server.URL + "/img/cover.png"}, false},
+		{"/plain", LinkPreview{Title: "Plain page", Description: "No Open Graph here"}, false},
+		{"/redirect-ok", LinkPreview{Title: "Launch day", Description: "We shipped.", Image: server.URL + "/img/cover.png"}, false},
+		{"/late", LinkPreview{}, false}, // tags past the size cap are ignored
+		{"/json", LinkPreview{}, true},
+		{"/missing", LinkPreview{}, true},
+		{"/redirect-denied", LinkPreview{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			preview, err := fetchLinkPreview(server.URL + tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			tt.want.URL = server.URL + tt.path
+			if *preview != tt.want {
+				t.Errorf("preview = %+v, want %+v", *preview, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchLinkPreviewHostLists(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		url  string
+	}{
+		{"denied host", map[string]string{"LINK_PREVIEW_DENYLIST": "example.com"}, "https://example.com/page"},
+		{"denied subdomain", map[string]string{"LINK_PREVIEW_DENYLIST": "example.com"}, "https://www.example.com/page"},
+		{"not on the allowlist", map[string]string{"LINK_PREVIEW_ALLOWLIST": "example.org"}, "https://example.com/page"},
+		{"other scheme", nil, "ftp://example.com/file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useLinkPreviews(t, tt.env)
+			if _, err := fetchLinkPreview(tt.url); err == nil || err.Error() != "host not allowed" {
+				t.Errorf("fetchLinkPreview(%s) error = %v, want host not allowed", tt.url, err)
+			}
+		})
+	}
+}
+
+func TestFetchLinkPreviewRefusesInternalAddresses(t *testing.T) {
+	server := startOpenGraphServer(t)
+	useLinkPreviews(t, nil)
+
+	// The mock server listens on loopback, as an internal service would
+	port := server.URL[strings.LastIndex(server.URL, ":"):]
+	for _, target := range []string{server.URL + "/og", "http://localhost" + port + "/og"} {
+		_, err := fetchLinkPreview(target)
+		if err == nil || !strings.Contains(err.Error(), "internal address") {
+			t.Errorf("fetchLinkPreview(%s) error = %v, want an internal address refusal", target, err)
+		}
+	}
+}
+
+func TestPublishLinkPreview(t *testing.T) {
+	server := startOpenGraphServer(t)
+	useLinkPreviews(t, map[string]string{"LINK_PREVIEW_MAX_BYTES": "1024"})
+	allowLoopbackPreviews()
+	queue := useBroadcastQueue(t)
+
+	publishLinkPreview(Message{ID: "m1", Room: "general", Username: "alice", Content: "look: " + server.URL + "/og and more"})
+	event, ok := queue.Pop()
+	if !ok {
+		t.Fatal("no preview published")
+	}
+	if event.Type != MessageTypeLinkPreview || event.MessageID != "m1" || event.Room != "general" || event.Preview == nil || event.Preview.Title != "Launch day" {
+		t.Errorf("published %+v, want a preview of m1", event)
+	}
+
+	// Pages with nothing to show publish nothing
+	publishLinkPreview(Message{ID: "m2", Room: "general", Content: server.URL + "/late"})
+	if event, ok := queue.Pop(); ok {
+		t.Errorf("published %+v for an empty preview", event)
+	}
+}
+
+func TestStartLinkPreviewBounded(t *testing.T) {
+	server := startOpenGraphServer(t)
+	useLinkPreviews(t, map[string]string{"LINK_PREVIEW_MAX_CONCURRENT": "1"})
+	allowLoopbackPreviews()
+	queue := useBroadcastQueue(t)
+
+	// With the only slot taken, further links get no preview
+	previewSlots <- struct{}{}
+	skipped := testutil.ToFloat64(linkPreviewsSkippedTotal)
+	startLinkPreview(Message{ID: "m1", Room: "general", Content: server.URL + "/og"})
+	if got := testutil.ToFloat64(linkPreviewsSkippedTotal) - skipped; got != 1 {
+		t.Errorf("skipped %v previews, want 1", got)
+	}
+	<-previewSlots
+
+	// Messages without a link don't take a slot
+	startLinkPreview(Message{ID: "m2", Room: "general", Content: "no link"})
+	if len(previewSlots) != 0 {
+		t.Error("message without a link took a slot")
+	}
+
+	startLinkPreview(Message{ID: "m3", Room: "general", Content: server.URL + "/og"})
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		if event, ok := queue.Pop(); ok {
+			if event.MessageID != "m3" {
+				t.Errorf("published a preview of %s, want m3", event.MessageID)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no preview published")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for len(previewSlots) != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(previewSlots) != 0 {
+		t.Error("slot not released after the fetch")
+	}
+}
@@ -0,0 +1,116 @@
+// proxy.go
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go/v7"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// Headers a trusted proxy uses to pass on the client's address, in the
+// order they are checked
+var remoteIPHeaders = []string{"X-Forwarded-For", "X-Real-IP"}
+
+// Proxies from TRUSTED_PROXIES, for checking other forwarded headers
+var trustedProxies []netip.Prefix
+
+// Configure which proxies may set the client IP. The headers in
+// remoteIPHeaders are only honored for requests arriving from
+// TRUSTED_PROXIES, a comma-separated list of IPs and CIDR ranges. When it is
+// unset the headers are ignored and the connection's address is used, so
+// clients connecting directly cannot spoof their IP.
+//
+// c.ClientIP() then resolves the real client IP everywhere: in request
+// logs, rate limits and connection logs.
+func initTrustedProxies(router *gin.Engine) {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	if err := router.SetTrustedProxies(proxies); err != nil {
+		log.Fatalf("Error parsing TRUSTED_PROXIES: %v", err)
+	}
+	trustedProxies = nil
+	for _, proxy := range proxies {
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			// Gin accepted it above, so it is a single IP
+			addr := netip.MustParseAddr(proxy)
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		trustedProxies = append(trustedProxies, prefix)
+	}
+	router.RemoteIPHeaders = remoteIPHeaders
+	if len(proxies) > 0 {
+		log.Printf("Trusting client IP headers from proxies %s", strings.Join(proxies, ", "))
+	}
+}
+
+// Report whether a request came straight from one of TRUSTED_PROXIES
+func fromTrustedProxy(c *gin.Context) bool {
+	addr, err := netip.ParseAddr(c.RemoteIP())
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Environment variables naming the proxy for outgoing requests. Go also
+// reads their lowercase forms.
+var outboundProxyVars = []string{"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY"}
+
+// Build the transport for outgoing MinIO and webhook requests. Requests go
+// through the proxy in HTTPS_PROXY or HTTP_PROXY, depending on their scheme,
+// except for hosts matched by NO_PROXY; requests to localhost never use a
+// proxy. secure enables the TLS settings the MinIO client expects.
+//
+// The settings are read when the transport is built rather than once per
+// process like http.ProxyFromEnvironment does.
+func outboundTransport(secure bool) *http.Transport {
+	transport, err := minio.DefaultTransport(secure)
+	if err != nil {
+		log.Fatalf("Error creating HTTP transport: %v", err)
+	}
+	proxy := httpproxy.FromEnvironment().ProxyFunc()
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		return proxy(req.URL)
+	}
+	return transport
+}
+
+// Log the proxy used for outgoing requests, hiding any credentials in the
+// proxy URLs
+func logOutboundProxy() {
+	var settings []string
+	for _, name := range outboundProxyVars {
+		value := os.Getenv(name)
+		if value == "" {
+			value = os.Getenv(strings.ToLower(name))
+		}
+		if value == "" {
+			continue
+		}
+		if u, err := url.Parse(value); err == nil && u.User != nil {
+			value = u.Redacted()
+		}
+		settings = append(settings, name+"="+value)
+	}
+	if len(settings) > 0 {
+		log.Printf("Outgoing requests use proxy settings %s", strings.Join(settings, " "))
+	}
+}
@@ -0,0 +1,152 @@
+// proxy_test.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+func TestClientIPFromTrustedProxies(t *testing.T) {
+	saved := trustedProxies
+	t.Cleanup(func() { trustedProxies = saved })
+
+	tests := []struct {
+		name    string
+		proxies string // TRUSTED_PROXIES
+		peer    string // address the request arrives from
+		headers map[string]string
+		want    string
+		trusted bool
+	}{
+		{"no proxies ignores headers", "", "203.0.113.7", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "203.0.113.7", false},
+		{"trusted proxy", "10.0.0.0/8", "10.1.2.3", map[string]string{"X-Forwarded-For": "203.0.113.7"}, "203.0.113.7", true},
+		{"trusted single IP", "10.1.2.3", "10.1.2.3", map[string]string{"X-Forwarded-For": "203.0.113.7"}, "203.0.113.7", true},
+		{"X-Real-IP from a trusted proxy", "10.0.0.0/8", "10.1.2.3", map[string]string{"X-Real-IP": "203.0.113.7"}, "203.0.113.7", true},
+		{"spoofed header from an untrusted peer", "10.0.0.0/8", "198.51.100.9", map[string]string{"X-Forwarded-For": "203.0.113.7", "X-Real-IP": "203.0.113.8"}, "198.51.100.9", false},
+		{"chain of trusted proxies", "10.0.0.0/8", "10.1.2.3", map[string]string{"X-Forwarded-For": "203.0.113.7, 10.0.0.5"}, "203.0.113.7", true},
+		{"spoofed entry before the real client", "10.0.0.0/8", "10.1.2.3", map[string]string{"X-Forwarded-For": "6.6.6.6, 203.0.113.7"}, "203.0.113.7", true},
+		{"IPv6 proxy", "fd00::/8", "[fd00::1]", map[string]string{"X-Forwarded-For": "2001:db8::7"}, "2001:db8::7", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TRUSTED_PROXIES", tt.proxies)
+			gin.SetMode(gin.TestMode)
+			router := gin.New()
+			initTrustedProxies(router)
+			router.GET("/ip", func(c *gin.Context) {
+				c.String(http.StatusOK, "%s %v", c.ClientIP(), fromTrustedProxy(c))
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/ip", nil)
+			req.RemoteAddr = tt.peer + ":12345"
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if want := fmt.Sprintf("%s %v", tt.want, tt.trusted); rec.Body.String() != want {
+				t.Errorf("client IP and trusted = %q, want %q", rec.Body.String(), want)
+			}
+		})
+	}
+}
+
+// Start a forward proxy that records the hosts it is asked for and answers
+// with handler, and point the proxy environment variables at it
+func useOutboundProxy(t *testing.T, handler http.Handler) *[]string {
+	t.Helper()
+	var mu sync.Mutex
+	var hosts []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hosts = append(hosts, r.URL.Host)
+		mu.Unlock()
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(proxy.Close)
+	for _, name := range outboundProxyVars {
+		t.Setenv(name, "")
+		t.Setenv(strings.ToLower(name), "")
+	}
+	t.Setenv("HTTP_PROXY", proxy.URL)
+	return &hosts
+}
+
+func TestOutboundRequestsUseProxy(t *testing.T) {
+	fake := useFakeS3(t, bucketName)
+	hosts := useOutboundProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Host == "hooks.example.com" {
+			return // the webhook receiver accepts everything
+		}
+		fake.server.Config.Handler.ServeHTTP(w, r)
+	}))
+
+	// MinIO requests
+	client, err := minio.New("minio.internal:9000", &minio.Options{
+		Creds:     credentials.NewStaticV4("minioadmin", "minioadmin", ""),
+		Transport: outboundTransport(false),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.PutObject(context.Background(), bucketName, "general/a.txt", strings.NewReader("hi"), 2, minio.PutObjectOptions{}); err != nil {
+		t.Fatalf("put through proxy: %v", err)
+	}
+	if fake.object(bucketName, "general/a.txt") == nil {
+		t.Error("object put through the proxy was not stored")
+	}
+
+	// Webhook deliveries
+	savedTransport := webhookClient.Transport
+	webhookClient.Transport = outboundTransport(true)
+	t.Cleanup(func() { webhookClient.Transport = savedTransport })
+	hook := &Webhook{URL: "http://hooks.example.com/chat", Secret: "s3cret"}
+	useWebhooks(t, hook)
+	if result := deliverWebhook(webhookDelivery{hook: hook, body: []byte("{}"), id: "d1", event: WebhookEventMessage}); result.Err != nil {
+		t.Fatalf("webhook through proxy: %v", result.Err)
+	}
+
+	var minioProxied, webhookProxied bool
+	for _, host := range *hosts {
+		minioProxied = minioProxied || host == "minio.internal:9000"
+		webhookProxied = webhookProxied || host == "hooks.example.com"
+	}
+	if !minioProxied || !webhookProxied {
+		t.Errorf("proxy was asked for %v, want minio.internal:9000 and hooks.example.com", *hosts)
+	}
+}
+
+func TestOutboundProxyExclusions(t *testing.T) {
+	useOutboundProxy(t, http.NotFoundHandler())
+	t.Setenv("NO_PROXY", "minio.internal")
+	transport := outboundTransport(false)
+
+	tests := []struct {
+		url     string
+		proxied bool
+	}{
+		{"http://hooks.example.com/chat", true},
+		{"http://minio.internal:9000/uploads", false},
+		{"http://localhost:9000/uploads", false},
+		{"http://127.0.0.1:9000/uploads", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+		proxy, err := transport.Proxy(req)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.url, err)
+		}
+		if (proxy != nil) != tt.proxied {
+			t.Errorf("%s: proxy = %v, want proxied %v", tt.url, proxy, tt.proxied)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+// ratelimit.go
+package main
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tokenBucket allows bursts of up to burst requests, refilling at rate
+// tokens per second
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// ipRateLimiter keeps a token bucket per client IP
+type ipRateLimiter struct {
+	rate  float64 // tokens per second
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastPrune time.Time
+}
+
+// Rate limit for uploads, nil when disabled; replaced on a config reload,
+// so read it with currentUploadLimiter
+var uploadLimiter *ipRateLimiter
+
+// Initialize upload rate limiting from environment variables. Each client
+// IP may upload UPLOAD_RATE_PER_MINUTE files per minute on average, in
+// bursts of up to UPLOAD_RATE_BURST; a rate of 0 disables the limit.
+func initUploadRateLimit() {
+	uploadLimiter = newUploadRateLimiter()
+}
+
+// Build the upload rate limiter from environment variables; nil when
+// uploads are not limited
+func newUploadRateLimiter() *ipRateLimiter {
+	perMinute := getEnvInt("UPLOAD_RATE_PER_MINUTE", 10)
+	burst := getEnvInt("UPLOAD_RATE_BURST", 5)
+	if perMinute <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		log.Printf("Warning: UPLOAD_RATE_BURST must be positive, using 1")
+		burst = 1
+	}
+	return &ipRateLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// Take a token for key. When none is left, retryAfter is how long until
+// the next one is available.
+func (l *ipRateLimiter) allow(key string, now time.Time) (ok bool, retryAfter time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Buckets that have refilled completely hold no state worth keeping
+	if now.Sub(l.lastPrune) > time.Minute {
+		for k, b := range l.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastPrune = now
+	}
+
+	b, found := l.buckets[key]
+	if !found {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// UploadRateLimitMiddleware rejects uploads from client IPs over the upload
+// rate with 429 and a Retry-After header. Behind a proxy the limit applies
+// to the forwarded client IP; see initTrustedProxies.
+func UploadRateLimitMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !allowUpload(c) {
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// Take an upload token for the client's IP; responds with 429 and returns
+// false when it is over the upload rate. For handlers that only sometimes
+// carry a file, such as POST /messages.
+func allowUpload(c *gin.Context) bool {
+	limiter := currentUploadLimiter()
+	if limiter == nil {
+		return true
+	}
+	ok, retryAfter := limiter.allow(c.ClientIP(), time.Now())
+	if !ok {
+		seconds := int(math.Ceil(retryAfter.Seconds()))
+		c.Header("Retry-After", strconv.Itoa(seconds))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error":             "Too many uploads, try again later",
+			"retryAfterSeconds": seconds,
+		})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,163 @@
+// ratelimit_test.go
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Limit uploads to perMinute per IP in bursts of burst for a test
+func useUploadLimiter(t *testing.T, perMinute, burst int) *ipRateLimiter {
+	t.Helper()
+	limiter := &ipRateLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+	}
+	liveConfigMu.Lock()
+	saved := uploadLimiter
+	uploadLimiter = limiter
+	liveConfigMu.Unlock()
+	t.Cleanup(func() {
+		liveConfigMu.Lock()
+		uploadLimiter = saved
+		liveConfigMu.Unlock()
+	})
+	return limiter
+}
+
+func TestIPRateLimiterAllow(t *testing.T) {
+	limiter := &ipRateLimiter{rate: 1, burst: 2, buckets: make(map[string]*tokenBucket)}
+	now := time.Now()
+
+	steps := []struct {
+		name       string
+		key        string
+		after      time.Duration
+		ok         bool
+		retryAfter time.Duration
+	}{
+		{"burst 1", "a", 0, true, 0},
+		{"burst 2", "a", 0, true, 0},
+		{"over the burst", "a", 0, false, time.Second},
+		{"other IPs have their own bucket", "b", 0, true, 0},
+		{"partly refilled", "a", 500 * time.Millisecond, false, 500 * time.Millisecond},
+		{"refilled", "a", 500 * time.Millisecond, true, 0},
+	}
+	for _, step := range steps {
+		now = now.Add(step.after)
+		ok, retryAfter := limiter.allow(step.key, now)
+		if ok != step.ok || retryAfter != step.retryAfter {
+			t.Errorf("%s: allow = %v, %v, want %v, %v", step.name, ok, retryAfter, step.ok, step.retryAfter)
+		}
+	}
+}
+
+func TestIPRateLimiterPrunesFullBuckets(t *testing.T) {
+	limiter := &ipRateLimiter{rate: 1, burst: 1, buckets: make(map[string]*tokenBucket)}
+	now := time.Now()
+	limiter.allow("a", now)
+	limiter.allow("b", now.Add(2*time.Minute))
+	if _, found := limiter.buckets["a"]; found {
+		t.Error("refilled bucket was kept")
+	}
+}
+
+// Upload through a router set up like the server's, trusting proxies
+// in trusted
+func serveUpload(t *testing.T, trusted, remoteAddr, forwardedFor string) *httptest.ResponseRecorder {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	t.Setenv("TRUSTED_PROXIES", trusted)
+	saved := trustedProxies
+	t.Cleanup(func() { trustedProxies = saved })
+	initTrustedProxies(router)
+	router.POST("/upload", UploadRateLimitMiddleware(), func(c *gin.Context) { c.Status(http.StatusCreated) })
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", nil)
+	req.RemoteAddr = remoteAddr
+	if forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestUploadRateLimitMiddleware(t *testing.T) {
+	useUploadLimiter(t, 1, 1)
+
+	if rec := serveUpload(t, "", "192.0.2.1:1234", ""); rec.Code != http.StatusCreated {
+		t.Fatalf("first upload: status = %d, want 201", rec.Code)
+	}
+	rec := serveUpload(t, "", "192.0.2.1:1234", "")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second upload: status = %d, want 429", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("Retry-After = %q, want 60", got)
+	}
+}
+
+func TestUploadRateLimitBehindProxy(t *testing.T) {
+	tests := []struct {
+		name    string
+		trusted string
+		remote  string
+		// Forwarded client IPs of two uploads
+		first, second string
+		code          int // status of the second upload
+	}{
+		{"trusted proxy, different clients", "10.0.0.0/8", "10.0.0.5:80", "203.0.113.1", "203.0.113.2", http.StatusCreated},
+		{"trusted proxy, same client", "10.0.0.0/8", "10.0.0.5:80", "203.0.113.1", "203.0.113.1", http.StatusTooManyRequests},
+		{"untrusted peer spoofing the header", "10.0.0.0/8", "192.0.2.1:80", "203.0.113.1", "203.0.113.2", http.StatusTooManyRequests},
+		{"no trusted proxies", "", "10.0.0.5:80", "203.0.113.1", "203.0.113.2", http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useUploadLimiter(t, 1, 1)
+			if rec := serveUpload(t, tt.trusted, tt.remote, tt.first); rec.Code != http.StatusCreated {
+				t.Fatalf("first upload: status = %d, want 201", rec.Code)
+			}
+			if rec := serveUpload(t, tt.trusted, tt.remote, tt.second); rec.Code != tt.code {
+				t.Errorf("second upload: status = %d, want %d", rec.Code, tt.code)
+			}
+		})
+	}
+}
+
+func TestHandlePostMessageUploadRateLimit(t *testing.T) {
+	useMemoryStore(t)
+	limiter := useUploadLimiter(t, 1, 1)
+
+	post := func(withFile bool) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		form.WriteField("username", "alice")
+		form.WriteField("content", "hi")
+		if withFile {
+			part, _ := form.CreateFormFile("file", "notes.txt")
+			part.Write([]byte("notes"))
+		}
+		form.Close()
+		req := httptest.NewRequest(http.MethodPost, "/messages", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		return serveTestRequest("/messages", req, handlePostMessage)
+	}
+
+	// Use up the IP's only upload, as /upload would
+	limiter.allow("192.0.2.1", time.Now())
+	if rec := post(true); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("message with a file: status = %d, want 429", rec.Code)
+	}
+	if rec := post(false); rec.Code != http.StatusCreated {
+		t.Errorf("message without a file: status = %d, want 201: %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,48 @@
+// reactions.go
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"unicode/utf8"
+)
+
+// Longest accepted reaction (an emoji sequence or a :shortcode:)
+const maxReactionLength = 64
+
+// Toggle the reaction described by a reaction event and fill in the
+// message's updated counts; returns false if the event should be dropped
+func applyReaction(ctx context.Context, msg *Message) bool {
+	emoji := msg.Content
+	if emoji == "" || utf8.RuneCountInString(emoji) > maxReactionLength {
+		return false
+	}
+
+	// :name: reactions must be a known shortcode or custom emoji
+	if strings.HasPrefix(emoji, ":") && strings.HasSuffix(emoji, ":") && !knownShortcode(emoji) {
+		return false
+	}
+
+	target, err := messageStore.Get(ctx, msg.MessageID)
+	if err != nil || target.Room != msg.Room {
+		return false
+	}
+
+	counts, err := messageStore.ToggleReaction(ctx, msg.MessageID, emoji, msg.Username)
+	if err != nil {
+		log.Printf("Error storing reaction: %v", err)
+		return false
+	}
+	msg.Reactions = counts
+	recordActivity(msg.Username, ActivityReaction, msg.Room, &ActivityDetail{MessageID: msg.MessageID, Emoji: emoji})
+	for reaction := range counts {
+		if refs := customEmojiRefs(reaction); refs != nil {
+			if msg.Emoji == nil {
+				msg.Emoji = make(map[string]string)
+			}
+			msg.Emoji[reaction] = refs[reaction]
+		}
+	}
+	return true
+}
@@ -0,0 +1,112 @@
+// recovery.go
+package main
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Header carrying the request ID, accepted from clients and echoed back
+const requestIDHeader = "X-Request-ID"
+
+// Panics recovered in handlers and WebSocket connections
+var panicsTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "chat_panics_total",
+	Help: "Number of panics recovered by the server.",
+})
+
+// Whether panics are reported to Sentry (SENTRY_DSN)
+var sentryEnabled bool
+
+// Initialize Sentry reporting when SENTRY_DSN is set
+func initSentry() {
+	dsn := os.Getenv("SENTRY_DSN")
+	if dsn == "" {
+		return
+	}
+	if err := sentry.Init(sentry.ClientOptions{Dsn: dsn}); err != nil {
+		log.Printf("Warning: Sentry disabled: %v", err)
+		return
+	}
+	sentryEnabled = true
+	log.Println("Sentry panic reporting enabled")
+}
+
+// Send reports still queued for Sentry, bounded by timeout
+func flushSentry(timeout time.Duration) {
+	if sentryEnabled {
+		sentry.Flush(timeout)
+	}
+}
+
+// RecoveryMiddleware turns a panic in a handler into a 500 response carrying
+// a request ID the user can report, after logging it with its stack trace,
+// counting it and reporting it to Sentry. Every request is given an ID,
+// taken from X-Request-ID when the client sends one.
+func RecoveryMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(requestIDHeader)
+		if requestID == "" {
+			requestID = uuid.New().String()
+		}
+		c.Set("requestId", requestID)
+		c.Header(requestIDHeader, requestID)
+
+		defer func() {
+			if r := recover(); r != nil {
+				// Deliberate connection aborts are not errors
+				if r == http.ErrAbortHandler {
+					panic(r)
+				}
+				reportPanic(r, requestID, "method", c.Request.Method, "path", c.Request.URL.Path, "clientIp", c.ClientIP())
+				if c.Writer.Written() {
+					c.Abort()
+					return
+				}
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+					"error":     "Internal server error",
+					"requestId": requestID,
+				})
+			}
+		}()
+		c.Next()
+	}
+}
+
+// Recover a panic in a WebSocket connection's goroutine; the connection is
+// closed with an internal error instead of taking the server down. Must be
+// called directly by defer.
+func recoverWebSocket(ws *websocket.Conn, requestID, username, clientIP string) {
+	if r := recover(); r != nil {
+		reportPanic(r, requestID, "username", username, "clientIp", clientIP)
+		writeClose(ws, transientClose(websocket.CloseInternalServerErr, CloseCodeInternal, "internal error", transientRetryAfter))
+		ws.Close()
+	}
+}
+
+// Log, count and report a recovered panic
+func reportPanic(r any, requestID string, attrs ...any) {
+	panicsTotal.Inc()
+
+	stack := debug.Stack()
+	attrs = append(attrs, "panic", fmt.Sprint(r), "requestId", requestID, "stack", string(stack))
+	slog.Error("Recovered from panic", attrs...)
+
+	if sentryEnabled {
+		hub := sentry.CurrentHub().Clone()
+		hub.Scope().SetTag("requestId", requestID)
+		hub.Recover(r)
+	}
+}
@@ -0,0 +1,88 @@
+// recovery_test.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// Router with the recovery middleware and a handler that panics
+func newRecoveryRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(RecoveryMiddleware())
+	router.GET("/panic", func(c *gin.Context) { panic("boom") })
+	router.GET("/partial", func(c *gin.Context) {
+		c.String(http.StatusOK, "partial")
+		panic("boom after writing")
+	})
+	router.GET("/ok", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	return router
+}
+
+func TestRecoveryMiddlewareHandlesPanic(t *testing.T) {
+	logs := captureLog(t)
+	router := newRecoveryRouter()
+	before := testutil.ToFloat64(panicsTotal)
+
+	req := httptest.NewRequest(http.MethodGet, "/panic", nil)
+	req.Header.Set(requestIDHeader, "req-123")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", rec.Code)
+	}
+	var body struct {
+		Error     string `json:"error"`
+		RequestID string `json:"requestId"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.RequestID != "req-123" || rec.Header().Get(requestIDHeader) != "req-123" {
+		t.Errorf("request ID %q (header %q), want the client's req-123", body.RequestID, rec.Header().Get(requestIDHeader))
+	}
+	if n := testutil.ToFloat64(panicsTotal) - before; n != 1 {
+		t.Errorf("chat_panics_total increased by %v, want 1", n)
+	}
+	if !strings.Contains(logs.String(), "boom") || !strings.Contains(logs.String(), "req-123") {
+		t.Errorf("log = %q, want the panic and its request ID", logs.String())
+	}
+
+	// The server keeps serving
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ok", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status after a panic = %d, want 200", rec.Code)
+	}
+}
+
+func TestRecoveryMiddlewareAfterPartialWrite(t *testing.T) {
+	captureLog(t)
+	router := newRecoveryRouter()
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/partial", nil))
+
+	// The response already started, so it is left as it is
+	if rec.Code != http.StatusOK || rec.Body.String() != "partial" {
+		t.Errorf("response = %d %q, want the partial response untouched", rec.Code, rec.Body)
+	}
+}
+
+func TestRecoveryMiddlewareAssignsRequestID(t *testing.T) {
+	router := newRecoveryRouter()
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ok", nil))
+	if rec.Header().Get(requestIDHeader) == "" {
+		t.Error("no request ID assigned to a request without one")
+	}
+}
@@ -0,0 +1,160 @@
+// reload.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joho/godotenv"
+)
+
+// Guards the settings a config reload replaces: wordFilter, bots and
+// uploadLimiter. A reload swaps all of them at once under the write lock,
+// so readers never see a mix of old and new settings.
+var (
+	liveConfigMu sync.RWMutex
+	reloadMu     sync.Mutex // one reload at a time
+)
+
+// Return the word filter in effect (nil when filtering is disabled)
+func currentWordFilter() *WordFilter {
+	liveConfigMu.RLock()
+	defer liveConfigMu.RUnlock()
+	return wordFilter
+}
+
+// Return the enabled bots
+func currentBots() []Bot {
+	liveConfigMu.RLock()
+	defer liveConfigMu.RUnlock()
+	return bots
+}
+
+// Return the upload rate limiter in effect (nil when uploads are not limited)
+func currentUploadLimiter() *ipRateLimiter {
+	liveConfigMu.RLock()
+	defer liveConfigMu.RUnlock()
+	return uploadLimiter
+}
+
+// Reload the word filter, bots and upload rate limit without a restart.
+// Variables in the .env file, if there is one, are re-read first and
+// override the process environment, as does editing the filter words
+// file. Everything is rebuilt before anything is replaced: when any of it
+// is invalid the error is returned, the environment is put back and the
+// running configuration is kept. Connections stay open; upload rate
+// counts start over. Names of bots that are removed stay reserved until
+// the server restarts.
+func reloadConfig() error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	restoreEnv, err := overloadEnvFile(".env")
+	if err != nil {
+		return err
+	}
+
+	filter, err := loadWordFilter()
+	if err != nil {
+		restoreEnv()
+		return err
+	}
+	enabledBots, err := loadBots()
+	if err != nil {
+		restoreEnv()
+		return err
+	}
+	limiter := newUploadRateLimiter()
+
+	for _, bot := range enabledBots {
+		reserveUsername(bot.Name())
+	}
+	liveConfigMu.Lock()
+	wordFilter = filter
+	bots = enabledBots
+	uploadLimiter = limiter
+	liveConfigMu.Unlock()
+	log.Printf("Configuration reloaded (word filter: %t, bots: %d, upload rate limit: %t)", filter != nil, len(enabledBots), limiter != nil)
+	return nil
+}
+
+// Set the variables in an env file, overriding the environment, and return
+// a function that puts the previous values back. A missing file changes
+// nothing.
+func overloadEnvFile(path string) (restore func(), err error) {
+	vars, err := godotenv.Read(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return func() {}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	previous := make(map[string]*string, len(vars))
+	for key, value := range vars {
+		if old, ok := os.LookupEnv(key); ok {
+			previous[key] = &old
+		} else {
+			previous[key] = nil
+		}
+		os.Setenv(key, value)
+	}
+	return func() {
+		for key, old := range previous {
+			if old == nil {
+				os.Unsetenv(key)
+			} else {
+				os.Setenv(key, *old)
+			}
+		}
+	}, nil
+}
+
+// Reload the configuration whenever the process receives SIGHUP, until
+// ctx is canceled
+func runReloadOnSIGHUP(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			log.Printf("Received SIGHUP, reloading configuration")
+			if err := reloadConfig(); err != nil {
+				log.Printf("Error reloading configuration, keeping the current one: %v", err)
+			}
+		}
+	}
+}
+
+// Reload the configuration, as SIGHUP does
+func handleReloadConfig(c *gin.Context) {
+	if err := reloadConfig(); err != nil {
+		log.Printf("Error reloading configuration, keeping the current one: %v", err)
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Invalid configuration, keeping the current one", "reason": err.Error()})
+		return
+	}
+	filter, enabledBots, limiter := currentWordFilter(), currentBots(), currentUploadLimiter()
+	names := []string{}
+	for _, bot := range enabledBots {
+		names = append(names, bot.Name())
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"reloaded":        true,
+		"wordFilter":      filter != nil,
+		"bots":            names,
+		"uploadRateLimit": limiter != nil,
+	})
+}
@@ -0,0 +1,131 @@
+// reload_test.go
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Settings a reload reads, all cleared for the test
+var reloadTestVars = []string{
+	"FILTER_ENABLED", "FILTER_WORDS", "FILTER_WORDS_FILE", "FILTER_MODE", "FILTER_REPLACEMENT",
+	"OPENAI_ENABLED", "OPENAI_API_KEY", "OPENAI_BOT_NAME",
+	"UPLOAD_RATE_PER_MINUTE", "UPLOAD_RATE_BURST",
+}
+
+// Run a test in a directory whose .env file holds env, with the running
+// configuration and the settings a reload reads restored afterwards
+func useReloadEnv(t *testing.T, env string) {
+	t.Helper()
+	for _, name := range reloadTestVars {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+	useReservedUsernames(t)
+	liveConfigMu.Lock()
+	savedFilter, savedBots, savedLimiter := wordFilter, bots, uploadLimiter
+	liveConfigMu.Unlock()
+	t.Cleanup(func() {
+		liveConfigMu.Lock()
+		wordFilter, bots, uploadLimiter = savedFilter, savedBots, savedLimiter
+		liveConfigMu.Unlock()
+	})
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ".env"), []byte(env), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestSIGHUPReloadsConfig(t *testing.T) {
+	captureLog(t)
+	useReloadEnv(t, strings.Join([]string{
+		"FILTER_ENABLED=true",
+		"FILTER_WORDS=darn",
+		"OPENAI_ENABLED=true",
+		"OPENAI_API_KEY=sk-test",
+		"OPENAI_BOT_NAME=helper",
+		"UPLOAD_RATE_PER_MINUTE=120",
+		"UPLOAD_RATE_BURST=7",
+	}, "\n"))
+	liveConfigMu.Lock()
+	wordFilter, bots, uploadLimiter = nil, nil, nil
+	liveConfigMu.Unlock()
+
+	// Keep SIGHUP from killing the test binary until the reloader listens
+	held := make(chan os.Signal, 1)
+	signal.Notify(held, syscall.SIGHUP)
+	defer signal.Stop(held)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		runReloadOnSIGHUP(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// Signal until the reloader, which may not be listening yet, reloads
+	for deadline := time.Now().Add(3 * time.Second); currentUploadLimiter() == nil; time.Sleep(20 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("configuration not reloaded after SIGHUP")
+		}
+		syscall.Kill(os.Getpid(), syscall.SIGHUP)
+	}
+
+	if filter := currentWordFilter(); filter == nil {
+		t.Error("word filter not enabled")
+	} else if masked, _ := filter.Apply("well darn"); masked == "well darn" {
+		t.Errorf("filter passed %q, want darn filtered", masked)
+	}
+	if enabled := currentBots(); len(enabled) != 1 || enabled[0].Name() != "helper" {
+		t.Errorf("bots = %v, want helper", enabled)
+	}
+	if !isReservedUsername("helper") {
+		t.Error("bot name not reserved")
+	}
+	if limiter := currentUploadLimiter(); limiter.rate != 2 || limiter.burst != 7 {
+		t.Errorf("upload limit %v/s with burst %v, want 2/s and 7", limiter.rate, limiter.burst)
+	}
+}
+
+func TestReloadKeepsConfigWhenInvalid(t *testing.T) {
+	logs := captureLog(t)
+	useReloadEnv(t, "FILTER_ENABLED=true\nFILTER_WORDS=darn\nOPENAI_ENABLED=true\n")
+	limiter := &ipRateLimiter{rate: 1, burst: 1, buckets: make(map[string]*tokenBucket)}
+	liveConfigMu.Lock()
+	wordFilter, bots, uploadLimiter = nil, nil, limiter
+	liveConfigMu.Unlock()
+
+	// The bot is enabled without an API key
+	if err := reloadConfig(); err == nil || !strings.Contains(err.Error(), "OPENAI_API_KEY") {
+		t.Fatalf("reload error = %v, want the missing API key", err)
+	}
+	if currentWordFilter() != nil || currentBots() != nil || currentUploadLimiter() != limiter {
+		t.Error("running configuration replaced by an invalid one")
+	}
+	for _, name := range []string{"FILTER_ENABLED", "FILTER_WORDS", "OPENAI_ENABLED"} {
+		if value, ok := os.LookupEnv(name); ok {
+			t.Errorf("%s=%q left set from the rejected .env", name, value)
+		}
+	}
+	if strings.Contains(logs.String(), "Configuration reloaded") {
+		t.Errorf("log = %q, want no reload", logs)
+	}
+}
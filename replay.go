@@ -0,0 +1,114 @@
+// replay.go
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// History replay settings. Clients that connect with ?history=N are sent
+// up to N of their room's most recent messages over the socket, marked
+// replay, followed by a replay_end event. Replay starts only once the
+// client sends {"type":"ready"}, so a client that is still setting up
+// isn't flooded. It runs alongside live messages and is paced to the
+// client's send buffer, so it never fills the buffer and gets the client
+// disconnected. A message sent around the time of connecting may arrive
+// both live and replayed; clients drop the duplicate by ID.
+var (
+	replayMax       int           // most messages one connection may ask for; 0 disables replay
+	replayBatchSize int           // messages queued at a time
+	replayInterval  time.Duration // pause between batches
+)
+
+// Initialize history replay from environment variables
+func initReplay() {
+	replayMax = getEnvInt("HISTORY_REPLAY_MAX", maxHistoryLimit)
+	if replayMax < 0 {
+		log.Printf("Warning: HISTORY_REPLAY_MAX must not be negative, using %d", maxHistoryLimit)
+		replayMax = maxHistoryLimit
+	}
+	replayBatchSize = getEnvInt("HISTORY_REPLAY_BATCH", 20)
+	if replayBatchSize < 1 {
+		log.Printf("Warning: HISTORY_REPLAY_BATCH must be positive, using 20")
+		replayBatchSize = 20
+	}
+	replayInterval = time.Duration(getEnvInt("HISTORY_REPLAY_INTERVAL_MS", 50)) * time.Millisecond
+	if replayInterval <= 0 {
+		log.Printf("Warning: HISTORY_REPLAY_INTERVAL_MS must be positive, using 50")
+		replayInterval = 50 * time.Millisecond
+	}
+}
+
+// Most messages a replay lets wait in a client's send buffer: half the
+// buffer, leaving the rest to live messages, and below the backpressure
+// high-water mark
+func replayBufferLimit() int {
+	limit := sendBufferSize / 2
+	if highWaterMark > 0 {
+		limit = min(limit, highWaterMark-1)
+	}
+	return max(limit, 1)
+}
+
+// Start replaying history to a client that sent ready; only the first
+// ready of a connection that asked for history does anything
+func startReplay(ctx context.Context, client *Client) {
+	if client.replayLimit == 0 || !client.replayStarted.CompareAndSwap(false, true) {
+		return
+	}
+	go replayHistory(ctx, client)
+}
+
+// Send a client its room's recent messages, a batch at a time, waiting
+// whenever its send buffer is too full to take the next batch
+func replayHistory(ctx context.Context, client *Client) {
+	end := Message{Type: MessageTypeReplayEnd, Room: client.room, Username: "System", Timestamp: time.Now()}
+	if !canAccessRoom(ctx, client.room, client.username) {
+		end.Reason = "forbidden"
+		queueMessage(client, end)
+		return
+	}
+	messages, err := messageStore.Recent(ctx, client.room, client.replayLimit)
+	if err != nil {
+		log.Printf("Error loading history to replay for %s: %v", client.username, err)
+		end.Reason = "unavailable"
+		queueMessage(client, end)
+		return
+	}
+
+	bufferLimit := replayBufferLimit()
+	batchSize := min(replayBatchSize, bufferLimit)
+	for len(messages) > 0 {
+		batch := messages[:min(batchSize, len(messages))]
+		for len(client.send)+len(batch) > bufferLimit {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(replayInterval):
+			}
+		}
+
+		clientsMu.Lock()
+		if !clients[client] {
+			clientsMu.Unlock()
+			return
+		}
+		for _, msg := range batch {
+			msg.Replay = true
+			queueMessageLocked(client, msg)
+		}
+		clientsMu.Unlock()
+		end.Seq = batch[len(batch)-1].Seq
+		messages = messages[len(batch):]
+
+		if len(messages) > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(replayInterval):
+			}
+		}
+	}
+	queueMessage(client, end)
+}
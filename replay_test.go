@@ -0,0 +1,118 @@
+// replay_test.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Configure history replay and client send buffers for a test
+func useReplay(t *testing.T, batchSize int, interval time.Duration, bufferSize, highWater int) {
+	t.Helper()
+	savedMax, savedBatch, savedInterval := replayMax, replayBatchSize, replayInterval
+	savedBuffer, savedHighWater := sendBufferSize, highWaterMark
+	replayMax, replayBatchSize, replayInterval = 100, batchSize, interval
+	sendBufferSize, highWaterMark = bufferSize, highWater
+	t.Cleanup(func() {
+		replayMax, replayBatchSize, replayInterval = savedMax, savedBatch, savedInterval
+		sendBufferSize, highWaterMark = savedBuffer, savedHighWater
+	})
+}
+
+func TestReplayBufferLimit(t *testing.T) {
+	tests := []struct {
+		bufferSize, highWater int
+		want                  int
+	}{
+		{256, 0, 128},
+		{256, 50, 49},
+		{256, 200, 128},
+		{1, 0, 1},
+		{10, 1, 1},
+	}
+	for _, tt := range tests {
+		useReplay(t, 20, time.Millisecond, tt.bufferSize, tt.highWater)
+		if got := replayBufferLimit(); got != tt.want {
+			t.Errorf("buffer %d, high-water mark %d: limit = %d, want %d", tt.bufferSize, tt.highWater, got, tt.want)
+		}
+	}
+}
+
+func TestReplayPacedToClient(t *testing.T) {
+	useMemoryStore(t)
+	useReplay(t, 3, 5*time.Millisecond, 10, 0)
+	limit := replayBufferLimit()
+	ctx := context.Background()
+	for i := 1; i <= 20; i++ {
+		messageStore.Insert(ctx, &Message{ID: fmt.Sprintf("m%d", i), Room: "general", Username: "bob", Content: "hi"})
+	}
+	client := &Client{username: "alice", room: "general", send: make(chan Message, sendBufferSize), replayLimit: 20}
+	useClients(t, client)
+
+	startReplay(ctx, client)
+	startReplay(ctx, client) // a second ready is ignored
+
+	// A client that doesn't read is never sent more than the limit, and so
+	// isn't disconnected for a full buffer
+	time.Sleep(100 * time.Millisecond)
+	if n := len(client.send); n == 0 || n > limit {
+		t.Fatalf("%d messages waiting for a stalled client, want 1 to %d", n, limit)
+	}
+
+	// A slow reader gets every message, in order, then the end marker
+	var replayed []Message
+	for {
+		// The end marker isn't held back, so it may come on top of a batch
+		if n := len(client.send); n > limit+1 {
+			t.Fatalf("%d messages waiting, over the limit of %d", n, limit)
+		}
+		var msg Message
+		select {
+		case msg = <-client.send:
+		case <-time.After(3 * time.Second):
+			t.Fatalf("replay stalled after %d messages", len(replayed))
+		}
+		if msg.Type == MessageTypeReplayEnd {
+			if len(replayed) > 0 && msg.Seq != replayed[len(replayed)-1].Seq {
+				t.Errorf("replay_end at seq %d, want the last replayed %d", msg.Seq, replayed[len(replayed)-1].Seq)
+			}
+			break
+		}
+		replayed = append(replayed, msg)
+		time.Sleep(2 * time.Millisecond)
+	}
+	if len(replayed) != 20 {
+		t.Fatalf("replayed %d messages, want 20", len(replayed))
+	}
+	for i, msg := range replayed {
+		if want := fmt.Sprintf("m%d", i+1); msg.ID != want || !msg.Replay {
+			t.Errorf("message %d = %s (replay %v), want %s marked replay", i, msg.ID, msg.Replay, want)
+		}
+	}
+	if !isConnectedUser("alice") {
+		t.Error("client disconnected during replay")
+	}
+	if len(client.send) != 0 {
+		t.Errorf("%d more messages after replay_end, want the second ready ignored", len(client.send))
+	}
+}
+
+func TestReplayForbidden(t *testing.T) {
+	useMemoryStore(t)
+	useReplay(t, 3, time.Millisecond, 10, 0)
+	privateRooms = true
+	t.Cleanup(func() { privateRooms = false })
+	messageStore.Insert(context.Background(), &Message{ID: "m1", Room: "secret", Username: "bob", Content: "hi"})
+	client := &Client{username: "alice", room: "secret", send: make(chan Message, sendBufferSize), replayLimit: 20}
+	useClients(t, client)
+
+	replayHistory(context.Background(), client)
+	if msg := <-client.send; msg.Type != MessageTypeReplayEnd || msg.Reason != "forbidden" {
+		t.Errorf("got %+v, want replay_end refusing the room", msg)
+	}
+	if len(client.send) != 0 {
+		t.Errorf("%d messages replayed to a non-member", len(client.send))
+	}
+}
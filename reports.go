@@ -0,0 +1,171 @@
+// reports.go
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Longest report reason, in characters
+const maxReportReasonLength = 500
+
+// Message type sent to connected moderators when a message is reported;
+// MessageID is the reported message and Reason the reporter's reason
+const MessageTypeReport = "report"
+
+// Report is one user's flag on a message
+type Report struct {
+	Username   string    `json:"username"`
+	Reason     string    `json:"reason"`
+	ReportedAt time.Time `json:"reportedAt"`
+}
+
+// ReportedMessage is a message in the moderation queue with its reports,
+// oldest first
+type ReportedMessage struct {
+	MessageID      string    `json:"messageId"`
+	Room           string    `json:"room"`
+	Message        *Message  `json:"message,omitempty"` // nil once deleted
+	Reports        []Report  `json:"reports"`
+	LastReportedAt time.Time `json:"lastReportedAt"`
+}
+
+// Moderation queue: reports by message ID
+var (
+	reports   = make(map[string]*ReportedMessage)
+	reportsMu sync.Mutex
+)
+
+// Body of POST /messages/:id/report
+type reportRequest struct {
+	Username string `json:"username" binding:"required"`
+	Reason   string `json:"reason" binding:"required"`
+}
+
+// Flag a message for moderators. The reporter must be able to access the
+// message's room. A user reporting the same message again gets their
+// earlier report back (200) instead of a new one (201).
+func handleReportMessage(c *gin.Context) {
+	var req reportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		if limit, ok := isBodyTooLarge(err); ok {
+			abortBodyTooLarge(c, limit)
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "username and reason are required"})
+		return
+	}
+	username, ok := checkUsername(c, req.Username)
+	if !ok {
+		return
+	}
+	reason := strings.TrimSpace(req.Reason)
+	if username == "" || reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "username and reason are required"})
+		return
+	}
+	if utf8.RuneCountInString(reason) > maxReportReasonLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason is too long", "maxLength": maxReportReasonLength})
+		return
+	}
+
+	ctx := c.Request.Context()
+	msg, err := messageStore.Get(ctx, c.Param("id"))
+	if errors.Is(err, ErrMessageNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load message"})
+		log.Printf("Error loading message: %v", err)
+		return
+	}
+	if !canAccessRoom(ctx, msg.Room, username) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not a member of this room"})
+		return
+	}
+
+	reportsMu.Lock()
+	entry, ok := reports[msg.ID]
+	if !ok {
+		entry = &ReportedMessage{MessageID: msg.ID, Room: msg.Room}
+		reports[msg.ID] = entry
+	}
+	for _, existing := range entry.Reports {
+		if existing.Username == username {
+			reportsMu.Unlock()
+			c.JSON(http.StatusOK, gin.H{"messageId": msg.ID, "report": existing, "duplicate": true})
+			return
+		}
+	}
+	report := Report{Username: username, Reason: reason, ReportedAt: time.Now()}
+	entry.Reports = append(entry.Reports, report)
+	entry.LastReportedAt = report.ReportedAt
+	reportsMu.Unlock()
+
+	log.Printf("Message %s in %s reported by %s", msg.ID, msg.Room, username)
+	notifyModerators(Message{
+		ID:        uuid.New().String(),
+		Type:      MessageTypeReport,
+		Room:      msg.Room,
+		Username:  "System",
+		MessageID: msg.ID,
+		Reason:    reason,
+		Timestamp: report.ReportedAt,
+	})
+	c.JSON(http.StatusCreated, gin.H{"messageId": msg.ID, "report": report})
+}
+
+// Queue a message for every connection opened with the moderator or admin token
+func notifyModerators(msg Message) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	for client := range clients {
+		if client.moderator {
+			queueMessageLocked(client, msg)
+		}
+	}
+}
+
+// List reported messages, most recently reported first, with the current
+// state of each message; ?room= limits the queue to one room and ?limit=
+// sets how many are listed
+func handleListReports(c *gin.Context) {
+	limit, ok := historyLimit(c)
+	if !ok {
+		return
+	}
+	room := c.Query("room")
+
+	reportsMu.Lock()
+	queue := []ReportedMessage{}
+	for _, entry := range reports {
+		if room == "" || entry.Room == room {
+			copied := *entry
+			copied.Reports = append([]Report(nil), entry.Reports...)
+			queue = append(queue, copied)
+		}
+	}
+	reportsMu.Unlock()
+
+	sort.Slice(queue, func(i, j int) bool { return queue[i].LastReportedAt.After(queue[j].LastReportedAt) })
+	total := len(queue)
+	if len(queue) > limit {
+		queue = queue[:limit]
+	}
+	ctx := c.Request.Context()
+	for i := range queue {
+		if msg, err := messageStore.Get(ctx, queue[i].MessageID); err == nil {
+			queue[i].Message = &msg
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"reports": queue, "total": total})
+}
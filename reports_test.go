@@ -0,0 +1,137 @@
+// reports_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Start a test with an empty moderation queue
+func useEmptyReports(t *testing.T) {
+	t.Helper()
+	reportsMu.Lock()
+	saved := reports
+	reports = make(map[string]*ReportedMessage)
+	reportsMu.Unlock()
+	t.Cleanup(func() {
+		reportsMu.Lock()
+		reports = saved
+		reportsMu.Unlock()
+	})
+}
+
+func TestHandleReportMessage(t *testing.T) {
+	useMemoryStore(t)
+	useEmptyReports(t)
+	ctx := context.Background()
+	for _, msg := range []Message{
+		{ID: "m1", Room: "general", Username: "carol", Content: "spam"},
+		{ID: "m2", Room: "secret", Username: "carol", Content: "psst"},
+	} {
+		if err := messageStore.Insert(ctx, &msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, m := range []struct{ room, username string }{
+		{"general", "alice"}, {"general", "bob"}, {"general", "dave"}, {"secret", "alice"},
+	} {
+		messageStore.JoinRoom(ctx, m.room, m.username)
+	}
+	privateRooms = true
+	t.Cleanup(func() { privateRooms = false })
+
+	steps := []struct {
+		name string
+		id   string
+		body string
+		code int
+	}{
+		{"first report", "m1", `{"username":"bob","reason":"spam"}`, http.StatusCreated},
+		{"same user again", "m1", `{"username":"bob","reason":"really spam"}`, http.StatusOK},
+		{"second user", "m1", `{"username":"alice","reason":"ads"}`, http.StatusCreated},
+		{"member of a private room", "m2", `{"username":"alice","reason":"rude"}`, http.StatusCreated},
+		{"not a member", "m2", `{"username":"bob","reason":"rude"}`, http.StatusForbidden},
+		{"unknown message", "m3", `{"username":"bob","reason":"spam"}`, http.StatusNotFound},
+		{"blank reason", "m1", `{"username":"dave","reason":"   "}`, http.StatusBadRequest},
+		{"reason too long", "m1", `{"username":"dave","reason":"` + strings.Repeat("x", maxReportReasonLength+1) + `"}`, http.StatusBadRequest},
+		{"missing username", "m1", `{"reason":"spam"}`, http.StatusBadRequest},
+	}
+	for _, step := range steps {
+		rec := serveTest(http.MethodPost, "/messages/:id/report", "/messages/"+step.id+"/report", strings.NewReader(step.body), handleReportMessage)
+		if rec.Code != step.code {
+			t.Errorf("%s: status = %d, want %d: %s", step.name, rec.Code, step.code, rec.Body)
+		}
+	}
+
+	reportsMu.Lock()
+	defer reportsMu.Unlock()
+	if reports["m1"] == nil || len(reports["m1"].Reports) != 2 {
+		t.Fatalf("m1 reports = %+v, want 2", reports["m1"])
+	}
+	if reason := reports["m1"].Reports[0].Reason; reason != "spam" {
+		t.Errorf("repeated report replaced the reason with %q", reason)
+	}
+}
+
+func TestHandleListReports(t *testing.T) {
+	useMemoryStore(t)
+	useEmptyReports(t)
+	ctx := context.Background()
+	for _, msg := range []Message{
+		{ID: "m1", Room: "general", Content: "one"},
+		{ID: "m2", Room: "random", Content: "two"},
+	} {
+		if err := messageStore.Insert(ctx, &msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+	reportsMu.Lock()
+	for _, entry := range []ReportedMessage{
+		{MessageID: "m1", Room: "general", LastReportedAt: now.Add(-2 * time.Minute)},
+		{MessageID: "m2", Room: "random", LastReportedAt: now.Add(-time.Minute)},
+		// Deleted since it was reported
+		{MessageID: "gone", Room: "general", LastReportedAt: now.Add(-time.Hour)},
+	} {
+		entry.Reports = []Report{{Username: "bob", Reason: "spam", ReportedAt: entry.LastReportedAt}}
+		reports[entry.MessageID] = &entry
+	}
+	reportsMu.Unlock()
+
+	tests := []struct {
+		name   string
+		target string
+		want   []string // message IDs, most recently reported first
+		total  int
+	}{
+		{"all", "/admin/reports", []string{"m2", "m1", "gone"}, 3},
+		{"one room", "/admin/reports?room=general", []string{"m1", "gone"}, 2},
+		{"limited", "/admin/reports?limit=1", []string{"m2"}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serveTest(http.MethodGet, "/admin/reports", tt.target, nil, handleListReports)
+			var resp struct {
+				Reports []ReportedMessage `json:"reports"`
+				Total   int               `json:"total"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, entry := range resp.Reports {
+				got = append(got, entry.MessageID)
+				if (entry.Message == nil) != (entry.MessageID == "gone") {
+					t.Errorf("%s: message present = %v", entry.MessageID, entry.Message != nil)
+				}
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") || resp.Total != tt.total {
+				t.Errorf("reports = %v (total %d), want %v (total %d)", got, resp.Total, tt.want, tt.total)
+			}
+		})
+	}
+}
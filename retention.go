@@ -0,0 +1,211 @@
+// retention.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Objects in the state bucket holding the per-room retention policies and
+// the log of changes to them
+const (
+	retentionPolicyObject = "config/retention.json"
+	retentionEventsObject = "config/retention-events.json"
+)
+
+// RetentionEvent records a change to a room's retention override. Nil days
+// mean the room had no override and followed the global default.
+type RetentionEvent struct {
+	Room      string    `json:"room"`
+	ChangedBy string    `json:"changedBy"`
+	OldDays   *int      `json:"oldDays"`
+	NewDays   *int      `json:"newDays"`
+	ChangedAt time.Time `json:"changedAt"`
+}
+
+// Message retention settings
+var (
+	retentionDays     int // global default; 0 keeps messages until the history limit drops them
+	retentionInterval time.Duration
+
+	// Also mark messages whose files disappeared from storage as expired
+	fileExpiryCheck bool
+
+	roomRetention   = make(map[string]int) // room -> retention days overriding the global default; 0 keeps forever
+	retentionEvents []RetentionEvent       // oldest first
+	roomRetentionMu sync.RWMutex
+)
+
+// Initialize retention from environment variables and load the per-room
+// policies stored in MinIO
+func initRetention(ctx context.Context) {
+	retentionDays = getEnvInt("MESSAGE_RETENTION_DAYS", 0)
+	retentionInterval = time.Duration(getEnvInt("RETENTION_CHECK_INTERVAL_MINUTES", 60)) * time.Minute
+	if retentionInterval <= 0 {
+		log.Printf("Warning: RETENTION_CHECK_INTERVAL_MINUTES must be positive, using 60")
+		retentionInterval = time.Hour
+	}
+	fileExpiryCheck = getEnvBool("FILE_EXPIRY_CHECK", true)
+
+	policies := make(map[string]int)
+	if err := loadConfigObject(ctx, retentionPolicyObject, &policies); err != nil {
+		log.Fatalf("Error loading room retention policies: %v", err)
+	}
+	var events []RetentionEvent
+	if err := loadConfigObject(ctx, retentionEventsObject, &events); err != nil {
+		log.Fatalf("Error loading room retention events: %v", err)
+	}
+	roomRetentionMu.Lock()
+	roomRetention = policies
+	retentionEvents = events
+	roomRetentionMu.Unlock()
+}
+
+// Return the number of days messages are kept in a room; 0 means no
+// time-based retention. A room's override wins over the global default,
+// including an override of 0 that keeps a room's messages forever.
+func effectiveRetentionDays(room string) int {
+	roomRetentionMu.RLock()
+	defer roomRetentionMu.RUnlock()
+	if days, ok := roomRetention[room]; ok {
+		return days
+	}
+	return retentionDays
+}
+
+// Delete expired messages, and check for expired files, periodically
+// until ctx is canceled
+func runRetention(ctx context.Context) {
+	ticker := time.NewTicker(retentionInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pruneExpiredMessages(ctx, time.Now())
+			if fileExpiryCheck {
+				expireMissingFilesInRooms(ctx)
+			}
+		}
+	}
+}
+
+// Delete every room's messages that are older than its retention allows
+func pruneExpiredMessages(ctx context.Context, now time.Time) {
+	rooms, err := messageStore.Rooms(ctx)
+	if err != nil {
+		log.Printf("Error listing rooms for retention: %v", err)
+		return
+	}
+	for _, room := range rooms {
+		days := effectiveRetentionDays(room)
+		if days <= 0 {
+			continue
+		}
+		deleted, err := messageStore.DeleteBefore(ctx, room, now.AddDate(0, 0, -days))
+		if err != nil {
+			log.Printf("Error pruning messages in %s: %v", room, err)
+			continue
+		}
+		if deleted > 0 {
+			log.Printf("Retention: deleted %d messages older than %d days from %s", deleted, days, room)
+		}
+	}
+}
+
+// Return a room's retention policy
+func handleGetRoomRetention(c *gin.Context) {
+	room := c.Param("room")
+
+	roomRetentionMu.RLock()
+	days, override := roomRetention[room]
+	roomRetentionMu.RUnlock()
+
+	c.JSON(http.StatusOK, gin.H{
+		"room":          room,
+		"days":          days,
+		"override":      override,
+		"globalDays":    retentionDays,
+		"effectiveDays": effectiveRetentionDays(room),
+	})
+}
+
+// Set or, with nil days, remove a room's retention override and record
+// the change. Nothing changes in memory unless both objects are saved.
+func setRoomRetention(ctx context.Context, room string, days *int, changedBy string) error {
+	roomRetentionMu.Lock()
+	defer roomRetentionMu.Unlock()
+
+	var oldDays *int
+	if previous, ok := roomRetention[room]; ok {
+		oldDays = &previous
+	}
+	if (oldDays == nil) == (days == nil) && (days == nil || *oldDays == *days) {
+		return nil
+	}
+
+	policies := make(map[string]int, len(roomRetention)+1)
+	for name, d := range roomRetention {
+		policies[name] = d
+	}
+	if days == nil {
+		delete(policies, room)
+	} else {
+		policies[room] = *days
+	}
+	events := append(retentionEvents[:len(retentionEvents):len(retentionEvents)], RetentionEvent{
+		Room:      room,
+		ChangedBy: changedBy,
+		OldDays:   oldDays,
+		NewDays:   days,
+		ChangedAt: time.Now(),
+	})
+
+	// Log the event first, so a stored policy always has its audit entry
+	if err := saveConfigObject(ctx, retentionEventsObject, events); err != nil {
+		return err
+	}
+	if err := saveConfigObject(ctx, retentionPolicyObject, policies); err != nil {
+		return err
+	}
+	roomRetention = policies
+	retentionEvents = events
+	log.Printf("Retention for room %s changed by %s: %s -> %s", room, changedBy, formatRetentionDays(oldDays), formatRetentionDays(days))
+	return nil
+}
+
+// Describe a retention override for logs
+func formatRetentionDays(days *int) string {
+	switch {
+	case days == nil:
+		return "global default"
+	case *days == 0:
+		return "forever"
+	default:
+		return fmt.Sprintf("%d days", *days)
+	}
+}
+
+// List changes to room retention overrides, newest first; ?room= filters
+// by room (admin only)
+func handleListRetentionEvents(c *gin.Context) {
+	room := c.Query("room")
+
+	roomRetentionMu.RLock()
+	events := []RetentionEvent{}
+	for i := len(retentionEvents) - 1; i >= 0; i-- {
+		if room == "" || retentionEvents[i].Room == room {
+			events = append(events, retentionEvents[i])
+		}
+	}
+	roomRetentionMu.RUnlock()
+
+	c.JSON(http.StatusOK, gin.H{"events": events})
+}
@@ -0,0 +1,178 @@
+// retention_test.go
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Set the global retention and the per-room overrides for a test
+func useRetention(t *testing.T, days int, overrides map[string]int) {
+	t.Helper()
+	roomRetentionMu.Lock()
+	savedDays, savedRooms, savedEvents := retentionDays, roomRetention, retentionEvents
+	retentionDays = days
+	roomRetention = overrides
+	retentionEvents = nil
+	roomRetentionMu.Unlock()
+	t.Cleanup(func() {
+		roomRetentionMu.Lock()
+		retentionDays, roomRetention, retentionEvents = savedDays, savedRooms, savedEvents
+		roomRetentionMu.Unlock()
+	})
+}
+
+func TestEffectiveRetentionDays(t *testing.T) {
+	useRetention(t, 30, map[string]int{"short": 1, "forever": 0})
+
+	tests := []struct {
+		room string
+		want int
+	}{
+		{"general", 30},
+		{"short", 1},
+		{"forever", 0},
+	}
+	for _, tt := range tests {
+		if got := effectiveRetentionDays(tt.room); got != tt.want {
+			t.Errorf("effectiveRetentionDays(%q) = %d, want %d", tt.room, got, tt.want)
+		}
+	}
+}
+
+func TestPruneHonorsRoomOverrides(t *testing.T) {
+	useMemoryStore(t)
+	useRetention(t, 7, map[string]int{"short": 1, "forever": 0})
+	ctx := context.Background()
+	now := time.Now()
+	for _, room := range []string{"general", "short", "forever"} {
+		for i, age := range []time.Duration{30 * 24 * time.Hour, 3 * 24 * time.Hour, time.Hour} {
+			msg := &Message{ID: room + string(rune('a'+i)), Room: room, Username: "alice", Content: "hi", Timestamp: now.Add(-age)}
+			if err := messageStore.Insert(ctx, msg); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	pruneExpiredMessages(ctx, now)
+
+	// The global 7 days keep the 3-day-old message, the 1-day override
+	// doesn't, and the 0-day override keeps everything
+	want := map[string]int{"general": 2, "short": 1, "forever": 3}
+	for room, count := range want {
+		messages, err := messageStore.Recent(ctx, room, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(messages) != count {
+			t.Errorf("%s kept %d messages, want %d", room, len(messages), count)
+		}
+	}
+}
+
+func TestSetRoomRetention(t *testing.T) {
+	useRetention(t, 30, map[string]int{})
+	useFakeS3(t, "chat-state")
+	saved := stateBucketName
+	stateBucketName = "chat-state"
+	t.Cleanup(func() { stateBucketName = saved })
+	ctx := context.Background()
+
+	days := 0
+	if err := setRoomRetention(ctx, "general", &days, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if got := effectiveRetentionDays("general"); got != 0 {
+		t.Errorf("effective retention with a 0-day override = %d, want 0", got)
+	}
+
+	// The stored policy is what a restart loads
+	policies := make(map[string]int)
+	if err := loadConfigObject(ctx, retentionPolicyObject, &policies); err != nil {
+		t.Fatal(err)
+	}
+	if d, ok := policies["general"]; !ok || d != 0 {
+		t.Errorf("stored policies = %v, want general kept forever", policies)
+	}
+
+	if err := setRoomRetention(ctx, "general", nil, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if got := effectiveRetentionDays("general"); got != 30 {
+		t.Errorf("effective retention after removing the override = %d, want the global 30", got)
+	}
+	if len(retentionEvents) != 2 || retentionEvents[1].OldDays == nil || *retentionEvents[1].OldDays != 0 || retentionEvents[1].NewDays != nil {
+		t.Errorf("events = %+v, want the override and its removal", retentionEvents)
+	}
+}
+
+func TestPatchRoomRetention(t *testing.T) {
+	useRetention(t, 30, map[string]int{})
+	useFakeS3(t, "chat-state")
+	saved := stateBucketName
+	stateBucketName = "chat-state"
+	t.Cleanup(func() { stateBucketName = saved })
+	adminToken = "admin-secret"
+	t.Cleanup(func() { adminToken = "" })
+	captureLog(t)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.PATCH("/rooms/:room", AdminRequired(), handlePatchRoom)
+	patch := func(token, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPatch, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Only admins may change retention
+	for _, token := range []string{"", "wrong"} {
+		if rec := patch(token, "/rooms/general?username=mallory", `{"retentionDays": 1}`); rec.Code != http.StatusForbidden {
+			t.Errorf("token %q: status = %d, want 403", token, rec.Code)
+		}
+	}
+	if rec := patch("admin-secret", "/rooms/general", `{"retentionDays": -1}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("negative days: status = %d, want 400", rec.Code)
+	}
+	if got := effectiveRetentionDays("general"); got != 30 || len(retentionEvents) != 0 {
+		t.Fatalf("refused requests changed retention to %d with events %+v", got, retentionEvents)
+	}
+
+	if rec := patch("admin-secret", "/rooms/general?username=carol", `{"retentionDays": 3}`); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if got := effectiveRetentionDays("general"); got != 3 {
+		t.Errorf("effective retention = %d, want 3", got)
+	}
+
+	// The change is in the audit log, in memory and in storage
+	var stored []RetentionEvent
+	if err := loadConfigObject(context.Background(), retentionEventsObject, &stored); err != nil {
+		t.Fatal(err)
+	}
+	for _, events := range [][]RetentionEvent{retentionEvents, stored} {
+		if len(events) != 1 {
+			t.Fatalf("events = %+v, want one", events)
+		}
+		event := events[0]
+		if event.Room != "general" || event.ChangedBy != "carol" || event.OldDays != nil || event.NewDays == nil || *event.NewDays != 3 {
+			t.Errorf("event = %+v, want carol setting general from the default to 3 days", event)
+		}
+	}
+
+	rec := serveTest(http.MethodGet, "/admin/retention/events", "/admin/retention/events?room=general", nil, handleListRetentionEvents)
+	if !strings.Contains(rec.Body.String(), `"changedBy":"carol"`) {
+		t.Errorf("events listing = %s, want carol's change", rec.Body)
+	}
+}
@@ -0,0 +1,182 @@
+// roomdelete.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/minio/minio-go/v7"
+)
+
+// Prefix of the archives of deleted rooms in the state bucket
+const roomArchivePrefix = "archive/rooms/"
+
+// Close frame reason sent to the members of a deleted room
+const roomDeletedReason = "room deleted"
+
+// Close sent to the members of a deleted room and to clients joining it
+// while it is being deleted
+var roomDeletedClose = permanentClose(websocket.ClosePolicyViolation, CloseCodeRoomDeleted, roomDeletedReason)
+
+// Rooms being deleted. New connections and messages for them are refused
+// until the deletion is over, after which the name may be used again.
+var (
+	deletingRooms   = make(map[string]bool)
+	deletingRoomsMu sync.Mutex
+)
+
+// Report whether a room is being deleted
+func roomDeleting(room string) bool {
+	deletingRoomsMu.Lock()
+	defer deletingRoomsMu.Unlock()
+	return deletingRooms[room]
+}
+
+// Delete a room (admin only): disconnect its members, drop messages still
+// waiting to be broadcast to it, delete its history and settings, and
+// cancel messages scheduled for it. With ?archive=true the history is
+// first saved to the state bucket as NDJSON that POST /admin/import
+// accepts; the room is not deleted if that fails. Files shared in the room
+// are kept.
+func handleDeleteRoom(c *gin.Context) {
+	room := c.Param("room")
+	if !validRoomName(room) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid room name"})
+		return
+	}
+	if room == defaultRoom {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The default room can't be deleted"})
+		return
+	}
+	archive := c.Query("archive") == "true"
+
+	deletingRoomsMu.Lock()
+	if deletingRooms[room] {
+		deletingRoomsMu.Unlock()
+		c.JSON(http.StatusConflict, gin.H{"error": "Room is already being deleted"})
+		return
+	}
+	deletingRooms[room] = true
+	deletingRoomsMu.Unlock()
+	defer func() {
+		deletingRoomsMu.Lock()
+		delete(deletingRooms, room)
+		deletingRoomsMu.Unlock()
+	}()
+
+	ctx := c.Request.Context()
+	archiveObject := ""
+	if archive {
+		var err error
+		if archiveObject, err = archiveRoom(ctx, room); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to archive room"})
+			log.Printf("Error archiving room %s: %v", room, err)
+			return
+		}
+	}
+
+	evicted := evictRoomMembers(room)
+	dropped := broadcast.DropRoom(room)
+	deleted, err := messageStore.DeleteRoom(ctx, room)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete room messages"})
+		log.Printf("Error deleting room %s: %v", room, err)
+		return
+	}
+	canceled, err := deleteRoomSettings(ctx, room)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete room settings"})
+		log.Printf("Error deleting settings of room %s: %v", room, err)
+		return
+	}
+
+	log.Printf("Room %s deleted: %d members disconnected, %d messages deleted, %d queued and %d scheduled messages dropped", room, evicted, deleted, dropped, canceled)
+	response := gin.H{
+		"room":              room,
+		"disconnected":      evicted,
+		"deletedMessages":   deleted,
+		"droppedMessages":   dropped,
+		"canceledScheduled": canceled,
+	}
+	if archiveObject != "" {
+		response["archive"] = archiveObject
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Save a room's history to the private state bucket, one message per
+// line, and return the archive's object name
+func archiveRoom(ctx context.Context, room string) (string, error) {
+	messages, err := messageStore.Recent(ctx, room, 0)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, msg := range messages {
+		msg.Reactions = nil
+		if err := encoder.Encode(msg); err != nil {
+			return "", err
+		}
+	}
+
+	name := fmt.Sprintf("%s%s/%s.ndjson", roomArchivePrefix, room, time.Now().UTC().Format("20060102-150405"))
+	_, err = minioClient.PutObject(ctx, stateBucketName, name, &buf, int64(buf.Len()), minio.PutObjectOptions{
+		ContentType: "application/x-ndjson",
+	})
+	return name, err
+}
+
+// Disconnect every client in a room with a close frame saying why, and
+// return how many there were
+func evictRoomMembers(room string) int {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	evicted := 0
+	for client := range clients {
+		if client.room == room {
+			client.closing = &roomDeletedClose
+			removeClientLocked(client)
+			evicted++
+		}
+	}
+	return evicted
+}
+
+// Remove a room's runtime state and stored settings, and cancel the
+// messages scheduled for it; returns how many were canceled
+func deleteRoomSettings(ctx context.Context, room string) (int, error) {
+	if config := findRoom(room); config != nil {
+		config.mu.RLock()
+		slowMode := config.slowMode
+		config.mu.RUnlock()
+		if slowMode > 0 {
+			if err := setSlowMode(ctx, room, 0); err != nil {
+				return 0, err
+			}
+		}
+	}
+	roomConfigsMu.Lock()
+	delete(roomConfigs, room)
+	roomConfigsMu.Unlock()
+
+	if err := setRoomRetention(ctx, room, nil, "room deletion"); err != nil {
+		return 0, err
+	}
+	if err := setDisappearingAllowed(ctx, room, true); err != nil {
+		return 0, err
+	}
+	summaryCacheMu.Lock()
+	delete(summaryCache, room)
+	summaryCacheMu.Unlock()
+
+	return cancelScheduledInRoom(ctx, room)
+}
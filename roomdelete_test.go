@@ -0,0 +1,108 @@
+// roomdelete_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// Send DELETE /rooms/:room through the admin check, with token if set
+func serveDeleteRoom(router *gin.Engine, room, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodDelete, "/rooms/"+room, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+// Route DELETE /rooms/:room like the server does
+func deleteRoomRouter(router *gin.Engine) *gin.Engine {
+	if router == nil {
+		gin.SetMode(gin.TestMode)
+		router = gin.New()
+	}
+	router.DELETE("/rooms/:room", AdminRequired(), handleDeleteRoom)
+	return router
+}
+
+func TestDeleteRoomIsAdminOnly(t *testing.T) {
+	useMemoryStore(t)
+	adminToken = "admin-secret"
+	t.Cleanup(func() { adminToken = "" })
+	messageStore.Insert(context.Background(), &Message{ID: "m1", Room: "project", Username: "alice", Content: "hi"})
+	router := deleteRoomRouter(nil)
+
+	tests := []struct {
+		name  string
+		room  string
+		token string
+		code  int
+	}{
+		{"no token", "project", "", http.StatusForbidden},
+		{"wrong token", "project", "guess", http.StatusForbidden},
+		{"default room", defaultRoom, "admin-secret", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if rec := serveDeleteRoom(router, tt.room, tt.token); rec.Code != tt.code {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, rec.Code, tt.code, rec.Body)
+		}
+	}
+	if msgs, _ := messageStore.Recent(context.Background(), "project", 0); len(msgs) != 1 {
+		t.Errorf("room has %d messages after refused deletes, want 1", len(msgs))
+	}
+}
+
+func TestDeleteRoomEvictsMembers(t *testing.T) {
+	captureLog(t)
+	server := useWSServer(t)
+	useScheduler(t)
+	useRetention(t, 0, map[string]int{})
+	adminToken = "admin-secret"
+	t.Cleanup(func() { adminToken = "" })
+	router := deleteRoomRouter(server.router)
+
+	alice := server.connect("alice", "project")
+	carol := server.connect("carol", "project")
+	bob := server.connect("bob", "general")
+	waitConnected(t, "alice", "carol", "bob")
+	messageStore.Insert(context.Background(), &Message{ID: "m1", Room: "project", Username: "alice", Content: "plans"})
+
+	rec := serveDeleteRoom(router, "project", "admin-secret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var result struct {
+		Disconnected    int `json:"disconnected"`
+		DeletedMessages int `json:"deletedMessages"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &result)
+	if result.Disconnected != 2 || result.DeletedMessages != 1 {
+		t.Errorf("result = %s, want 2 disconnected and 1 message deleted", rec.Body)
+	}
+
+	for name, ws := range map[string]*websocket.Conn{"alice": alice, "carol": carol} {
+		_, closeErr := readUntilClose(t, ws)
+		if closeErr.Code != websocket.ClosePolicyViolation || !strings.Contains(closeErr.Text, roomDeletedReason) {
+			t.Errorf("%s closed with %d %q, want %d for the deleted room", name, closeErr.Code, closeErr.Text, websocket.ClosePolicyViolation)
+		}
+	}
+	if msgs, _ := messageStore.Recent(context.Background(), "project", 0); len(msgs) != 0 {
+		t.Errorf("room still has %d messages", len(msgs))
+	}
+
+	// Members of other rooms stay connected
+	if !isConnectedUser("bob") {
+		t.Fatal("bob was disconnected from another room")
+	}
+	publish(Message{ID: "m2", Room: "general", Username: "dave", Content: "still here?"})
+	readUntil(t, bob, func(msg Message) bool { return msg.ID == "m2" })
+}
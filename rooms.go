@@ -0,0 +1,206 @@
+// rooms.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Room names are used in object keys and URLs, so keep them simple
+var roomNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// Room settings
+var (
+	// When true, room files can only be listed and downloaded by room members (PRIVATE_ROOMS)
+	privateRooms bool
+
+	// Room that clients and requests without one use, and whether
+	// WebSocket clients that don't ask for a room join it
+	defaultRoom         = "general"
+	autoJoinDefaultRoom = true
+)
+
+// Initialize room settings from environment variables. DEFAULT_ROOM names
+// the room used when a client or request gives none; it is an ordinary
+// room with membership, retention and sequence numbers like any other.
+// AUTO_JOIN_DEFAULT_ROOM=false makes WebSocket clients name a room.
+func initRooms() {
+	privateRooms = getEnvBool("PRIVATE_ROOMS", false)
+	defaultRoom = "general"
+	if name := os.Getenv("DEFAULT_ROOM"); name != "" {
+		if !validRoomName(name) {
+			log.Fatalf("Invalid DEFAULT_ROOM %q", name)
+		}
+		defaultRoom = name
+	}
+	autoJoinDefaultRoom = getEnvBool("AUTO_JOIN_DEFAULT_ROOM", true)
+}
+
+// Report whether name is a valid room name
+func validRoomName(name string) bool {
+	return roomNamePattern.MatchString(name)
+}
+
+// Return the room an object key is namespaced under, or "" for keys
+// uploaded before files were namespaced by room
+func roomOfObject(objectName string) string {
+	room, _, ok := strings.Cut(objectName, "/")
+	if !ok {
+		return ""
+	}
+	return room
+}
+
+// Report whether username may access a room's files; every room is open
+// unless private rooms are enabled
+func canAccessRoom(ctx context.Context, room, username string) bool {
+	if !privateRooms || room == "" {
+		return true
+	}
+	member, err := messageStore.IsMember(ctx, room, username)
+	if err != nil {
+		log.Printf("Error checking room membership: %v", err)
+		return false
+	}
+	return member
+}
+
+// RoomFile describes a file shared in a room
+type RoomFile struct {
+	MessageID string    `json:"messageId"`
+	FileName  string    `json:"fileName"`
+	FileURL   string    `json:"fileUrl"`
+	FileSize  int64     `json:"fileSize,omitempty"`
+	Caption   string    `json:"caption,omitempty"`
+	AltText   string    `json:"altText,omitempty"`
+	Uploader  string    `json:"uploader"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// List the files shared in a room, newest first
+func handleListRoomFiles(c *gin.Context) {
+	room := c.Param("room")
+	if !canAccessRoom(c.Request.Context(), room, c.Query("username")) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not a member of this room"})
+		return
+	}
+
+	messages, err := messageStore.Recent(c.Request.Context(), room, 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load files"})
+		log.Printf("Error loading room files: %v", err)
+		return
+	}
+
+	files := []RoomFile{}
+	for i := len(messages) - 1; i >= 0; i-- {
+		msg := messages[i]
+		if msg.FileURL == "" {
+			continue
+		}
+		files = append(files, RoomFile{
+			MessageID: msg.ID,
+			FileName:  msg.FileName,
+			FileURL:   msg.FileURL,
+			FileSize:  msg.FileSize,
+			Caption:   msg.Caption,
+			AltText:   msg.AltText,
+			Uploader:  msg.Username,
+			Timestamp: msg.Timestamp,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"room": room, "files": files})
+}
+
+// Update a room's settings (admin only). The body is a JSON object with any
+// of:
+//
+//   - "retentionDays": the room's retention override; null removes the
+//     override and 0 keeps messages forever
+//   - "disappearingMessages": false turns off message timers in the room
+//
+// Retention changes are recorded in the audit log with the ?username= of
+// the admin making them.
+func handlePatchRoom(c *gin.Context) {
+	room := c.Param("room")
+	if !validRoomName(room) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid room name"})
+		return
+	}
+
+	var req map[string]json.RawMessage
+	if err := c.ShouldBindJSON(&req); err != nil {
+		if limit, ok := isBodyTooLarge(err); ok {
+			abortBodyTooLarge(c, limit)
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	// Validate every field before changing anything
+	var days *int
+	rawDays, setDays := req["retentionDays"]
+	if setDays {
+		if err := json.Unmarshal(rawDays, &days); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "retentionDays must be an integer or null"})
+			return
+		}
+		if days != nil && *days < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "retentionDays must not be negative"})
+			return
+		}
+	}
+	var disappearing bool
+	rawDisappearing, setDisappearing := req["disappearingMessages"]
+	if setDisappearing {
+		if err := json.Unmarshal(rawDisappearing, &disappearing); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "disappearingMessages must be a boolean"})
+			return
+		}
+	}
+	if !setDays && !setDisappearing {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nothing to update"})
+		return
+	}
+
+	if setDays {
+		changedBy := c.Query("username")
+		if changedBy == "" {
+			changedBy = "admin"
+		}
+		if err := setRoomRetention(c.Request.Context(), room, days, changedBy); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save retention policy"})
+			log.Printf("Error saving retention policies: %v", err)
+			return
+		}
+	}
+	if setDisappearing {
+		if err := setDisappearingAllowed(c.Request.Context(), room, disappearing); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save disappearing message setting"})
+			log.Printf("Error saving disappearing message settings: %v", err)
+			return
+		}
+	}
+
+	roomRetentionMu.RLock()
+	var override *int
+	if d, ok := roomRetention[room]; ok {
+		override = &d
+	}
+	roomRetentionMu.RUnlock()
+	c.JSON(http.StatusOK, gin.H{
+		"room":                 room,
+		"retentionDays":        override,
+		"effectiveDays":        effectiveRetentionDays(room),
+		"disappearingMessages": disappearingAllowed(room),
+	})
+}
@@ -0,0 +1,153 @@
+// rooms_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestRoomOfObject(t *testing.T) {
+	tests := map[string]string{
+		"general/a1b2.png":     "general",
+		"general/sub/a1b2.png": "general",
+		"20240501-a1b2.png":    "",
+		"":                     "",
+	}
+	for objectName, want := range tests {
+		if got := roomOfObject(objectName); got != want {
+			t.Errorf("roomOfObject(%q) = %q, want %q", objectName, got, want)
+		}
+	}
+}
+
+func TestHandleListRoomFiles(t *testing.T) {
+	useMemoryStore(t)
+	ctx := context.Background()
+	for _, msg := range []Message{
+		{ID: "f1", Room: "general", Username: "bob", FileURL: "/download/general/a.png", FileName: "a.png", Caption: "first"},
+		{ID: "m1", Room: "general", Username: "bob", Content: "no file"},
+		{ID: "f2", Room: "general", Username: "alice", FileURL: "/download/general/b.pdf", FileName: "b.pdf", AltText: "report"},
+		{ID: "f3", Room: "random", Username: "alice", FileURL: "/download/random/c.png", FileName: "c.png"},
+	} {
+		if err := messageStore.Insert(ctx, &msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := serveTest(http.MethodGet, "/rooms/:room/files", "/rooms/general/files", nil, handleListRoomFiles)
+	var resp struct {
+		Files []RoomFile `json:"files"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Files) != 2 || resp.Files[0].MessageID != "f2" || resp.Files[1].MessageID != "f1" {
+		t.Fatalf("files = %+v, want f2 then f1", resp.Files)
+	}
+	if resp.Files[0].Uploader != "alice" || resp.Files[0].AltText != "report" || resp.Files[1].Caption != "first" {
+		t.Errorf("files = %+v, want uploaders and attachment text kept", resp.Files)
+	}
+
+	privateRooms = true
+	t.Cleanup(func() { privateRooms = false })
+	rec = serveTest(http.MethodGet, "/rooms/:room/files", "/rooms/general/files?username=bob", nil, handleListRoomFiles)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("non-member in a private room: status = %d, want 403", rec.Code)
+	}
+}
+
+// Set the default room and auto-join for a test
+func useDefaultRoom(t *testing.T, room string, autoJoin bool) {
+	t.Helper()
+	savedRoom, savedAutoJoin := defaultRoom, autoJoinDefaultRoom
+	defaultRoom, autoJoinDefaultRoom = room, autoJoin
+	t.Cleanup(func() { defaultRoom, autoJoinDefaultRoom = savedRoom, savedAutoJoin })
+}
+
+// Return the room a connected user is in
+func connectedRoom(username string) string {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	for client := range clients {
+		if client.username == username {
+			return client.room
+		}
+	}
+	return ""
+}
+
+func TestInitRoomsDefaultRoom(t *testing.T) {
+	useDefaultRoom(t, "general", true)
+	savedPrivate := privateRooms
+	t.Cleanup(func() { privateRooms = savedPrivate })
+
+	tests := []struct {
+		room, autoJoin string
+		wantRoom       string
+		wantAutoJoin   bool
+	}{
+		{"", "", "general", true},
+		{"lobby", "", "lobby", true},
+		{"lobby", "false", "lobby", false},
+		{"", "true", "general", true},
+	}
+	for _, tt := range tests {
+		t.Setenv("DEFAULT_ROOM", tt.room)
+		t.Setenv("AUTO_JOIN_DEFAULT_ROOM", tt.autoJoin)
+		initRooms()
+		if defaultRoom != tt.wantRoom || autoJoinDefaultRoom != tt.wantAutoJoin {
+			t.Errorf("DEFAULT_ROOM=%q AUTO_JOIN_DEFAULT_ROOM=%q: room %q, auto-join %v; want %q, %v",
+				tt.room, tt.autoJoin, defaultRoom, autoJoinDefaultRoom, tt.wantRoom, tt.wantAutoJoin)
+		}
+	}
+}
+
+func TestAutoJoinDefaultRoom(t *testing.T) {
+	server := useWSServer(t)
+	useDefaultRoom(t, "lobby", true)
+
+	// Connecting without a room joins the default one
+	ws, _, err := server.dial("username=alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitConnected(t, "alice")
+	if room := connectedRoom("alice"); room != "lobby" {
+		t.Errorf("alice joined %q, want lobby", room)
+	}
+	publish(Message{ID: "m1", Room: "lobby", Username: "bob", Content: "welcome"})
+	readUntil(t, ws, func(msg Message) bool { return msg.ID == "m1" })
+
+	// Requests without a room use it too
+	messageStore.Insert(context.Background(), &Message{ID: "m2", Room: "lobby", Username: "bob", Content: "stored"})
+	rec := serveTest(http.MethodGet, "/messages", "/messages", nil, handleListMessages)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"m2"`) {
+		t.Errorf("GET /messages = %d %s, want the default room's messages", rec.Code, rec.Body)
+	}
+}
+
+func TestAutoJoinDisabled(t *testing.T) {
+	server := useWSServer(t)
+	useDefaultRoom(t, "lobby", false)
+
+	ws, _, err := server.dial("username=alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, closeErr := readUntilClose(t, ws)
+	if closeErr.Code != websocket.ClosePolicyViolation || !strings.Contains(closeErr.Text, "room required") {
+		t.Errorf("closed with %d %q, want %d because no room was named", closeErr.Code, closeErr.Text, websocket.ClosePolicyViolation)
+	}
+
+	// Naming a room, the default one included, still works
+	server.connect("bob", "lobby")
+	waitConnected(t, "bob")
+	if room := connectedRoom("bob"); room != "lobby" {
+		t.Errorf("bob joined %q, want lobby", room)
+	}
+}
@@ -0,0 +1,34 @@
+// sanitize.go
+
+// Package sanitize removes HTML from user-supplied text before it is
+// stored, so clients that render message content as HTML cannot be made
+// to run injected markup.
+package sanitize
+
+import (
+	"html"
+
+	"github.com/microcosm-cc/bluemonday"
+)
+
+// Rounds of stripping before giving up on reaching plain text; each round
+// peels off one layer of entity-encoded markup
+const maxRounds = 8
+
+// Strips every tag; elements like <script> and <style> lose their contents too
+var strict = bluemonday.StrictPolicy()
+
+// Content strips all HTML tags from s and returns the remaining text
+// unescaped, so "a < b & c" stays as written. Markup hidden behind entities
+// ("&lt;script&gt;") is stripped as well. Text that still holds markup
+// after maxRounds is returned HTML-escaped.
+func Content(s string) string {
+	for range maxRounds {
+		out := html.UnescapeString(strict.Sanitize(s))
+		if out == s {
+			return out
+		}
+		s = out
+	}
+	return html.EscapeString(s)
+}
@@ -0,0 +1,74 @@
+// sanitize_test.go
+package sanitize
+
+import (
+	"html"
+	"strings"
+	"testing"
+)
+
+func TestContent(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain text", "hello", "hello"},
+		{"empty", "", ""},
+		{"comparison kept as written", "a < b & c", "a < b & c"},
+		{"greater than", "5 > 3", "5 > 3"},
+		{"entity unescaped", "Tom &amp; Jerry", "Tom & Jerry"},
+		{"tags stripped", "<b>bold</b>", "bold"},
+		{"script dropped with its contents", "<script>alert(1)</script>hi", "hi"},
+		{"attributes dropped", `<a href="javascript:x">link</a>`, "link"},
+		{"void element", "<img src=x onerror=alert(1)>", ""},
+		{"entity-encoded markup", "&lt;script&gt;alert(1)&lt;/script&gt;x", "x"},
+		{"double-encoded markup", "&amp;lt;b&amp;gt;x", "x"},
+		{"nested brackets", "<<b>>", "<>"},
+		{"non-ASCII kept", "emoji 😀 <i>ok</i>", "emoji 😀 ok"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Content(tt.in); got != tt.want {
+				t.Errorf("Content(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContentGivesUpEscaped(t *testing.T) {
+	s := "<b>x</b>"
+	for range maxRounds + 2 {
+		s = html.EscapeString(s)
+	}
+	got := Content(s)
+	if strings.ContainsAny(got, "<>") {
+		t.Errorf("Content(%q) = %q, want HTML-escaped text", s, got)
+	}
+}
+
+func FuzzContent(f *testing.F) {
+	for _, seed := range []string{
+		"hello",
+		"a < b & c",
+		"<script>alert(1)</script>",
+		"<SCRIPT SRC=//evil.example/x.js></SCRIPT>",
+		"<scr<script>ipt>alert(1)</script>",
+		"<<script>script>alert(1)<</script>/script>",
+		"&lt;script&gt;alert(1)&lt;/script&gt;",
+		"&amp;lt;script&amp;gt;alert(1)",
+		"&#60;script&#62;alert(1)&#60;/script&#62;",
+		"<img src=x onerror=alert(1)>",
+		"<svg><script>alert(1)</script></svg>",
+		"<a href=\"javascript:alert(1)\">x</a>",
+		"<!--<script>-->alert(1)",
+		"<![CDATA[<script>]]>",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		if got := Content(s); strings.Contains(strings.ToLower(got), "<script") {
+			t.Errorf("Content(%q) = %q, which still holds a script tag", s, got)
+		}
+	})
+}
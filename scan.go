@@ -0,0 +1,240 @@
+// scan.go
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go/v7"
+)
+
+// FileScanner checks uploaded files for malware
+type FileScanner interface {
+	// Scan reads r and reports whether it is clean; reason names what was
+	// found when it is not
+	Scan(ctx context.Context, r io.Reader) (clean bool, reason string, err error)
+}
+
+// errScanUnavailable means the scanner could not be reached; uploads fail
+// closed rather than being stored unscanned
+var errScanUnavailable = errors.New("virus scanner unavailable")
+
+// RejectedFileError is returned for an upload the scanner rejected; the file
+// was quarantined instead of being stored in its room
+type RejectedFileError struct {
+	Reason string
+}
+
+func (e *RejectedFileError) Error() string {
+	return "file rejected by virus scan: " + e.Reason
+}
+
+// Upload scanning settings
+var (
+	fileScanner      FileScanner // nil when scanning is disabled
+	quarantineBucket string
+	quarantinePrefix string
+)
+
+// Initialize upload scanning from environment variables. Scanning uses a
+// clamd daemon at CLAMD_ADDRESS (host:port); rejected files are kept in
+// QUARANTINE_BUCKET (default: the private state bucket) under
+// QUARANTINE_PREFIX. The quarantine bucket must not be the public chat
+// bucket, or rejected files would stay downloadable.
+func initScanning(ctx context.Context) {
+	address := os.Getenv("CLAMD_ADDRESS")
+	if address == "" {
+		return
+	}
+	fileScanner = &clamdScanner{address: address, timeout: time.Duration(getEnvInt("CLAMD_TIMEOUT_SECONDS", 30)) * time.Second}
+
+	quarantineBucket = os.Getenv("QUARANTINE_BUCKET")
+	if quarantineBucket == "" {
+		quarantineBucket = stateBucketName
+	}
+	if quarantineBucket == bucketName {
+		log.Fatalf("QUARANTINE_BUCKET must be a private bucket other than %s", bucketName)
+	}
+	quarantinePrefix = os.Getenv("QUARANTINE_PREFIX")
+	if quarantinePrefix == "" {
+		quarantinePrefix = "quarantine/"
+	}
+
+	if quarantineBucket != stateBucketName {
+		exists, err := minioClient.BucketExists(ctx, quarantineBucket)
+		if err != nil {
+			log.Fatalf("Error checking quarantine bucket: %v", err)
+		}
+		if !exists {
+			err := minioClient.MakeBucket(ctx, quarantineBucket, minio.MakeBucketOptions{})
+			if err != nil && !isBucketExistsError(err) {
+				log.Fatalf("Error creating quarantine bucket: %v", err)
+			}
+		} else if policy, err := minioClient.GetBucketPolicy(ctx, quarantineBucket); err == nil && policy != "" {
+			log.Printf("Warning: quarantine bucket %s has an access policy; it should not be publicly readable", quarantineBucket)
+		}
+	}
+	log.Printf("Upload scanning enabled (clamd %s, quarantine %s/%s)", address, quarantineBucket, quarantinePrefix)
+}
+
+// Scan an upload before it is stored. Rejected files are written to
+// quarantine with the reason in their metadata and a *RejectedFileError is
+// returned; the caller must not broadcast them.
+func scanUpload(ctx context.Context, username, objectName string, file multipart.File, header *multipart.FileHeader) error {
+	if fileScanner == nil {
+		return nil
+	}
+
+	clean, reason, err := fileScanner.Scan(ctx, file)
+	if _, seekErr := file.Seek(0, io.SeekStart); seekErr != nil && err == nil {
+		err = seekErr
+	}
+	if err != nil {
+		log.Printf("Error scanning upload %s: %v", objectName, err)
+		return errScanUnavailable
+	}
+	if clean {
+		return nil
+	}
+
+	log.Printf("Upload %s from %s rejected by virus scan: %s", objectName, username, reason)
+	_, err = minioClient.PutObject(ctx, quarantineBucket, quarantinePrefix+objectName, file, header.Size, minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+		UserMetadata: map[string]string{
+			"uploader": url.PathEscape(username),
+			"filename": url.PathEscape(header.Filename),
+			"reason":   url.PathEscape(reason),
+		},
+	})
+	if err != nil {
+		log.Printf("Error quarantining upload %s: %v", objectName, err)
+	}
+	return &RejectedFileError{Reason: reason}
+}
+
+// Respond to an upload that failed scanning; returns false for other errors
+func respondScanError(c *gin.Context, err error) bool {
+	var rejected *RejectedFileError
+	switch {
+	case errors.As(err, &rejected):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "File rejected by virus scan", "reason": rejected.Reason})
+	case errors.Is(err, errScanUnavailable):
+		c.Header("Retry-After", "30")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Virus scanning is unavailable, try again later"})
+	default:
+		return false
+	}
+	return true
+}
+
+// QuarantinedFile describes an upload held in quarantine
+type QuarantinedFile struct {
+	Key        string    `json:"key"`
+	FileName   string    `json:"fileName"`
+	Uploader   string    `json:"uploader"`
+	Reason     string    `json:"reason"`
+	Size       int64     `json:"size"`
+	RejectedAt time.Time `json:"rejectedAt"`
+}
+
+// List quarantined uploads (admin only)
+func handleListQuarantine(c *gin.Context) {
+	if fileScanner == nil {
+		c.JSON(http.StatusOK, gin.H{"files": []QuarantinedFile{}})
+		return
+	}
+
+	files := []QuarantinedFile{}
+	for obj := range minioClient.ListObjects(c.Request.Context(), quarantineBucket, minio.ListObjectsOptions{
+		Prefix:       quarantinePrefix,
+		Recursive:    true,
+		WithMetadata: true,
+	}) {
+		if obj.Err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list quarantined files"})
+			log.Printf("Error listing quarantine: %v", obj.Err)
+			return
+		}
+		files = append(files, QuarantinedFile{
+			Key:        obj.Key,
+			FileName:   objectMetadata(obj.UserMetadata, "Filename"),
+			Uploader:   objectMetadata(obj.UserMetadata, "Uploader"),
+			Reason:     objectMetadata(obj.UserMetadata, "Reason"),
+			Size:       obj.Size,
+			RejectedAt: obj.LastModified,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"files": files})
+}
+
+// clamdScanner scans files with a ClamAV daemon using its INSTREAM command
+type clamdScanner struct {
+	address string
+	timeout time.Duration
+}
+
+func (s *clamdScanner) Scan(ctx context.Context, r io.Reader) (bool, string, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.address)
+	if err != nil {
+		return false, "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(s.timeout))
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return false, "", err
+	}
+	// The file is sent as length-prefixed chunks, ended by a zero-length chunk
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if err := binary.Write(conn, binary.BigEndian, uint32(n)); err != nil {
+				return false, "", err
+			}
+			if _, err := conn.Write(buf[:n]); err != nil {
+				return false, "", err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return false, "", err
+		}
+	}
+	if err := binary.Write(conn, binary.BigEndian, uint32(0)); err != nil {
+		return false, "", err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && err != io.EOF {
+		return false, "", err
+	}
+	reply = strings.TrimSpace(strings.TrimSuffix(reply, "\x00"))
+
+	// Replies look like "stream: OK" or "stream: Eicar-Signature FOUND"
+	result := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case result == "OK":
+		return true, "", nil
+	case strings.HasSuffix(result, " FOUND"):
+		return false, strings.TrimSuffix(result, " FOUND"), nil
+	default:
+		return false, "", fmt.Errorf("unexpected clamd reply %q", reply)
+	}
+}
@@ -0,0 +1,164 @@
+// scan_test.go
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test signature the fake clamd reports as infected
+const eicarSignature = "X5O!P%@AP[4\\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*"
+
+// Start a fake clamd that answers INSTREAM scans, finding files that
+// contain the EICAR signature; returns its address
+func startFakeClamd(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveFakeClamd(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func serveFakeClamd(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	if command, err := r.ReadString(0); err != nil || command != "zINSTREAM\x00" {
+		io.WriteString(conn, "UNKNOWN COMMAND\x00")
+		return
+	}
+	var data bytes.Buffer
+	for {
+		var size uint32
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			return
+		}
+		if size == 0 {
+			break
+		}
+		if _, err := io.CopyN(&data, r, int64(size)); err != nil {
+			return
+		}
+	}
+	if bytes.Contains(data.Bytes(), []byte("EICAR-STANDARD-ANTIVIRUS-TEST-FILE")) {
+		io.WriteString(conn, "stream: Eicar-Signature FOUND\x00")
+		return
+	}
+	io.WriteString(conn, "stream: OK\x00")
+}
+
+// Scan uploads with a clamd at address, quarantining into the state bucket
+func useScanner(t *testing.T, address string) {
+	t.Helper()
+	savedScanner, savedBucket, savedPrefix, savedState := fileScanner, quarantineBucket, quarantinePrefix, stateBucketName
+	fileScanner = &clamdScanner{address: address, timeout: 5 * time.Second}
+	stateBucketName = "chat-state"
+	quarantineBucket = stateBucketName
+	quarantinePrefix = "quarantine/"
+	t.Cleanup(func() {
+		fileScanner, quarantineBucket, quarantinePrefix, stateBucketName = savedScanner, savedBucket, savedPrefix, savedState
+	})
+}
+
+func TestClamdScanner(t *testing.T) {
+	scanner := &clamdScanner{address: startFakeClamd(t), timeout: 5 * time.Second}
+
+	tests := []struct {
+		name   string
+		data   string
+		clean  bool
+		reason string
+	}{
+		{"clean", "just some notes", true, ""},
+		{"empty", "", true, ""},
+		{"infected", eicarSignature, false, "Eicar-Signature"},
+		{"infected past the first chunk", strings.Repeat("x", 100<<10) + eicarSignature, false, "Eicar-Signature"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clean, reason, err := scanner.Scan(context.Background(), strings.NewReader(tt.data))
+			if err != nil || clean != tt.clean || reason != tt.reason {
+				t.Errorf("Scan = %v, %q, %v; want %v, %q", clean, reason, err, tt.clean, tt.reason)
+			}
+		})
+	}
+}
+
+func TestInfectedUploadIsQuarantined(t *testing.T) {
+	captureLog(t)
+	useMemoryStore(t)
+	useUploadLimiter(t, 60, 10)
+	queue := useBroadcastQueue(t)
+	fake := useFakeS3(t, bucketName, "chat-state")
+	useScanner(t, startFakeClamd(t))
+
+	req := newUploadForm("/upload", map[string]string{"username": "alice", "room": "general"}, "invoice.pdf", eicarSignature)
+	rec := serveTestRequest("/upload", req, handleFileUpload)
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "Eicar-Signature") {
+		t.Fatalf("status = %d: %s, want 422 naming the signature", rec.Code, rec.Body)
+	}
+
+	if keys := fake.keys(bucketName); len(keys) != 0 {
+		t.Errorf("chat bucket holds %v, want the infected file kept out", keys)
+	}
+	quarantined := fake.keys("chat-state")
+	if len(quarantined) != 1 || !strings.HasPrefix(quarantined[0], "quarantine/general/") {
+		t.Fatalf("quarantine holds %v, want the infected file", quarantined)
+	}
+	if reason := fake.object("chat-state", quarantined[0]).metadata.Get("X-Amz-Meta-Reason"); reason != "Eicar-Signature" {
+		t.Errorf("quarantined with reason %q, want Eicar-Signature", reason)
+	}
+	if msg, ok := queue.Pop(); ok {
+		t.Errorf("rejected upload was broadcast: %+v", msg)
+	}
+
+	// A clean file goes through
+	req = newUploadForm("/upload", map[string]string{"username": "alice", "room": "general"}, "notes.txt", "just some notes")
+	if rec := serveTestRequest("/upload", req, handleFileUpload); rec.Code != http.StatusOK {
+		t.Errorf("clean upload: status = %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestUploadFailsClosedWhenScannerDown(t *testing.T) {
+	captureLog(t)
+	useMemoryStore(t)
+	useUploadLimiter(t, 60, 10)
+	useBroadcastQueue(t)
+	fake := useFakeS3(t, bucketName, "chat-state")
+
+	// Nothing listens on the scanner's address
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+	useScanner(t, address)
+
+	req := newUploadForm("/upload", map[string]string{"username": "alice", "room": "general"}, "notes.txt", "just some notes")
+	rec := serveTestRequest("/upload", req, handleFileUpload)
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("status = %d (Retry-After %q), want 503 with Retry-After", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if keys := fake.keys(bucketName); len(keys) != 0 {
+		t.Errorf("chat bucket holds %v, want nothing stored unscanned", keys)
+	}
+}
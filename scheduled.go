@@ -0,0 +1,288 @@
+// scheduled.go
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Object in the state bucket holding the pending scheduled messages
+const scheduledMessagesObject = "config/scheduled.json"
+
+// Most messages one user may have waiting for delivery
+const maxScheduledPerUser = 100
+
+// ScheduledMessage is a message waiting to be sent at SendAt. It is sent
+// with the same ID.
+type ScheduledMessage struct {
+	ID        string    `json:"id"`
+	Room      string    `json:"room"`
+	Username  string    `json:"username"`
+	Content   string    `json:"content"`
+	ReplyTo   string    `json:"replyTo,omitempty"`
+	SendAt    time.Time `json:"sendAt"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Pending scheduled messages and their delivery timers, by ID
+var (
+	scheduledMessages  = make(map[string]ScheduledMessage)
+	scheduledTimers    = make(map[string]*time.Timer)
+	scheduledMu        sync.Mutex
+	scheduleMaxHorizon time.Duration
+)
+
+// Initialize the scheduler from environment variables and reload the
+// messages still waiting from before a restart. Messages whose time passed
+// while the server was down are sent right away.
+// SCHEDULE_MAX_HORIZON_DAYS (default 30) limits how far ahead messages may
+// be scheduled.
+func initScheduler(ctx context.Context) {
+	scheduleMaxHorizon = time.Duration(getEnvInt("SCHEDULE_MAX_HORIZON_DAYS", 30)) * 24 * time.Hour
+	if scheduleMaxHorizon <= 0 {
+		log.Printf("Warning: SCHEDULE_MAX_HORIZON_DAYS must be positive, using 30")
+		scheduleMaxHorizon = 30 * 24 * time.Hour
+	}
+
+	var pending []ScheduledMessage
+	if err := loadConfigObject(ctx, scheduledMessagesObject, &pending); err != nil {
+		log.Fatalf("Error loading scheduled messages: %v", err)
+	}
+	scheduledMu.Lock()
+	defer scheduledMu.Unlock()
+	for _, s := range pending {
+		scheduledMessages[s.ID] = s
+		armScheduledLocked(s)
+	}
+	if len(pending) > 0 {
+		log.Printf("Loaded %d scheduled messages", len(pending))
+	}
+}
+
+// Start the timer that sends a scheduled message. The caller must hold
+// scheduledMu.
+func armScheduledLocked(s ScheduledMessage) {
+	scheduledTimers[s.ID] = time.AfterFunc(time.Until(s.SendAt), func() { deliverScheduled(s.ID) })
+}
+
+// Store the pending scheduled messages. The caller must hold scheduledMu.
+func saveScheduledLocked(ctx context.Context) error {
+	pending := make([]ScheduledMessage, 0, len(scheduledMessages))
+	for _, s := range scheduledMessages {
+		pending = append(pending, s)
+	}
+	return saveConfigObject(ctx, scheduledMessagesObject, pending)
+}
+
+// Send a scheduled message unless it was canceled. It is removed from the
+// stored list first, so a crash in between loses the message rather than
+// sending it twice.
+func deliverScheduled(id string) {
+	scheduledMu.Lock()
+	s, ok := scheduledMessages[id]
+	if ok {
+		delete(scheduledMessages, id)
+		delete(scheduledTimers, id)
+		if err := saveScheduledLocked(context.Background()); err != nil {
+			log.Printf("Error saving scheduled messages: %v", err)
+		}
+	}
+	scheduledMu.Unlock()
+	if !ok {
+		return
+	}
+
+	// The sender may have lost access to the room in the meantime
+	if !canAccessRoom(context.Background(), s.Room, s.Username) {
+		log.Printf("Dropped scheduled message %s: %s can no longer post in room %s", s.ID, s.Username, s.Room)
+		return
+	}
+	publish(Message{
+		SchemaVersion: CurrentSchemaVersion,
+		ID:            s.ID,
+		Room:          s.Room,
+		Username:      s.Username,
+		Content:       s.Content,
+		ReplyTo:       s.ReplyTo,
+		Timestamp:     time.Now(),
+	})
+}
+
+// Cancel every message scheduled for a room and return how many there were
+func cancelScheduledInRoom(ctx context.Context, room string) (int, error) {
+	scheduledMu.Lock()
+	defer scheduledMu.Unlock()
+
+	var canceled []ScheduledMessage
+	for id, s := range scheduledMessages {
+		if s.Room == room {
+			canceled = append(canceled, s)
+			delete(scheduledMessages, id)
+		}
+	}
+	if len(canceled) == 0 {
+		return 0, nil
+	}
+	if err := saveScheduledLocked(ctx); err != nil {
+		for _, s := range canceled {
+			scheduledMessages[s.ID] = s
+		}
+		return 0, err
+	}
+	for _, s := range canceled {
+		scheduledTimers[s.ID].Stop()
+		delete(scheduledTimers, s.ID)
+	}
+	return len(canceled), nil
+}
+
+// Body of POST /messages/schedule
+type scheduleRequest struct {
+	Username string    `json:"username"`
+	Room     string    `json:"room"`
+	Content  string    `json:"content" binding:"required"`
+	ReplyTo  string    `json:"replyTo"`
+	SendAt   time.Time `json:"sendAt" binding:"required"`
+}
+
+// Schedule a message to be sent to a room at sendAt (RFC 3339)
+func handleScheduleMessage(c *gin.Context) {
+	var req scheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		if limit, ok := isBodyTooLarge(err); ok {
+			abortBodyTooLarge(c, limit)
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if req.Username == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "username is required"})
+		return
+	}
+	username, ok := checkUsername(c, req.Username)
+	if !ok {
+		return
+	}
+	if req.Room == "" {
+		req.Room = defaultRoom
+	}
+	if !validRoomName(req.Room) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid room name"})
+		return
+	}
+	if !canAccessRoom(c.Request.Context(), req.Room, username) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not a member of this room"})
+		return
+	}
+
+	now := time.Now()
+	if !req.SendAt.After(now) || req.SendAt.After(now.Add(scheduleMaxHorizon)) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":      "sendAt must be in the future and within the scheduling horizon",
+			"maxSendAt":  now.Add(scheduleMaxHorizon),
+			"serverTime": now,
+		})
+		return
+	}
+	if _, ok := moderateContent(req.Content); !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Message contains blocked words"})
+		return
+	}
+	if req.ReplyTo != "" {
+		target, err := messageStore.Get(c.Request.Context(), req.ReplyTo)
+		if err != nil || target.Room != req.Room {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "replyTo must be a message in the same room"})
+			return
+		}
+	}
+
+	s := ScheduledMessage{
+		ID:        uuid.New().String(),
+		Room:      req.Room,
+		Username:  username,
+		Content:   req.Content,
+		ReplyTo:   req.ReplyTo,
+		SendAt:    req.SendAt,
+		CreatedAt: now,
+	}
+
+	scheduledMu.Lock()
+	defer scheduledMu.Unlock()
+	count := 0
+	for _, other := range scheduledMessages {
+		if other.Username == username {
+			count++
+		}
+	}
+	if count >= maxScheduledPerUser {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many scheduled messages", "limit": maxScheduledPerUser})
+		return
+	}
+	scheduledMessages[s.ID] = s
+	if err := saveScheduledLocked(c.Request.Context()); err != nil {
+		delete(scheduledMessages, s.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to schedule message"})
+		log.Printf("Error saving scheduled messages: %v", err)
+		return
+	}
+	armScheduledLocked(s)
+	c.JSON(http.StatusCreated, s)
+}
+
+// List a user's (?username=) pending scheduled messages, soonest first
+func handleListScheduled(c *gin.Context) {
+	username := c.Query("username")
+	if username == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "username is required"})
+		return
+	}
+
+	scheduledMu.Lock()
+	pending := []ScheduledMessage{}
+	for _, s := range scheduledMessages {
+		if s.Username == username {
+			pending = append(pending, s)
+		}
+	}
+	scheduledMu.Unlock()
+
+	slices.SortFunc(pending, func(a, b ScheduledMessage) int { return a.SendAt.Compare(b.SendAt) })
+	c.JSON(http.StatusOK, gin.H{"scheduled": pending})
+}
+
+// Cancel a scheduled message before it is sent. Only its sender (?username=)
+// or an admin may cancel it.
+func handleCancelScheduled(c *gin.Context) {
+	id := c.Param("id")
+
+	scheduledMu.Lock()
+	defer scheduledMu.Unlock()
+	s, ok := scheduledMessages[id]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Scheduled message not found"})
+		return
+	}
+	if c.Query("username") != s.Username && !isAdmin(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the sender can cancel a scheduled message"})
+		return
+	}
+
+	delete(scheduledMessages, id)
+	if err := saveScheduledLocked(c.Request.Context()); err != nil {
+		scheduledMessages[id] = s
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel scheduled message"})
+		log.Printf("Error saving scheduled messages: %v", err)
+		return
+	}
+	scheduledTimers[id].Stop()
+	delete(scheduledTimers, id)
+	c.JSON(http.StatusOK, gin.H{"message": "Scheduled message canceled", "id": id})
+}
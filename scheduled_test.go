@@ -0,0 +1,139 @@
+// scheduled_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Start a test with no scheduled messages, kept in a fake state bucket;
+// timers still pending when the test ends are stopped
+func useScheduler(t *testing.T) *fakeS3 {
+	t.Helper()
+	fake := useFakeS3(t, "chat-state")
+	savedBucket, savedHorizon := stateBucketName, scheduleMaxHorizon
+	stateBucketName, scheduleMaxHorizon = "chat-state", time.Hour
+	scheduledMu.Lock()
+	savedMessages, savedTimers := scheduledMessages, scheduledTimers
+	scheduledMessages, scheduledTimers = make(map[string]ScheduledMessage), make(map[string]*time.Timer)
+	scheduledMu.Unlock()
+	t.Cleanup(func() {
+		scheduledMu.Lock()
+		for _, timer := range scheduledTimers {
+			timer.Stop()
+		}
+		scheduledMessages, scheduledTimers = savedMessages, savedTimers
+		scheduledMu.Unlock()
+		stateBucketName, scheduleMaxHorizon = savedBucket, savedHorizon
+	})
+	return fake
+}
+
+// Return the scheduled messages kept in the state bucket
+func storedScheduled(t *testing.T, fake *fakeS3) []ScheduledMessage {
+	t.Helper()
+	var pending []ScheduledMessage
+	if obj := fake.object("chat-state", scheduledMessagesObject); obj != nil {
+		if err := json.Unmarshal(obj.data, &pending); err != nil {
+			t.Fatalf("decoding stored scheduled messages: %v", err)
+		}
+	}
+	return pending
+}
+
+func TestScheduledMessageDelivered(t *testing.T) {
+	useMemoryStore(t)
+	fake := useScheduler(t)
+	queue := useBroadcastQueue(t)
+
+	sendAt := time.Now().Add(300 * time.Millisecond)
+	body, _ := json.Marshal(scheduleRequest{Username: "alice", Room: "general", Content: "standup!", SendAt: sendAt})
+	rec := serveTest(http.MethodPost, "/messages/schedule", "/messages/schedule", strings.NewReader(string(body)), handleScheduleMessage)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var scheduled ScheduledMessage
+	json.Unmarshal(rec.Body.Bytes(), &scheduled)
+	if stored := storedScheduled(t, fake); len(stored) != 1 || stored[0].ID != scheduled.ID {
+		t.Errorf("stored %+v, want %s", stored, scheduled.ID)
+	}
+	if _, ok := queue.Pop(); ok {
+		t.Fatal("scheduled message sent right away")
+	}
+
+	msg := waitPublished(t, queue)
+	if time.Now().Before(sendAt) {
+		t.Errorf("sent before %v", sendAt)
+	}
+	if msg.ID != scheduled.ID || msg.Room != "general" || msg.Username != "alice" || msg.Content != "standup!" {
+		t.Errorf("sent %+v, want the scheduled message %s", msg, scheduled.ID)
+	}
+	if stored := storedScheduled(t, fake); len(stored) != 0 {
+		t.Errorf("still stored after sending: %+v", stored)
+	}
+}
+
+func TestScheduleMessageValidation(t *testing.T) {
+	useMemoryStore(t)
+	useScheduler(t)
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		sendAt time.Time
+		code   int
+	}{
+		{"in the past", now.Add(-time.Minute), http.StatusBadRequest},
+		{"beyond the horizon", now.Add(2 * time.Hour), http.StatusBadRequest},
+		{"within the horizon", now.Add(30 * time.Minute), http.StatusCreated},
+	}
+	for _, tt := range tests {
+		body, _ := json.Marshal(scheduleRequest{Username: "alice", Room: "general", Content: "hi", SendAt: tt.sendAt})
+		rec := serveTest(http.MethodPost, "/messages/schedule", "/messages/schedule", strings.NewReader(string(body)), handleScheduleMessage)
+		if rec.Code != tt.code {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, rec.Code, tt.code, rec.Body)
+		}
+	}
+}
+
+func TestScheduledMessagesRecoveredAfterRestart(t *testing.T) {
+	captureLog(t)
+	fake := useScheduler(t)
+	queue := useBroadcastQueue(t)
+
+	// Left by the previous run: one due while the server was down, one later
+	now := time.Now()
+	pending := []ScheduledMessage{
+		{ID: "overdue", Room: "general", Username: "alice", Content: "missed", SendAt: now.Add(-time.Minute), CreatedAt: now.Add(-time.Hour)},
+		{ID: "later", Room: "general", Username: "bob", Content: "soon", SendAt: now.Add(300 * time.Millisecond), CreatedAt: now.Add(-time.Hour)},
+	}
+	data, _ := json.Marshal(pending)
+	fake.putObject("chat-state", scheduledMessagesObject, data, nil)
+
+	initScheduler(context.Background())
+
+	if msg := waitPublished(t, queue); msg.ID != "overdue" {
+		t.Fatalf("sent %s first, want the overdue message", msg.ID)
+	}
+	if time.Now().After(pending[1].SendAt) {
+		t.Skip("machine too slow to check the later message waits")
+	}
+	if msg, ok := queue.Pop(); ok {
+		t.Fatalf("sent %s before it was due", msg.ID)
+	}
+	if stored := storedScheduled(t, fake); len(stored) != 1 || stored[0].ID != "later" {
+		t.Errorf("stored %+v, want only the later message", stored)
+	}
+
+	msg := waitPublished(t, queue)
+	if msg.ID != "later" || msg.Content != "soon" {
+		t.Errorf("sent %+v, want the later message", msg)
+	}
+	if time.Now().Before(pending[1].SendAt) {
+		t.Errorf("later message sent before %v", pending[1].SendAt)
+	}
+}
@@ -0,0 +1,35 @@
+// schema.go
+package main
+
+// Message schema versions. Bump CurrentSchemaVersion whenever Message
+// fields change meaning or new required fields appear, and add a migration
+// from the previous version so older stored or imported messages can be
+// upgraded on read.
+//
+//	1: original protocol (id, username, content, fileUrl, fileName, timestamp)
+//	2: rooms, per-room sequence numbers, event types, replies and reactions
+const CurrentSchemaVersion = 2
+
+// Migrations keyed by the version they upgrade from
+var schemaMigrations = map[int]func(*Message){
+	1: func(msg *Message) {
+		// Version 1 had a single global chat, which became the default room
+		if msg.Room == "" {
+			msg.Room = defaultRoom
+		}
+	},
+}
+
+// Upgrade a message to the current schema version. Messages without a
+// version predate versioning and are treated as version 1.
+func migrateMessage(msg *Message) {
+	if msg.SchemaVersion == 0 {
+		msg.SchemaVersion = 1
+	}
+	for version := msg.SchemaVersion; version < CurrentSchemaVersion; version++ {
+		if migrate, ok := schemaMigrations[version]; ok {
+			migrate(msg)
+		}
+	}
+	msg.SchemaVersion = CurrentSchemaVersion
+}
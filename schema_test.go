@@ -0,0 +1,81 @@
+// schema_test.go
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMigrateMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		in   Message
+		room string
+	}{
+		{"unversioned", Message{Content: "hi"}, defaultRoom},
+		{"version 1", Message{SchemaVersion: 1, Content: "hi"}, defaultRoom},
+		{"version 1 with a room", Message{SchemaVersion: 1, Room: "random"}, "random"},
+		{"current", Message{SchemaVersion: CurrentSchemaVersion, Room: "random"}, "random"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := tt.in
+			migrateMessage(&msg)
+			if msg.SchemaVersion != CurrentSchemaVersion || msg.Room != tt.room {
+				t.Errorf("migrated to version %d, room %q; want %d, %q", msg.SchemaVersion, msg.Room, CurrentSchemaVersion, tt.room)
+			}
+		})
+	}
+}
+
+func TestStoredMessagesMigratedOnLoad(t *testing.T) {
+	store := newMemoryStore(100)
+	saved := messageStore
+	messageStore = store
+	t.Cleanup(func() { messageStore = saved })
+	ctx := context.Background()
+
+	// Written by an older server, before rooms and versions
+	store.rooms[defaultRoom] = []Message{{ID: "old", Username: "alice", Content: "from v1"}}
+	store.byID["old"] = defaultRoom
+
+	msg, err := store.Get(ctx, "old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.SchemaVersion != CurrentSchemaVersion || msg.Room != defaultRoom {
+		t.Errorf("Get: version %d, room %q; want %d, %q", msg.SchemaVersion, msg.Room, CurrentSchemaVersion, defaultRoom)
+	}
+	recent, err := store.Recent(ctx, defaultRoom, 10)
+	if err != nil || len(recent) != 1 {
+		t.Fatalf("Recent = %v, %v", recent, err)
+	}
+	if recent[0].SchemaVersion != CurrentSchemaVersion || recent[0].Room != defaultRoom {
+		t.Errorf("Recent: version %d, room %q; want %d, %q", recent[0].SchemaVersion, recent[0].Room, CurrentSchemaVersion, defaultRoom)
+	}
+}
+
+func TestImportMigratesOldMessages(t *testing.T) {
+	useMemoryStore(t)
+
+	body := strings.Join([]string{
+		`{"id":"v0","username":"alice","content":"unversioned","timestamp":"2020-01-01T00:00:00Z"}`,
+		`{"schemaVersion":1,"id":"v1","username":"bob","content":"version one","timestamp":"2020-01-02T00:00:00Z"}`,
+	}, "\n")
+	req, _ := http.NewRequest(http.MethodPost, "/admin/import", strings.NewReader(body))
+	if rec := serveTestRequest("/admin/import", req, handleImportMessages); rec.Code != http.StatusOK {
+		t.Fatalf("import: status %d: %s", rec.Code, rec.Body)
+	}
+
+	recent, _ := messageStore.Recent(context.Background(), defaultRoom, 10)
+	if len(recent) != 2 {
+		t.Fatalf("%d messages in %s, want both imported there", len(recent), defaultRoom)
+	}
+	for _, msg := range recent {
+		if msg.SchemaVersion != CurrentSchemaVersion || msg.Seq == 0 {
+			t.Errorf("message %s: version %d, seq %d; want version %d with a sequence number", msg.ID, msg.SchemaVersion, msg.Seq, CurrentSchemaVersion)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+// search.go
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Longest search query, in characters
+const maxSearchQueryLength = 200
+
+// Most characters of context a request may ask for on each side of a match
+const maxSnippetContext = 500
+
+// Snippet settings: characters of context kept on each side of a match,
+// and the markers placed around each match
+var (
+	snippetContext  int
+	highlightPrefix string
+	highlightSuffix string
+)
+
+// SearchResult is a message matching a search, with snippets of its
+// content around the matches
+type SearchResult struct {
+	Message  Message  `json:"message"`
+	Matches  int      `json:"matches"`
+	Snippets []string `json:"snippets"`
+}
+
+// Initialize search snippets from environment variables
+func initSearch() {
+	snippetContext = getEnvInt("SEARCH_SNIPPET_CONTEXT", 40)
+	if snippetContext < 0 {
+		log.Printf("Warning: SEARCH_SNIPPET_CONTEXT must not be negative, using 40")
+		snippetContext = 40
+	}
+	highlightPrefix = getEnvString("SEARCH_HIGHLIGHT_PREFIX", "<mark>")
+	highlightSuffix = getEnvString("SEARCH_HIGHLIGHT_SUFFIX", "</mark>")
+}
+
+// Find the case-insensitive matches of query in text, as rune offsets of
+// their starts; matches don't overlap
+func findMatches(text, query []rune) []int {
+	var starts []int
+	for i := 0; i+len(query) <= len(text); {
+		if equalFold(text[i:i+len(query)], query) {
+			starts = append(starts, i)
+			i += len(query)
+			continue
+		}
+		i++
+	}
+	return starts
+}
+
+// Report whether two rune slices of the same length are equal ignoring case
+func equalFold(a, b []rune) bool {
+	for i := range a {
+		if a[i] != b[i] && unicode.ToLower(a[i]) != unicode.ToLower(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Cut snippets of text around the matches of a query of queryLen runes,
+// with context runes on each side and each match wrapped in the markers.
+// Matches whose context overlaps share a snippet. Snippets that don't
+// reach the start or end of the text are marked with an ellipsis.
+func extractSnippets(text []rune, starts []int, queryLen, context int, prefix, suffix string) []string {
+	var snippets []string
+	for i := 0; i < len(starts); {
+		from := max(starts[i]-context, 0)
+		to := min(starts[i]+queryLen+context, len(text))
+
+		// Take in the following matches that fall within this snippet
+		j := i + 1
+		for j < len(starts) && starts[j]-context <= to {
+			to = min(starts[j]+queryLen+context, len(text))
+			j++
+		}
+
+		var b strings.Builder
+		if from > 0 {
+			b.WriteString("…")
+		}
+		pos := from
+		for _, start := range starts[i:j] {
+			b.WriteString(string(text[pos:start]))
+			b.WriteString(prefix)
+			b.WriteString(string(text[start : start+queryLen]))
+			b.WriteString(suffix)
+			pos = start + queryLen
+		}
+		b.WriteString(string(text[pos:to]))
+		if to < len(text) {
+			b.WriteString("…")
+		}
+		snippets = append(snippets, b.String())
+		i = j
+	}
+	return snippets
+}
+
+// Search the chat messages of a room (?room=, default room) for ?q=,
+// ignoring case, newest first; ?limit= sets how many results. Each result
+// has snippets of the message around its matches, with ?context=
+// characters on each side (SEARCH_SNIPPET_CONTEXT by default).
+func handleSearchMessages(c *gin.Context) {
+	room := c.DefaultQuery("room", defaultRoom)
+	if !canAccessRoom(c.Request.Context(), room, c.Query("username")) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not a member of this room"})
+		return
+	}
+
+	query := []rune(strings.TrimSpace(c.Query("q")))
+	if len(query) == 0 || len(query) > maxSearchQueryLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q must be 1 to " + strconv.Itoa(maxSearchQueryLength) + " characters"})
+		return
+	}
+	limit, ok := historyLimit(c)
+	if !ok {
+		return
+	}
+	contextLen := snippetContext
+	if value := c.Query("context"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 || n > maxSnippetContext {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "context must be 0 to " + strconv.Itoa(maxSnippetContext)})
+			return
+		}
+		contextLen = n
+	}
+
+	messages, err := messageStore.Recent(c.Request.Context(), room, 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load messages"})
+		log.Printf("Error loading messages to search: %v", err)
+		return
+	}
+
+	results := []SearchResult{}
+	for i := len(messages) - 1; i >= 0 && len(results) < limit; i-- {
+		msg := messages[i]
+		if msg.Type != "" {
+			continue
+		}
+		content := []rune(msg.Content)
+		starts := findMatches(content, query)
+		if len(starts) == 0 {
+			continue
+		}
+		results = append(results, SearchResult{
+			Message:  msg,
+			Matches:  len(starts),
+			Snippets: extractSnippets(content, starts, len(query), contextLen, highlightPrefix, highlightSuffix),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"room": room, "query": string(query), "results": results})
+}
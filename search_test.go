@@ -0,0 +1,112 @@
+// search_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestFindMatches(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		query string
+		want  []int
+	}{
+		{"no match", "hello world", "bye", nil},
+		{"single", "hello world", "world", []int{6}},
+		{"ignores case", "Hello HELLO hello", "hello", []int{0, 6, 12}},
+		{"no overlap", "aaaa", "aa", []int{0, 2}},
+		{"runes not bytes", "héllo wörld wörld", "WÖRLD", []int{6, 12}},
+		{"query longer than text", "hi", "hello", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := findMatches([]rune(tt.text), []rune(tt.query))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("findMatches(%q, %q) = %v, want %v", tt.text, tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractSnippets(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		query   string
+		context int
+		want    []string
+	}{
+		{"whole text", "say hello", "hello", 10, []string{"say [hello]"}},
+		{"ellipsis both sides", "one two three four five", "three", 4, []string{"…two [three] fou…"}},
+		{"ellipsis at start only", "one two three", "three", 2, []string{"…o [three]"}},
+		{"overlapping context shares a snippet", "cat and cat", "cat", 5, []string{"[cat] and [cat]"}},
+		{"distant matches are split", "cat xxxxxxxxxxxxxxxx cat", "cat", 1, []string{"[cat] …", "… [cat]"}},
+		{"zero context", "a cat here", "cat", 0, []string{"…[cat]…"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text := []rune(tt.text)
+			starts := findMatches(text, []rune(tt.query))
+			got := extractSnippets(text, starts, len([]rune(tt.query)), tt.context, "[", "]")
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("extractSnippets(%q, %q, %d) = %q, want %q", tt.text, tt.query, tt.context, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleSearchMessages(t *testing.T) {
+	useMemoryStore(t)
+	ctx := context.Background()
+	for _, content := range []string{"the cat sat", "a dog", "Cat and cat"} {
+		if err := messageStore.Insert(ctx, &Message{ID: uuid.New().String(), Room: "general", Username: "bob", Content: content}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		target   string
+		code     int
+		snippets [][]string // per result, newest first
+	}{
+		{"newest first", "/messages/search?q=cat&context=0", http.StatusOK, [][]string{{"[Cat]…", "…[cat]"}, {"…[cat]…"}}},
+		{"limit", "/messages/search?q=cat&limit=1&context=50", http.StatusOK, [][]string{{"[Cat] and [cat]"}}},
+		{"no results", "/messages/search?q=bird", http.StatusOK, [][]string{}},
+		{"other room", "/messages/search?q=cat&room=random", http.StatusOK, [][]string{}},
+		{"missing query", "/messages/search?q=+", http.StatusBadRequest, nil},
+		{"bad context", "/messages/search?q=cat&context=-1", http.StatusBadRequest, nil},
+	}
+	highlightPrefix, highlightSuffix = "[", "]"
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serveTest(http.MethodGet, "/messages/search", tt.target, nil, handleSearchMessages)
+			if rec.Code != tt.code {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.code, rec.Body)
+			}
+			if tt.code != http.StatusOK {
+				return
+			}
+			var resp struct {
+				Results []SearchResult `json:"results"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			got := [][]string{}
+			for _, result := range resp.Results {
+				got = append(got, result.Snippets)
+			}
+			if !reflect.DeepEqual(got, tt.snippets) {
+				t.Errorf("snippets = %q, want %q", got, tt.snippets)
+			}
+		})
+	}
+}
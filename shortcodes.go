@@ -0,0 +1,87 @@
+// shortcodes.go
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Matches :name: shortcodes
+var shortcodePattern = regexp.MustCompile(`:([a-zA-Z0-9_+\-]+):`)
+
+// Built-in shortcode table, used when SHORTCODES_FILE is not set
+var defaultShortcodes = map[string]string{
+	"smile":      "😄",
+	"laughing":   "😆",
+	"wink":       "😉",
+	"heart":      "❤️",
+	"thumbsup":   "👍",
+	"+1":         "👍",
+	"thumbsdown": "👎",
+	"-1":         "👎",
+	"fire":       "🔥",
+	"tada":       "🎉",
+	"eyes":       "👀",
+	"rocket":     "🚀",
+	"cry":        "😢",
+	"thinking":   "🤔",
+	"wave":       "👋",
+	"ok_hand":    "👌",
+	"clap":       "👏",
+	"100":        "💯",
+}
+
+// Shortcode table (nil when expansion is disabled)
+var shortcodes map[string]string
+
+// Initialize shortcode expansion from environment variables.
+// SHORTCODES_FILE points to a JSON object mapping names (without colons) to
+// unicode emoji or custom image URLs.
+func initShortcodes() {
+	if !getEnvBool("SHORTCODES_ENABLED", false) {
+		return
+	}
+
+	shortcodes = defaultShortcodes
+	if path := os.Getenv("SHORTCODES_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("Error reading shortcodes file: %v", err)
+		}
+		table := make(map[string]string)
+		if err := json.Unmarshal(data, &table); err != nil {
+			log.Fatalf("Error parsing shortcodes file: %v", err)
+		}
+		shortcodes = table
+	}
+	log.Printf("Shortcode expansion enabled (%d shortcodes)", len(shortcodes))
+}
+
+// Matches `code spans` and ```fenced blocks```, whose text is shown verbatim
+var codeSpanPattern = regexp.MustCompile("(?s)```.*?```|`[^`\n]*`")
+
+// Replace known :shortcodes: in content; unknown shortcodes and those
+// inside code spans are left untouched
+func expandShortcodes(content string, table map[string]string) string {
+	expand := func(text string) string {
+		return shortcodePattern.ReplaceAllStringFunc(text, func(match string) string {
+			if value, ok := table[match[1:len(match)-1]]; ok {
+				return value
+			}
+			return match
+		})
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, span := range codeSpanPattern.FindAllStringIndex(content, -1) {
+		b.WriteString(expand(content[last:span[0]]))
+		b.WriteString(content[span[0]:span[1]])
+		last = span[1]
+	}
+	b.WriteString(expand(content[last:]))
+	return b.String()
+}
@@ -0,0 +1,89 @@
+// shortcodes_test.go
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExpandShortcodes(t *testing.T) {
+	table := map[string]string{
+		"smile":  "😄",
+		"+1":     "👍",
+		"parrot": "https://cdn.example.com/parrot.gif",
+	}
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"known", "hi :smile:", "hi 😄"},
+		{"several", ":smile::+1: :smile:", "😄👍 😄"},
+		{"custom image URL", "party :parrot:", "party https://cdn.example.com/parrot.gif"},
+		{"unknown left untouched", "hi :nope: :smile:", "hi :nope: 😄"},
+		{"not a shortcode", "at 10:30: done", "at 10:30: done"},
+		{"case-sensitive", ":SMILE:", ":SMILE:"},
+		{"code span", "type `:smile:` to get :smile:", "type `:smile:` to get 😄"},
+		{"fenced block", "```\n:smile:\n``` :smile:", "```\n:smile:\n``` 😄"},
+		{"unclosed backtick", "it's ` :smile:", "it's ` 😄"},
+		{"no shortcodes", "plain text", "plain text"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := expandShortcodes(tt.in, table); got != tt.want {
+				t.Errorf("expandShortcodes(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShortcodesInMessages(t *testing.T) {
+	useMemoryStore(t)
+	saved := shortcodes
+	t.Cleanup(func() { shortcodes = saved })
+	client := &Client{username: "bob", room: "general", send: make(chan Message, 10)}
+	useClients(t, client)
+
+	tests := []struct {
+		name     string
+		enabled  bool
+		content  string
+		expanded string
+	}{
+		{"expanded", true, "ship it :rocket:", "ship it 🚀"},
+		{"nothing to expand", true, "ship it", ""},
+		{"unknown only", true, "ship it :nope:", ""},
+		{"disabled", false, "ship it :rocket:", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shortcodes = nil
+			if tt.enabled {
+				shortcodes = defaultShortcodes
+			}
+			handleMessage(Message{ID: tt.name, Room: "general", Username: "alice", Content: tt.content})
+			msg := <-client.send
+			// The raw text is kept alongside the expansion
+			if msg.Content != tt.content || msg.ExpandedContent != tt.expanded {
+				t.Errorf("content %q, expanded %q; want %q, %q", msg.Content, msg.ExpandedContent, tt.content, tt.expanded)
+			}
+		})
+	}
+}
+
+func TestInitShortcodesFromFile(t *testing.T) {
+	saved := shortcodes
+	t.Cleanup(func() { shortcodes = saved })
+	path := filepath.Join(t.TempDir(), "shortcodes.json")
+	if err := os.WriteFile(path, []byte(`{"shipit": "🐿️"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SHORTCODES_ENABLED", "true")
+	t.Setenv("SHORTCODES_FILE", path)
+	initShortcodes()
+
+	if got := expandShortcodes(":shipit: :smile:", shortcodes); got != "🐿️ :smile:" {
+		t.Errorf("expanded = %q, want only the configured shortcode expanded", got)
+	}
+}
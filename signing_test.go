@@ -0,0 +1,146 @@
+// signing_test.go
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Turn on signing with keys "a" and "b" (b active) for a test
+func useSigningKeys(t *testing.T) {
+	t.Helper()
+	seed := func(b byte) string {
+		return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, ed25519.SeedSize))
+	}
+	t.Setenv("MESSAGE_SIGNING_KEYS", "a="+seed(1)+", b="+seed(2))
+	t.Setenv("MESSAGE_SIGNING_KEY_ID", "b")
+	initSigning()
+	t.Cleanup(func() { signingKeys, signingKeyID = nil, "" })
+}
+
+func TestSigningPayload(t *testing.T) {
+	msg := Message{
+		ID:        "m1",
+		Room:      "general",
+		Username:  "bob",
+		Content:   "hi",
+		Seq:       7,
+		Timestamp: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+	want := `{"id":"m1","type":"","room":"general","username":"bob","content":"hi","fileUrl":"","fileName":"","messageId":"","replyTo":"","threadRootId":"","timestamp":"2024-05-01T12:00:00Z"}`
+	if got := string(signingPayload(msg)); got != want {
+		t.Errorf("signingPayload = %s, want %s", got, want)
+	}
+
+	// Fields that change after sending are not covered
+	later := msg
+	later.Seq = 8
+	later.ReplyCount = 3
+	if !bytes.Equal(signingPayload(later), signingPayload(msg)) {
+		t.Error("signingPayload covers fields that change after sending")
+	}
+}
+
+func TestVerifyMessage(t *testing.T) {
+	useSigningKeys(t)
+	signed := func() Message {
+		msg := Message{ID: "m1", Room: "general", Username: "bob", Content: "hi", Timestamp: time.Now()}
+		signMessage(&msg)
+		return msg
+	}
+
+	tests := []struct {
+		name   string
+		change func(*Message)
+		want   error
+	}{
+		{"valid", func(*Message) {}, nil},
+		{"sent over JSON", func(m *Message) {
+			data, _ := json.Marshal(m)
+			*m = Message{}
+			json.Unmarshal(data, m)
+		}, nil},
+		{"unsigned", func(m *Message) { m.Signature = "" }, errNoSignature},
+		{"unknown key", func(m *Message) { m.SignatureKeyID = "c" }, errUnknownKeyID},
+		{"wrong key", func(m *Message) { m.SignatureKeyID = "a" }, errBadSignature},
+		{"not base64", func(m *Message) { m.Signature = "%%%" }, errBadSignature},
+		{"content changed", func(m *Message) { m.Content = "bye" }, errBadSignature},
+		{"room changed", func(m *Message) { m.Room = "random" }, errBadSignature},
+		{"timestamp changed", func(m *Message) { m.Timestamp = m.Timestamp.Add(time.Second) }, errBadSignature},
+		{"sequence changed", func(m *Message) { m.Seq = 42 }, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := signed()
+			tt.change(&msg)
+			if err := verifyMessage(msg); !errors.Is(err, tt.want) {
+				t.Errorf("verifyMessage = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyMessageAfterRotation(t *testing.T) {
+	useSigningKeys(t)
+	signingKeyID = "a"
+	msg := Message{ID: "m1", Content: "hi", Timestamp: time.Now()}
+	signMessage(&msg)
+
+	signingKeyID = "b"
+	if err := verifyMessage(msg); err != nil {
+		t.Errorf("message signed with the old key: verifyMessage = %v", err)
+	}
+}
+
+func TestHandleVerifyMessage(t *testing.T) {
+	useSigningKeys(t)
+	msg := Message{ID: "m1", Content: "hi", Timestamp: time.Now()}
+	signMessage(&msg)
+	valid, _ := json.Marshal(msg)
+	msg.Content = "bye"
+	tampered, _ := json.Marshal(msg)
+
+	tests := []struct {
+		name string
+		body string
+		code int
+		want string
+	}{
+		{"valid", string(valid), http.StatusOK, `"valid":true`},
+		{"tampered", string(tampered), http.StatusOK, `"valid":false`},
+		{"not a message", "nope", http.StatusBadRequest, `"error"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serveTest(http.MethodPost, "/signing-keys/verify", "/signing-keys/verify", strings.NewReader(tt.body), handleVerifyMessage)
+			if rec.Code != tt.code || !strings.Contains(rec.Body.String(), tt.want) {
+				t.Errorf("got %d %s, want %d containing %s", rec.Code, rec.Body, tt.code, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleListSigningKeys(t *testing.T) {
+	useSigningKeys(t)
+	rec := serveTest(http.MethodGet, "/signing-keys", "/signing-keys", nil, handleListSigningKeys)
+	var resp struct {
+		Keys []SigningKey `json:"keys"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Keys) != 2 || resp.Keys[0].ID != "a" || resp.Keys[0].Active || resp.Keys[1].ID != "b" || !resp.Keys[1].Active {
+		t.Fatalf("keys = %+v, want a and active b", resp.Keys)
+	}
+	public, _ := base64.StdEncoding.DecodeString(resp.Keys[1].PublicKey)
+	if !ed25519.PublicKey(public).Equal(signingKeys["b"].Public()) {
+		t.Error("listed public key does not match the signing key")
+	}
+}
@@ -0,0 +1,406 @@
+// slackimport.go
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+)
+
+// Most error descriptions returned by one Slack import
+const maxSlackImportErrors = 100
+
+// Slack subtypes of messages that users wrote; joins, topic changes, bot
+// posts and the like are skipped
+var slackUserSubtypes = map[string]bool{
+	"":                 true,
+	"thread_broadcast": true,
+	"file_share":       true,
+	"me_message":       true,
+}
+
+// Slack markup for mentions (<@U123> or <@U123|name>) and links
+// (<https://example.com|label>)
+var (
+	slackMentionPattern = regexp.MustCompile(`<@([A-Z0-9]+)(?:\|[^>]*)?>`)
+	slackLinkPattern    = regexp.MustCompile(`<((?:https?|mailto):[^|>]+)(?:\|([^>]*))?>`)
+)
+
+// Slack import settings
+var (
+	importAttachments bool   // download files referenced by imported messages
+	slackImportToken  string // sent as a bearer token when downloading files
+	slackFileClient   *http.Client
+)
+
+// Initialize Slack imports from environment variables. With
+// IMPORT_ATTACHMENTS=true the files referenced by imported messages are
+// downloaded into the room, authenticating with SLACK_IMPORT_TOKEN if set;
+// otherwise they are skipped.
+func initSlackImport() {
+	importAttachments = getEnvBool("IMPORT_ATTACHMENTS", false)
+	slackImportToken = os.Getenv("SLACK_IMPORT_TOKEN")
+	slackFileClient = &http.Client{Timeout: 60 * time.Second, Transport: outboundTransport(true)}
+}
+
+// A message in a Slack export's per-channel, per-day JSON files
+type slackMessage struct {
+	Type     string      `json:"type"`
+	Subtype  string      `json:"subtype"`
+	User     string      `json:"user"`
+	Text     string      `json:"text"`
+	TS       string      `json:"ts"`
+	ThreadTS string      `json:"thread_ts"`
+	Files    []slackFile `json:"files"`
+}
+
+// A file attached to a Slack message
+type slackFile struct {
+	Name               string `json:"name"`
+	URLPrivateDownload string `json:"url_private_download"`
+	URLPrivate         string `json:"url_private"`
+}
+
+// A user in a Slack export's users.json
+type slackUser struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// Collects the outcome of one Slack import
+type slackImport struct {
+	room     string
+	users    map[string]string // Slack user ID -> username
+	imported int
+	skipped  int
+	errors   []string
+}
+
+// Record a problem with part of the export
+func (imp *slackImport) errorf(format string, args ...any) {
+	if len(imp.errors) < maxSlackImportErrors {
+		imp.errors = append(imp.errors, fmt.Sprintf(format, args...))
+	}
+}
+
+// Import message history from a Slack export into a room (admin only). The
+// multipart body carries the export ZIP as "export" and, optionally, a JSON
+// object mapping Slack user IDs to usernames as "mapping". Users missing
+// from the mapping get their name from the export's users.json; messages
+// from users found in neither are skipped. "channel" limits the import to
+// one channel; by default every channel in the export goes into the room.
+func handleSlackImport(c *gin.Context) {
+	room := c.Param("room")
+	if !validRoomName(room) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid room name"})
+		return
+	}
+	if !parseUploadForm(c) {
+		return
+	}
+	defer c.Request.MultipartForm.RemoveAll()
+
+	file, header, err := c.Request.FormFile("export")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No export provided", "code": "ERR_FILE_MISSING"})
+		return
+	}
+	defer file.Close()
+	archive, err := zip.NewReader(file, header.Size)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Export is not a valid ZIP file"})
+		return
+	}
+
+	imp := &slackImport{room: room, users: make(map[string]string)}
+	if err := imp.loadUsers(archive); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid users.json in export"})
+		return
+	}
+	if mapping, _, err := c.Request.FormFile("mapping"); err == nil {
+		defer mapping.Close()
+		var users map[string]string
+		if err := json.NewDecoder(mapping).Decode(&users); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "mapping must be a JSON object of Slack user IDs to usernames"})
+			return
+		}
+		for id, username := range users {
+			imp.users[id] = normalizeUsername(username)
+		}
+	}
+
+	channel := c.PostForm("channel")
+	messages := imp.readChannels(archive, channel)
+	if channel != "" && len(messages) == 0 && imp.skipped == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Channel not found in export", "channel": channel})
+		return
+	}
+
+	// Sequence numbers follow insertion order, so insert oldest first
+	sort.SliceStable(messages, func(i, j int) bool { return messages[i].Timestamp.Before(messages[j].Timestamp) })
+	if importAttachments {
+		messages = imp.downloadAttachments(c.Request.Context(), messages)
+	}
+	for start := 0; start < len(messages); start += batchInsertSize {
+		batch := messages[start:min(start+batchInsertSize, len(messages))]
+		if err := messageStore.BatchInsert(c.Request.Context(), batch); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store messages", "imported": imp.imported})
+			log.Printf("Error importing Slack messages: %v", err)
+			return
+		}
+		imp.imported += len(batch)
+	}
+
+	log.Printf("Imported %d Slack messages into room %s (%d skipped)", imp.imported, room, imp.skipped)
+	c.JSON(http.StatusOK, gin.H{"imported": imp.imported, "skipped": imp.skipped, "errors": imp.errorList()})
+}
+
+// Return the recorded errors, never nil so they encode as a JSON array
+func (imp *slackImport) errorList() []string {
+	if imp.errors == nil {
+		return []string{}
+	}
+	return imp.errors
+}
+
+// Read usernames from the export's users.json, if it has one
+func (imp *slackImport) loadUsers(archive *zip.Reader) error {
+	f, err := archive.Open("users.json")
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var users []slackUser
+	if err := json.NewDecoder(f).Decode(&users); err != nil {
+		return err
+	}
+	for _, user := range users {
+		if user.ID != "" && user.Name != "" {
+			imp.users[user.ID] = normalizeUsername(user.Name)
+		}
+	}
+	return nil
+}
+
+// Convert the messages of every channel in the export, or only of channel
+// if it is set. Each channel is a directory of per-day JSON files.
+func (imp *slackImport) readChannels(archive *zip.Reader, channel string) []Message {
+	var messages []Message
+	threads := make(map[string]string) // channel + thread ts -> message ID
+	unknownUsers := make(map[string]bool)
+	for _, f := range archive.File {
+		dir, name := path.Split(f.Name)
+		dir = strings.TrimSuffix(dir, "/")
+		if dir == "" || strings.Contains(dir, "/") || path.Ext(name) != ".json" {
+			continue
+		}
+		if channel != "" && dir != channel {
+			continue
+		}
+
+		var day []slackMessage
+		if err := readZipJSON(f, &day); err != nil {
+			imp.errorf("%s: %v", f.Name, err)
+			continue
+		}
+		for _, sm := range day {
+			msg, ok := imp.convert(dir, sm, threads, unknownUsers)
+			if !ok {
+				imp.skipped++
+				continue
+			}
+			messages = append(messages, msg)
+		}
+	}
+
+	// Replies may come before their thread's parent in file order
+	for i := range messages {
+		if messages[i].ReplyTo != "" {
+			messages[i].ReplyTo = threads[messages[i].ReplyTo]
+		}
+	}
+	return messages
+}
+
+// Decode a JSON file in the export
+func readZipJSON(f *zip.File, v any) error {
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return json.NewDecoder(r).Decode(v)
+}
+
+// Convert one Slack message. ReplyTo is left holding the thread's key,
+// which readChannels swaps for the parent's message ID.
+func (imp *slackImport) convert(channel string, sm slackMessage, threads map[string]string, unknownUsers map[string]bool) (Message, bool) {
+	if sm.Type != "message" || !slackUserSubtypes[sm.Subtype] {
+		return Message{}, false
+	}
+	username, ok := imp.users[sm.User]
+	if !ok || username == "" {
+		if !unknownUsers[sm.User] {
+			unknownUsers[sm.User] = true
+			imp.errorf("no username for Slack user %q", sm.User)
+		}
+		return Message{}, false
+	}
+	timestamp, err := parseSlackTS(sm.TS)
+	if err != nil {
+		imp.errorf("%s: invalid timestamp %q", channel, sm.TS)
+		return Message{}, false
+	}
+
+	msg := Message{
+		SchemaVersion: CurrentSchemaVersion,
+		ID:            uuid.New().String(),
+		Room:          imp.room,
+		Username:      username,
+		Content:       imp.convertText(sm.Text),
+		Timestamp:     timestamp,
+	}
+	threads[channel+"/"+sm.TS] = msg.ID
+	if sm.ThreadTS != "" && sm.ThreadTS != sm.TS {
+		msg.ReplyTo = channel + "/" + sm.ThreadTS
+	}
+
+	if len(sm.Files) > 0 && importAttachments {
+		file := sm.Files[0]
+		msg.FileName = file.Name
+		msg.FileURL = file.URLPrivateDownload
+		if msg.FileURL == "" {
+			msg.FileURL = file.URLPrivate
+		}
+		if len(sm.Files) > 1 {
+			imp.errorf("%s: message %s has %d files, only the first is imported", channel, sm.TS, len(sm.Files))
+		}
+	}
+	if msg.Content == "" && msg.FileURL == "" {
+		return Message{}, false
+	}
+	return msg, true
+}
+
+// Turn Slack's markup into plain text: mentions become @username, links
+// their label or URL, and escaped characters are unescaped
+func (imp *slackImport) convertText(text string) string {
+	text = slackMentionPattern.ReplaceAllStringFunc(text, func(m string) string {
+		id := slackMentionPattern.FindStringSubmatch(m)[1]
+		if username, ok := imp.users[id]; ok {
+			return "@" + username
+		}
+		return "@" + id
+	})
+	text = slackLinkPattern.ReplaceAllStringFunc(text, func(m string) string {
+		parts := slackLinkPattern.FindStringSubmatch(m)
+		if parts[2] != "" {
+			return parts[2] + " (" + parts[1] + ")"
+		}
+		return parts[1]
+	})
+	return html.UnescapeString(text)
+}
+
+// Parse a Slack timestamp: Unix seconds with a microsecond fraction
+func parseSlackTS(ts string) (time.Time, error) {
+	secs, frac, _ := strings.Cut(ts, ".")
+	s, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var micros int64
+	if frac != "" {
+		frac = (frac + "000000")[:6]
+		if micros, err = strconv.ParseInt(frac, 10, 64); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return time.Unix(s, micros*1000), nil
+}
+
+// Copy the files of imported messages into the room and return the
+// messages to store. Messages whose file can't be fetched keep their text,
+// or are dropped if they have none.
+func (imp *slackImport) downloadAttachments(ctx context.Context, messages []Message) []Message {
+	kept := messages[:0]
+	for _, msg := range messages {
+		if msg.FileURL == "" {
+			kept = append(kept, msg)
+			continue
+		}
+		objectName, info, size, err := imp.downloadFile(ctx, msg.FileURL, msg.FileName, msg.Username)
+		if err != nil {
+			imp.errorf("file %s: %v", msg.FileName, err)
+			msg.FileURL, msg.FileName = "", ""
+			if msg.Content == "" {
+				imp.skipped++
+				continue
+			}
+		} else {
+			msg.FileURL = fileDownloadURL(msg.ID, objectName, info.VersionID)
+			msg.FileSize = size
+			msg.VersionID = info.VersionID
+			msg.objectName = objectName
+			if msg.Content == "" {
+				msg.Content = fmt.Sprintf("shared a file: %s", msg.FileName)
+			}
+		}
+		kept = append(kept, msg)
+	}
+	return kept
+}
+
+// Download one Slack file and store it in the room
+func (imp *slackImport) downloadFile(ctx context.Context, fileURL, fileName, username string) (string, minio.UploadInfo, int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return "", minio.UploadInfo{}, 0, err
+	}
+	if slackImportToken != "" {
+		req.Header.Set("Authorization", "Bearer "+slackImportToken)
+	}
+	resp, err := slackFileClient.Do(req)
+	if err != nil {
+		return "", minio.UploadInfo{}, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", minio.UploadInfo{}, 0, fmt.Errorf("download returned HTTP %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxRequestBodyBytes+1))
+	if err != nil {
+		return "", minio.UploadInfo{}, 0, err
+	}
+	if int64(len(data)) > maxRequestBodyBytes {
+		return "", minio.UploadInfo{}, 0, fmt.Errorf("file is larger than %d bytes", maxRequestBodyBytes)
+	}
+
+	objectName := fmt.Sprintf("%s/%s-%s%s", imp.room, time.Now().Format("20060102-150405"), uuid.New().String()[0:8], path.Ext(fileName))
+	info, err := minioClient.PutObject(ctx, bucketName, objectName, bytes.NewReader(data), int64(len(data)), uploadPutOptions(minio.PutObjectOptions{
+		ContentType: http.DetectContentType(data),
+		UserMetadata: map[string]string{
+			"uploader": url.PathEscape(username),
+			"filename": url.PathEscape(fileName),
+		},
+	}))
+	return objectName, info, int64(len(data)), err
+}
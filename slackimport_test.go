@@ -0,0 +1,192 @@
+// slackimport_test.go
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A small Slack export: two users, a thread whose reply is in an earlier
+// day's file than its parent, a join, a message from an unknown user and a
+// second channel
+var slackExportFixture = map[string]string{
+	"users.json":    `[{"id": "U1", "name": "alice"}, {"id": "U2", "name": "bob"}]`,
+	"channels.json": `[{"id": "C1", "name": "general"}, {"id": "C2", "name": "random"}]`,
+	"general/2024-01-01.json": `[
+		{"type": "message", "subtype": "channel_join", "user": "U2", "text": "<@U2> has joined the channel", "ts": "1704103200.000100"},
+		{"type": "message", "user": "U2", "text": "agreed", "ts": "1704189700.000300", "thread_ts": "1704189600.000200"}
+	]`,
+	"general/2024-01-02.json": `[
+		{"type": "message", "user": "U1", "text": "hey <@U2>, see <https://example.com|the plan> &amp; reply", "ts": "1704189600.000200", "thread_ts": "1704189600.000200"},
+		{"type": "message", "user": "U9", "text": "who am I", "ts": "1704189800.000400"}
+	]`,
+	"random/2024-01-02.json": `[
+		{"type": "message", "user": "U1", "text": "lunch?", "ts": "1704190000.000500"}
+	]`,
+}
+
+// Build an export ZIP from file names and contents
+func slackExportZip(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// Post an export to a room's import endpoint with optional form fields
+func serveSlackImport(t *testing.T, room string, export []byte, fields map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for name, value := range fields {
+		if name == "mapping" {
+			part, _ := form.CreateFormFile("mapping", "mapping.json")
+			part.Write([]byte(value))
+			continue
+		}
+		form.WriteField(name, value)
+	}
+	part, _ := form.CreateFormFile("export", "export.zip")
+	part.Write(export)
+	form.Close()
+	req := httptest.NewRequest(http.MethodPost, "/rooms/"+room+"/import", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return serveTestRequest("/rooms/:room/import", req, handleSlackImport)
+}
+
+// Decode an import response
+func slackImportResult(t *testing.T, rec *httptest.ResponseRecorder) (imported, skipped int, errors []string) {
+	t.Helper()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var result struct {
+		Imported int      `json:"imported"`
+		Skipped  int      `json:"skipped"`
+		Errors   []string `json:"errors"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	return result.Imported, result.Skipped, result.Errors
+}
+
+func TestSlackImportFixture(t *testing.T) {
+	captureLog(t)
+	useMemoryStore(t)
+
+	rec := serveSlackImport(t, "imported", slackExportZip(t, slackExportFixture), map[string]string{"mapping": `{"U2": " Robert  B "}`})
+	imported, skipped, errors := slackImportResult(t, rec)
+	if imported != 3 || skipped != 2 {
+		t.Errorf("imported %d, skipped %d; want 3 and 2", imported, skipped)
+	}
+	if len(errors) != 1 || !strings.Contains(errors[0], "U9") {
+		t.Errorf("errors %q, want one naming the unknown user U9", errors)
+	}
+
+	messages, err := messageStore.Recent(context.Background(), "imported", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 3 {
+		t.Fatalf("stored %d messages, want 3", len(messages))
+	}
+	// Stored oldest first, whatever the order in the export
+	parent, reply, lunch := messages[0], messages[1], messages[2]
+	if parent.Username != "alice" || parent.Content != "hey @Robert B, see the plan (https://example.com) & reply" {
+		t.Errorf("parent = %s: %q, want the markup converted", parent.Username, parent.Content)
+	}
+	if !parent.Timestamp.Equal(time.Unix(1704189600, 200000)) {
+		t.Errorf("parent timestamp = %v, want the Slack ts", parent.Timestamp)
+	}
+	if reply.Username != "Robert B" || reply.Content != "agreed" || reply.ReplyTo != parent.ID {
+		t.Errorf("reply = %+v, want Robert B replying to %s", reply, parent.ID)
+	}
+	if lunch.Content != "lunch?" || lunch.Room != "imported" {
+		t.Errorf("last message = %+v, want the random channel's message in the room", lunch)
+	}
+}
+
+func TestSlackImportChannel(t *testing.T) {
+	captureLog(t)
+	useMemoryStore(t)
+	export := slackExportZip(t, slackExportFixture)
+
+	imported, _, _ := slackImportResult(t, serveSlackImport(t, "imported", export, map[string]string{"channel": "random"}))
+	if imported != 1 {
+		t.Errorf("imported %d from random, want 1", imported)
+	}
+	if rec := serveSlackImport(t, "imported", export, map[string]string{"channel": "missing"}); rec.Code != http.StatusBadRequest {
+		t.Errorf("missing channel: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if rec := serveSlackImport(t, "imported", []byte("not a zip"), nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid ZIP: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestSlackImportAttachments(t *testing.T) {
+	captureLog(t)
+	useMemoryStore(t)
+	fake := useFakeS3(t, bucketName)
+	savedAttachments, savedToken, savedClient := importAttachments, slackImportToken, slackFileClient
+	importAttachments, slackImportToken, slackFileClient = true, "xoxe-token", http.DefaultClient
+	t.Cleanup(func() {
+		importAttachments, slackImportToken, slackFileClient = savedAttachments, savedToken, savedClient
+	})
+
+	files := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer xoxe-token" || r.URL.Path != "/files/notes.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("meeting notes"))
+	}))
+	defer files.Close()
+
+	export := slackExportZip(t, map[string]string{
+		"users.json": `[{"id": "U1", "name": "alice"}]`,
+		"general/2024-01-01.json": `[
+			{"type": "message", "subtype": "file_share", "user": "U1", "text": "", "ts": "1704103200.000100",
+				"files": [{"name": "notes.txt", "url_private_download": "` + files.URL + `/files/notes.txt"}]},
+			{"type": "message", "subtype": "file_share", "user": "U1", "text": "gone", "ts": "1704103300.000200",
+				"files": [{"name": "gone.txt", "url_private_download": "` + files.URL + `/files/gone.txt"}]}
+		]`,
+	})
+	imported, _, errors := slackImportResult(t, serveSlackImport(t, "general", export, nil))
+	if imported != 2 || len(errors) != 1 || !strings.Contains(errors[0], "gone.txt") {
+		t.Errorf("imported %d with errors %q, want 2 and one for gone.txt", imported, errors)
+	}
+
+	messages, _ := messageStore.Recent(context.Background(), "general", 10)
+	if len(messages) != 2 {
+		t.Fatalf("stored %d messages, want 2", len(messages))
+	}
+	withFile, withoutFile := messages[0], messages[1]
+	if withFile.FileName != "notes.txt" || withFile.FileSize != 13 || withFile.Content != "shared a file: notes.txt" {
+		t.Errorf("file message = %+v, want notes.txt copied into the room", withFile)
+	}
+	if obj := fake.object(bucketName, withFile.objectName); obj == nil || string(obj.data) != "meeting notes" {
+		t.Errorf("object %q not stored with the downloaded file", withFile.objectName)
+	}
+	if withoutFile.FileURL != "" || withoutFile.Content != "gone" {
+		t.Errorf("message with an unavailable file = %+v, want its text kept", withoutFile)
+	}
+}
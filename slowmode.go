@@ -0,0 +1,196 @@
+// slowmode.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Object in the state bucket holding each room's slow-mode interval in
+// seconds
+const slowModeObject = "config/slowmode.json"
+
+// Longest slow-mode interval a moderator may set
+const maxSlowModeSeconds = 6 * 60 * 60
+
+// Serializes slow-mode changes so each save includes the ones before it
+var slowModeMu sync.Mutex
+
+// Initialize slow mode from the intervals saved before a restart
+func initSlowMode(ctx context.Context) {
+	intervals := make(map[string]int)
+	if err := loadConfigObject(ctx, slowModeObject, &intervals); err != nil {
+		log.Fatalf("Error loading slow mode settings: %v", err)
+	}
+	for name, seconds := range intervals {
+		room := getRoom(name)
+		room.mu.Lock()
+		room.slowMode = time.Duration(seconds) * time.Second
+		room.mu.Unlock()
+	}
+}
+
+// Check a user's message against the room's slow mode. Returns how long
+// the user must still wait, or 0 when the message may be sent. Checking
+// doesn't count as sending: once the message has gone out, record it with
+// recordSlowModeSend.
+func slowModeWait(name, username string, now time.Time) time.Duration {
+	room := findRoom(name)
+	if room == nil {
+		return 0
+	}
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	if room.slowMode <= 0 {
+		return 0
+	}
+	return max(room.lastSent[username].Add(room.slowMode).Sub(now), 0)
+}
+
+// Record a user's message sent at now as their latest in a slow-mode room
+func recordSlowModeSend(name, username string, now time.Time) {
+	room := findRoom(name)
+	if room == nil {
+		return
+	}
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	if room.slowMode <= 0 {
+		return
+	}
+	if room.lastSent == nil {
+		room.lastSent = make(map[string]time.Time)
+	}
+	room.lastSent[username] = now
+	// Forget users who could send again anyway so the map stays small
+	if len(room.lastSent) > 1000 {
+		for user, sent := range room.lastSent {
+			if now.Sub(sent) >= room.slowMode {
+				delete(room.lastSent, user)
+			}
+		}
+	}
+}
+
+// Build the nack sent to a client whose message came too soon
+func slowModeNack(wait time.Duration) Message {
+	return Message{
+		ID:           uuid.New().String(),
+		Type:         MessageTypeNack,
+		Username:     "System",
+		Content:      fmt.Sprintf("Slow mode is on. You can send another message in %d seconds.", int(math.Ceil(wait.Seconds()))),
+		Reason:       "slow_mode",
+		RetryAfterMs: wait.Milliseconds(),
+		Timestamp:    time.Now(),
+	}
+}
+
+// Respond with 429 when a message sent over HTTP comes too soon; returns
+// false in that case. Moderators are exempt. Like slowModeWait, this only
+// checks; the caller records the message once it is sent.
+func checkSlowMode(c *gin.Context, room, username string) bool {
+	if isModerator(c) {
+		return true
+	}
+	wait := slowModeWait(room, username, time.Now())
+	if wait <= 0 {
+		return true
+	}
+	seconds := int(math.Ceil(wait.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":             "Slow mode is on",
+		"retryAfterSeconds": seconds,
+	})
+	return false
+}
+
+// Body of PUT /rooms/:room/slow-mode
+type slowModeRequest struct {
+	Seconds *int `json:"seconds" binding:"required"`
+}
+
+// Set a room's slow-mode interval; 0 turns slow mode off. The change is
+// announced in the room.
+func handleSetSlowMode(c *gin.Context) {
+	name := c.Param("room")
+	if !validRoomName(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid room name"})
+		return
+	}
+	var req slowModeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		if limit, ok := isBodyTooLarge(err); ok {
+			abortBodyTooLarge(c, limit)
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "seconds is required"})
+		return
+	}
+	seconds := *req.Seconds
+	if seconds < 0 || seconds > maxSlowModeSeconds {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("seconds must be between 0 and %d", maxSlowModeSeconds)})
+		return
+	}
+
+	if err := setSlowMode(c.Request.Context(), name, seconds); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save slow mode setting"})
+		log.Printf("Error saving slow mode settings: %v", err)
+		return
+	}
+
+	content := "Slow mode is off"
+	if seconds > 0 {
+		content = fmt.Sprintf("Slow mode is on: one message every %d seconds", seconds)
+	}
+	publish(Message{
+		ID:              uuid.New().String(),
+		Type:            MessageTypeSlowMode,
+		Room:            name,
+		Username:        "System",
+		Content:         content,
+		SlowModeSeconds: &seconds,
+		Timestamp:       time.Now(),
+	})
+	c.JSON(http.StatusOK, gin.H{"room": name, "slowModeSeconds": seconds})
+}
+
+// Save a room's slow-mode interval and apply it. Turning slow mode off or
+// changing the interval starts every user afresh.
+func setSlowMode(ctx context.Context, name string, seconds int) error {
+	slowModeMu.Lock()
+	defer slowModeMu.Unlock()
+
+	roomConfigsMu.Lock()
+	intervals := make(map[string]int)
+	for other, room := range roomConfigs {
+		room.mu.RLock()
+		if room.slowMode > 0 && other != name {
+			intervals[other] = int(room.slowMode / time.Second)
+		}
+		room.mu.RUnlock()
+	}
+	roomConfigsMu.Unlock()
+	if seconds > 0 {
+		intervals[name] = seconds
+	}
+	if err := saveConfigObject(ctx, slowModeObject, intervals); err != nil {
+		return err
+	}
+
+	room := getRoom(name)
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	room.slowMode = time.Duration(seconds) * time.Second
+	room.lastSent = nil
+	return nil
+}
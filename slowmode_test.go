@@ -0,0 +1,116 @@
+// slowmode_test.go
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Put a room in slow mode for a test
+func useSlowMode(t *testing.T, name string, interval time.Duration) {
+	t.Helper()
+	room := getRoom(name)
+	room.mu.Lock()
+	room.slowMode = interval
+	room.lastSent = nil
+	room.mu.Unlock()
+	t.Cleanup(func() {
+		roomConfigsMu.Lock()
+		delete(roomConfigs, name)
+		roomConfigsMu.Unlock()
+	})
+}
+
+func TestSlowModeWait(t *testing.T) {
+	useSlowMode(t, "slow", 10*time.Second)
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	steps := []struct {
+		name     string
+		room     string
+		username string
+		at       time.Duration // after start
+		record   bool          // record a send after checking
+		want     time.Duration
+	}{
+		{"first message", "slow", "bob", 0, true, 0},
+		{"too soon", "slow", "bob", 3 * time.Second, false, 7 * time.Second},
+		{"checking doesn't count as sending", "slow", "bob", 4 * time.Second, false, 6 * time.Second},
+		{"other user", "slow", "alice", 4 * time.Second, true, 0},
+		{"interval passed", "slow", "bob", 10 * time.Second, true, 0},
+		{"counted from the last send", "slow", "bob", 12 * time.Second, false, 8 * time.Second},
+		{"room without slow mode", "fast", "bob", 12 * time.Second, true, 0},
+		{"unknown room", "nowhere", "bob", 12 * time.Second, false, 0},
+	}
+	for _, step := range steps {
+		now := start.Add(step.at)
+		if got := slowModeWait(step.room, step.username, now); got != step.want {
+			t.Errorf("%s: slowModeWait = %v, want %v", step.name, got, step.want)
+		}
+		if step.record {
+			recordSlowModeSend(step.room, step.username, now)
+		}
+	}
+	if findRoom("nowhere") != nil {
+		t.Error("checking slow mode created a room")
+	}
+}
+
+func TestRecordSlowModeSendPrunes(t *testing.T) {
+	useSlowMode(t, "busy", time.Second)
+	start := time.Now()
+	for i := range 1001 {
+		recordSlowModeSend("busy", fmt.Sprintf("user%d", i), start)
+	}
+	recordSlowModeSend("busy", "late", start.Add(2*time.Second))
+
+	room := findRoom("busy")
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	if len(room.lastSent) != 1 {
+		t.Errorf("%d users remembered, want only the latest", len(room.lastSent))
+	}
+}
+
+func TestCheckSlowMode(t *testing.T) {
+	useSlowMode(t, "slow", time.Minute)
+	recordSlowModeSend("slow", "bob", time.Now())
+	moderatorToken = "mod-secret"
+	t.Cleanup(func() { moderatorToken = "" })
+
+	tests := []struct {
+		name     string
+		username string
+		token    string
+		code     int
+	}{
+		{"waiting", "bob", "", http.StatusTooManyRequests},
+		{"moderator exempt", "bob", "mod-secret", http.StatusOK},
+		{"other user", "alice", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := func(c *gin.Context) {
+				if checkSlowMode(c, "slow", c.Query("username")) {
+					c.Status(http.StatusOK)
+				}
+			}
+			req := httptest.NewRequest(http.MethodPost, "/send?username="+tt.username, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := serveTestRequest("/send", req, handler)
+			if rec.Code != tt.code {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.code, rec.Body)
+			}
+			if tt.code == http.StatusTooManyRequests && rec.Header().Get("Retry-After") != "60" {
+				t.Errorf("Retry-After = %q, want 60", rec.Header().Get("Retry-After"))
+			}
+		})
+	}
+}
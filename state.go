@@ -0,0 +1,116 @@
+// state.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// Private bucket for server state: settings and queues saved as JSON
+// (config/), deleted-room archives (archive/) and, by default, quarantined
+// uploads (quarantine/). The chat bucket is publicly readable, so nothing
+// but shared files may be kept there.
+var stateBucketName string
+
+// Prefixes under which server state used to be kept in the chat bucket,
+// and where it is moved in the state bucket
+var legacyStatePrefixes = []struct{ from, to string }{
+	{".config/", "config/"},
+	{".archive/", "archive/"},
+	{".quarantine/", "quarantine/"},
+}
+
+// Create the state bucket (STATE_BUCKET, default chat-state) without any
+// access policy, and move state left in the chat bucket by older versions
+// into it
+func initStateBucket(ctx context.Context) {
+	stateBucketName = getEnvString("STATE_BUCKET", "chat-state")
+	if stateBucketName == "" || stateBucketName == bucketName {
+		log.Fatalf("STATE_BUCKET must name a private bucket other than %s", bucketName)
+	}
+
+	exists, err := minioClient.BucketExists(ctx, stateBucketName)
+	if err != nil {
+		log.Fatalf("Error checking state bucket: %v", err)
+	}
+	if !exists {
+		err := minioClient.MakeBucket(ctx, stateBucketName, minio.MakeBucketOptions{})
+		if err != nil && !isBucketExistsError(err) {
+			log.Fatalf("Error creating state bucket: %v", err)
+		}
+	} else if policy, err := minioClient.GetBucketPolicy(ctx, stateBucketName); err == nil && policy != "" {
+		log.Printf("Warning: state bucket %s has an access policy; it should not be publicly readable", stateBucketName)
+	}
+
+	for _, prefix := range legacyStatePrefixes {
+		moveLegacyState(ctx, prefix.from, prefix.to)
+	}
+}
+
+// Move every object under from in the chat bucket to the state bucket,
+// under to
+func moveLegacyState(ctx context.Context, from, to string) {
+	moved := 0
+	for obj := range minioClient.ListObjects(ctx, bucketName, minio.ListObjectsOptions{Prefix: from, Recursive: true}) {
+		if obj.Err != nil {
+			log.Printf("Warning: listing %s in %s: %v", from, bucketName, obj.Err)
+			return
+		}
+		dst := to + strings.TrimPrefix(obj.Key, from)
+		_, err := minioClient.CopyObject(ctx,
+			minio.CopyDestOptions{Bucket: stateBucketName, Object: dst},
+			minio.CopySrcOptions{Bucket: bucketName, Object: obj.Key})
+		if err != nil {
+			log.Printf("Warning: moving %s to the state bucket: %v", obj.Key, err)
+			continue
+		}
+		if err := minioClient.RemoveObject(ctx, bucketName, obj.Key, minio.RemoveObjectOptions{}); err != nil {
+			log.Printf("Warning: removing %s after moving it to the state bucket: %v", obj.Key, err)
+			continue
+		}
+		moved++
+	}
+	if moved > 0 {
+		log.Printf("Moved %d objects from %s/%s to %s/%s", moved, bucketName, from, stateBucketName, to)
+	}
+}
+
+// Report whether an object name in the chat bucket is reserved for the
+// server rather than a shared file. Such names start with a dot, like
+// custom emoji (served by /emoji) and state left by older versions; they
+// are never served or listed as files.
+func isReservedObjectName(objectName string) bool {
+	return strings.HasPrefix(objectName, ".")
+}
+
+// Decode a JSON object kept in the state bucket into v, leaving v
+// unchanged when the object does not exist yet
+func loadConfigObject(ctx context.Context, name string, v any) error {
+	object, err := minioClient.GetObject(ctx, stateBucketName, name, minio.GetObjectOptions{})
+	if err != nil {
+		return err
+	}
+	defer object.Close()
+
+	if err := json.NewDecoder(object).Decode(v); err != nil && minio.ToErrorResponse(err).Code != "NoSuchKey" {
+		return err
+	}
+	return nil
+}
+
+// Encode v as JSON and keep it in the state bucket
+func saveConfigObject(ctx context.Context, name string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = minioClient.PutObject(ctx, stateBucketName, name, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType: "application/json",
+	})
+	return err
+}
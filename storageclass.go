@@ -0,0 +1,117 @@
+// storageclass.go
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
+)
+
+// Storage classes uploads may be stored in or moved to
+var validStorageClasses = map[string]bool{
+	"STANDARD":            true,
+	"REDUCED_REDUNDANCY":  true,
+	"STANDARD_IA":         true,
+	"ONEZONE_IA":          true,
+	"INTELLIGENT_TIERING": true,
+	"GLACIER_IR":          true,
+}
+
+// Uploads are tagged so the cold storage rule leaves server objects (emoji,
+// config) alone
+const (
+	uploadTagKey   = "go-chat-upload"
+	uploadTagValue = "true"
+)
+
+// ID of the bucket lifecycle rule managed by the server
+const coldStorageRuleID = "go-chat-cold-storage"
+
+// Storage tiering settings
+var (
+	uploadStorageClass string // empty uses the bucket's default
+	coldStorageClass   string // empty disables the transition
+	coldStorageDays    int
+)
+
+// Initialize storage tiering from environment variables. Uploads are stored
+// in UPLOAD_STORAGE_CLASS; with COLD_STORAGE_CLASS set, a bucket lifecycle
+// rule moves uploads to that class COLD_STORAGE_AFTER_DAYS (default 30)
+// after they were uploaded. Only classes that can be read without a restore
+// are accepted, so old files stay downloadable.
+func initStorageClass(ctx context.Context) {
+	uploadStorageClass = strings.ToUpper(strings.TrimSpace(os.Getenv("UPLOAD_STORAGE_CLASS")))
+	if uploadStorageClass != "" && !validStorageClasses[uploadStorageClass] {
+		log.Fatalf("Invalid UPLOAD_STORAGE_CLASS %q", uploadStorageClass)
+	}
+
+	coldStorageClass = strings.ToUpper(strings.TrimSpace(os.Getenv("COLD_STORAGE_CLASS")))
+	coldStorageDays = getEnvInt("COLD_STORAGE_AFTER_DAYS", 30)
+	if coldStorageClass != "" && !validStorageClasses[coldStorageClass] {
+		log.Fatalf("Invalid COLD_STORAGE_CLASS %q", coldStorageClass)
+	}
+	if coldStorageClass != "" && coldStorageDays < 1 {
+		log.Fatalf("COLD_STORAGE_AFTER_DAYS must be positive")
+	}
+
+	// A rule left over from an earlier configuration is removed, but failing
+	// to check for one should not stop a server that doesn't tier storage
+	err := applyColdStorageRule(ctx)
+	switch {
+	case err != nil && coldStorageClass != "":
+		log.Fatalf("Error configuring cold storage lifecycle rule: %v", err)
+	case err != nil:
+		log.Printf("Warning: could not check bucket lifecycle rules: %v", err)
+	case coldStorageClass != "":
+		log.Printf("Uploads move to storage class %s after %d days", coldStorageClass, coldStorageDays)
+	}
+}
+
+// Options that apply the configured storage class and upload tag
+func uploadPutOptions(opts minio.PutObjectOptions) minio.PutObjectOptions {
+	opts.StorageClass = uploadStorageClass
+	opts.UserTags = map[string]string{uploadTagKey: uploadTagValue}
+	return opts
+}
+
+// Add, update or remove the server's cold storage rule, keeping any other
+// lifecycle rules configured on the bucket
+func applyColdStorageRule(ctx context.Context) error {
+	config, err := minioClient.GetBucketLifecycle(ctx, bucketName)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code != "NoSuchLifecycleConfiguration" {
+			return err
+		}
+		config = lifecycle.NewConfiguration()
+	}
+
+	rules := config.Rules[:0]
+	found := false
+	for _, rule := range config.Rules {
+		if rule.ID == coldStorageRuleID {
+			found = true
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	if coldStorageClass == "" && !found {
+		return nil
+	}
+	if coldStorageClass != "" {
+		rules = append(rules, lifecycle.Rule{
+			ID:         coldStorageRuleID,
+			Status:     "Enabled",
+			RuleFilter: lifecycle.Filter{Tag: lifecycle.Tag{Key: uploadTagKey, Value: uploadTagValue}},
+			Transition: lifecycle.Transition{
+				StorageClass: coldStorageClass,
+				Days:         lifecycle.ExpirationDays(coldStorageDays),
+			},
+		})
+	}
+	config.Rules = rules
+	return minioClient.SetBucketLifecycle(ctx, bucketName, config)
+}
@@ -0,0 +1,142 @@
+// storageclass_test.go
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
+)
+
+// Configure storage tiering for a test
+func useStorageClass(t *testing.T, upload, cold string, days int) {
+	t.Helper()
+	savedUpload, savedCold, savedDays := uploadStorageClass, coldStorageClass, coldStorageDays
+	uploadStorageClass, coldStorageClass, coldStorageDays = upload, cold, days
+	t.Cleanup(func() { uploadStorageClass, coldStorageClass, coldStorageDays = savedUpload, savedCold, savedDays })
+}
+
+// A lifecycle rule managed by someone else, which must survive
+var otherLifecycleRule = lifecycle.Rule{
+	ID:         "expire-tmp",
+	Status:     "Enabled",
+	RuleFilter: lifecycle.Filter{Prefix: "tmp/"},
+	Expiration: lifecycle.Expiration{Days: 7},
+}
+
+// Return the bucket's lifecycle rules by ID
+func lifecycleRules(t *testing.T) map[string]lifecycle.Rule {
+	t.Helper()
+	config, err := minioClient.GetBucketLifecycle(context.Background(), bucketName)
+	if err != nil {
+		t.Fatalf("getting lifecycle: %v", err)
+	}
+	rules := make(map[string]lifecycle.Rule)
+	for _, rule := range config.Rules {
+		rules[rule.ID] = rule
+	}
+	return rules
+}
+
+func TestUploadStorageClassAndTag(t *testing.T) {
+	useMemoryStore(t)
+	useUploadLimiter(t, 100, 100)
+	useBroadcastQueue(t)
+
+	tests := []struct {
+		name  string
+		class string
+	}{
+		{"bucket default", ""},
+		{"configured class", "STANDARD_IA"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := useFakeS3(t, bucketName)
+			useStorageClass(t, tt.class, "", 0)
+			req := newUploadForm("/upload", map[string]string{"username": "alice", "room": "general"}, "notes.txt", "hello")
+			if rec := serveTestRequest("/upload", req, handleFileUpload); rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+			keys := fake.keys(bucketName)
+			if len(keys) != 1 {
+				t.Fatalf("stored %v, want one object", keys)
+			}
+			obj := fake.object(bucketName, keys[0])
+			if obj.storageClass != tt.class {
+				t.Errorf("stored with class %q, want %q", obj.storageClass, tt.class)
+			}
+			if obj.tags != uploadTagKey+"="+uploadTagValue {
+				t.Errorf("tagged %q, want %s=%s", obj.tags, uploadTagKey, uploadTagValue)
+			}
+		})
+	}
+}
+
+func TestApplyColdStorageRule(t *testing.T) {
+	fake := useFakeS3(t, bucketName)
+	ctx := context.Background()
+	config := lifecycle.NewConfiguration()
+	config.Rules = []lifecycle.Rule{otherLifecycleRule}
+	if err := minioClient.SetBucketLifecycle(ctx, bucketName, config); err != nil {
+		t.Fatal(err)
+	}
+
+	// Added with the tag filter, next to the existing rule
+	useStorageClass(t, "", "GLACIER_IR", 30)
+	if err := applyColdStorageRule(ctx); err != nil {
+		t.Fatal(err)
+	}
+	rules := lifecycleRules(t)
+	rule, ok := rules[coldStorageRuleID]
+	if !ok {
+		t.Fatalf("rules %v, want %s", rules, coldStorageRuleID)
+	}
+	if rule.RuleFilter.Tag.Key != uploadTagKey || rule.RuleFilter.Tag.Value != uploadTagValue {
+		t.Errorf("rule filters on %+v, want the upload tag", rule.RuleFilter)
+	}
+	if rule.Transition.StorageClass != "GLACIER_IR" || rule.Transition.Days != 30 {
+		t.Errorf("rule moves to %s after %d days, want GLACIER_IR after 30", rule.Transition.StorageClass, rule.Transition.Days)
+	}
+	if _, ok := rules[otherLifecycleRule.ID]; !ok {
+		t.Errorf("rules %v, want %s kept", rules, otherLifecycleRule.ID)
+	}
+
+	// Updated in place when the settings change
+	coldStorageClass, coldStorageDays = "STANDARD_IA", 90
+	if err := applyColdStorageRule(ctx); err != nil {
+		t.Fatal(err)
+	}
+	rules = lifecycleRules(t)
+	if len(rules) != 2 {
+		t.Errorf("rules %v, want the cold storage rule replaced", rules)
+	}
+	if rule := rules[coldStorageRuleID]; rule.Transition.StorageClass != "STANDARD_IA" || rule.Transition.Days != 90 {
+		t.Errorf("rule moves to %s after %d days, want STANDARD_IA after 90", rule.Transition.StorageClass, rule.Transition.Days)
+	}
+
+	// Removed when tiering is turned off
+	coldStorageClass = ""
+	if err := applyColdStorageRule(ctx); err != nil {
+		t.Fatal(err)
+	}
+	rules = lifecycleRules(t)
+	if _, ok := rules[coldStorageRuleID]; ok || len(rules) != 1 {
+		t.Errorf("rules %v, want only %s", rules, otherLifecycleRule.ID)
+	}
+
+	// Nothing to remove leaves the bucket alone
+	fake.mu.Lock()
+	fake.buckets[bucketName].lifecycle = nil
+	fake.mu.Unlock()
+	if err := applyColdStorageRule(ctx); err != nil {
+		t.Fatal(err)
+	}
+	fake.mu.Lock()
+	written := fake.buckets[bucketName].lifecycle
+	fake.mu.Unlock()
+	if written != nil {
+		t.Errorf("lifecycle written with tiering off: %s", written)
+	}
+}
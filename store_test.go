@@ -0,0 +1,89 @@
+// store_test.go
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Compares storing 10,000 messages one Insert at a time with storing them
+// through BatchInsert, in one call and in import-sized batches
+func BenchmarkInsert(b *testing.B) {
+	const count = 10000
+	messages := benchmarkMessages(count)
+	ctx := context.Background()
+
+	for _, bc := range []struct {
+		name  string
+		batch int // 0 for one Insert per message
+	}{
+		{"single", 0},
+		{"batch=500", 500},
+		{"batch=10000", count},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			for range b.N {
+				b.StopTimer()
+				store := newMemoryStore(count)
+				msgs := append([]Message(nil), messages...)
+				b.StartTimer()
+
+				if bc.batch == 0 {
+					for i := range msgs {
+						if err := store.Insert(ctx, &msgs[i]); err != nil {
+							b.Fatal(err)
+						}
+					}
+					continue
+				}
+				for start := 0; start < len(msgs); start += bc.batch {
+					if err := store.BatchInsert(ctx, msgs[start:min(start+bc.batch, len(msgs))]); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*count), "ns/msg")
+		})
+	}
+}
+
+// Writes are not applied once the request's context is canceled
+func TestMemoryStoreHonorsCanceledContext(t *testing.T) {
+	store := newMemoryStore(100)
+	if err := store.Insert(context.Background(), &Message{ID: "m1", Room: "general", Username: "alice", Content: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name string
+		call func() error
+	}{
+		{"Insert", func() error { return store.Insert(ctx, &Message{ID: "m2", Room: "general"}) }},
+		{"BatchInsert", func() error { return store.BatchInsert(ctx, []Message{{ID: "m3", Room: "general"}}) }},
+		{"ToggleReaction", func() error { _, err := store.ToggleReaction(ctx, "m1", "👍", "bob"); return err }},
+		{"DeleteBefore", func() error { _, err := store.DeleteBefore(ctx, "general", time.Now()); return err }},
+		{"DeleteRoom", func() error { _, err := store.DeleteRoom(ctx, "general"); return err }},
+		{"JoinRoom", func() error { return store.JoinRoom(ctx, "general", "bob") }},
+		{"MarkRead", func() error { return store.MarkRead(ctx, "bob", "general", 1) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.call(); !errors.Is(err, context.Canceled) {
+				t.Errorf("%s with a canceled context returned %v, want context.Canceled", tt.name, err)
+			}
+		})
+	}
+
+	// Nothing was changed by the canceled calls
+	messages, err := store.Recent(context.Background(), "general", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || len(messages[0].Reactions) != 0 {
+		t.Errorf("store holds %+v, want only the original message", messages)
+	}
+}
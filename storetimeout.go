@@ -0,0 +1,192 @@
+// storetimeout.go
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// SlowQueryLogger logs store queries that take longer than Threshold
+type SlowQueryLogger struct {
+	Threshold time.Duration // 0 disables logging
+}
+
+// Observe logs query if it started longer than the threshold ago. query
+// names the operation and its scope only, never message text or other
+// user-supplied values beyond the room.
+func (l SlowQueryLogger) Observe(query string, start time.Time) {
+	if l.Threshold <= 0 {
+		return
+	}
+	if elapsed := time.Since(start); elapsed > l.Threshold {
+		log.Printf("Warning: slow store query %s took %v", query, elapsed.Round(time.Millisecond))
+	}
+}
+
+// timeoutStore is a MessageStore that bounds every call by a timeout and
+// reports slow calls, so a heavy query can't hold a handler indefinitely
+type timeoutStore struct {
+	store   MessageStore
+	timeout time.Duration // 0 means no timeout
+	slow    SlowQueryLogger
+}
+
+// Wrap a store with the query timeout and slow-query logging configured by
+// DB_QUERY_TIMEOUT_SECONDS and DB_SLOW_QUERY_THRESHOLD_MS
+func newTimeoutStore(store MessageStore) *timeoutStore {
+	timeout := getEnvInt("DB_QUERY_TIMEOUT_SECONDS", 30)
+	if timeout < 0 {
+		log.Printf("Warning: DB_QUERY_TIMEOUT_SECONDS must not be negative, using 30")
+		timeout = 30
+	}
+	threshold := getEnvInt("DB_SLOW_QUERY_THRESHOLD_MS", 100)
+	if threshold < 0 {
+		log.Printf("Warning: DB_SLOW_QUERY_THRESHOLD_MS must not be negative, using 100")
+		threshold = 100
+	}
+	return &timeoutStore{
+		store:   store,
+		timeout: time.Duration(timeout) * time.Second,
+		slow:    SlowQueryLogger{Threshold: time.Duration(threshold) * time.Millisecond},
+	}
+}
+
+// Start a query: derive its context and return a function that ends it
+func (s *timeoutStore) begin(ctx context.Context, query string) (context.Context, func()) {
+	start := time.Now()
+	cancel := context.CancelFunc(func() {})
+	if s.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+	}
+	return ctx, func() {
+		cancel()
+		s.slow.Observe(query, start)
+	}
+}
+
+func (s *timeoutStore) Insert(ctx context.Context, msg *Message) error {
+	ctx, end := s.begin(ctx, "Insert room="+msg.Room)
+	defer end()
+	return s.store.Insert(ctx, msg)
+}
+
+func (s *timeoutStore) BatchInsert(ctx context.Context, msgs []Message) error {
+	ctx, end := s.begin(ctx, "BatchInsert")
+	defer end()
+	return s.store.BatchInsert(ctx, msgs)
+}
+
+func (s *timeoutStore) Get(ctx context.Context, id string) (Message, error) {
+	ctx, end := s.begin(ctx, "Get")
+	defer end()
+	return s.store.Get(ctx, id)
+}
+
+func (s *timeoutStore) Recent(ctx context.Context, room string, limit int) ([]Message, error) {
+	ctx, end := s.begin(ctx, "Recent room="+room)
+	defer end()
+	return s.store.Recent(ctx, room, limit)
+}
+
+func (s *timeoutStore) ToggleReaction(ctx context.Context, messageID, emoji, username string) (map[string]int, error) {
+	ctx, end := s.begin(ctx, "ToggleReaction")
+	defer end()
+	return s.store.ToggleReaction(ctx, messageID, emoji, username)
+}
+
+func (s *timeoutStore) RemoveFile(ctx context.Context, messageID, reason string) error {
+	ctx, end := s.begin(ctx, "RemoveFile")
+	defer end()
+	return s.store.RemoveFile(ctx, messageID, reason)
+}
+
+func (s *timeoutStore) DeleteBefore(ctx context.Context, room string, cutoff time.Time) (int, error) {
+	ctx, end := s.begin(ctx, "DeleteBefore room="+room)
+	defer end()
+	return s.store.DeleteBefore(ctx, room, cutoff)
+}
+
+func (s *timeoutStore) DeleteExpired(ctx context.Context, now time.Time) ([]Message, error) {
+	ctx, end := s.begin(ctx, "DeleteExpired")
+	defer end()
+	return s.store.DeleteExpired(ctx, now)
+}
+
+func (s *timeoutStore) DeleteRoom(ctx context.Context, room string) (int, error) {
+	ctx, end := s.begin(ctx, "DeleteRoom room="+room)
+	defer end()
+	return s.store.DeleteRoom(ctx, room)
+}
+
+func (s *timeoutStore) Rooms(ctx context.Context) ([]string, error) {
+	ctx, end := s.begin(ctx, "Rooms")
+	defer end()
+	return s.store.Rooms(ctx)
+}
+
+func (s *timeoutStore) UserFiles(ctx context.Context, username string) ([]Message, error) {
+	ctx, end := s.begin(ctx, "UserFiles")
+	defer end()
+	return s.store.UserFiles(ctx, username)
+}
+
+func (s *timeoutStore) LatestSeq(ctx context.Context, room string) uint64 {
+	ctx, end := s.begin(ctx, "LatestSeq room="+room)
+	defer end()
+	return s.store.LatestSeq(ctx, room)
+}
+
+func (s *timeoutStore) JoinRoom(ctx context.Context, room, username string) error {
+	ctx, end := s.begin(ctx, "JoinRoom room="+room)
+	defer end()
+	return s.store.JoinRoom(ctx, room, username)
+}
+
+func (s *timeoutStore) IsMember(ctx context.Context, room, username string) (bool, error) {
+	ctx, end := s.begin(ctx, "IsMember room="+room)
+	defer end()
+	return s.store.IsMember(ctx, room, username)
+}
+
+func (s *timeoutStore) Members(ctx context.Context, room string) (map[string]time.Time, error) {
+	ctx, end := s.begin(ctx, "Members room="+room)
+	defer end()
+	return s.store.Members(ctx, room)
+}
+
+func (s *timeoutStore) InitReadMarker(ctx context.Context, username, room string) error {
+	ctx, end := s.begin(ctx, "InitReadMarker room="+room)
+	defer end()
+	return s.store.InitReadMarker(ctx, username, room)
+}
+
+func (s *timeoutStore) MarkRead(ctx context.Context, username, room string, seq uint64) error {
+	ctx, end := s.begin(ctx, "MarkRead room="+room)
+	defer end()
+	return s.store.MarkRead(ctx, username, room, seq)
+}
+
+func (s *timeoutStore) UnreadCounts(ctx context.Context, username string) (map[string]int, error) {
+	ctx, end := s.begin(ctx, "UnreadCounts")
+	defer end()
+	return s.store.UnreadCounts(ctx, username)
+}
+
+func (s *timeoutStore) ThreadReplies(ctx context.Context, rootID string) ([]Message, error) {
+	ctx, end := s.begin(ctx, "ThreadReplies")
+	defer end()
+	return s.store.ThreadReplies(ctx, rootID)
+}
+
+func (s *timeoutStore) MarkThreadRead(ctx context.Context, username, rootID string, seq uint64) error {
+	ctx, end := s.begin(ctx, "MarkThreadRead")
+	defer end()
+	return s.store.MarkThreadRead(ctx, username, rootID, seq)
+}
+
+func (s *timeoutStore) ThreadUnreadCounts(ctx context.Context, username string) (map[string]int, error) {
+	ctx, end := s.begin(ctx, "ThreadUnreadCounts")
+	defer end()
+	return s.store.ThreadUnreadCounts(ctx, username)
+}
@@ -0,0 +1,81 @@
+// storetimeout_test.go
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stallingStore is a memory store whose Recent blocks until its context ends
+type stallingStore struct {
+	MessageStore
+}
+
+func (s stallingStore) Recent(ctx context.Context, room string, limit int) ([]Message, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestTimeoutStoreTimesOutSlowCall(t *testing.T) {
+	logs := captureLog(t)
+	store := &timeoutStore{
+		store:   stallingStore{newMemoryStore(100)},
+		timeout: 50 * time.Millisecond,
+		slow:    SlowQueryLogger{Threshold: 20 * time.Millisecond},
+	}
+
+	start := time.Now()
+	_, err := store.Recent(context.Background(), "general", 10)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want the deadline exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("returned after %v, want the 50ms timeout", elapsed)
+	}
+	if !strings.Contains(logs.String(), "slow store query Recent room=general") {
+		t.Errorf("log = %q, want the slow query logged", logs)
+	}
+}
+
+func TestTimeoutStoreFastCalls(t *testing.T) {
+	logs := captureLog(t)
+	store := &timeoutStore{
+		store:   newMemoryStore(100),
+		timeout: time.Second,
+		slow:    SlowQueryLogger{Threshold: time.Second},
+	}
+	ctx := context.Background()
+
+	if err := store.Insert(ctx, &Message{ID: "m1", Room: "general", Username: "alice", Content: "secret plans"}); err != nil {
+		t.Fatal(err)
+	}
+	if msgs, err := store.Recent(ctx, "general", 10); err != nil || len(msgs) != 1 {
+		t.Errorf("Recent = %d messages, %v; want the inserted one", len(msgs), err)
+	}
+	if logs.String() != "" {
+		t.Errorf("log = %q, want nothing for fast calls", logs)
+	}
+}
+
+func TestSlowQueryLogger(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold time.Duration
+		elapsed   time.Duration
+		logged    bool
+	}{
+		{"fast", time.Second, 0, false},
+		{"slow", 10 * time.Millisecond, time.Minute, true},
+		{"disabled", 0, time.Minute, false},
+	}
+	for _, tt := range tests {
+		logs := captureLog(t)
+		SlowQueryLogger{Threshold: tt.threshold}.Observe("Insert room=general", time.Now().Add(-tt.elapsed))
+		if logged := strings.Contains(logs.String(), "slow store query Insert room=general"); logged != tt.logged {
+			t.Errorf("%s: logged %q, want logged %v", tt.name, logs, tt.logged)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+// streams.go
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+)
+
+// A message being streamed in chunks, e.g. a bot's reply as it is generated
+type activeStream struct {
+	room     string
+	username string
+	started  time.Time
+	content  strings.Builder // raw text as sent
+	relayed  string          // moderated text relayed so far in chunks
+	timer    *time.Timer     // ends the stream at the time limit
+}
+
+// Streaming limits and the streams in progress
+var (
+	maxStreamDuration time.Duration
+	maxStreamBytes    int
+
+	streams   = make(map[string]*activeStream) // stream ID -> stream
+	streamsMu sync.Mutex
+)
+
+// Initialize streaming limits from environment variables
+func initStreams() {
+	maxStreamDuration = time.Duration(getEnvInt("STREAM_MAX_SECONDS", 30)) * time.Second
+	maxStreamBytes = getEnvInt("STREAM_MAX_BYTES", 100<<10)
+}
+
+// Report whether a message is part of a stream
+func isStreamEvent(msgType string) bool {
+	return msgType == MessageTypeStreamStart || msgType == MessageTypeStreamChunk || msgType == MessageTypeStreamEnd
+}
+
+// Handle a stream_start, stream_chunk or stream_end from a client. Starts
+// and chunks are relayed to the room as they arrive; the end stores the
+// assembled message, with the stream ID as its ID, and relays it as the
+// stream_end event. Events for streams the sender does not own are ignored.
+//
+// Streamed text goes through the same HTML stripping and word filter as
+// other messages. Chunks are not moderated one by one, since markup or a
+// blocked word may span chunks: each chunk relays what moderating the text
+// so far adds to what was already relayed, holding back an unfinished
+// word, tag or entity at its end. Together the relayed chunks are always
+// moderated text. If moderating more text changes what was already
+// relayed, no more chunks are relayed and the stream_end, which carries
+// the whole moderated message, brings clients up to date.
+func handleStreamEvent(ctx context.Context, msg Message) {
+	streamsMu.Lock()
+	defer streamsMu.Unlock()
+
+	stream, exists := streams[msg.StreamID]
+	switch msg.Type {
+	case MessageTypeStreamStart:
+		if _, err := uuid.Parse(msg.StreamID); err != nil || exists {
+			log.Printf("Invalid stream start from %s: %q", msg.Username, msg.StreamID)
+			return
+		}
+		if _, err := messageStore.Get(ctx, msg.StreamID); err == nil {
+			log.Printf("Stream ID %s from %s is already a message", msg.StreamID, msg.Username)
+			return
+		}
+		id := msg.StreamID
+		streams[id] = &activeStream{
+			room:     msg.Room,
+			username: msg.Username,
+			started:  msg.Timestamp,
+			timer: time.AfterFunc(maxStreamDuration, func() {
+				streamsMu.Lock()
+				defer streamsMu.Unlock()
+				endStreamLocked(context.Background(), id, "timeout")
+			}),
+		}
+		msg.Content = ""
+		fanOut(msg)
+
+	case MessageTypeStreamChunk:
+		if !exists || stream.username != msg.Username || stream.room != msg.Room {
+			return
+		}
+		if stream.content.Len()+len(msg.Content) > maxStreamBytes {
+			endStreamLocked(ctx, msg.StreamID, "too_large")
+			return
+		}
+		stream.content.WriteString(msg.Content)
+		raw := stream.content.String()
+		content, ok := moderateContent(raw[:settledLength(raw)])
+		if !ok {
+			notifyRejected(stream.username)
+			endStreamLocked(ctx, msg.StreamID, "rejected")
+			return
+		}
+		if len(content) > len(stream.relayed) && strings.HasPrefix(content, stream.relayed) {
+			msg.Content = content[len(stream.relayed):]
+			stream.relayed = content
+			fanOut(msg)
+		}
+
+	case MessageTypeStreamEnd:
+		if !exists || stream.username != msg.Username || stream.room != msg.Room {
+			return
+		}
+		endStreamLocked(ctx, msg.StreamID, "")
+	}
+}
+
+// Store a stream's assembled message and tell the room it ended; reason
+// says why a stream was cut short. The caller must hold streamsMu.
+func endStreamLocked(ctx context.Context, id, reason string) {
+	stream, ok := streams[id]
+	if !ok {
+		return
+	}
+	delete(streams, id)
+	stream.timer.Stop()
+
+	msg := Message{
+		SchemaVersion: CurrentSchemaVersion,
+		ID:            id,
+		Room:          stream.room,
+		Username:      stream.username,
+		Timestamp:     stream.started,
+	}
+	content, ok := moderateContent(stream.content.String())
+	switch {
+	case !ok:
+		if reason != "rejected" {
+			notifyRejected(stream.username)
+		}
+		reason = "rejected"
+	case duplicates != nil && content != "" && duplicates.IsDuplicate(Message{Room: msg.Room, Username: msg.Username, Content: content}):
+		reason = "duplicate"
+	default:
+		msg.Content = content
+	}
+	if msg.Content != "" {
+		if shortcodes != nil {
+			if expanded := expandShortcodes(msg.Content, shortcodes); expanded != msg.Content {
+				msg.ExpandedContent = expanded
+			}
+		}
+		msg.Emoji = customEmojiRefs(msg.Content)
+		if err := messageStore.Insert(ctx, &msg); err != nil {
+			log.Printf("Error storing streamed message: %v", err)
+		}
+		notifyWebhooks(WebhookEventMessage, msg)
+		recordMessageActivity(msg)
+	}
+	if reason != "" {
+		log.Printf("Stream %s from %s ended early: %s", id, stream.username, reason)
+	}
+
+	msg.Type = MessageTypeStreamEnd
+	msg.StreamID = id
+	msg.Reason = reason
+	fanOut(msg)
+}
+
+// Length of the part of a stream's raw text that later chunks can't
+// change: up to its last whitespace, and before an unclosed tag or entity
+func settledLength(raw string) int {
+	n := strings.LastIndexFunc(raw, unicode.IsSpace) + 1
+	if i := strings.LastIndex(raw, "<"); i >= 0 && i < n && !strings.Contains(raw[i:], ">") {
+		n = i
+	}
+	if i := strings.LastIndex(raw, "&"); i >= 0 && i < n && !strings.Contains(raw[i:], ";") {
+		n = i
+	}
+	return n
+}
@@ -0,0 +1,136 @@
+// streams_test.go
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestSettledLength(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string // the settled prefix
+	}{
+		{"", ""},
+		{"hello", ""},
+		{"hello ", "hello "},
+		{"hello wor", "hello "},
+		{"one two\nthree", "one two\n"},
+		{"say <b", "say "},
+		{"say <b>hi</b> ", "say <b>hi</b> "},
+		{"a <b class=x", "a "},
+		{"fish &amp", "fish "},
+		{"fish &amp; chips ", "fish &amp; chips "},
+		{"x <i>y</i> &lt z ", "x <i>y</i> "},
+	}
+	for _, tt := range tests {
+		if got := tt.raw[:settledLength(tt.raw)]; got != tt.want {
+			t.Errorf("settled part of %q = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+// Run a stream of chunks and return the text relayed in chunks, the final
+// stream_end and what was stored
+func runStream(t *testing.T, chunks ...string) (relayed string, end Message, stored *Message) {
+	t.Helper()
+	listener := &Client{username: "alice", room: "general", send: make(chan Message, 100)}
+	useClients(t, listener)
+
+	id := uuid.New().String()
+	ctx := context.Background()
+	handleStreamEvent(ctx, Message{Type: MessageTypeStreamStart, StreamID: id, Room: "general", Username: "bot", Timestamp: time.Now()})
+	for _, chunk := range chunks {
+		handleStreamEvent(ctx, Message{Type: MessageTypeStreamChunk, StreamID: id, Room: "general", Username: "bot", Content: chunk})
+	}
+	handleStreamEvent(ctx, Message{Type: MessageTypeStreamEnd, StreamID: id, Room: "general", Username: "bot"})
+
+	close(listener.send)
+	for msg := range listener.send {
+		switch msg.Type {
+		case MessageTypeStreamChunk:
+			relayed += msg.Content
+		case MessageTypeStreamEnd:
+			end = msg
+		}
+	}
+	if msg, err := messageStore.Get(ctx, id); err == nil {
+		stored = &msg
+	}
+	return relayed, end, stored
+}
+
+func TestStreamModeration(t *testing.T) {
+	useMemoryStore(t)
+	savedDuration, savedBytes := maxStreamDuration, maxStreamBytes
+	maxStreamDuration, maxStreamBytes = time.Minute, 1<<10
+	t.Cleanup(func() { maxStreamDuration, maxStreamBytes = savedDuration, savedBytes })
+	liveConfigMu.Lock()
+	savedFilter := wordFilter
+	wordFilter = NewWordFilter([]string{"darn"}, true, false, "***")
+	liveConfigMu.Unlock()
+	t.Cleanup(func() {
+		liveConfigMu.Lock()
+		wordFilter = savedFilter
+		liveConfigMu.Unlock()
+	})
+
+	tests := []struct {
+		name    string
+		chunks  []string
+		relayed string // moderated text relayed in chunks
+		final   string // content of the stream_end and stored message
+		reason  string
+	}{
+		{"plain", []string{"hello ", "there ", "friend"}, "hello there ", "hello there friend", ""},
+		{"word split across chunks", []string{"oh da", "rn it ", "all"}, "oh *** it ", "oh *** it all", ""},
+		{"tag split across chunks", []string{"be <scr", "ipt>x</script> ", "ok"}, "be  ", "be  ok", ""},
+		{"entity split across chunks", []string{"fish &am", "p; chips"}, "fish & ", "fish & chips", ""},
+		{"too large", []string{strings.Repeat("a ", 300), strings.Repeat("b ", 300)}, strings.Repeat("a ", 300), strings.Repeat("a ", 300), "too_large"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			relayed, end, stored := runStream(t, tt.chunks...)
+			if relayed != tt.relayed {
+				t.Errorf("relayed %q, want %q", relayed, tt.relayed)
+			}
+			if end.Content != tt.final || end.Reason != tt.reason {
+				t.Errorf("stream_end = %q (%q), want %q (%q)", end.Content, end.Reason, tt.final, tt.reason)
+			}
+			if stored == nil || stored.Content != tt.final {
+				t.Errorf("stored %+v, want %q", stored, tt.final)
+			}
+		})
+	}
+}
+
+func TestStreamRejected(t *testing.T) {
+	useMemoryStore(t)
+	savedDuration, savedBytes := maxStreamDuration, maxStreamBytes
+	maxStreamDuration, maxStreamBytes = time.Minute, 1<<10
+	t.Cleanup(func() { maxStreamDuration, maxStreamBytes = savedDuration, savedBytes })
+	liveConfigMu.Lock()
+	savedFilter := wordFilter
+	wordFilter = NewWordFilter([]string{"darn"}, true, true, "***")
+	liveConfigMu.Unlock()
+	t.Cleanup(func() {
+		liveConfigMu.Lock()
+		wordFilter = savedFilter
+		liveConfigMu.Unlock()
+	})
+
+	relayed, end, stored := runStream(t, "all fine ", "then d<b></b>a", "rn ", "more")
+	if relayed != "all fine then " {
+		t.Errorf("relayed %q, want only the text before the blocked word", relayed)
+	}
+	if end.Reason != "rejected" || end.Content != "" {
+		t.Errorf("stream_end = %q (%q), want an empty rejected end", end.Content, end.Reason)
+	}
+	if stored != nil {
+		t.Errorf("rejected stream stored as %+v", stored)
+	}
+}
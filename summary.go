@@ -0,0 +1,154 @@
+// summary.go
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// How long a computed summary is served from cache
+const summaryCacheTTL = 5 * time.Minute
+
+// Number of top messages picked by replies and by reactions
+const summaryTopN = 5
+
+// ConversationSummary condenses the recent history of a room
+type ConversationSummary struct {
+	Period struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	} `json:"period"`
+	Highlights       []SummaryHighlight `json:"highlights"`
+	ParticipantCount int                `json:"participantCount"`
+	MessageCount     int                `json:"messageCount"`
+}
+
+// SummaryHighlight is a message picked for a summary
+type SummaryHighlight struct {
+	MessageID string `json:"messageId"`
+	Content   string `json:"content"`
+	Score     int    `json:"score"`
+}
+
+// A summary with its cache expiry
+type cachedSummary struct {
+	summary *ConversationSummary
+	expires time.Time
+}
+
+// Summary settings and cache
+var (
+	summaryWindow  int
+	summaryCache   = make(map[string]cachedSummary) // room -> summary
+	summaryCacheMu sync.Mutex
+)
+
+// Initialize summaries from environment variables
+func initSummaries() {
+	summaryWindow = getEnvInt("SUMMARY_WINDOW_MESSAGES", 100)
+}
+
+// Return a summary of the recent messages in a room
+func handleRoomSummary(c *gin.Context) {
+	room := c.Param("room")
+
+	summaryCacheMu.Lock()
+	cached, ok := summaryCache[room]
+	summaryCacheMu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		c.JSON(http.StatusOK, cached.summary)
+		return
+	}
+
+	messages, err := messageStore.Recent(c.Request.Context(), room, summaryWindow)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load messages"})
+		log.Printf("Error loading messages for summary: %v", err)
+		return
+	}
+	summary := summarize(messages)
+
+	summaryCacheMu.Lock()
+	summaryCache[room] = cachedSummary{summary: summary, expires: time.Now().Add(summaryCacheTTL)}
+	summaryCacheMu.Unlock()
+
+	c.JSON(http.StatusOK, summary)
+}
+
+// Build an extractive summary of messages (oldest first).
+//
+// Highlights are the summaryTopN messages with the most replies, the
+// summaryTopN with the most reactions, and the first and last message of
+// the window, in chronological order. Each highlight is scored as
+//
+//	score = 2*replies + reactions
+//
+// where replies counts messages in the window replying to it and reactions
+// is its total reaction count. Replies weigh double because they show a
+// message started a conversation rather than just being acknowledged.
+func summarize(messages []Message) *ConversationSummary {
+	summary := &ConversationSummary{Highlights: []SummaryHighlight{}, MessageCount: len(messages)}
+	if len(messages) == 0 {
+		return summary
+	}
+	summary.Period.From = messages[0].Timestamp
+	summary.Period.To = messages[len(messages)-1].Timestamp
+
+	replies := make(map[string]int)
+	reactions := make(map[string]int)
+	participants := make(map[string]bool)
+	for _, msg := range messages {
+		participants[msg.Username] = true
+		if msg.ReplyTo != "" {
+			replies[msg.ReplyTo]++
+		}
+		for _, count := range msg.Reactions {
+			reactions[msg.ID] += count
+		}
+	}
+	summary.ParticipantCount = len(participants)
+
+	picked := map[int]bool{0: true, len(messages) - 1: true}
+	for _, counts := range []map[string]int{replies, reactions} {
+		for _, i := range topMessages(messages, counts, summaryTopN) {
+			picked[i] = true
+		}
+	}
+
+	indexes := make([]int, 0, len(picked))
+	for i := range picked {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	for _, i := range indexes {
+		msg := messages[i]
+		summary.Highlights = append(summary.Highlights, SummaryHighlight{
+			MessageID: msg.ID,
+			Content:   msg.Content,
+			Score:     2*replies[msg.ID] + reactions[msg.ID],
+		})
+	}
+	return summary
+}
+
+// Return the indexes of up to n messages with the highest non-zero counts
+func topMessages(messages []Message, counts map[string]int, n int) []int {
+	var indexes []int
+	for i, msg := range messages {
+		if counts[msg.ID] > 0 {
+			indexes = append(indexes, i)
+		}
+	}
+	sort.SliceStable(indexes, func(a, b int) bool {
+		return counts[messages[indexes[a]].ID] > counts[messages[indexes[b]].ID]
+	})
+	if len(indexes) > n {
+		indexes = indexes[:n]
+	}
+	return indexes
+}
@@ -0,0 +1,129 @@
+// summary_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Start a test with an empty summary cache and the given window
+func useSummaries(t *testing.T, window int) {
+	t.Helper()
+	summaryCacheMu.Lock()
+	savedCache, savedWindow := summaryCache, summaryWindow
+	summaryCache, summaryWindow = make(map[string]cachedSummary), window
+	summaryCacheMu.Unlock()
+	t.Cleanup(func() {
+		summaryCacheMu.Lock()
+		summaryCache, summaryWindow = savedCache, savedWindow
+		summaryCacheMu.Unlock()
+	})
+}
+
+// Fetch a room's summary
+func getSummary(t *testing.T, room string) ConversationSummary {
+	t.Helper()
+	rec := serveTest(http.MethodGet, "/rooms/:room/summary", "/rooms/"+room+"/summary", nil, handleRoomSummary)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if !strings.Contains(rec.Body.String(), `"highlights":[`) {
+		t.Errorf("body %s, want highlights as a list", rec.Body)
+	}
+	var summary ConversationSummary
+	if err := json.Unmarshal(rec.Body.Bytes(), &summary); err != nil {
+		t.Fatal(err)
+	}
+	return summary
+}
+
+// Return the message IDs and scores of a summary's highlights
+func highlightScores(summary ConversationSummary) string {
+	var parts []string
+	for _, h := range summary.Highlights {
+		parts = append(parts, fmt.Sprintf("%s=%d", h.MessageID, h.Score))
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestHandleRoomSummary(t *testing.T) {
+	useMemoryStore(t)
+	ctx := context.Background()
+	start := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	users := []string{"alice", "bob", "carol"}
+	replyTo := map[int]string{5: "m2", 6: "m2", 7: "m2", 8: "m3"}
+	for i := range 12 {
+		msg := Message{
+			ID:        fmt.Sprintf("m%d", i),
+			Room:      "general",
+			Username:  users[i%len(users)],
+			Content:   fmt.Sprintf("message %d", i),
+			ReplyTo:   replyTo[i],
+			Timestamp: start.Add(time.Duration(i) * time.Minute),
+		}
+		if err := messageStore.Insert(ctx, &msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	messageStore.ToggleReaction(ctx, "m4", "👍", "alice")
+	messageStore.ToggleReaction(ctx, "m4", "🎉", "bob")
+	messageStore.ToggleReaction(ctx, "m3", "👍", "carol")
+
+	t.Run("whole window", func(t *testing.T) {
+		useSummaries(t, 100)
+		summary := getSummary(t, "general")
+		// First and last, then the most replied to and reacted to, in order
+		if got, want := highlightScores(summary), "m0=0 m2=6 m3=3 m4=2 m11=0"; got != want {
+			t.Errorf("highlights %s, want %s", got, want)
+		}
+		if summary.MessageCount != 12 || summary.ParticipantCount != 3 {
+			t.Errorf("%d messages from %d participants, want 12 from 3", summary.MessageCount, summary.ParticipantCount)
+		}
+		if !summary.Period.From.Equal(start) || !summary.Period.To.Equal(start.Add(11*time.Minute)) {
+			t.Errorf("period %v to %v", summary.Period.From, summary.Period.To)
+		}
+	})
+
+	t.Run("limited window", func(t *testing.T) {
+		useSummaries(t, 4)
+		summary := getSummary(t, "general")
+		// Replies to messages before the window don't pull them in
+		if got, want := highlightScores(summary), "m8=0 m11=0"; got != want {
+			t.Errorf("highlights %s, want %s", got, want)
+		}
+		if summary.MessageCount != 4 {
+			t.Errorf("%d messages, want 4", summary.MessageCount)
+		}
+	})
+
+	t.Run("empty room", func(t *testing.T) {
+		useSummaries(t, 100)
+		if summary := getSummary(t, "quiet"); summary.MessageCount != 0 || len(summary.Highlights) != 0 {
+			t.Errorf("summary %+v, want an empty one", summary)
+		}
+	})
+
+	t.Run("cached", func(t *testing.T) {
+		useSummaries(t, 100)
+		getSummary(t, "general")
+		messageStore.Insert(ctx, &Message{ID: "m12", Room: "general", Username: "dave", Content: "late", Timestamp: start.Add(time.Hour)})
+		if summary := getSummary(t, "general"); summary.MessageCount != 12 {
+			t.Errorf("%d messages, want the cached summary of 12", summary.MessageCount)
+		}
+
+		// Recomputed once the cache entry expires
+		summaryCacheMu.Lock()
+		entry := summaryCache["general"]
+		entry.expires = time.Now().Add(-time.Second)
+		summaryCache["general"] = entry
+		summaryCacheMu.Unlock()
+		if summary := getSummary(t, "general"); summary.MessageCount != 13 || summary.ParticipantCount != 4 {
+			t.Errorf("%d messages from %d participants, want 13 from 4", summary.MessageCount, summary.ParticipantCount)
+		}
+	})
+}
@@ -0,0 +1,119 @@
+// supervisor.go
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Restarts of crashed background workers, by worker
+var workerRestartsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "chat_worker_restarts_total",
+	Help: "Background workers restarted after a panic, by worker",
+}, []string{"worker"})
+
+// Supervisor restart policy
+const (
+	supervisorMaxRestarts = 10 // across all workers within supervisorWindow
+	supervisorWindow      = time.Minute
+)
+
+// Backoff before restarting a crashed worker; variables so tests can shorten them
+var (
+	supervisorMinBackoff = time.Second
+	supervisorMaxBackoff = 30 * time.Second
+)
+
+// Supervisor runs background workers and restarts any that panic, after
+// a backoff that doubles with each consecutive crash of the same worker.
+// A server whose workers crash over and over is not healthy, so once too
+// many restarts happen within a minute the supervisor shuts it down.
+type Supervisor struct {
+	ctx      context.Context
+	shutdown func() // starts a graceful shutdown
+
+	mu       sync.Mutex
+	restarts []time.Time // restarts within the last supervisorWindow
+}
+
+// Supervisor of the server's background workers, set up in main
+var supervisor *Supervisor
+
+// Create a supervisor whose workers stop when ctx is canceled; shutdown
+// is called when workers keep crashing
+func NewSupervisor(ctx context.Context, shutdown func()) *Supervisor {
+	return &Supervisor{ctx: ctx, shutdown: shutdown}
+}
+
+// Go runs fn in a new goroutine, restarting it if it panics until the
+// supervisor's context is canceled. A worker that returns is done.
+func (s *Supervisor) Go(name string, fn func(ctx context.Context)) {
+	go func() {
+		backoff := supervisorMinBackoff
+		for {
+			started := time.Now()
+			if !s.run(name, fn) {
+				return
+			}
+
+			// A worker that ran for a while before crashing starts over
+			// from the shortest backoff
+			if time.Since(started) > supervisorWindow {
+				backoff = supervisorMinBackoff
+			}
+			if !s.recordRestart(name) {
+				return
+			}
+			log.Printf("Restarting worker %s in %v", name, backoff)
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			workerRestartsTotal.WithLabelValues(name).Inc()
+			backoff = min(backoff*2, supervisorMaxBackoff)
+		}
+	}()
+}
+
+// Run a worker until it returns; reports whether it panicked
+func (s *Supervisor) run(name string, fn func(ctx context.Context)) (panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			reportPanic(r, "", "worker", name)
+			panicked = true
+		}
+	}()
+	fn(s.ctx)
+	return false
+}
+
+// Count a restart of a worker. Returns false, after starting a shutdown,
+// when the restart limit is reached or the server is already stopping.
+func (s *Supervisor) recordRestart(name string) bool {
+	if s.ctx.Err() != nil {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	recent := s.restarts[:0]
+	for _, at := range s.restarts {
+		if now.Sub(at) < supervisorWindow {
+			recent = append(recent, at)
+		}
+	}
+	s.restarts = append(recent, now)
+	if len(s.restarts) > supervisorMaxRestarts {
+		log.Printf("FATAL: workers crashed %d times within %v, last %s; shutting down", len(s.restarts), supervisorWindow, name)
+		s.shutdown()
+		return false
+	}
+	return true
+}
@@ -0,0 +1,123 @@
+// supervisor_test.go
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// Shorten the supervisor's backoff for a test
+func useSupervisorBackoff(t *testing.T, minBackoff, maxBackoff time.Duration) {
+	t.Helper()
+	savedMin, savedMax := supervisorMinBackoff, supervisorMaxBackoff
+	supervisorMinBackoff, supervisorMaxBackoff = minBackoff, maxBackoff
+	t.Cleanup(func() { supervisorMinBackoff, supervisorMaxBackoff = savedMin, savedMax })
+}
+
+func TestSupervisorRestartsPanickingWorker(t *testing.T) {
+	useSupervisorBackoff(t, 20*time.Millisecond, 50*time.Millisecond)
+	var shutdowns atomic.Int32
+	s := NewSupervisor(context.Background(), func() { shutdowns.Add(1) })
+	restarts := testutil.ToFloat64(workerRestartsTotal.WithLabelValues("flaky"))
+
+	// Panics on its first four runs, then finishes
+	var mu sync.Mutex
+	var starts []time.Time
+	done := make(chan struct{})
+	s.Go("flaky", func(ctx context.Context) {
+		mu.Lock()
+		starts = append(starts, time.Now())
+		n := len(starts)
+		mu.Unlock()
+		if n <= 4 {
+			panic("worker crashed")
+		}
+		close(done)
+	})
+
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("worker not restarted")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(starts) != 5 {
+		t.Fatalf("worker ran %d times, want 5", len(starts))
+	}
+	// The backoff doubles from the minimum up to the maximum
+	for i, want := range []time.Duration{20, 40, 50, 50} {
+		if gap := starts[i+1].Sub(starts[i]); gap < want*time.Millisecond {
+			t.Errorf("restart %d after %v, want at least %v", i+1, gap, want*time.Millisecond)
+		}
+	}
+	if got := testutil.ToFloat64(workerRestartsTotal.WithLabelValues("flaky")) - restarts; got != 4 {
+		t.Errorf("restarts counted = %v, want 4", got)
+	}
+	if shutdowns.Load() != 0 {
+		t.Error("server shut down for a worker that recovered")
+	}
+}
+
+func TestSupervisorStopsOnCancel(t *testing.T) {
+	useSupervisorBackoff(t, 50*time.Millisecond, 50*time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	s := NewSupervisor(ctx, func() {})
+
+	// A worker waiting on the context returns when it is canceled and
+	// is not restarted
+	var waits atomic.Int32
+	returned := make(chan struct{})
+	s.Go("waiter", func(ctx context.Context) {
+		waits.Add(1)
+		<-ctx.Done()
+		close(returned)
+	})
+
+	// A worker canceled during its backoff is not restarted either
+	var crashes atomic.Int32
+	crashed := make(chan struct{}, 1)
+	s.Go("crasher", func(ctx context.Context) {
+		crashes.Add(1)
+		crashed <- struct{}{}
+		panic("worker crashed")
+	})
+	<-crashed
+	cancel()
+
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatal("worker did not stop on cancel")
+	}
+	time.Sleep(150 * time.Millisecond) // past the backoff
+	if waits.Load() != 1 || crashes.Load() != 1 {
+		t.Errorf("workers ran %d and %d times, want once each", waits.Load(), crashes.Load())
+	}
+}
+
+func TestSupervisorShutsDownAfterRepeatedCrashes(t *testing.T) {
+	useSupervisorBackoff(t, time.Millisecond, time.Millisecond)
+	shutdown := make(chan struct{})
+	s := NewSupervisor(context.Background(), func() { close(shutdown) })
+
+	var runs atomic.Int32
+	s.Go("broken", func(ctx context.Context) {
+		runs.Add(1)
+		panic("worker crashed")
+	})
+	select {
+	case <-shutdown:
+	case <-time.After(3 * time.Second):
+		t.Fatal("supervisor did not shut down the server")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if got := runs.Load(); got != supervisorMaxRestarts+1 {
+		t.Errorf("worker ran %d times, want %d", got, supervisorMaxRestarts+1)
+	}
+}
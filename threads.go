@@ -0,0 +1,153 @@
+// threads.go
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Resolve the thread a message posted in room under rootID belongs to.
+// Replying to a reply puts the message in that reply's thread, so threads
+// are one level deep. Returns "" when rootID is not a message in the room.
+func threadRoot(ctx context.Context, room, rootID string) string {
+	root, err := messageStore.Get(ctx, rootID)
+	if err != nil || root.Room != room {
+		return ""
+	}
+	if root.ThreadRootID != "" {
+		return root.ThreadRootID
+	}
+	return root.ID
+}
+
+// Tell a thread reply's room the thread's new reply count
+func fanOutThreadReply(ctx context.Context, reply Message) {
+	root, err := messageStore.Get(ctx, reply.ThreadRootID)
+	if err != nil {
+		// The root left history between the reply's insert and now
+		return
+	}
+	fanOut(Message{
+		ID:         uuid.New().String(),
+		Type:       MessageTypeThreadReply,
+		Room:       reply.Room,
+		Username:   "System",
+		RootID:     root.ID,
+		ReplyCount: root.ThreadReplyCount,
+		MessageID:  reply.ID,
+		Timestamp:  time.Now(),
+	})
+}
+
+// Parse a sequence number cursor; responds with 400 and returns false when
+// it is invalid
+func seqCursor(c *gin.Context, name string) (uint64, bool) {
+	value := c.Query(name)
+	if value == "" {
+		return 0, true
+	}
+	seq, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": name + " must be a message sequence number"})
+		return 0, false
+	}
+	return seq, true
+}
+
+// List a room's newest top-level messages, oldest first. Thread replies
+// are left out unless ?replies=true. ?before=<seq> pages back through
+// older messages; nextCursor is the value for the next page, or empty on
+// the last one.
+func handleListRoomMessages(c *gin.Context) {
+	room := c.Param("room")
+	if !canAccessRoom(c.Request.Context(), room, c.Query("username")) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not a member of this room"})
+		return
+	}
+	limit, ok := historyLimit(c)
+	if !ok {
+		return
+	}
+	before, ok := seqCursor(c, "before")
+	if !ok {
+		return
+	}
+	withReplies := c.Query("replies") == "true"
+
+	messages, err := messageStore.Recent(c.Request.Context(), room, 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load messages"})
+		log.Printf("Error loading messages: %v", err)
+		return
+	}
+	listed := messages[:0]
+	for _, msg := range messages {
+		if before != 0 && msg.Seq >= before {
+			break
+		}
+		if msg.ThreadRootID == "" || withReplies {
+			listed = append(listed, msg)
+		}
+	}
+
+	nextCursor := ""
+	if len(listed) > limit {
+		listed = listed[len(listed)-limit:]
+		nextCursor = strconv.FormatUint(listed[0].Seq, 10)
+	}
+	c.JSON(http.StatusOK, gin.H{"room": room, "messages": withDownloadCounts(listed), "nextCursor": nextCursor})
+}
+
+// Return a thread's root and its replies, oldest first. ?after=<seq>
+// continues from the reply with that sequence number; nextCursor is the
+// value for the next page, or empty on the last one.
+func handleGetThread(c *gin.Context) {
+	root, err := messageStore.Get(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, ErrMessageNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load message"})
+		log.Printf("Error loading message: %v", err)
+		return
+	}
+	if !canAccessRoom(c.Request.Context(), root.Room, c.Query("username")) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not a member of this room"})
+		return
+	}
+	if root.ThreadRootID != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Message is a thread reply", "threadRootId": root.ThreadRootID})
+		return
+	}
+	limit, ok := historyLimit(c)
+	if !ok {
+		return
+	}
+	after, ok := seqCursor(c, "after")
+	if !ok {
+		return
+	}
+
+	replies, err := messageStore.ThreadReplies(c.Request.Context(), root.ID)
+	if err != nil && !errors.Is(err, ErrMessageNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load thread"})
+		log.Printf("Error loading thread: %v", err)
+		return
+	}
+	start := sort.Search(len(replies), func(i int) bool { return replies[i].Seq > after })
+	end := min(start+limit, len(replies))
+
+	nextCursor := ""
+	if end < len(replies) {
+		nextCursor = strconv.FormatUint(replies[end-1].Seq, 10)
+	}
+	c.JSON(http.StatusOK, gin.H{"root": root, "replies": replies[start:end], "nextCursor": nextCursor})
+}
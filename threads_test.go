@@ -0,0 +1,120 @@
+// threads_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// Store a room with two top-level messages and a thread of three replies
+func seedThread(t *testing.T) {
+	t.Helper()
+	useMemoryStore(t)
+	ctx := context.Background()
+	for _, msg := range []Message{
+		{ID: "root", Room: "general", Content: "question"},
+		{ID: "r1", Room: "general", ThreadRootID: "root", Content: "answer 1"},
+		{ID: "other", Room: "general", Content: "unrelated"},
+		{ID: "r2", Room: "general", ThreadRootID: "root", Content: "answer 2"},
+		{ID: "r3", Room: "general", ThreadRootID: "root", Content: "answer 3"},
+		{ID: "away", Room: "random", Content: "elsewhere"},
+	} {
+		if err := messageStore.Insert(ctx, &msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// IDs of a list of messages, comma-separated
+func messageIDs(messages []Message) string {
+	ids := make([]string, len(messages))
+	for i, msg := range messages {
+		ids[i] = msg.ID
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestThreadRoot(t *testing.T) {
+	seedThread(t)
+	tests := []struct {
+		room, rootID, want string
+	}{
+		{"general", "root", "root"},
+		{"general", "r2", "root"}, // replying to a reply stays in its thread
+		{"random", "root", ""},
+		{"general", "missing", ""},
+	}
+	for _, tt := range tests {
+		if got := threadRoot(context.Background(), tt.room, tt.rootID); got != tt.want {
+			t.Errorf("threadRoot(%s, %s) = %q, want %q", tt.room, tt.rootID, got, tt.want)
+		}
+	}
+}
+
+func TestHandleListRoomMessages(t *testing.T) {
+	seedThread(t)
+	tests := []struct {
+		target string
+		want   string
+		next   bool
+	}{
+		{"/rooms/general/messages", "root,other", false},
+		{"/rooms/general/messages?replies=true", "root,r1,other,r2,r3", false},
+		{"/rooms/general/messages?replies=true&limit=2", "r2,r3", true},
+		{"/rooms/general/messages?replies=true&limit=2&before=4", "r1,other", true},
+	}
+	for _, tt := range tests {
+		rec := serveTest(http.MethodGet, "/rooms/:room/messages", tt.target, nil, handleListRoomMessages)
+		var resp struct {
+			Messages   []Message `json:"messages"`
+			NextCursor string    `json:"nextCursor"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if got := messageIDs(resp.Messages); got != tt.want || (resp.NextCursor != "") != tt.next {
+			t.Errorf("%s: messages = %s (next %q), want %s (next %v)", tt.target, got, resp.NextCursor, tt.want, tt.next)
+		}
+	}
+}
+
+func TestHandleGetThread(t *testing.T) {
+	seedThread(t)
+	tests := []struct {
+		target string
+		code   int
+		want   string
+		next   string
+	}{
+		{"/messages/root/thread", http.StatusOK, "r1,r2,r3", ""},
+		{"/messages/root/thread?limit=2", http.StatusOK, "r1,r2", "4"},
+		{"/messages/root/thread?limit=2&after=4", http.StatusOK, "r3", ""},
+		{"/messages/other/thread", http.StatusOK, "", ""},
+		{"/messages/r1/thread", http.StatusBadRequest, "", ""},
+		{"/messages/missing/thread", http.StatusNotFound, "", ""},
+		{"/messages/root/thread?after=x", http.StatusBadRequest, "", ""},
+	}
+	for _, tt := range tests {
+		rec := serveTest(http.MethodGet, "/messages/:id/thread", tt.target, nil, handleGetThread)
+		if rec.Code != tt.code {
+			t.Errorf("%s: status = %d, want %d: %s", tt.target, rec.Code, tt.code, rec.Body)
+			continue
+		}
+		if tt.code != http.StatusOK {
+			continue
+		}
+		var resp struct {
+			Replies    []Message `json:"replies"`
+			NextCursor string    `json:"nextCursor"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if got := messageIDs(resp.Replies); got != tt.want || resp.NextCursor != tt.next {
+			t.Errorf("%s: replies = %s (next %q), want %s (next %q)", tt.target, got, resp.NextCursor, tt.want, tt.next)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// tls.go
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// TLS settings
+var (
+	tlsCertFile string
+	tlsKeyFile  string
+
+	// Port of a plain HTTP listener that only redirects to HTTPS/WSS;
+	// empty when there is none
+	httpRedirectPort string
+
+	// Redirect plain HTTP requests to HTTPS (and ws:// to wss://). On when
+	// the server terminates TLS itself, or with FORCE_HTTPS=true behind a
+	// TLS-terminating proxy that sets X-Forwarded-Proto.
+	tlsRedirect bool
+)
+
+// Initialize TLS from environment variables. TLS_CERT_FILE and
+// TLS_KEY_FILE make the server listen with TLS on PORT; HTTP_REDIRECT_PORT
+// adds a plain HTTP listener that redirects clients to it.
+func initTLS() {
+	tlsCertFile = os.Getenv("TLS_CERT_FILE")
+	tlsKeyFile = os.Getenv("TLS_KEY_FILE")
+	if (tlsCertFile == "") != (tlsKeyFile == "") {
+		log.Fatal("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	httpRedirectPort = os.Getenv("HTTP_REDIRECT_PORT")
+	if httpRedirectPort != "" && tlsCertFile == "" {
+		log.Printf("Warning: HTTP_REDIRECT_PORT requires TLS_CERT_FILE and TLS_KEY_FILE, ignoring it")
+		httpRedirectPort = ""
+	}
+	tlsRedirect = tlsCertFile != "" || getEnvBool("FORCE_HTTPS", false)
+	if tlsRedirect {
+		log.Println("Redirecting plain HTTP and WebSocket requests to HTTPS/WSS")
+	}
+}
+
+// Report whether the server terminates TLS itself
+func tlsEnabled() bool {
+	return tlsCertFile != ""
+}
+
+// TLSRedirectMiddleware redirects requests that didn't arrive over TLS to
+// the same path and query on https:// (wss:// for WebSocket handshakes).
+// It runs before any handler, so WebSocket clients are redirected before
+// the connection is upgraded. GET and HEAD get 301; other methods get 308
+// so clients resend the same body to the new URL.
+func TLSRedirectMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !tlsRedirect || isSecureRequest(c) {
+			c.Next()
+			return
+		}
+
+		scheme := "https"
+		if websocket.IsWebSocketUpgrade(c.Request) {
+			scheme = "wss"
+		}
+		status := http.StatusMovedPermanently
+		if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+			status = http.StatusPermanentRedirect
+		}
+		c.Redirect(status, scheme+"://"+redirectHost(c)+c.Request.URL.RequestURI())
+		c.Abort()
+	}
+}
+
+// Report whether a request arrived over TLS, directly or at a trusted
+// proxy that says so in X-Forwarded-Proto
+func isSecureRequest(c *gin.Context) bool {
+	if c.Request.TLS != nil {
+		return true
+	}
+	return fromTrustedProxy(c) && strings.EqualFold(c.GetHeader("X-Forwarded-Proto"), "https")
+}
+
+// Host to redirect a plain request to. A client that reached this server
+// directly on the redirect port is sent to the TLS port; behind a proxy
+// the public host is kept as is.
+func redirectHost(c *gin.Context) string {
+	host := c.Request.Host
+	if !tlsEnabled() || fromTrustedProxy(c) {
+		return host
+	}
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	} else {
+		host = strings.Trim(host, "[]") // IPv6 literal without a port
+	}
+	port := serverPort()
+	if port != "443" {
+		return net.JoinHostPort(host, port)
+	}
+	if strings.Contains(host, ":") {
+		return "[" + host + "]"
+	}
+	return host
+}
+
+// Port the main server listens on
+func serverPort() string {
+	if port := os.Getenv("PORT"); port != "" {
+		return port
+	}
+	return "8080"
+}
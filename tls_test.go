@@ -0,0 +1,74 @@
+// tls_test.go
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestTLSRedirectMiddleware(t *testing.T) {
+	savedCert, savedRedirect, savedProxies := tlsCertFile, tlsRedirect, trustedProxies
+	t.Cleanup(func() { tlsCertFile, tlsRedirect, trustedProxies = savedCert, savedRedirect, savedProxies })
+	trustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	tests := []struct {
+		name         string
+		cert         string // TLS_CERT_FILE; empty for TLS terminated at a proxy
+		redirect     bool
+		port         string
+		method       string
+		target       string
+		peer         string
+		headers      map[string]string
+		tls          bool
+		wantCode     int
+		wantLocation string // Location header
+	}{
+		{"redirect off", "", false, "8080", "GET", "http://chat.example.com/messages", "203.0.113.7", nil, false, http.StatusOK, ""},
+		{"plain HTTP", "cert.pem", true, "8443", "GET", "http://chat.example.com:8080/messages?room=a", "203.0.113.7", nil, false, http.StatusMovedPermanently, "https://chat.example.com:8443/messages?room=a"},
+		{"default TLS port", "cert.pem", true, "443", "GET", "http://chat.example.com/messages", "203.0.113.7", nil, false, http.StatusMovedPermanently, "https://chat.example.com/messages"},
+		{"HEAD", "cert.pem", true, "443", "HEAD", "http://chat.example.com/health", "203.0.113.7", nil, false, http.StatusMovedPermanently, "https://chat.example.com/health"},
+		{"POST keeps the method", "cert.pem", true, "443", "POST", "http://chat.example.com/upload", "203.0.113.7", nil, false, http.StatusPermanentRedirect, "https://chat.example.com/upload"},
+		{"IPv6 host", "cert.pem", true, "443", "GET", "http://[::1]:8080/messages", "203.0.113.7", nil, false, http.StatusMovedPermanently, "https://[::1]/messages"},
+		{"ws:// to wss://", "cert.pem", true, "8443", "GET", "http://chat.example.com:8080/ws?room=general", "203.0.113.7",
+			map[string]string{"Connection": "Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "13", "Sec-WebSocket-Key": "dGhlIHNhbXBsZSBub25jZQ=="},
+			false, http.StatusMovedPermanently, "wss://chat.example.com:8443/ws?room=general"},
+		{"already TLS", "cert.pem", true, "8443", "GET", "https://chat.example.com:8443/messages", "203.0.113.7", nil, true, http.StatusOK, ""},
+		{"proxy says https", "", true, "8080", "GET", "http://chat.example.com/messages", "10.0.0.2", map[string]string{"X-Forwarded-Proto": "https"}, false, http.StatusOK, ""},
+		{"proxy says http", "", true, "8080", "GET", "http://chat.example.com/messages", "10.0.0.2", map[string]string{"X-Forwarded-Proto": "http"}, false, http.StatusMovedPermanently, "https://chat.example.com/messages"},
+		{"ws:// behind a proxy", "", true, "8080", "GET", "http://chat.example.com/ws", "10.0.0.2",
+			map[string]string{"X-Forwarded-Proto": "http", "Connection": "Upgrade", "Upgrade": "websocket"},
+			false, http.StatusMovedPermanently, "wss://chat.example.com/ws"},
+		{"untrusted peer claims https", "", true, "8080", "GET", "http://chat.example.com/messages", "203.0.113.7", map[string]string{"X-Forwarded-Proto": "https"}, false, http.StatusMovedPermanently, "https://chat.example.com/messages"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tlsCertFile, tlsRedirect = tt.cert, tt.redirect
+			t.Setenv("PORT", tt.port)
+			gin.SetMode(gin.TestMode)
+			router := gin.New()
+			router.Use(TLSRedirectMiddleware())
+			router.Handle(tt.method, "/*path", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			req.RemoteAddr = tt.peer + ":12345"
+			req.TLS = nil
+			if tt.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != tt.wantCode || rec.Header().Get("Location") != tt.wantLocation {
+				t.Errorf("status %d, Location %q; want %d, %q", rec.Code, rec.Header().Get("Location"), tt.wantCode, tt.wantLocation)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+// tombstones.go
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+)
+
+// Reason given for files that disappeared from storage
+const fileExpiredReason = "expired"
+
+// Delete a shared file from storage and tell its room
+func handleDeleteFile(c *gin.Context) {
+	objectName := c.Param("id")
+
+	err := minioClient.RemoveObject(c.Request.Context(), bucketName, objectName, minio.RemoveObjectOptions{})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete file"})
+		log.Printf("Error deleting object: %v", err)
+		return
+	}
+	broadcastFileDeleted(c.Request.Context(), objectName, "", "")
+	c.JSON(http.StatusOK, gin.H{"message": "File deleted"})
+}
+
+// Mark the messages that shared a deleted object as deleted and send a
+// file_deleted tombstone for each to the room, so clients can replace the
+// download link with a placeholder. An empty versionID means the whole
+// object was deleted; otherwise only the message for that version is
+// affected. reason is empty for deletions through the server and
+// fileExpiredReason for objects found missing. Returns the number of
+// messages marked.
+func broadcastFileDeleted(ctx context.Context, objectName, versionID, reason string) int {
+	messages, err := messagesSharingObject(ctx, objectName, versionID)
+	if err != nil {
+		log.Printf("Error loading messages for deleted file: %v", err)
+		return 0
+	}
+
+	marked := 0
+	for _, msg := range messages {
+		if err := messageStore.RemoveFile(ctx, msg.ID, reason); err != nil {
+			log.Printf("Error marking file message deleted: %v", err)
+		}
+		publish(Message{
+			ID:        uuid.New().String(),
+			Type:      MessageTypeFileDeleted,
+			Room:      msg.Room,
+			Username:  "System",
+			MessageID: msg.ID,
+			FileName:  msg.FileName,
+			Reason:    reason,
+			Timestamp: time.Now(),
+		})
+		marked++
+	}
+	return marked
+}
+
+// Return the messages, oldest first, that shared an object. An empty
+// versionID matches every version; otherwise messages pinned to another
+// version are left out.
+func messagesSharingObject(ctx context.Context, objectName, versionID string) ([]Message, error) {
+	// Objects uploaded before rooms existed belong to the default room
+	room := roomOfObject(objectName)
+	if room == "" {
+		room = defaultRoom
+	}
+
+	messages, err := messageStore.Recent(ctx, room, 0)
+	if err != nil {
+		return nil, err
+	}
+	sharing := messages[:0]
+	for _, msg := range messages {
+		if messageObjectName(msg) != objectName {
+			continue
+		}
+		if versionID != "" && msg.VersionID != "" && msg.VersionID != versionID {
+			continue
+		}
+		sharing = append(sharing, msg)
+	}
+	return sharing, nil
+}
+
+// Report whether a storage error means the object (or version) is gone
+func isMissingObject(err error) bool {
+	code := minio.ToErrorResponse(err).Code
+	return code == "NoSuchKey" || code == "NoSuchVersion"
+}
+
+// Check every room's files for ones that have expired
+func expireMissingFilesInRooms(ctx context.Context) {
+	rooms, err := messageStore.Rooms(ctx)
+	if err != nil {
+		log.Printf("Error listing rooms to check files: %v", err)
+		return
+	}
+	for _, room := range rooms {
+		expireMissingFiles(ctx, room)
+	}
+}
+
+// Find the files shared in a room whose objects have disappeared from
+// storage, e.g. expired by a bucket lifecycle rule, and mark their
+// messages expired so history stops linking to them
+func expireMissingFiles(ctx context.Context, room string) {
+	messages, err := messageStore.Recent(ctx, room, 0)
+	if err != nil {
+		log.Printf("Error loading messages to check files in %s: %v", room, err)
+		return
+	}
+
+	checked := make(map[string]bool)
+	expired := 0
+	for _, msg := range messages {
+		objectName := messageObjectName(msg)
+		key := objectName + "?versionId=" + msg.VersionID
+		if objectName == "" || checked[key] {
+			continue
+		}
+		checked[key] = true
+
+		_, err := minioClient.StatObject(ctx, bucketName, objectName, minio.StatObjectOptions{VersionID: msg.VersionID})
+		if err == nil {
+			continue
+		}
+		if !isMissingObject(err) {
+			log.Printf("Error checking file %s: %v", objectName, err)
+			continue
+		}
+		expired += broadcastFileDeleted(ctx, objectName, msg.VersionID, fileExpiredReason)
+	}
+	if expired > 0 {
+		log.Printf("Marked %d messages in %s whose files have expired", expired, room)
+	}
+}
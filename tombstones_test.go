@@ -0,0 +1,154 @@
+// tombstones_test.go
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMessageObjectName(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  Message
+		want string
+	}{
+		{"no file", Message{}, ""},
+		{"direct URL", Message{FileURL: "/download/general/a1b2.png"}, "general/a1b2.png"},
+		{"versioned URL", Message{FileURL: "/download/general/a1b2.png?version=v1"}, "general/a1b2.png"},
+		{"pre-room object", Message{FileURL: "/download/a1b2.png"}, "a1b2.png"},
+		{"by-message URL", Message{FileURL: "/download/by-message/0b7c6a0e-3bfc-4bd6-9d0f-7a8f6c9e2d11"}, ""},
+		{"external URL", Message{FileURL: "https://example.com/a.png"}, ""},
+		{"object name known", Message{FileURL: "/download/by-message/x", objectName: "general/a1b2.png"}, "general/a1b2.png"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := messageObjectName(tt.msg); got != tt.want {
+				t.Errorf("messageObjectName = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessagesSharingObject(t *testing.T) {
+	useMemoryStore(t)
+	ctx := context.Background()
+	for _, msg := range []Message{
+		{ID: "v1", Room: "general", FileURL: "/download/general/a.png?version=1", VersionID: "1"},
+		{ID: "v2", Room: "general", FileURL: "/download/general/a.png?version=2", VersionID: "2"},
+		{ID: "unversioned", Room: "general", FileURL: "/download/general/a.png"},
+		{ID: "other", Room: "general", FileURL: "/download/general/b.png"},
+		{ID: "text", Room: "general", Content: "a.png"},
+		{ID: "legacy", Room: defaultRoom, FileURL: "/download/old.png"},
+		{ID: "elsewhere", Room: "random", FileURL: "/download/general/a.png"},
+	} {
+		if err := messageStore.Insert(ctx, &msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		objectName string
+		versionID  string
+		want       []string
+	}{
+		{"every version", "general/a.png", "", []string{"v1", "v2", "unversioned"}},
+		{"one version", "general/a.png", "2", []string{"v2", "unversioned"}},
+		{"unknown version", "general/a.png", "3", []string{"unversioned"}},
+		{"pre-room object", "old.png", "", []string{"legacy"}},
+		{"no messages", "general/c.png", "", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, err := messagesSharingObject(ctx, tt.objectName, tt.versionID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, msg := range messages {
+				got = append(got, msg.ID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("messagesSharingObject(%q, %q) = %v, want %v", tt.objectName, tt.versionID, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpireMissingFiles(t *testing.T) {
+	captureLog(t)
+	useMemoryStore(t)
+	queue := useBroadcastQueue(t)
+	fake := useFakeS3(t, bucketName)
+	fake.putObject(bucketName, "general/kept.png", []byte("png"), nil)
+	fake.putObject(bucketName, "random/kept.txt", []byte("txt"), nil)
+	ctx := context.Background()
+	for _, msg := range []Message{
+		{ID: "kept", Room: "general", FileName: "kept.png", FileURL: "/download/general/kept.png"},
+		{ID: "gone", Room: "general", FileName: "gone.pdf", FileURL: "/download/general/gone.pdf"},
+		{ID: "gone-again", Room: "general", FileName: "gone.pdf", FileURL: "/download/general/gone.pdf"},
+		{ID: "text", Room: "general", Content: "gone.pdf"},
+		{ID: "elsewhere", Room: "random", FileName: "kept.txt", FileURL: "/download/random/kept.txt"},
+	} {
+		if err := messageStore.Insert(ctx, &msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expireMissingFilesInRooms(ctx)
+
+	for id, expired := range map[string]bool{"kept": false, "gone": true, "gone-again": true, "text": false, "elsewhere": false} {
+		msg, err := messageStore.Get(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.FileDeleted != expired || (expired && (msg.FileDeletedReason != fileExpiredReason || msg.FileURL != "")) {
+			t.Errorf("%s: deleted %v (%q) with link %q, want expired %v", id, msg.FileDeleted, msg.FileDeletedReason, msg.FileURL, expired)
+		}
+	}
+
+	tombstones := map[string]bool{}
+	for _, msg := range drainPublished(queue) {
+		if msg.Type != MessageTypeFileDeleted || msg.Reason != fileExpiredReason || msg.Room != "general" || msg.FileName != "gone.pdf" {
+			t.Errorf("published %+v, want an expired tombstone for gone.pdf", msg)
+		}
+		tombstones[msg.MessageID] = true
+	}
+	if !reflect.DeepEqual(tombstones, map[string]bool{"gone": true, "gone-again": true}) {
+		t.Errorf("tombstones for %v, want gone and gone-again", tombstones)
+	}
+
+	// Marked messages no longer link to the object, so they aren't checked again
+	expireMissingFilesInRooms(ctx)
+	if msgs := drainPublished(queue); len(msgs) != 0 {
+		t.Errorf("second check published %+v, want nothing", msgs)
+	}
+}
+
+func TestExpireMissingFilesStorageDown(t *testing.T) {
+	logs := captureLog(t)
+	useMemoryStore(t)
+	queue := useBroadcastQueue(t)
+	fake := useFakeS3(t, bucketName)
+	ctx := context.Background()
+	messageStore.Insert(ctx, &Message{ID: "m1", Room: "general", FileName: "a.png", FileURL: "/download/general/a.png"})
+
+	// Failing to reach storage is not the same as the file being gone. The
+	// timeout cuts the client's retries short.
+	fake.server.Close()
+	checkCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	expireMissingFiles(checkCtx, "general")
+	if msg, _ := messageStore.Get(ctx, "m1"); msg.FileDeleted {
+		t.Error("file marked expired while storage was unreachable")
+	}
+	if msgs := drainPublished(queue); len(msgs) != 0 {
+		t.Errorf("published %+v, want nothing", msgs)
+	}
+	if !strings.Contains(logs.String(), "Error checking file general/a.png") {
+		t.Errorf("log = %q, want the storage error", logs)
+	}
+}
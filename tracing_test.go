@@ -0,0 +1,94 @@
+// tracing_test.go
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// Record the server's spans in memory for a test
+func useTraceRecorder(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	savedTracer, savedPropagator := tracer, otel.GetTextMapPropagator()
+	tracer = provider.Tracer("go-chat")
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		tracer = savedTracer
+		otel.SetTextMapPropagator(savedPropagator)
+		provider.Shutdown(context.Background())
+	})
+	return exporter
+}
+
+// Find a recorded span by name
+func findSpan(spans tracetest.SpanStubs, name string) *tracetest.SpanStub {
+	for i := range spans {
+		if spans[i].Name == name {
+			return &spans[i]
+		}
+	}
+	return nil
+}
+
+func TestTracePropagatesFromHTTPToBroadcast(t *testing.T) {
+	useMemoryStore(t)
+	queue := useBroadcastQueue(t)
+	exporter := useTraceRecorder(t)
+
+	// A request continuing the caller's trace
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := newMessageForm(map[string]string{"username": "alice", "room": "general", "content": "hello"}, "", "")
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(TracingMiddleware())
+	router.POST("/messages", handlePostMessage)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want 201: %s", rec.Code, rec.Body)
+	}
+
+	// The message is broadcast later, outside the request
+	msg, ok := queue.Pop()
+	if !ok {
+		t.Fatal("message was not queued for broadcast")
+	}
+	handleMessage(msg)
+
+	spans := exporter.GetSpans()
+	server := findSpan(spans, "POST /messages")
+	broadcastSpan := findSpan(spans, "broadcast")
+	insert := findSpan(spans, "store.Insert")
+	if server == nil || broadcastSpan == nil || insert == nil {
+		t.Fatalf("recorded spans %v, want the request, broadcast and store.Insert", spanNames(spans))
+	}
+	if server.SpanContext.TraceID().String() != traceID {
+		t.Errorf("request span in trace %s, want the caller's %s", server.SpanContext.TraceID(), traceID)
+	}
+	if len(broadcastSpan.Links) != 1 || broadcastSpan.Links[0].SpanContext.SpanID() != server.SpanContext.SpanID() {
+		t.Errorf("broadcast span links %v, want a link to the request span", broadcastSpan.Links)
+	}
+	if insert.Parent.SpanID() != broadcastSpan.SpanContext.SpanID() {
+		t.Error("store.Insert span is not a child of the broadcast span")
+	}
+}
+
+// Names of recorded spans, for failure messages
+func spanNames(spans tracetest.SpanStubs) []string {
+	names := make([]string, len(spans))
+	for i, span := range spans {
+		names[i] = span.Name
+	}
+	return names
+}
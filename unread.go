@@ -0,0 +1,103 @@
+// unread.go
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Body of POST /read
+type markReadRequest struct {
+	Username  string `json:"username" binding:"required"`
+	Room      string `json:"room"`
+	MessageID string `json:"messageId"` // last message read; empty marks the whole room read
+
+	// Thread to mark read instead of the room; MessageID is then the last
+	// reply read, and empty marks the whole thread read
+	ThreadRootID string `json:"threadRootId"`
+}
+
+// Return a user's unread message counts per room, and unread reply counts
+// per followed thread
+func handleGetUnread(c *gin.Context) {
+	username := c.Query("username")
+	if username == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "username is required"})
+		return
+	}
+
+	counts, err := messageStore.UnreadCounts(c.Request.Context(), username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load unread counts"})
+		log.Printf("Error loading unread counts: %v", err)
+		return
+	}
+	threads, err := messageStore.ThreadUnreadCounts(c.Request.Context(), username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load unread counts"})
+		log.Printf("Error loading thread unread counts: %v", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"unread": counts, "threads": threads})
+}
+
+// Advance a user's read marker in a room
+func handleMarkRead(c *gin.Context) {
+	var req markReadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if req.ThreadRootID != "" {
+		markThreadRead(c, req)
+		return
+	}
+	if req.Room == "" {
+		req.Room = defaultRoom
+	}
+
+	seq := messageStore.LatestSeq(c.Request.Context(), req.Room)
+	if req.MessageID != "" {
+		msg, err := messageStore.Get(c.Request.Context(), req.MessageID)
+		if err != nil || msg.Room != req.Room {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+			return
+		}
+		seq = msg.Seq
+	}
+
+	if err := messageStore.MarkRead(c.Request.Context(), req.Username, req.Room, seq); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update read marker"})
+		log.Printf("Error updating read marker: %v", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"room": req.Room, "seq": seq})
+}
+
+// Advance a user's read marker in a thread
+func markThreadRead(c *gin.Context, req markReadRequest) {
+	root, err := messageStore.Get(c.Request.Context(), req.ThreadRootID)
+	if err != nil || root.ThreadRootID != "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Thread not found"})
+		return
+	}
+
+	seq := messageStore.LatestSeq(c.Request.Context(), root.Room)
+	if req.MessageID != "" {
+		msg, err := messageStore.Get(c.Request.Context(), req.MessageID)
+		if err != nil || msg.ThreadRootID != root.ID {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+			return
+		}
+		seq = msg.Seq
+	}
+
+	if err := messageStore.MarkThreadRead(c.Request.Context(), req.Username, root.ID, seq); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update read marker"})
+		log.Printf("Error updating thread read marker: %v", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"room": root.Room, "threadRootId": root.ID, "seq": seq})
+}
@@ -0,0 +1,76 @@
+// unread_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUnreadCounts(t *testing.T) {
+	useMemoryStore(t)
+	ctx := context.Background()
+	messageStore.InitReadMarker(ctx, "bob", "general")
+	for _, msg := range []Message{
+		{ID: "m1", Room: "general", Username: "alice", Content: "one"},
+		{ID: "m2", Room: "general", Username: "alice", Content: "two"},
+		{ID: "m3", Room: "general", Username: "alice", Content: "three"},
+		{ID: "r1", Room: "general", ThreadRootID: "m1", Username: "alice", Content: "reply one"},
+		{ID: "r2", Room: "general", ThreadRootID: "m1", Username: "alice", Content: "reply two"},
+		{ID: "x1", Room: "random", Username: "alice", Content: "elsewhere"},
+	} {
+		if err := messageStore.Insert(ctx, &msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	unread := func() (map[string]int, map[string]int) {
+		t.Helper()
+		rec := serveTest(http.MethodGet, "/unread", "/unread?username=bob", nil, handleGetUnread)
+		var resp struct {
+			Unread  map[string]int `json:"unread"`
+			Threads map[string]int `json:"threads"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp.Unread, resp.Threads
+	}
+
+	steps := []struct {
+		name    string
+		body    string // POST /read, or "" to only check counts
+		code    int
+		rooms   map[string]int
+		threads map[string]int
+	}{
+		{"replies don't count for the room", "", 0, map[string]int{"general": 3}, map[string]int{}},
+		{"read up to a message", `{"username":"bob","room":"general","messageId":"m1"}`, http.StatusOK, map[string]int{"general": 2}, map[string]int{}},
+		{"message in another room", `{"username":"bob","room":"general","messageId":"x1"}`, http.StatusNotFound, map[string]int{"general": 2}, map[string]int{}},
+		{"follow a thread", `{"username":"bob","threadRootId":"m1","messageId":"r1"}`, http.StatusOK, map[string]int{"general": 2}, map[string]int{"m1": 1}},
+		{"reply is not a thread", `{"username":"bob","threadRootId":"r1"}`, http.StatusNotFound, map[string]int{"general": 2}, map[string]int{"m1": 1}},
+		{"whole thread read", `{"username":"bob","threadRootId":"m1"}`, http.StatusOK, map[string]int{"general": 2}, map[string]int{"m1": 0}},
+		{"whole room read", `{"username":"bob","room":"general"}`, http.StatusOK, map[string]int{"general": 0}, map[string]int{"m1": 0}},
+		{"never backwards", `{"username":"bob","room":"general","messageId":"m1"}`, http.StatusOK, map[string]int{"general": 0}, map[string]int{"m1": 0}},
+		{"username required", `{"room":"general"}`, http.StatusBadRequest, map[string]int{"general": 0}, map[string]int{"m1": 0}},
+	}
+	for _, step := range steps {
+		if step.body != "" {
+			rec := serveTest(http.MethodPost, "/read", "/read", strings.NewReader(step.body), handleMarkRead)
+			if rec.Code != step.code {
+				t.Errorf("%s: status = %d, want %d: %s", step.name, rec.Code, step.code, rec.Body)
+			}
+		}
+		rooms, threads := unread()
+		if !reflect.DeepEqual(rooms, step.rooms) || !reflect.DeepEqual(threads, step.threads) {
+			t.Errorf("%s: unread = %v, threads = %v, want %v, %v", step.name, rooms, threads, step.rooms, step.threads)
+		}
+	}
+
+	if rec := serveTest(http.MethodGet, "/unread", "/unread", nil, handleGetUnread); rec.Code != http.StatusBadRequest {
+		t.Errorf("GET /unread without username: status = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,202 @@
+// uploadstream.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Progress events sent per upload at most, not counting the first and last
+const uploadProgressSteps = 100
+
+// An update from a streamed upload's goroutine to its SSE response
+type uploadEvent struct {
+	name string // "progress", "complete" or "error"
+	data gin.H
+}
+
+// Counts the bytes MinIO has sent and reports every uploadProgressSteps-th
+// of the file. MinIO reads from PutObjectOptions.Progress as much as it has
+// just uploaded, from several goroutines for multipart uploads.
+type uploadProgress struct {
+	mu     sync.Mutex
+	total  int64
+	sent   int64
+	next   int64 // byte count of the next report
+	report func(sent, total int64)
+}
+
+func (p *uploadProgress) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sent += int64(len(b))
+	if p.sent >= p.next || p.sent >= p.total {
+		p.report(p.sent, p.total)
+		step := max(p.total/uploadProgressSteps, 1)
+		p.next = (p.sent/step + 1) * step
+	}
+	return len(b), nil
+}
+
+// Handle a file upload like POST /upload, streaming the progress of the
+// transfer to storage as server-sent events:
+//
+//	event:progress  data:{"bytes":1048576,"total":10485760}
+//	event:complete  data:{"fileUrl":"...","fileName":"...","versionId":"..."}
+//	event:error     data:{"error":"..."}
+//
+// Validation errors are plain JSON responses, sent before the stream
+// starts. Once started, the upload runs to completion in its own goroutine
+// even if the client goes away, and counts towards the per-user limit on
+// concurrent uploads until then.
+func handleFileUploadStream(c *gin.Context) {
+	if !parseUploadForm(c) {
+		return
+	}
+	form := c.Request.MultipartForm
+	removeForm := true
+	defer func() {
+		if removeForm {
+			form.RemoveAll()
+		}
+	}()
+
+	username, ok := checkUsername(c, c.PostForm("username"))
+	if !ok {
+		return
+	}
+	if username == "" {
+		username = anonymousUsername()
+	}
+	room := c.DefaultPostForm("room", defaultRoom)
+	if !validRoomName(room) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid room name"})
+		return
+	}
+	if !canAccessRoom(c.Request.Context(), room, username) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not a member of this room"})
+		return
+	}
+	caption, altText, ok := attachmentText(c)
+	if !ok {
+		return
+	}
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No file provided", "code": "ERR_FILE_MISSING"})
+		return
+	}
+	if !acquireUploadSlot(username) {
+		file.Close()
+		c.Header("Retry-After", "5")
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many concurrent uploads"})
+		return
+	}
+	metadata, err := analyzeUpload(c.Request.Context(), file, header)
+	if err != nil {
+		file.Close()
+		releaseUploadSlot(username)
+		respondAnalyzeError(c, err)
+		return
+	}
+
+	// Progress updates are dropped when the client falls behind; the final
+	// event has its own slot so it always gets through
+	events := make(chan uploadEvent, 16)
+	done := make(chan uploadEvent, 1)
+	progress := &uploadProgress{
+		total: header.Size,
+		report: func(sent, total int64) {
+			select {
+			case events <- uploadEvent{"progress", gin.H{"bytes": sent, "total": total}}:
+			default:
+			}
+		},
+	}
+	ctx := context.WithoutCancel(c.Request.Context())
+	spanContext := trace.SpanContextFromContext(c.Request.Context())
+	removeForm = false
+	go func() {
+		defer form.RemoveAll()
+		defer releaseUploadSlot(username)
+		defer file.Close()
+
+		objectName, info, err := putUploadedFile(ctx, username, room, "", file, header, progress)
+		if err != nil {
+			done <- uploadEvent{"error", uploadStreamError(err)}
+			return
+		}
+		id := uuid.New().String()
+		msg := Message{
+			ID:        id,
+			Room:      room,
+			Username:  username,
+			Content:   fmt.Sprintf("shared a file: %s", header.Filename),
+			FileURL:   fileDownloadURL(id, objectName, info.VersionID),
+			FileName:  header.Filename,
+			FileSize:  header.Size,
+			VersionID: info.VersionID,
+			Metadata:  metadata,
+			Caption:   caption,
+			AltText:   altText,
+			Timestamp: time.Now(),
+
+			spanContext: spanContext,
+			objectName:  objectName,
+		}
+		publish(msg)
+		done <- uploadEvent{"complete", gin.H{
+			"fileUrl":   msg.FileURL,
+			"fileName":  msg.FileName,
+			"versionId": msg.VersionID,
+			"caption":   msg.Caption,
+			"altText":   msg.AltText,
+		}}
+	}()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.SSEvent("progress", gin.H{"bytes": 0, "total": header.Size})
+	c.Writer.Flush()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event := <-events:
+			c.SSEvent(event.name, event.data)
+			return true
+		case event := <-done:
+			// Progress reported before the final event still goes first
+			for len(events) > 0 {
+				pending := <-events
+				c.SSEvent(pending.name, pending.data)
+			}
+			c.SSEvent(event.name, event.data)
+			return false
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+// Describe why a streamed upload failed, with the same errors as POST /upload
+func uploadStreamError(err error) gin.H {
+	var rejected *RejectedFileError
+	switch {
+	case errors.As(err, &rejected):
+		return gin.H{"error": "File rejected by virus scan", "reason": rejected.Reason}
+	case errors.Is(err, errScanUnavailable):
+		return gin.H{"error": "Virus scanning is unavailable, try again later"}
+	}
+	log.Printf("Error uploading file: %v", err)
+	return gin.H{"error": "Failed to upload file to storage"}
+}
@@ -0,0 +1,149 @@
+// uploadstream_test.go
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A server-sent event from a streamed upload
+type sseEvent struct {
+	name string
+	data map[string]any
+}
+
+// Stream an upload through POST /upload/stream and return the events sent
+func streamUpload(t *testing.T, fileName, fileData string) []sseEvent {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/upload/stream", handleFileUploadStream)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	form := newUploadForm(server.URL+"/upload/stream", map[string]string{"username": "alice", "room": "general"}, fileName, fileData)
+	form.RequestURI = ""
+	resp, err := http.DefaultClient.Do(form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		t.Fatalf("Content-Type = %q, want an event stream", ct)
+	}
+
+	var events []sseEvent
+	var event sseEvent
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			event.name = strings.TrimPrefix(line, "event:")
+		case strings.HasPrefix(line, "data:"):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data:")), &event.data); err != nil {
+				t.Fatalf("decoding %q: %v", line, err)
+			}
+		case line == "" && event.name != "":
+			events = append(events, event)
+			event = sseEvent{}
+		}
+	}
+	return events
+}
+
+func TestUploadProgressReports(t *testing.T) {
+	tests := []struct {
+		name  string
+		total int64
+		chunk int
+	}{
+		{"chunks smaller than a step", 1000, 3},
+		{"chunks of several steps", 1000, 25},
+		{"file smaller than the steps", 40, 1},
+		{"one chunk", 1000, 1000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reports []int64
+			progress := &uploadProgress{total: tt.total, report: func(sent, total int64) {
+				if total != tt.total {
+					t.Errorf("reported total %d, want %d", total, tt.total)
+				}
+				reports = append(reports, sent)
+			}}
+			for sent := int64(0); sent < tt.total; sent += int64(tt.chunk) {
+				progress.Read(make([]byte, min(int64(tt.chunk), tt.total-sent)))
+			}
+			// One per step, plus the last one
+			if len(reports) == 0 || len(reports) > uploadProgressSteps+1 {
+				t.Fatalf("%d reports, want between 1 and %d", len(reports), uploadProgressSteps+1)
+			}
+			if last := reports[len(reports)-1]; last != tt.total {
+				t.Errorf("last report at %d bytes, want %d", last, tt.total)
+			}
+			for i := 1; i < len(reports); i++ {
+				if reports[i] <= reports[i-1] {
+					t.Fatalf("reports %v are not increasing", reports)
+				}
+			}
+		})
+	}
+}
+
+func TestUploadStreamEvents(t *testing.T) {
+	useMemoryStore(t)
+	useFakeS3(t, bucketName)
+	queue := useBroadcastQueue(t)
+	data := strings.Repeat("0123456789", 50000)
+
+	events := streamUpload(t, "notes.txt", data)
+	if len(events) < 2 {
+		t.Fatalf("events %+v, want progress and completion", events)
+	}
+	first, last := events[0], events[len(events)-1]
+	if first.name != "progress" || first.data["bytes"] != float64(0) || first.data["total"] != float64(len(data)) {
+		t.Errorf("first event = %+v, want progress at 0 of %d bytes", first, len(data))
+	}
+	previous := float64(0)
+	for _, event := range events[1 : len(events)-1] {
+		bytes, _ := event.data["bytes"].(float64)
+		if event.name != "progress" || bytes < previous || bytes > float64(len(data)) {
+			t.Fatalf("events %+v, want progress increasing up to the file size", events)
+		}
+		previous = bytes
+	}
+	if last.name != "complete" || last.data["fileName"] != "notes.txt" || last.data["fileUrl"] == "" {
+		t.Errorf("last event = %+v, want completion with the file's link", last)
+	}
+
+	msg := waitPublished(t, queue)
+	if msg.FileURL != last.data["fileUrl"] || msg.FileSize != int64(len(data)) {
+		t.Errorf("published %+v, want the completed upload", msg)
+	}
+}
+
+func TestUploadStreamError(t *testing.T) {
+	captureLog(t)
+	useMemoryStore(t)
+	fake := useFakeS3(t, bucketName)
+	fake.onPut = func(string) bool { return false }
+	queue := useBroadcastQueue(t)
+
+	events := streamUpload(t, "notes.txt", "hello")
+	if len(events) < 2 || events[0].name != "progress" {
+		t.Fatalf("events %+v, want progress then an error", events)
+	}
+	if last := events[len(events)-1]; last.name != "error" || last.data["error"] != "Failed to upload file to storage" {
+		t.Errorf("last event = %+v, want a storage error", last)
+	}
+	if msg, ok := queue.Pop(); ok {
+		t.Errorf("failed upload was published: %+v", msg)
+	}
+}
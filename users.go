@@ -0,0 +1,313 @@
+// users.go
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Username rules
+var (
+	// Usernames nobody may use, so users can't pass themselves off as the
+	// server (whose messages come from "System") or its staff; keyed by
+	// confusable skeleton, so lookalike spellings are reserved too
+	reservedUsernames   map[string]bool
+	reservedUsernamesMu sync.RWMutex // bots may be added by a config reload
+
+	maxUsernameLength int  // in characters
+	confusableCheck   bool // reject names that look like a connected user's
+
+	// Format of the names given to users who don't pick one; {id} is
+	// replaced by a random ID
+	anonymousNameFormat string
+)
+
+// Placeholder for the random part of an anonymous name
+const anonymousIDPlaceholder = "{id}"
+
+// Attempts at an anonymous name no connected user has before falling back
+// to a longer ID
+const maxAnonymousNameAttempts = 5
+
+// Generate the random part of an anonymous name
+var anonymousID = func() string {
+	return uuid.New().String()[0:8]
+}
+
+// Initialize username rules from environment variables.
+// RESERVED_USERNAMES is a comma-separated, case-insensitive list;
+// MAX_USERNAME_LENGTH (default 32) caps names in characters; with
+// USERNAME_CONFUSABLE_CHECK=true a name that looks like a different
+// connected user's (e.g. "bob" and "b0b") is refused.
+// ANONYMOUS_NAME_FORMAT (default "anonymous-{id}") shapes generated names.
+func initUsers() {
+	list := os.Getenv("RESERVED_USERNAMES")
+	if list == "" {
+		list = "system,admin,root,bot,server,moderator"
+	}
+	reservedUsernames = make(map[string]bool)
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			reservedUsernames[usernameSkeleton(name)] = true
+		}
+	}
+	maxUsernameLength = getEnvInt("MAX_USERNAME_LENGTH", 32)
+	confusableCheck = getEnvBool("USERNAME_CONFUSABLE_CHECK", false)
+
+	anonymousNameFormat = os.Getenv("ANONYMOUS_NAME_FORMAT")
+	if anonymousNameFormat == "" {
+		anonymousNameFormat = "anonymous-" + anonymousIDPlaceholder
+	} else if strings.Count(anonymousNameFormat, anonymousIDPlaceholder) != 1 {
+		log.Printf("Warning: ANONYMOUS_NAME_FORMAT must contain %s exactly once, using anonymous-%s", anonymousIDPlaceholder, anonymousIDPlaceholder)
+		anonymousNameFormat = "anonymous-" + anonymousIDPlaceholder
+	}
+}
+
+// Generate a name for a user who didn't give one, avoiding the names of
+// connected users. After a few collisions the whole UUID is used, which
+// won't collide in practice.
+func anonymousUsername() string {
+	for range maxAnonymousNameAttempts {
+		name := strings.Replace(anonymousNameFormat, anonymousIDPlaceholder, anonymousID(), 1)
+		if !isConnectedUser(name) {
+			return name
+		}
+	}
+	return strings.Replace(anonymousNameFormat, anonymousIDPlaceholder, strings.ReplaceAll(uuid.New().String(), "-", ""), 1)
+}
+
+// Report whether a user with exactly this name is connected
+func isConnectedUser(username string) bool {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	for client := range clients {
+		if client.username == username {
+			return true
+		}
+	}
+	return false
+}
+
+// Reserve a username for use by the server, e.g. a bot's
+func reserveUsername(username string) {
+	reservedUsernamesMu.Lock()
+	defer reservedUsernamesMu.Unlock()
+	reservedUsernames[usernameSkeleton(username)] = true
+}
+
+// Report whether a username is reserved
+func isReservedUsername(username string) bool {
+	reservedUsernamesMu.RLock()
+	defer reservedUsernamesMu.RUnlock()
+	return reservedUsernames[usernameSkeleton(username)]
+}
+
+// Normalize a username: NFC form with leading and trailing whitespace
+// removed and inner runs of whitespace collapsed to one space
+func normalizeUsername(username string) string {
+	return strings.Join(strings.Fields(norm.NFC.String(username)), " ")
+}
+
+// Characters that look like others, mapped to the character they imitate
+var confusables = map[rune]rune{
+	// Cyrillic
+	'а': 'a', 'в': 'b', 'е': 'e', 'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o',
+	'р': 'p', 'с': 'c', 'т': 't', 'у': 'y', 'х': 'x', 'і': 'i', 'ј': 'j',
+	'ѕ': 's', 'ԁ': 'd', 'ӏ': 'l', 'ԛ': 'q', 'ԝ': 'w',
+	// Greek
+	'α': 'a', 'β': 'b', 'ε': 'e', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o',
+	'ρ': 'p', 'τ': 't', 'υ': 'u', 'χ': 'x',
+	// Latin, digits and punctuation
+	'i': 'l', '0': 'o', '1': 'l', '3': 'e', '5': 's', '|': 'l', '_': '-', '.': '-',
+}
+
+// Reduce a username to a skeleton shared by names that look alike: case
+// folded, compatibility-decomposed without combining marks, with common
+// lookalike characters replaced and "rn" read as "m"
+func usernameSkeleton(username string) string {
+	var b strings.Builder
+	for _, r := range norm.NFKD.String(strings.ToLower(normalizeUsername(username))) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if lookalike, ok := confusables[r]; ok {
+			r = lookalike
+		}
+		b.WriteRune(r)
+	}
+	return strings.ReplaceAll(b.String(), "rn", "m")
+}
+
+// Normalize and check a username given by a client, responding with an
+// error and returning false when it may not be used. Empty names are
+// returned as is, for the caller to replace with an anonymous one.
+func checkUsername(c *gin.Context, username string) (string, bool) {
+	username = normalizeUsername(username)
+	if username == "" {
+		return "", true
+	}
+	if strings.ContainsFunc(username, unicode.IsControl) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Username contains invalid characters", "code": "ERR_USERNAME_INVALID"})
+		return "", false
+	}
+	if maxUsernameLength > 0 && utf8.RuneCountInString(username) > maxUsernameLength {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Username must be at most %d characters", maxUsernameLength),
+			"code":  "ERR_USERNAME_TOO_LONG",
+		})
+		return "", false
+	}
+	if isReservedUsername(username) {
+		abortUsernameReserved(c)
+		return "", false
+	}
+	if confusableCheck {
+		if looksLikeConnectedUser(username) {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "Username is too similar to a connected user's", "code": "ERR_USERNAME_CONFUSABLE"})
+			return "", false
+		}
+	}
+	return username, true
+}
+
+// Report whether a connected user has a different name that looks the
+// same as username
+func looksLikeConnectedUser(username string) bool {
+	skeleton := usernameSkeleton(username)
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	for client := range clients {
+		if client.username != username && usernameSkeleton(client.username) == skeleton {
+			return true
+		}
+	}
+	return false
+}
+
+// Respond that a username is reserved
+func abortUsernameReserved(c *gin.Context) {
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Username is reserved", "code": "ERR_USERNAME_RESERVED"})
+}
+
+// Page size limits for roster listings
+const (
+	defaultRosterLimit = 100
+	maxRosterLimit     = 1000
+)
+
+// RosterEntry is a connected user and the rooms they are in
+type RosterEntry struct {
+	Username string   `json:"username"`
+	Rooms    []string `json:"rooms"`
+}
+
+// Build the roster of connected users, optionally limited to one room,
+// sorted by username
+func buildRoster(room string) []RosterEntry {
+	rooms := make(map[string]map[string]bool) // username -> rooms
+	clientsMu.Lock()
+	for client := range clients {
+		if room != "" && client.room != room {
+			continue
+		}
+		if rooms[client.username] == nil {
+			rooms[client.username] = make(map[string]bool)
+		}
+		rooms[client.username][client.room] = true
+	}
+	clientsMu.Unlock()
+
+	roster := make([]RosterEntry, 0, len(rooms))
+	for username, userRooms := range rooms {
+		entry := RosterEntry{Username: username, Rooms: make([]string, 0, len(userRooms))}
+		for r := range userRooms {
+			entry.Rooms = append(entry.Rooms, r)
+		}
+		sort.Strings(entry.Rooms)
+		roster = append(roster, entry)
+	}
+	sort.Slice(roster, func(i, j int) bool { return roster[i].Username < roster[j].Username })
+	return roster
+}
+
+// Respond with one page of a roster. ?limit= sets the page size and
+// ?cursor= continues after the last username of the previous page; live
+// changes arrive as join/leave messages, so clients load the roster once
+// and page through it at their own pace.
+func respondRosterPage(c *gin.Context, roster []RosterEntry) {
+	limit := defaultRosterLimit
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		limit = min(n, maxRosterLimit)
+	}
+
+	start := 0
+	if cursor := c.Query("cursor"); cursor != "" {
+		start = sort.Search(len(roster), func(i int) bool { return roster[i].Username > cursor })
+	}
+	end := min(start+limit, len(roster))
+
+	nextCursor := ""
+	if end < len(roster) {
+		nextCursor = roster[end-1].Username
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"users":      roster[start:end],
+		"total":      len(roster),
+		"nextCursor": nextCursor,
+	})
+}
+
+// List connected users across all rooms, paginated. In private mode only
+// the rooms the caller (?username=) can access are listed, and users in
+// none of them are left out.
+func handleListUsers(c *gin.Context) {
+	username := c.Query("username")
+	access := make(map[string]bool) // room -> caller may access it
+	roster := buildRoster("")
+	visible := roster[:0]
+	for _, entry := range roster {
+		rooms := entry.Rooms[:0]
+		for _, room := range entry.Rooms {
+			allowed, checked := access[room]
+			if !checked {
+				allowed = canAccessRoom(c.Request.Context(), room, username)
+				access[room] = allowed
+			}
+			if allowed {
+				rooms = append(rooms, room)
+			}
+		}
+		if len(rooms) > 0 {
+			entry.Rooms = rooms
+			visible = append(visible, entry)
+		}
+	}
+	respondRosterPage(c, visible)
+}
+
+// List the users connected to a room, paginated
+func handleListRoomUsers(c *gin.Context) {
+	room := c.Param("room")
+	if !canAccessRoom(c.Request.Context(), room, c.Query("username")) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not a member of this room"})
+		return
+	}
+	respondRosterPage(c, buildRoster(room))
+}
@@ -0,0 +1,273 @@
+// users_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUsernameSkeleton(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"bob", "BOB", true},
+		{"bob", "b0b", true},
+		{"alice", "аlice", true}, // Cyrillic а
+		{"admin", "adrnin", true},
+		{"paul", "pαul", true}, // Greek α
+		{"jose", "josé", true},
+		{"bill", "b1ll", true},
+		{"john_doe", "john.doe", true},
+		{"  bob  ", "bob", true},
+		{"ﬁle", "file", true}, // compatibility ligature
+		{"bob", "rob", false},
+		{"alice", "alicia", false},
+	}
+	for _, tt := range tests {
+		a, b := usernameSkeleton(tt.a), usernameSkeleton(tt.b)
+		if (a == b) != tt.same {
+			t.Errorf("usernameSkeleton(%q) = %q, usernameSkeleton(%q) = %q, want same = %v", tt.a, a, tt.b, b, tt.same)
+		}
+	}
+}
+
+func TestNormalizeUsername(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"bob", "bob"},
+		{"  bob  ", "bob"},
+		{"mary   jane", "mary jane"},
+		{"mary\tjane", "mary jane"},
+		{"jose\u0301", "jos\u00e9"}, // decomposed é becomes one rune
+	}
+	for _, tt := range tests {
+		if got := normalizeUsername(tt.in); got != tt.want {
+			t.Errorf("normalizeUsername(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestHandleListUsers(t *testing.T) {
+	useMemoryStore(t)
+	ctx := context.Background()
+	for _, m := range []struct{ room, username string }{
+		{"general", "alice"}, {"general", "bob"}, {"secret", "bob"}, {"secret", "carol"},
+	} {
+		if err := messageStore.JoinRoom(ctx, m.room, m.username); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	useClients(t,
+		&Client{username: "alice", room: "general"},
+		&Client{username: "bob", room: "general"},
+		&Client{username: "bob", room: "secret"},
+		&Client{username: "carol", room: "secret"},
+	)
+	t.Cleanup(func() { privateRooms = false })
+
+	tests := []struct {
+		name    string
+		private bool
+		target  string
+		want    []RosterEntry
+	}{
+		{"open rooms", false, "/users", []RosterEntry{
+			{"alice", []string{"general"}},
+			{"bob", []string{"general", "secret"}},
+			{"carol", []string{"secret"}},
+		}},
+		{"private, member of one room", true, "/users?username=alice", []RosterEntry{
+			{"alice", []string{"general"}},
+			{"bob", []string{"general"}},
+		}},
+		{"private, member of both", true, "/users?username=bob", []RosterEntry{
+			{"alice", []string{"general"}},
+			{"bob", []string{"general", "secret"}},
+			{"carol", []string{"secret"}},
+		}},
+		{"private, anonymous", true, "/users", []RosterEntry{}},
+		{"paged", false, "/users?limit=1&cursor=alice", []RosterEntry{
+			{"bob", []string{"general", "secret"}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			privateRooms = tt.private
+			rec := serveTest(http.MethodGet, "/users", tt.target, nil, handleListUsers)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+			var resp struct {
+				Users []RosterEntry `json:"users"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(resp.Users, tt.want) {
+				t.Errorf("users = %v, want %v", resp.Users, tt.want)
+			}
+		})
+	}
+}
+
+// Reserve usernames for the duration of a test
+func useReservedUsernames(t *testing.T, names ...string) {
+	t.Helper()
+	reservedUsernamesMu.Lock()
+	saved := reservedUsernames
+	reservedUsernames = make(map[string]bool)
+	reservedUsernamesMu.Unlock()
+	t.Cleanup(func() {
+		reservedUsernamesMu.Lock()
+		reservedUsernames = saved
+		reservedUsernamesMu.Unlock()
+	})
+	for _, name := range names {
+		reserveUsername(name)
+	}
+}
+
+// Spellings of reserved names that must be refused, and names that must not
+var reservedUsernameTests = []struct {
+	username string
+	reserved bool
+}{
+	{"admin", true},
+	{"ADMIN", true},
+	{"System", true},
+	{"  system  ", true},
+	{"ѕуѕtem", true}, // Cyrillic ѕ and у
+	{"adm1n", true},
+	{"administrator", false},
+	{"alice", false},
+}
+
+func TestReservedUsernamesOverHTTP(t *testing.T) {
+	useMemoryStore(t)
+	useBroadcastQueue(t)
+	useReservedUsernames(t, "system", "admin")
+
+	for _, tt := range reservedUsernameTests {
+		t.Run(tt.username, func(t *testing.T) {
+			req := newMessageForm(map[string]string{"username": tt.username, "room": "general", "content": "hi"}, "", "")
+			rec := serveTestRequest("/messages", req, handlePostMessage)
+			if got := rec.Code == http.StatusForbidden && strings.Contains(rec.Body.String(), "ERR_USERNAME_RESERVED"); got != tt.reserved {
+				t.Errorf("posting as %q: status %d: %s; want reserved = %v", tt.username, rec.Code, rec.Body, tt.reserved)
+			}
+		})
+	}
+}
+
+func TestReservedUsernamesOverWebSocket(t *testing.T) {
+	server := useWSServer(t)
+	useReservedUsernames(t, "system", "admin")
+
+	for _, tt := range reservedUsernameTests {
+		t.Run(tt.username, func(t *testing.T) {
+			ws, resp, err := server.dial(url.Values{"username": {tt.username}, "room": {"general"}}.Encode())
+			if !tt.reserved {
+				if err != nil {
+					t.Fatalf("connecting as %q: %v", tt.username, err)
+				}
+				ws.Close()
+				return
+			}
+			if err == nil {
+				t.Fatalf("connected as reserved name %q", tt.username)
+			}
+			if resp == nil || resp.StatusCode != http.StatusForbidden {
+				t.Errorf("upgrade as %q answered %v, want 403", tt.username, resp)
+			}
+		})
+	}
+}
+
+func TestInitUsersReservedList(t *testing.T) {
+	useReservedUsernames(t)
+	savedLength, savedConfusable, savedFormat := maxUsernameLength, confusableCheck, anonymousNameFormat
+	t.Cleanup(func() {
+		maxUsernameLength, confusableCheck, anonymousNameFormat = savedLength, savedConfusable, savedFormat
+	})
+	t.Setenv("RESERVED_USERNAMES", " Support ,OPS,,")
+	initUsers()
+
+	for name, want := range map[string]bool{"support": true, "SUPPORT": true, "ops": true, "admin": false} {
+		if got := isReservedUsername(name); got != want {
+			t.Errorf("isReservedUsername(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+// Use format for anonymous names and ids for their random parts, in turn
+func useAnonymousNames(t *testing.T, format string, ids ...string) {
+	t.Helper()
+	savedFormat, savedID := anonymousNameFormat, anonymousID
+	anonymousNameFormat = format
+	anonymousID = func() string {
+		id := ids[0]
+		if len(ids) > 1 {
+			ids = ids[1:]
+		}
+		return id
+	}
+	t.Cleanup(func() { anonymousNameFormat, anonymousID = savedFormat, savedID })
+}
+
+func TestInitUsersAnonymousNameFormat(t *testing.T) {
+	useReservedUsernames(t)
+	savedLength, savedConfusable, savedFormat := maxUsernameLength, confusableCheck, anonymousNameFormat
+	t.Cleanup(func() {
+		maxUsernameLength, confusableCheck, anonymousNameFormat = savedLength, savedConfusable, savedFormat
+	})
+
+	tests := []struct {
+		env  string
+		want string
+	}{
+		{"", "anonymous-{id}"},
+		{"guest_{id}", "guest_{id}"},
+		{"{id} (visitor)", "{id} (visitor)"},
+		{"guest", "anonymous-{id}"},
+		{"{id}-{id}", "anonymous-{id}"},
+	}
+	for _, tt := range tests {
+		captureLog(t)
+		t.Setenv("ANONYMOUS_NAME_FORMAT", tt.env)
+		initUsers()
+		if anonymousNameFormat != tt.want {
+			t.Errorf("ANONYMOUS_NAME_FORMAT=%q: format = %q, want %q", tt.env, anonymousNameFormat, tt.want)
+		}
+	}
+}
+
+func TestAnonymousUsername(t *testing.T) {
+	useAnonymousNames(t, "guest_{id}", "a1b2c3d4")
+	useClients(t)
+	if name := anonymousUsername(); name != "guest_a1b2c3d4" {
+		t.Errorf("name = %q, want guest_a1b2c3d4", name)
+	}
+}
+
+func TestAnonymousUsernameAvoidsConnectedNames(t *testing.T) {
+	useAnonymousNames(t, "guest_{id}", "taken", "taken", "free")
+	useClients(t, &Client{username: "guest_taken", room: "general", send: make(chan Message, 1)})
+	if name := anonymousUsername(); name != "guest_free" {
+		t.Errorf("name = %q, want guest_free after the taken one", name)
+	}
+
+	// When every short ID collides the whole UUID is used
+	useAnonymousNames(t, "guest_{id}", "taken")
+	name := anonymousUsername()
+	id, ok := strings.CutPrefix(name, "guest_")
+	if !ok || len(id) != 32 || strings.Contains(id, "-") {
+		t.Errorf("name = %q, want guest_ and a whole UUID", name)
+	}
+}
@@ -0,0 +1,107 @@
+// versions.go
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go/v7"
+)
+
+// Whether bucket versioning is enabled (STORAGE_VERSIONING)
+var storageVersioning bool
+
+// FileVersion describes one stored version of an uploaded file
+type FileVersion struct {
+	VersionID    string    `json:"versionId"`
+	Size         int64     `json:"size"`
+	Uploader     string    `json:"uploader,omitempty"`
+	Timestamp    time.Time `json:"timestamp"`
+	IsLatest     bool      `json:"isLatest"`
+	DeleteMarker bool      `json:"deleteMarker,omitempty"`
+}
+
+// Enable bucket versioning when STORAGE_VERSIONING=true
+func initVersioning(ctx context.Context) {
+	storageVersioning = getEnvBool("STORAGE_VERSIONING", false)
+	if !storageVersioning {
+		return
+	}
+	if err := minioClient.EnableVersioning(ctx, bucketName); err != nil {
+		log.Fatalf("Error enabling bucket versioning: %v", err)
+	}
+	log.Printf("Bucket versioning enabled for %s", bucketName)
+}
+
+// Derive a stable object name for a user's file so that re-uploads of the
+// same filename become new versions of one object instead of new objects
+func versionedObjectName(username, filename string) string {
+	sum := sha256.Sum256([]byte(username + "\x00" + filename))
+	return hex.EncodeToString(sum[:8]) + filepath.Ext(filename)
+}
+
+// List all versions of an uploaded file. In private mode only members of
+// the file's room (?username=) may list them.
+func handleListFileVersions(c *gin.Context) {
+	objectName := c.Param("id")
+	if isReservedObjectName(objectName) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	if !canAccessRoom(c.Request.Context(), roomOfObject(objectName), c.Query("username")) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not a member of this room"})
+		return
+	}
+
+	var versions []FileVersion
+	for obj := range minioClient.ListObjects(c.Request.Context(), bucketName, minio.ListObjectsOptions{
+		Prefix:       objectName,
+		WithVersions: true,
+		WithMetadata: true,
+	}) {
+		if obj.Err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list file versions"})
+			log.Printf("Error listing object versions: %v", obj.Err)
+			return
+		}
+		if obj.Key != objectName {
+			continue
+		}
+
+		versions = append(versions, FileVersion{
+			VersionID:    obj.VersionID,
+			Size:         obj.Size,
+			Uploader:     objectMetadata(obj.UserMetadata, "Uploader"),
+			Timestamp:    obj.LastModified,
+			IsLatest:     obj.IsLatest,
+			DeleteMarker: obj.IsDeleteMarker,
+		})
+	}
+
+	if len(versions) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"file": objectName, "versions": versions})
+}
+
+// Permanently delete one version of an uploaded file (admin only)
+func handleDeleteFileVersion(c *gin.Context) {
+	objectName := c.Param("id")
+	versionID := c.Param("versionId")
+
+	err := minioClient.RemoveObject(c.Request.Context(), bucketName, objectName, minio.RemoveObjectOptions{VersionID: versionID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete file version"})
+		log.Printf("Error deleting object version: %v", err)
+		return
+	}
+	broadcastFileDeleted(c.Request.Context(), objectName, versionID, "")
+	c.JSON(http.StatusOK, gin.H{"message": "File version deleted", "versionId": versionID})
+}
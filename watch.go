@@ -0,0 +1,105 @@
+// watch.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7/pkg/notification"
+)
+
+// Sender of the file messages announcing files uploaded straight to MinIO
+const externalUploadUsername = "storage"
+
+// Bucket watch settings
+var (
+	watchEnabled bool
+	watchRoom    string // room for files that are not under a room prefix
+)
+
+// Initialize the bucket watch from environment variables. With
+// MINIO_WATCH_ENABLED=true, files uploaded to the bucket without going
+// through the server are announced as file messages. Files under a room's
+// prefix go to that room, others to MINIO_WATCH_ROOM (default: the default room).
+func initWatch() {
+	watchEnabled = getEnvBool("MINIO_WATCH_ENABLED", false)
+	watchRoom = os.Getenv("MINIO_WATCH_ROOM")
+	if watchRoom == "" {
+		watchRoom = defaultRoom
+	}
+}
+
+// Listen for objects created in the bucket until ctx is canceled,
+// reconnecting with exponential backoff when the listener drops. This uses
+// MinIO's listen API, which needs no notification target configured on the
+// bucket.
+func runWatch(ctx context.Context) {
+	if !watchEnabled {
+		return
+	}
+	const minDelay, maxDelay = time.Second, time.Minute
+	delay := minDelay
+	for {
+		for info := range minioClient.ListenBucketNotification(ctx, bucketName, "", "", []string{"s3:ObjectCreated:*"}) {
+			if info.Err != nil {
+				log.Printf("Error watching bucket: %v", info.Err)
+				break
+			}
+			delay = minDelay
+			for _, event := range info.Records {
+				announceExternalUpload(event)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxDelay)
+	}
+}
+
+// Broadcast a file message for an object created outside the server
+func announceExternalUpload(event notification.Event) {
+	object := event.S3.Object
+	key, err := url.QueryUnescape(object.Key)
+	if err != nil {
+		key = object.Key
+	}
+
+	// Uploads through the server carry their uploader, and server objects
+	// (emoji, config, quarantine) live under "." prefixes
+	if objectMetadata(object.UserMetadata, "Uploader") != "" || strings.HasPrefix(key, ".") {
+		return
+	}
+
+	room := roomOfObject(key)
+	if !validRoomName(room) {
+		room = watchRoom
+	}
+	fileName := path.Base(key)
+	id := uuid.New().String()
+	publish(Message{
+		SchemaVersion: CurrentSchemaVersion,
+		ID:            id,
+		Room:          room,
+		Username:      externalUploadUsername,
+		Content:       fmt.Sprintf("shared a file: %s", fileName),
+		FileURL:       fileDownloadURL(id, key, object.VersionID),
+		FileName:      fileName,
+		FileSize:      object.Size,
+		VersionID:     object.VersionID,
+		Timestamp:     time.Now(),
+
+		objectName: key,
+	})
+	log.Printf("Announced externally uploaded file %s in room %s", key, room)
+}
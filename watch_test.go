@@ -0,0 +1,106 @@
+// watch_test.go
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7/pkg/notification"
+)
+
+// Announce external uploads to room for a test
+func useWatch(t *testing.T, room string) {
+	t.Helper()
+	savedEnabled, savedRoom := watchEnabled, watchRoom
+	watchEnabled, watchRoom = true, room
+	t.Cleanup(func() { watchEnabled, watchRoom = savedEnabled, savedRoom })
+}
+
+// Wait for the next published message, failing the test after a few seconds
+func waitPublished(t *testing.T, queue *broadcastQueue) Message {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		if msg, ok := queue.Pop(); ok {
+			return msg
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("nothing was published")
+	return Message{}
+}
+
+func TestWatchAnnouncesExternalUpload(t *testing.T) {
+	captureLog(t)
+	queue := useBroadcastQueue(t)
+	fake := useFakeS3(t, bucketName)
+	useWatch(t, "lobby")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		runWatch(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	// Wait for the listener to connect before uploading
+	for deadline := time.Now().Add(3 * time.Second); ; {
+		fake.mu.Lock()
+		listening := len(fake.listeners) > 0
+		fake.mu.Unlock()
+		if listening {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("watch never started listening")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Uploaded straight to MinIO, bypassing the server
+	fake.putObject(bucketName, "general/Q3 report.pdf", []byte("%PDF-1.7"), nil)
+	msg := waitPublished(t, queue)
+	if msg.Room != "general" || msg.Username != externalUploadUsername || msg.FileName != "Q3 report.pdf" || msg.FileSize != 8 {
+		t.Errorf("announced %+v, want Q3 report.pdf in general from %s", msg, externalUploadUsername)
+	}
+	if msg.objectName != "general/Q3 report.pdf" || msg.FileURL == "" {
+		t.Errorf("announced object %q at %q, want a download link to general/Q3 report.pdf", msg.objectName, msg.FileURL)
+	}
+}
+
+func TestAnnounceExternalUpload(t *testing.T) {
+	useWatch(t, "lobby")
+
+	tests := []struct {
+		name     string
+		key      string
+		metadata map[string]string
+		room     string // "" when nothing should be announced
+	}{
+		{"under a room", "general%2Fa.png", nil, "general"},
+		{"outside any room", "a.png", nil, "lobby"},
+		{"server object", ".emoji%2Fparrot", nil, ""},
+		{"uploaded through the server", "general%2Fb.png", map[string]string{"X-Amz-Meta-Uploader": "alice"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queue := useBroadcastQueue(t)
+			var event notification.Event
+			event.S3.Object.Key = tt.key
+			event.S3.Object.UserMetadata = tt.metadata
+			announceExternalUpload(event)
+
+			msg, ok := queue.Pop()
+			switch {
+			case tt.room == "" && ok:
+				t.Errorf("announced %+v, want nothing", msg)
+			case tt.room != "" && (!ok || msg.Room != tt.room):
+				t.Errorf("announced %+v (%v), want a message in %s", msg, ok, tt.room)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+// webhook.go
+
+// Package webhook signs and verifies webhook payloads. Payloads are signed
+// with HMAC-SHA256 under a secret shared with the receiver, and the hex
+// digest is sent as "sha256=<digest>" in the X-Chat-Signature header.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// SignatureHeader carries a payload's signature
+const SignatureHeader = "X-Chat-Signature"
+
+// Prefix naming the signature algorithm in the header value
+const signaturePrefix = "sha256="
+
+// Sign returns the hex HMAC-SHA256 of payload under secret
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignatureValue returns the header value signing payload under secret
+func SignatureValue(secret string, payload []byte) string {
+	return signaturePrefix + Sign(secret, payload)
+}
+
+// VerifyWebhookSignature reports whether sig is a valid signature of
+// payload under secret. sig is the hex digest, with or without the
+// "sha256=" prefix. The comparison takes constant time, so a receiver
+// doesn't leak how much of a forged signature matched.
+func VerifyWebhookSignature(secret, payload, sig string) bool {
+	got, err := hex.DecodeString(strings.TrimPrefix(sig, signaturePrefix))
+	if err != nil || len(got) != sha256.Size {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return hmac.Equal(got, mac.Sum(nil))
+}
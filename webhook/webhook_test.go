@@ -0,0 +1,51 @@
+// webhook_test.go
+package webhook
+
+import (
+	"strings"
+	"testing"
+)
+
+// RFC 4231, test case 2
+const (
+	testSecret  = "Jefe"
+	testPayload = "what do ya want for nothing?"
+	testDigest  = "5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"
+)
+
+func TestSign(t *testing.T) {
+	if got := Sign(testSecret, []byte(testPayload)); got != testDigest {
+		t.Errorf("Sign = %s, want %s", got, testDigest)
+	}
+	if got := SignatureValue(testSecret, []byte(testPayload)); got != "sha256="+testDigest {
+		t.Errorf("SignatureValue = %s, want sha256=%s", got, testDigest)
+	}
+}
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	tests := []struct {
+		name    string
+		secret  string
+		payload string
+		sig     string
+		want    bool
+	}{
+		{"header value", testSecret, testPayload, "sha256=" + testDigest, true},
+		{"bare digest", testSecret, testPayload, testDigest, true},
+		{"uppercase hex", testSecret, testPayload, strings.ToUpper(testDigest), true},
+		{"wrong secret", "jefe", testPayload, testDigest, false},
+		{"payload changed", testSecret, testPayload + " ", testDigest, false},
+		{"digest changed", testSecret, testPayload, "6" + testDigest[1:], false},
+		{"truncated", testSecret, testPayload, testDigest[:32], false},
+		{"not hex", testSecret, testPayload, "sha256=" + strings.Repeat("zz", 32), false},
+		{"other algorithm", testSecret, testPayload, "sha1=" + testDigest, false},
+		{"empty", testSecret, testPayload, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VerifyWebhookSignature(tt.secret, tt.payload, tt.sig); got != tt.want {
+				t.Errorf("VerifyWebhookSignature(%q) = %v, want %v", tt.sig, got, tt.want)
+			}
+		})
+	}
+}
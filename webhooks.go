@@ -0,0 +1,281 @@
+// webhooks.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"go-chat/webhook"
+)
+
+// Webhook event types
+const (
+	WebhookEventMessage = "message"
+	WebhookEventFile    = "file"
+	WebhookEventJoin    = "join"
+	WebhookEventLeave   = "leave"
+
+	// Sent only by POST /webhooks/test
+	WebhookEventTest = "test"
+)
+
+// Webhook is an outbound integration receiving chat events over HTTP
+type Webhook struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"` // empty receives every event type
+	Rooms  []string `json:"rooms"`  // empty receives every room
+}
+
+// WebhookPayload is the JSON body POSTed to webhooks
+type WebhookPayload struct {
+	ID        string    `json:"id"`
+	Event     string    `json:"event"`
+	Room      string    `json:"room,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	Message   Message   `json:"message"`
+}
+
+// A payload queued for one webhook
+type webhookDelivery struct {
+	hook *Webhook
+	body []byte
+	id   string
+	// event type, sent as a header so receivers can route without parsing
+	event string
+}
+
+// Webhook settings
+var (
+	webhooks          []*Webhook
+	webhookQueue      chan webhookDelivery
+	webhookClient     = &http.Client{Timeout: 10 * time.Second}
+	webhookMaxRetries int
+
+	// Wait before the first retry of a failed delivery; doubles with each retry
+	webhookRetryBackoff = time.Second
+)
+
+// Load webhooks from WEBHOOKS_FILE (a JSON array of Webhook) and start the delivery workers
+func initWebhooks() {
+	path := os.Getenv("WEBHOOKS_FILE")
+	if path == "" {
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("Error reading webhooks file: %v", err)
+	}
+	if err := json.Unmarshal(data, &webhooks); err != nil {
+		log.Fatalf("Error parsing webhooks file: %v", err)
+	}
+	for _, hook := range webhooks {
+		if hook.URL == "" || hook.Secret == "" {
+			log.Fatalf("Every webhook needs a url and a secret")
+		}
+	}
+
+	webhookClient.Transport = outboundTransport(true)
+	webhookMaxRetries = getEnvInt("WEBHOOK_MAX_RETRIES", 3)
+	webhookQueue = make(chan webhookDelivery, getEnvInt("WEBHOOK_QUEUE_SIZE", 1000))
+	for i := 0; i < getEnvInt("WEBHOOK_WORKERS", 4); i++ {
+		supervisor.Go(fmt.Sprintf("webhook-%d", i+1), func(context.Context) { webhookWorker() })
+	}
+	log.Printf("Loaded %d webhooks", len(webhooks))
+}
+
+// Report whether a webhook subscribes to an event in a room
+func (w *Webhook) wants(event, room string) bool {
+	return (len(w.Events) == 0 || contains(w.Events, event)) &&
+		(len(w.Rooms) == 0 || contains(w.Rooms, room))
+}
+
+// Report whether list contains value
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Queue an event for every subscribed webhook. Never blocks: if the queue
+// is full the delivery is dropped so the broadcast loop keeps moving.
+func notifyWebhooks(event string, msg Message) {
+	if len(webhooks) == 0 {
+		return
+	}
+
+	payload := WebhookPayload{
+		ID:        uuid.New().String(),
+		Event:     event,
+		Room:      msg.Room,
+		Timestamp: time.Now(),
+		Message:   msg,
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Error encoding webhook payload: %v", err)
+		return
+	}
+
+	for _, hook := range webhooks {
+		if !hook.wants(event, msg.Room) {
+			continue
+		}
+		select {
+		case webhookQueue <- webhookDelivery{hook: hook, body: body, id: payload.ID, event: event}:
+		default:
+			log.Printf("Webhook queue full, dropping %s event for %s", event, hook.URL)
+		}
+	}
+}
+
+// Deliver queued webhooks
+func webhookWorker() {
+	for delivery := range webhookQueue {
+		deliverWebhook(delivery)
+	}
+}
+
+// Most of a receiver's response body kept for webhook test results
+const webhookResponseLimit = 64 << 10
+
+// Outcome of delivering a payload to a webhook, as of its last attempt
+type webhookResult struct {
+	Attempts int
+	Status   int    // 0 when no response was received
+	Body     []byte // start of the response body
+	Latency  time.Duration
+	Err      error
+}
+
+// POST a payload, retrying network errors and 5xx/429 responses with exponential backoff
+func deliverWebhook(d webhookDelivery) webhookResult {
+	backoff := webhookRetryBackoff
+	for attempt := 0; ; attempt++ {
+		result := postWebhook(d)
+		result.Attempts = attempt + 1
+		if result.Err == nil {
+			return result
+		}
+		if _, permanent := result.Err.(permanentWebhookError); permanent || attempt >= webhookMaxRetries {
+			log.Printf("Webhook delivery to %s failed: %v", d.hook.URL, result.Err)
+			return result
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// A webhook failure that retrying won't fix
+type permanentWebhookError struct{ status int }
+
+func (e permanentWebhookError) Error() string {
+	return fmt.Sprintf("receiver rejected delivery with status %d", e.status)
+}
+
+// Send a single delivery attempt
+func postWebhook(d webhookDelivery) webhookResult {
+	req, err := http.NewRequest(http.MethodPost, d.hook.URL, bytes.NewReader(d.body))
+	if err != nil {
+		return webhookResult{Err: permanentWebhookError{}}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Chat-Event", d.event)
+	req.Header.Set("X-Chat-Delivery", d.id)
+	req.Header.Set(webhook.SignatureHeader, webhook.SignatureValue(d.hook.Secret, d.body))
+
+	started := time.Now()
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return webhookResult{Latency: time.Since(started), Err: err}
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, webhookResponseLimit))
+	resp.Body.Close()
+	result := webhookResult{Status: resp.StatusCode, Body: body, Latency: time.Since(started)}
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		result.Err = fmt.Errorf("receiver returned status %d", resp.StatusCode)
+	default:
+		result.Err = permanentWebhookError{status: resp.StatusCode}
+	}
+	return result
+}
+
+// Body of POST /webhooks/test. Payload is sent as the body instead of a
+// generated test event when given.
+type webhookTestRequest struct {
+	URL     string          `json:"url" binding:"required"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Deliver a test payload to a registered webhook and report how the
+// receiver answered. Delivery goes through the same client, signing and
+// retries as real events, so the request lasts until the final attempt.
+func handleTestWebhook(c *gin.Context) {
+	var req webhookTestRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "url is required"})
+		return
+	}
+	var hook *Webhook
+	for _, h := range webhooks {
+		if h.URL == req.URL {
+			hook = h
+			break
+		}
+	}
+	if hook == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No webhook is registered with this url"})
+		return
+	}
+
+	id := uuid.New().String()
+	body := []byte(req.Payload)
+	if len(body) == 0 {
+		var err error
+		body, err = json.Marshal(WebhookPayload{
+			ID:        id,
+			Event:     WebhookEventTest,
+			Timestamp: time.Now(),
+			Message: Message{
+				ID:        uuid.New().String(),
+				Username:  "System",
+				Content:   "This is a test delivery.",
+				Timestamp: time.Now(),
+			},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build test payload"})
+			return
+		}
+	}
+
+	result := deliverWebhook(webhookDelivery{hook: hook, body: body, id: id, event: WebhookEventTest})
+	response := gin.H{
+		"url":       hook.URL,
+		"delivered": result.Err == nil,
+		"attempts":  result.Attempts,
+		"status":    result.Status,
+		"response":  string(result.Body),
+		"latencyMs": result.Latency.Milliseconds(),
+	}
+	if result.Err != nil {
+		response["error"] = result.Err.Error()
+	}
+	c.JSON(http.StatusOK, response)
+}
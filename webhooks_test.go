@@ -0,0 +1,218 @@
+// webhooks_test.go
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go-chat/webhook"
+)
+
+func TestHandleTestWebhook(t *testing.T) {
+	var received struct {
+		event, signature string
+		body             []byte
+	}
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.event = r.Header.Get("X-Chat-Event")
+		received.signature = r.Header.Get(webhook.SignatureHeader)
+		received.body, _ = io.ReadAll(r.Body)
+		if strings.Contains(string(received.body), "reject me") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+	defer receiver.Close()
+
+	saved, savedRetries := webhooks, webhookMaxRetries
+	webhooks = []*Webhook{{URL: receiver.URL, Secret: "s3cret"}}
+	webhookMaxRetries = 0
+	t.Cleanup(func() { webhooks, webhookMaxRetries = saved, savedRetries })
+
+	tests := []struct {
+		name      string
+		body      string
+		code      int
+		delivered bool
+	}{
+		{"generated payload", `{"url":"` + receiver.URL + `"}`, http.StatusOK, true},
+		{"custom payload", `{"url":"` + receiver.URL + `","payload":{"hello":"world"}}`, http.StatusOK, true},
+		{"receiver rejects", `{"url":"` + receiver.URL + `","payload":{"text":"reject me"}}`, http.StatusOK, false},
+		{"unknown url", `{"url":"http://example.invalid"}`, http.StatusNotFound, false},
+		{"missing url", `{}`, http.StatusBadRequest, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received.body = nil
+			rec := serveTest(http.MethodPost, "/webhooks/test", "/webhooks/test", strings.NewReader(tt.body), handleTestWebhook)
+			if rec.Code != tt.code {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.code, rec.Body)
+			}
+			if tt.code != http.StatusOK {
+				return
+			}
+			var resp struct {
+				Delivered bool `json:"delivered"`
+				Attempts  int  `json:"attempts"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Delivered != tt.delivered || resp.Attempts != 1 {
+				t.Errorf("delivered = %v after %d attempts, want %v after 1", resp.Delivered, resp.Attempts, tt.delivered)
+			}
+			if received.event != WebhookEventTest {
+				t.Errorf("X-Chat-Event = %q, want %q", received.event, WebhookEventTest)
+			}
+			if !webhook.VerifyWebhookSignature("s3cret", string(received.body), received.signature) {
+				t.Errorf("signature %q does not verify", received.signature)
+			}
+		})
+	}
+}
+
+func TestWebhookWants(t *testing.T) {
+	tests := []struct {
+		hook  Webhook
+		event string
+		room  string
+		want  bool
+	}{
+		{Webhook{}, WebhookEventMessage, "general", true},
+		{Webhook{Events: []string{WebhookEventFile}}, WebhookEventMessage, "general", false},
+		{Webhook{Events: []string{WebhookEventFile}}, WebhookEventFile, "general", true},
+		{Webhook{Rooms: []string{"random"}}, WebhookEventMessage, "general", false},
+		{Webhook{Events: []string{WebhookEventJoin}, Rooms: []string{"general"}}, WebhookEventJoin, "general", true},
+	}
+	for _, tt := range tests {
+		if got := tt.hook.wants(tt.event, tt.room); got != tt.want {
+			t.Errorf("%+v wants(%s, %s) = %v, want %v", tt.hook, tt.event, tt.room, got, tt.want)
+		}
+	}
+}
+
+// Register webhooks for a test with a delivery queue the test drains itself
+func useWebhooks(t *testing.T, hooks ...*Webhook) {
+	t.Helper()
+	saved, savedQueue, savedRetries, savedBackoff := webhooks, webhookQueue, webhookMaxRetries, webhookRetryBackoff
+	webhooks, webhookQueue = hooks, make(chan webhookDelivery, 4)
+	webhookMaxRetries, webhookRetryBackoff = 3, 10*time.Millisecond
+	t.Cleanup(func() {
+		webhooks, webhookQueue, webhookMaxRetries, webhookRetryBackoff = saved, savedQueue, savedRetries, savedBackoff
+	})
+}
+
+func TestNotifyWebhooksDelivery(t *testing.T) {
+	type request struct {
+		event, delivery, signature string
+		body                       []byte
+	}
+	received := make(chan request, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- request{r.Header.Get("X-Chat-Event"), r.Header.Get("X-Chat-Delivery"), r.Header.Get(webhook.SignatureHeader), body}
+	}))
+	defer receiver.Close()
+
+	all := &Webhook{URL: receiver.URL + "/all", Secret: "s3cret"}
+	filesOnly := &Webhook{URL: receiver.URL + "/files", Secret: "s3cret", Events: []string{WebhookEventFile}}
+	otherRoom := &Webhook{URL: receiver.URL + "/random", Secret: "s3cret", Rooms: []string{"random"}}
+	useWebhooks(t, all, filesOnly, otherRoom)
+
+	notifyWebhooks(WebhookEventMessage, Message{ID: "m1", Room: "general", Username: "alice", Content: "hello"})
+	if len(webhookQueue) != 1 {
+		t.Fatalf("queued %d deliveries, want 1 for the only subscribed webhook", len(webhookQueue))
+	}
+	delivery := <-webhookQueue
+	if delivery.hook != all {
+		t.Fatalf("queued for %s, want %s", delivery.hook.URL, all.URL)
+	}
+	if result := deliverWebhook(delivery); result.Err != nil || result.Attempts != 1 || result.Status != http.StatusOK {
+		t.Fatalf("delivery = %+v, want one successful attempt", result)
+	}
+
+	got := <-received
+	var payload WebhookPayload
+	if err := json.Unmarshal(got.body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if got.event != WebhookEventMessage || payload.Event != WebhookEventMessage || payload.Room != "general" || payload.Message.ID != "m1" {
+		t.Errorf("received event %q, payload %+v", got.event, payload)
+	}
+	if got.delivery != payload.ID {
+		t.Errorf("X-Chat-Delivery = %q, want the payload ID %q", got.delivery, payload.ID)
+	}
+	if !webhook.VerifyWebhookSignature("s3cret", string(got.body), got.signature) {
+		t.Errorf("signature %q does not verify", got.signature)
+	}
+
+	// A full queue drops deliveries instead of blocking the broadcaster
+	for range cap(webhookQueue) + 2 {
+		notifyWebhooks(WebhookEventFile, Message{Room: "random"})
+	}
+	if len(webhookQueue) != cap(webhookQueue) {
+		t.Errorf("queue holds %d deliveries, want it full at %d", len(webhookQueue), cap(webhookQueue))
+	}
+}
+
+func TestDeliverWebhookRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int // answers in order; the last one repeats
+		attempts int
+		wantErr  bool
+	}{
+		{"succeeds at once", []int{200}, 1, false},
+		{"recovers from 5xx", []int{503, 500, 200}, 3, false},
+		{"retries 429", []int{429, 204}, 2, false},
+		{"gives up after the retries", []int{502}, 4, true},
+		{"4xx is not retried", []int{400}, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			var times []time.Time
+			var mu sync.Mutex
+			receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				times = append(times, time.Now())
+				mu.Unlock()
+				n := int(calls.Add(1))
+				w.WriteHeader(tt.statuses[min(n, len(tt.statuses))-1])
+			}))
+			defer receiver.Close()
+			hook := &Webhook{URL: receiver.URL, Secret: "s3cret"}
+			useWebhooks(t, hook)
+
+			result := deliverWebhook(webhookDelivery{hook: hook, body: []byte(`{}`), id: "d1", event: WebhookEventMessage})
+			if result.Attempts != tt.attempts || int(calls.Load()) != tt.attempts || (result.Err != nil) != tt.wantErr {
+				t.Errorf("attempts %d (received %d), error %v; want %d attempts, error %v", result.Attempts, calls.Load(), result.Err, tt.attempts, tt.wantErr)
+			}
+			// Each retry waits twice as long as the one before
+			for i := 1; i < len(times); i++ {
+				if want := webhookRetryBackoff << (i - 1); times[i].Sub(times[i-1]) < want {
+					t.Errorf("retry %d after %v, want at least %v", i, times[i].Sub(times[i-1]), want)
+				}
+			}
+		})
+	}
+
+	t.Run("receiver down", func(t *testing.T) {
+		receiver := httptest.NewServer(http.NotFoundHandler())
+		receiver.Close()
+		hook := &Webhook{URL: receiver.URL, Secret: "s3cret"}
+		useWebhooks(t, hook)
+		webhookMaxRetries = 1
+		if result := deliverWebhook(webhookDelivery{hook: hook, body: []byte(`{}`)}); result.Attempts != 2 || result.Err == nil || result.Status != 0 {
+			t.Errorf("delivery = %+v, want 2 failed attempts without a status", result)
+		}
+	})
+}